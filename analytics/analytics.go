@@ -0,0 +1,234 @@
+// Package analytics turns the door openings recorded by UniFi Access into
+// reports about how members use the space.
+package analytics
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+	"github.com/fatcatfablab/fcfl-member-sync/updater"
+)
+
+const dayLayout = "2006-01-02"
+
+type Report interface {
+	Header() []string
+	Records() [][]string
+}
+
+type MemberVisits struct {
+	MemberId  int32  `json:"member_id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Visits    int    `json:"visits"`
+	Days      int    `json:"days"`
+	LastVisit string `json:"last_visit"`
+}
+
+type MemberVisitsReport []MemberVisits
+
+type HourVisits struct {
+	Hour   int `json:"hour"`
+	Visits int `json:"visits"`
+}
+
+type PeakHoursReport []HourVisits
+
+type DormantMember struct {
+	MemberId  int32  `json:"member_id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+type DormantReport []DormantMember
+
+// ToVisits keeps the granted door openings done by members. Actors are mapped
+// back to member ids through the UniFi Access users in members.
+func ToVisits(events []updater.DoorEvent, members types.MemberMap) []types.Visit {
+	var visits []types.Visit
+	for _, e := range events {
+		if !e.Granted {
+			continue
+		}
+
+		m, ok := members[e.ActorId]
+		if !ok {
+			continue
+		}
+
+		visits = append(visits, types.Visit{
+			EventId:   e.Id,
+			MemberId:  m.Id,
+			AccessId:  e.ActorId,
+			Door:      e.Door,
+			Timestamp: e.Timestamp,
+		})
+	}
+
+	return visits
+}
+
+// WithArchived adds the archived users to members, so the visits they did
+// before being archived are still mapped back to them. The users in members
+// win over archived ones.
+func WithArchived(members types.MemberMap, archived []types.ArchivedUser) types.MemberMap {
+	all := maps.Clone(members)
+	if all == nil {
+		all = make(types.MemberMap)
+	}
+	for _, a := range archived {
+		if _, ok := all[a.AccessId]; ok {
+			continue
+		}
+		all[a.AccessId] = types.ComparableMember{
+			Id:        a.MemberId,
+			FirstName: a.User.FirstName,
+			LastName:  a.User.LastName,
+			Status:    a.User.Status,
+		}
+	}
+
+	return all
+}
+
+// VisitsPerMember counts door openings and distinct days visited for each
+// member, busiest members first.
+func VisitsPerMember(visits []types.Visit, members types.MemberSet, loc *time.Location) MemberVisitsReport {
+	names := types.ToIdMap(members)
+	perMember := make(map[int32]*MemberVisits)
+	days := make(map[int32]map[string]bool)
+
+	for _, v := range visits {
+		mv, ok := perMember[v.MemberId]
+		if !ok {
+			m := names[v.MemberId]
+			mv = &MemberVisits{MemberId: v.MemberId, FirstName: m.FirstName, LastName: m.LastName}
+			perMember[v.MemberId] = mv
+			days[v.MemberId] = make(map[string]bool)
+		}
+
+		t := time.Unix(v.Timestamp, 0).In(loc)
+		mv.Visits++
+		days[v.MemberId][t.Format(dayLayout)] = true
+		if last := t.Format(time.DateTime); last > mv.LastVisit {
+			mv.LastVisit = last
+		}
+	}
+
+	report := make(MemberVisitsReport, 0, len(perMember))
+	for id, mv := range perMember {
+		mv.Days = len(days[id])
+		report = append(report, *mv)
+	}
+
+	slices.SortFunc(report, func(a, b MemberVisits) int {
+		if c := cmp.Compare(b.Days, a.Days); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.MemberId, b.MemberId)
+	})
+
+	return report
+}
+
+// PeakHours counts door openings by hour of the day.
+func PeakHours(visits []types.Visit, loc *time.Location) PeakHoursReport {
+	report := make(PeakHoursReport, 24)
+	for h := range report {
+		report[h].Hour = h
+	}
+
+	for _, v := range visits {
+		report[time.Unix(v.Timestamp, 0).In(loc).Hour()].Visits++
+	}
+
+	return report
+}
+
+// Dormant returns the active members that didn't visit at all.
+func Dormant(visits []types.Visit, active types.MemberSet) DormantReport {
+	visited := make(map[int32]bool)
+	for _, v := range visits {
+		visited[v.MemberId] = true
+	}
+
+	report := DormantReport{}
+	for m := range active.Iter() {
+		if !visited[m.Id] {
+			report = append(report, DormantMember{MemberId: m.Id, FirstName: m.FirstName, LastName: m.LastName})
+		}
+	}
+
+	slices.SortFunc(report, func(a, b DormantMember) int {
+		return cmp.Compare(a.MemberId, b.MemberId)
+	})
+
+	return report
+}
+
+func (r MemberVisitsReport) Header() []string {
+	return []string{"member_id", "first_name", "last_name", "visits", "days", "last_visit"}
+}
+
+func (r MemberVisitsReport) Records() [][]string {
+	records := make([][]string, 0, len(r))
+	for _, mv := range r {
+		records = append(records, []string{
+			strconv.Itoa(int(mv.MemberId)),
+			mv.FirstName,
+			mv.LastName,
+			strconv.Itoa(mv.Visits),
+			strconv.Itoa(mv.Days),
+			mv.LastVisit,
+		})
+	}
+	return records
+}
+
+func (r PeakHoursReport) Header() []string {
+	return []string{"hour", "visits"}
+}
+
+func (r PeakHoursReport) Records() [][]string {
+	records := make([][]string, 0, len(r))
+	for _, h := range r {
+		records = append(records, []string{strconv.Itoa(h.Hour), strconv.Itoa(h.Visits)})
+	}
+	return records
+}
+
+func (r DormantReport) Header() []string {
+	return []string{"member_id", "first_name", "last_name"}
+}
+
+func (r DormantReport) Records() [][]string {
+	records := make([][]string, 0, len(r))
+	for _, m := range r {
+		records = append(records, []string{strconv.Itoa(int(m.MemberId)), m.FirstName, m.LastName})
+	}
+	return records
+}
+
+func WriteCSV(w io.Writer, r Report) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(r.Header()); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(r.Records()); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func WriteJSON(w io.Writer, r Report) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(r)
+}
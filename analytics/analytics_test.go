@@ -0,0 +1,120 @@
+package analytics
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+	"github.com/fatcatfablab/fcfl-member-sync/updater"
+	"github.com/miquelruiz/go-unifi-access-api/schema"
+)
+
+var (
+	m1 = types.ComparableMember{Id: 1, FirstName: "m1", Status: types.StatusActive}
+	m2 = types.ComparableMember{Id: 2, FirstName: "m2", Status: types.StatusActive}
+	m3 = types.ComparableMember{Id: 3, FirstName: "m3", Status: types.StatusActive}
+
+	// 2025-01-06 is a Monday
+	day1 = time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC).Unix()
+	day2 = time.Date(2025, 1, 7, 0, 0, 0, 0, time.UTC).Unix()
+	hour = int64(time.Hour.Seconds())
+
+	visits = []types.Visit{
+		{EventId: "e1", MemberId: 1, Timestamp: day1 + 18*hour},
+		{EventId: "e2", MemberId: 1, Timestamp: day1 + 19*hour},
+		{EventId: "e3", MemberId: 1, Timestamp: day2 + 18*hour},
+		{EventId: "e4", MemberId: 2, Timestamp: day2 + 10*hour},
+	}
+)
+
+func TestToVisits(t *testing.T) {
+	events := []updater.DoorEvent{
+		{Id: "e1", ActorId: "uaid1", Door: "front", Timestamp: 1, Granted: true},
+		{Id: "e2", ActorId: "uaid1", Door: "front", Timestamp: 2, Granted: false},
+		{Id: "e3", ActorId: "staff", Door: "front", Timestamp: 3, Granted: true},
+		{Id: "e4", ActorId: "uaid2", Door: "back", Timestamp: 4, Granted: true},
+	}
+	members := types.MemberMap{"uaid1": m1, "uaid2": m2}
+
+	want := []types.Visit{
+		{EventId: "e1", MemberId: 1, AccessId: "uaid1", Door: "front", Timestamp: 1},
+		{EventId: "e4", MemberId: 2, AccessId: "uaid2", Door: "back", Timestamp: 4},
+	}
+
+	if got := ToVisits(events, members); !slices.Equal(got, want) {
+		t.Errorf("unexpected visits. Got: %+v Want: %+v", got, want)
+	}
+}
+
+func TestWithArchived(t *testing.T) {
+	events := []updater.DoorEvent{
+		{Id: "e1", ActorId: "uaid1", Door: "front", Timestamp: 1, Granted: true},
+		{Id: "e2", ActorId: "archived3", Door: "front", Timestamp: 2, Granted: true},
+	}
+	archived := []types.ArchivedUser{
+		{MemberId: 3, AccessId: "archived3", User: schema.UserResponse{FirstName: "m3", Status: types.StatusDeactivated}},
+		// Users still in UniFi Access win over an archived copy
+		{MemberId: 1, AccessId: "uaid1", User: schema.UserResponse{FirstName: "old"}},
+	}
+	members := WithArchived(types.MemberMap{"uaid1": m1}, archived)
+
+	if members["uaid1"] != m1 || members["archived3"].Id != 3 || members["archived3"].FirstName != "m3" {
+		t.Errorf("unexpected members: %+v", members)
+	}
+
+	want := []types.Visit{
+		{EventId: "e1", MemberId: 1, AccessId: "uaid1", Door: "front", Timestamp: 1},
+		{EventId: "e2", MemberId: 3, AccessId: "archived3", Door: "front", Timestamp: 2},
+	}
+	if got := ToVisits(events, members); !slices.Equal(got, want) {
+		t.Errorf("unexpected visits. Got: %+v Want: %+v", got, want)
+	}
+}
+
+func TestVisitsPerMember(t *testing.T) {
+	got := VisitsPerMember(visits, types.NewMemberSet(m1, m2, m3), time.UTC)
+	want := MemberVisitsReport{
+		{MemberId: 1, FirstName: "m1", Visits: 3, Days: 2, LastVisit: "2025-01-07 18:00:00"},
+		{MemberId: 2, FirstName: "m2", Visits: 1, Days: 1, LastVisit: "2025-01-07 10:00:00"},
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("unexpected report. Got: %+v Want: %+v", got, want)
+	}
+}
+
+func TestPeakHours(t *testing.T) {
+	got := PeakHours(visits, time.UTC)
+	if len(got) != 24 {
+		t.Fatalf("expected 24 hours, got %d", len(got))
+	}
+
+	for h, want := range map[int]int{10: 1, 18: 2, 19: 1, 0: 0} {
+		if got[h].Visits != want {
+			t.Errorf("unexpected visits at %d: got %d want %d", h, got[h].Visits, want)
+		}
+	}
+}
+
+func TestDormant(t *testing.T) {
+	got := Dormant(visits, types.NewMemberSet(m1, m2, m3))
+	want := DormantReport{{MemberId: 3, FirstName: "m3"}}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("unexpected report. Got: %+v Want: %+v", got, want)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteCSV(&b, DormantReport{{MemberId: 3, FirstName: "m3", LastName: "de la Cruz"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "member_id,first_name,last_name\n3,m3,de la Cruz\n"
+	if b.String() != want {
+		t.Errorf("unexpected csv. Got: %q Want: %q", b.String(), want)
+	}
+}
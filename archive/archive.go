@@ -0,0 +1,217 @@
+// Package archive deletes from UniFi Access the users that have been
+// deactivated for longer than the retention period, keeping a local record
+// so they can be restored later.
+package archive
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+	"github.com/miquelruiz/go-unifi-access-api/schema"
+)
+
+type store interface {
+	Deactivations(ctx context.Context) ([]types.Deactivation, error)
+	ForgetDeactivation(ctx context.Context, accessId string) error
+	SaveArchivedUser(ctx context.Context, a types.ArchivedUser) error
+	FindArchivedUser(ctx context.Context, memberId int32) (*types.ArchivedUser, error)
+	DeleteArchivedUser(ctx context.Context, accessId string, archivedAt int64) error
+	RecordHistory(ctx context.Context, e audit.Entry) error
+}
+
+type uaAPI interface {
+	GetUser(ctx context.Context, accessId string) (*schema.UserResponse, error)
+	CreateUser(ctx context.Context, r schema.UserRequest) (*schema.UserResponse, error)
+	UpdateUser(ctx context.Context, accessId string, r schema.UserRequest) error
+	DeleteUser(ctx context.Context, accessId string) error
+	AssignNfcCard(ctx context.Context, accessId string, token string) error
+	AssignAccessPolicies(ctx context.Context, accessId string, policyIds []string) error
+}
+
+type claimer interface {
+	Claim(ctx context.Context, accessId string) error
+}
+
+type Archiver struct {
+	store     store
+	api       uaAPI
+	ownership claimer
+	dryRun    bool
+}
+
+func New(s store, api uaAPI, o claimer, dryRun bool) *Archiver {
+	return &Archiver{store: s, api: api, ownership: o, dryRun: dryRun}
+}
+
+// Candidates returns the users deactivated for longer than retention
+func (a *Archiver) Candidates(ctx context.Context, retention time.Duration, now time.Time) ([]types.Deactivation, error) {
+	deactivations, err := a.store.Deactivations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := now.Add(-retention).Unix()
+	var candidates []types.Deactivation
+	for _, d := range deactivations {
+		if d.Since < cutoff {
+			candidates = append(candidates, d)
+		}
+	}
+
+	return candidates, nil
+}
+
+// Archive saves a local copy of every candidate and deletes them from UniFi
+// Access. In dry-run mode it only logs what would be archived.
+func (a *Archiver) Archive(ctx context.Context, retention time.Duration, now time.Time) ([]types.ArchivedUser, error) {
+	candidates, err := a.Candidates(ctx, retention, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var archived []types.ArchivedUser
+	var reterror error
+	for _, c := range candidates {
+		if ctx.Err() != nil {
+			return archived, ctx.Err()
+		}
+
+		ar, err := a.archiveUser(ctx, c, now)
+		if err != nil {
+			slog.Error("Error archiving member", logging.KeyMemberId, c.MemberId, logging.Err(err))
+			reterror = err
+			continue
+		}
+		archived = append(archived, *ar)
+	}
+
+	return archived, reterror
+}
+
+func (a *Archiver) archiveUser(ctx context.Context, d types.Deactivation, now time.Time) (*types.ArchivedUser, error) {
+	slog.Info(
+		"Archiving member",
+		logging.KeyMemberId, d.MemberId,
+		logging.KeyAccessId, d.AccessId,
+		"deactivated_since", time.Unix(d.Since, 0).Format(time.DateOnly),
+		logging.KeyAction, "archive",
+		logging.KeyDryRun, a.dryRun,
+	)
+
+	user, err := a.api.GetUser(ctx, d.AccessId)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching user %q: %w", d.AccessId, err)
+	}
+
+	if user.Status != types.StatusDeactivated {
+		return nil, fmt.Errorf("user %q is %s, not archiving", d.AccessId, user.Status)
+	}
+
+	ar := types.ArchivedUser{
+		MemberId:      d.MemberId,
+		AccessId:      d.AccessId,
+		DeactivatedAt: d.Since,
+		ArchivedAt:    now.Unix(),
+		User:          *user,
+	}
+
+	if a.dryRun {
+		return &ar, nil
+	}
+
+	// Save first, so a user never gets deleted without a record to restore it
+	if err := a.store.SaveArchivedUser(ctx, ar); err != nil {
+		return nil, err
+	}
+
+	err = a.api.DeleteUser(ctx, d.AccessId)
+	a.record(ctx, d.MemberId, audit.ActionUAArchive, d.AccessId, "", err)
+	if err != nil {
+		return nil, fmt.Errorf("error deleting user %q: %w", d.AccessId, err)
+	}
+
+	return &ar, a.store.ForgetDeactivation(ctx, d.AccessId)
+}
+
+// Restore recreates the latest archived user of a member in UniFi Access,
+// deactivated and with their cards and access policies, and returns its new
+// access id.
+func (a *Archiver) Restore(ctx context.Context, memberId int32) (string, error) {
+	ar, err := a.store.FindArchivedUser(ctx, memberId)
+	if err != nil {
+		return "", err
+	}
+
+	if ar == nil {
+		return "", fmt.Errorf("member %d is not archived", memberId)
+	}
+
+	slog.Info("Restoring member", logging.KeyMemberId, memberId, logging.KeyAction, "restore", logging.KeyDryRun, a.dryRun)
+
+	if a.dryRun {
+		return "", nil
+	}
+
+	u := ar.User
+	employeeNumber := u.EmployeeNumber
+	req := schema.UserRequest{
+		FirstName:      u.FirstName,
+		LastName:       u.LastName,
+		EmployeeNumber: &employeeNumber,
+	}
+	if u.UserEmail != "" {
+		req.UserEmail = &u.UserEmail
+	}
+
+	created, err := a.api.CreateUser(ctx, req)
+	if err != nil {
+		a.record(ctx, memberId, audit.ActionUARestore, ar.AccessId, "", err)
+		return "", fmt.Errorf("error creating user: %w", err)
+	}
+
+	if err := a.ownership.Claim(ctx, created.Id); err != nil {
+		return "", err
+	}
+
+	// From here on the user exists, so keep going and report the first error
+	var reterror error
+	deactivated := types.StatusDeactivated
+	req.Status = &deactivated
+	if err := a.api.UpdateUser(ctx, created.Id, req); err != nil {
+		reterror = fmt.Errorf("error deactivating restored user: %w", err)
+	}
+
+	for _, card := range u.NfcCards {
+		if err := a.api.AssignNfcCard(ctx, created.Id, card.Token); err != nil && reterror == nil {
+			reterror = fmt.Errorf("error assigning card %q: %w", card.Id, err)
+		}
+	}
+
+	if len(u.AccessPolicyIds) > 0 {
+		if err := a.api.AssignAccessPolicies(ctx, created.Id, u.AccessPolicyIds); err != nil && reterror == nil {
+			reterror = fmt.Errorf("error assigning access policies: %w", err)
+		}
+	}
+
+	a.record(ctx, memberId, audit.ActionUARestore, ar.AccessId, created.Id, reterror)
+	if reterror != nil {
+		return created.Id, reterror
+	}
+
+	return created.Id, a.store.DeleteArchivedUser(ctx, ar.AccessId, ar.ArchivedAt)
+}
+
+// record adds a change to the history of the member, logging any failure to
+// do so since the change already happened.
+func (a *Archiver) record(ctx context.Context, memberId int32, action, old, new string, err error) {
+	e := audit.NewEntry(ctx, int64(memberId), action, old, new)
+	e.Outcome = audit.Outcome(err)
+	if err := a.store.RecordHistory(ctx, e); err != nil {
+		slog.Error("Error recording history", logging.KeyMemberId, memberId, logging.Err(err))
+	}
+}
@@ -0,0 +1,204 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/localdb"
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+	"github.com/fatcatfablab/fcfl-member-sync/updater"
+	"github.com/miquelruiz/go-unifi-access-api/schema"
+)
+
+var now = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+type fakeUA struct {
+	users    map[string]schema.UserResponse
+	cards    map[string][]string
+	policies map[string][]string
+	nextId   int
+}
+
+func newFakeUA(users ...schema.UserResponse) *fakeUA {
+	f := &fakeUA{
+		users:    make(map[string]schema.UserResponse),
+		cards:    make(map[string][]string),
+		policies: make(map[string][]string),
+	}
+	for _, u := range users {
+		f.users[u.Id] = u
+	}
+	return f
+}
+
+func (f *fakeUA) GetUser(_ context.Context, id string) (*schema.UserResponse, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &u, nil
+}
+
+func (f *fakeUA) CreateUser(_ context.Context, r schema.UserRequest) (*schema.UserResponse, error) {
+	f.nextId++
+	u := schema.UserResponse{
+		Id:             fmt.Sprintf("restored%d", f.nextId),
+		FirstName:      r.FirstName,
+		LastName:       r.LastName,
+		EmployeeNumber: *r.EmployeeNumber,
+		Status:         types.StatusActive,
+	}
+	f.users[u.Id] = u
+	return &u, nil
+}
+
+func (f *fakeUA) UpdateUser(_ context.Context, id string, r schema.UserRequest) error {
+	u := f.users[id]
+	if r.Status != nil {
+		u.Status = *r.Status
+	}
+	f.users[id] = u
+	return nil
+}
+
+func (f *fakeUA) DeleteUser(_ context.Context, id string) error {
+	delete(f.users, id)
+	return nil
+}
+
+func (f *fakeUA) AssignNfcCard(_ context.Context, id string, token string) error {
+	f.cards[id] = append(f.cards[id], token)
+	return nil
+}
+
+func (f *fakeUA) AssignAccessPolicies(_ context.Context, id string, ids []string) error {
+	f.policies[id] = ids
+	return nil
+}
+
+func setup(t *testing.T, dryRun bool) (*Archiver, *localdb.DB, *fakeUA) {
+	ctx := context.Background()
+	db, err := localdb.New(path.Join(t.TempDir(), "archive-test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ua := newFakeUA(
+		schema.UserResponse{
+			Id:              "uaid1",
+			FirstName:       "m1",
+			EmployeeNumber:  "1",
+			Status:          types.StatusDeactivated,
+			NfcCards:        []schema.NfcCard{{Id: "card1", Token: "token1"}},
+			AccessPolicyIds: []string{"policy1"},
+		},
+		schema.UserResponse{Id: "uaid2", FirstName: "m2", EmployeeNumber: "2", Status: types.StatusDeactivated},
+	)
+
+	// m1 was deactivated a year ago, m2 just a week ago
+	if err := db.TrackDeactivations(ctx, types.MemberMap{
+		"uaid1": {Id: 1, FirstName: "m1", Status: types.StatusDeactivated},
+	}, now.AddDate(-1, 0, 0).Unix()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.TrackDeactivations(ctx, types.MemberMap{
+		"uaid1": {Id: 1, FirstName: "m1", Status: types.StatusDeactivated},
+		"uaid2": {Id: 2, FirstName: "m2", Status: types.StatusDeactivated},
+	}, now.AddDate(0, 0, -7).Unix()); err != nil {
+		t.Fatal(err)
+	}
+
+	return New(db, ua, updater.AnyIdOwnership{}, dryRun), db, ua
+}
+
+func TestArchiveDryRun(t *testing.T) {
+	ctx := context.Background()
+	a, db, ua := setup(t, true)
+
+	archived, err := a.Archive(ctx, 90*24*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(archived) != 1 || archived[0].MemberId != 1 {
+		t.Errorf("unexpected dry-run report: %+v", archived)
+	}
+
+	if _, ok := ua.users["uaid1"]; !ok {
+		t.Error("user deleted in dry-run mode")
+	}
+
+	if ar, err := db.FindArchivedUser(ctx, 1); err != nil || ar != nil {
+		t.Errorf("user archived in dry-run mode: %+v %v", ar, err)
+	}
+}
+
+func TestArchiveAndRestore(t *testing.T) {
+	ctx := context.Background()
+	a, db, ua := setup(t, false)
+
+	archived, err := a.Archive(ctx, 90*24*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(archived) != 1 || archived[0].MemberId != 1 {
+		t.Fatalf("unexpected archived users: %+v", archived)
+	}
+
+	if _, ok := ua.users["uaid1"]; ok {
+		t.Error("archived user still in UniFi Access")
+	}
+	if _, ok := ua.users["uaid2"]; !ok {
+		t.Error("recently deactivated user got deleted")
+	}
+
+	deactivations, err := db.Deactivations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deactivations) != 1 || deactivations[0].AccessId != "uaid2" {
+		t.Errorf("unexpected deactivations after archiving: %+v", deactivations)
+	}
+
+	accessId, err := a.Restore(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := ua.users[accessId]
+	if restored.FirstName != "m1" || restored.EmployeeNumber != "1" || restored.Status != types.StatusDeactivated {
+		t.Errorf("unexpected restored user: %+v", restored)
+	}
+	if !slices.Equal(ua.cards[accessId], []string{"token1"}) {
+		t.Errorf("unexpected cards: %v", ua.cards[accessId])
+	}
+	if !slices.Equal(ua.policies[accessId], []string{"policy1"}) {
+		t.Errorf("unexpected access policies: %v", ua.policies[accessId])
+	}
+
+	if ar, err := db.FindArchivedUser(ctx, 1); err != nil || ar != nil {
+		t.Errorf("restored user still archived: %+v %v", ar, err)
+	}
+
+	if _, err := a.Restore(ctx, 1); err == nil {
+		t.Error("restoring a member twice should fail")
+	}
+
+	history, err := db.History(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 ||
+		history[0].Action != audit.ActionUAArchive || history[0].Old != "uaid1" ||
+		history[1].Action != audit.ActionUARestore || history[1].New != accessId {
+		t.Errorf("unexpected history: %+v", history)
+	}
+}
@@ -0,0 +1,165 @@
+// Package audit keeps an append-only history of what happened to each
+// member, and who made it happen, so disputes about lost access can be
+// answered.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/user"
+	"text/tabwriter"
+	"time"
+)
+
+// Actions recorded in the history
+const (
+	ActionCreate       = "create"
+	ActionDetails      = "details"
+	ActionNames        = "names"
+	ActionStatus       = "status"
+	ActionAccessId     = "access_id"
+	ActionSubscription = "subscription"
+	ActionDependent    = "dependent"
+	ActionMerge        = "merge"
+	ActionUAAdd        = "ua_add"
+	ActionUAUpdate     = "ua_update"
+	ActionUADisable    = "ua_disable"
+	ActionUAInvite     = "ua_invite"
+	ActionUARevoke     = "ua_revoke"
+	ActionUAArchive    = "ua_archive"
+	ActionUARestore    = "ua_restore"
+	ActionUAPolicy     = "ua_policy"
+)
+
+const (
+	OutcomeOK    = "ok"
+	unknownActor = "unknown"
+)
+
+type key int
+
+const (
+	actorKey key = iota
+	primaryMemberKey
+)
+
+// Entry is one change to a member. Outcome is only set for UniFi Access
+// actions: "ok", or the error they failed with.
+type Entry struct {
+	MemberId int64
+	At       int64
+	Actor    string
+	Action   string
+	Old      string
+	New      string
+	Outcome  string
+}
+
+// Recorder appends entries to the history
+type Recorder interface {
+	RecordHistory(ctx context.Context, e Entry) error
+}
+
+// WithActor makes the changes done with ctx be attributed to actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// Actor returns who the changes done with ctx are attributed to
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey).(string); ok {
+		return actor
+	}
+	return unknownActor
+}
+
+// WithPrimaryMember makes the changes done with ctx to a dependent be recorded
+// in the history of the member they depend on, which is the one looked up
+// and merged.
+func WithPrimaryMember(ctx context.Context, memberId int64) context.Context {
+	return context.WithValue(ctx, primaryMemberKey, memberId)
+}
+
+// PrimaryMember returns the member the dependent changed with ctx depends on,
+// if any
+func PrimaryMember(ctx context.Context) (int64, bool) {
+	memberId, ok := ctx.Value(primaryMemberKey).(int64)
+	return memberId, ok
+}
+
+// Event is the actor for changes made handling a Stripe webhook event
+func Event(eventId string) string {
+	return "event:" + eventId
+}
+
+// Reconcile is the actor for changes made by a sync run
+func Reconcile(runId string) string {
+	return "reconcile:" + runId
+}
+
+// Admin is the actor for changes made by hand
+func Admin(user string) string {
+	return "admin:" + user
+}
+
+// LocalAdmin is the actor for changes made by hand by the user running this
+// process
+func LocalAdmin() string {
+	if u, err := user.Current(); err == nil {
+		return Admin(u.Username)
+	}
+	return Admin(unknownActor)
+}
+
+// RunId identifies a sync run by when it started
+func RunId(start time.Time) string {
+	return start.UTC().Format("20060102T150405Z")
+}
+
+// Outcome is what's recorded for a UniFi Access action that returned err
+func Outcome(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return OutcomeOK
+}
+
+// NewEntry is an entry for a change made with ctx, happening now
+func NewEntry(ctx context.Context, memberId int64, action, old, new string) Entry {
+	return Entry{
+		MemberId: memberId,
+		At:       time.Now().Unix(),
+		Actor:    Actor(ctx),
+		Action:   action,
+		Old:      old,
+		New:      new,
+	}
+}
+
+// WriteTimeline prints the entries as a table, in the order given
+func WriteTimeline(w io.Writer, entries []Entry) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tACTOR\tACTION\tOLD\tNEW\tOUTCOME")
+	for _, e := range entries {
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\t%s\n",
+			time.Unix(e.At, 0).Format(time.DateTime),
+			e.Actor,
+			e.Action,
+			orDash(e.Old),
+			orDash(e.New),
+			orDash(e.Outcome),
+		)
+	}
+
+	return tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
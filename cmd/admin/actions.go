@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/household"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/names"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+	"github.com/fatcatfablab/fcfl-member-sync/sync"
+	uaTypes "github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+// resync makes the UniFi Access user of the only person matching query look
+// like the member source says, or the Stripe database when there's no member
+// source.
+func resync(ctx context.Context, query string) error {
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	people, err := s.find(ctx, query)
+	if err != nil {
+		return err
+	}
+	if len(people) != 1 {
+		return fmt.Errorf("%d people match %q, resync needs exactly one", len(people), query)
+	}
+	p := people[0]
+
+	if *addr != "" {
+		return resyncFromSource(ctx, s, p)
+	}
+
+	if p.stripe == nil {
+		return errors.New("no member source or Stripe record to resync from")
+	}
+	m := memberToComparableMember(*p.stripe)
+	active := p.stripe.Status == types.MemberStatusActive
+
+	switch {
+	case active && p.user == nil:
+		accessId, err := s.updater.AddMember(ctx, m)
+		if err != nil {
+			return err
+		}
+		if accessId != "" {
+			return s.db.UpdateMemberAccess(ctx, p.stripe.CustomerId, accessId)
+		}
+	case active:
+		return s.updater.UpdateMember(ctx, p.user.Id, m)
+	case p.user != nil && p.user.Status == uaTypes.StatusActive:
+		return s.updater.DisableMember(ctx, p.user.Id, m)
+	default:
+		slog.Info("Nothing to do", logging.KeyMemberId, m.Id)
+	}
+
+	return nil
+}
+
+// resyncFromSource fetches just this person from the member source and
+// reconciles their UniFi Access user the way the sync would.
+func resyncFromSource(ctx context.Context, s *systems, p *person) error {
+	var id int32
+	switch {
+	case p.source != nil:
+		id = p.source.Id
+	case p.user != nil:
+		m, err := userToMember(p)
+		if err != nil {
+			return err
+		}
+		id = m.Id
+	default:
+		return errors.New("no member source record or UniFi Access user to resync")
+	}
+
+	remote := uaTypes.NewMemberSet()
+	source, err := getSourceMember(ctx, id)
+	if err != nil {
+		return err
+	}
+	if source != nil {
+		remote.Add(uaTypes.ComparableMember{
+			Id:        source.Id,
+			FirstName: source.FirstName,
+			LastName:  source.LastName,
+			Status:    uaTypes.StatusActive,
+		})
+	}
+
+	local, err := s.updater.Member(ctx, id)
+	if err != nil {
+		return fmt.Errorf("error fetching UniFi Access users: %w", err)
+	}
+
+	return sync.ReconcileMember(ctx, id, remote, local, s.updater)
+}
+
+func setEnabled(ctx context.Context, accessId string, enabled bool) error {
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.api.GetUser(ctx, accessId)
+	if err != nil {
+		return fmt.Errorf("error fetching user %q: %w", accessId, err)
+	}
+
+	m, err := userToMember(&person{user: user})
+	if err != nil {
+		return err
+	}
+
+	if enabled {
+		return s.updater.UpdateMember(ctx, accessId, m)
+	}
+	return s.updater.DisableMember(ctx, accessId, m)
+}
+
+func relink(ctx context.Context, customerId string, accessId string) error {
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	if s.db == nil {
+		return errors.New("relink needs -dsn")
+	}
+
+	if _, err := s.api.GetUser(ctx, accessId); err != nil {
+		return fmt.Errorf("error fetching user %q: %w", accessId, err)
+	}
+
+	slog.Info(
+		"Relinking member",
+		logging.KeyCustomerId, customerId,
+		logging.KeyAccessId, accessId,
+		logging.KeyDryRun, *dryRun,
+	)
+	if *dryRun {
+		return nil
+	}
+
+	return s.db.UpdateMemberAccess(ctx, customerId, accessId)
+}
+
+// setNames overrides the names a Stripe member is shown with on the doors,
+// and updates their UniFi Access user if they have access. Empty names clear
+// the override.
+func setNames(ctx context.Context, customerId string, firstName string, lastName string, preferredName string) error {
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	if s.db == nil {
+		return errors.New("set-names needs -dsn")
+	}
+
+	slog.Info(
+		"Setting member names",
+		logging.KeyCustomerId, customerId,
+		logging.KeyFirstName, firstName,
+		logging.KeyLastName, lastName,
+		logging.KeyDryRun, *dryRun,
+	)
+	if *dryRun {
+		return nil
+	}
+
+	if err := s.db.SetMemberNames(ctx, customerId, firstName, lastName, preferredName); err != nil {
+		return err
+	}
+
+	return s.updateDoorUser(ctx, customerId)
+}
+
+// merge makes the Stripe member of the customer from part of the member of
+// the customer into, e.g. when someone comes back under a new customer with
+// the same email. The users left without a member are disabled, and the one
+// they end up with, along with their dependents', follows their status.
+func merge(ctx context.Context, from string, into string) error {
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	if s.db == nil {
+		return errors.New("merge needs -dsn")
+	}
+
+	slog.Info(
+		"Merging members",
+		logging.KeyCustomerId, from,
+		"into_customer_id", into,
+		logging.KeyDryRun, *dryRun,
+	)
+	if *dryRun {
+		return nil
+	}
+
+	orphans, err := s.db.MergeMember(ctx, from, into)
+	if err != nil {
+		return err
+	}
+
+	h := household.New(s.db, s.updater)
+	if m := orphans.Member; m != nil {
+		if err := s.updater.DisableMember(ctx, *m.AccessId, memberToComparableMember(*m)); err != nil {
+			return fmt.Errorf("error disabling user %q of %q: %w", *m.AccessId, from, err)
+		}
+	}
+	if err := h.Disable(ctx, orphans.Dependents); err != nil {
+		return err
+	}
+
+	if err := s.updateDoorUser(ctx, into); err != nil {
+		return err
+	}
+	m, err := s.db.FindMemberByCustomerId(ctx, into)
+	if err != nil {
+		return err
+	}
+	return h.Follow(ctx, *m)
+}
+
+// updateDoorUser makes the UniFi Access user of an active Stripe member show
+// their current names and member id.
+func (s *systems) updateDoorUser(ctx context.Context, customerId string) error {
+	m, err := s.db.FindMemberByCustomerId(ctx, customerId)
+	if err != nil {
+		return err
+	}
+	if m.Status != types.MemberStatusActive || m.AccessId == nil {
+		return nil
+	}
+
+	return s.updater.UpdateMember(ctx, *m.AccessId, memberToComparableMember(*m))
+}
+
+// memberToComparableMember builds the door user of a Stripe member
+func memberToComparableMember(m types.Member) uaTypes.ComparableMember {
+	firstName, lastName := names.ForDoor(m)
+	return uaTypes.ComparableMember{Id: int32(m.MemberId), FirstName: firstName, LastName: lastName}
+}
+
+// userToMember builds the member of a UniFi Access user, refusing users the
+// sync doesn't manage so they don't get an employee number overwritten.
+func userToMember(p *person) (uaTypes.ComparableMember, error) {
+	id, err := strconv.ParseInt(p.user.EmployeeNumber, 0, 32)
+	if err != nil {
+		return uaTypes.ComparableMember{}, fmt.Errorf("user %q has no numeric employee number", p.user.Id)
+	}
+
+	return uaTypes.ComparableMember{
+		Id:        int32(id),
+		FirstName: p.user.FirstName,
+		LastName:  p.user.LastName,
+		Status:    p.user.Status,
+	}, nil
+}
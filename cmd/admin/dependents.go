@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/household"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+// dependents prints the people covered by the family membership of a
+// Stripe member.
+func dependents(ctx context.Context, customerId string) error {
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	if s.db == nil {
+		return errors.New("dependents needs -dsn")
+	}
+
+	deps, err := s.db.Dependents(ctx, customerId)
+	if err != nil {
+		return err
+	}
+	if len(deps) == 0 {
+		fmt.Printf("%s has no dependents\n", customerId)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tEMAIL\tSOURCE\tACCESS ID")
+	for _, d := range deps {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", d.DependentId, d.Name, orDash(d.Email), d.Source, orDash(deref(d.AccessId)))
+	}
+	return w.Flush()
+}
+
+// addDependent adds someone to the family membership of a Stripe member, and
+// gives them a door user if the member has access.
+func addDependent(ctx context.Context, customerId string, name string, email string) error {
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	if s.db == nil {
+		return errors.New("add-dependent needs -dsn")
+	}
+
+	m, err := s.db.FindMemberByCustomerId(ctx, customerId)
+	if err != nil {
+		return err
+	}
+
+	slog.Info(
+		"Adding dependent",
+		logging.KeyCustomerId, customerId,
+		logging.KeyName, name,
+		logging.KeyEmail, email,
+		logging.KeyDryRun, *dryRun,
+	)
+	if *dryRun {
+		return nil
+	}
+
+	d := types.Dependent{Name: name, Email: email, Source: types.DependentSourceAdmin}
+	if err := s.db.SaveDependent(ctx, customerId, d); err != nil {
+		return err
+	}
+
+	return household.New(s.db, s.updater).Follow(ctx, *m)
+}
+
+// removeDependent takes someone out of the family membership of a Stripe
+// member, disabling their door user.
+func removeDependent(ctx context.Context, customerId string, dependentId string) error {
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	if s.db == nil {
+		return errors.New("remove-dependent needs -dsn")
+	}
+
+	id, err := strconv.ParseInt(dependentId, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid dependent id %q: %w", dependentId, err)
+	}
+
+	deps, err := s.db.Dependents(ctx, customerId)
+	if err != nil {
+		return err
+	}
+	for _, d := range deps {
+		if d.DependentId != id {
+			continue
+		}
+
+		if *dryRun {
+			slog.Info("Removing dependent", logging.KeyCustomerId, customerId, logging.KeyMemberId, id, logging.KeyDryRun, true)
+			return nil
+		}
+		return household.New(s.db, s.updater).Remove(ctx, customerId, d)
+	}
+
+	return fmt.Errorf("%s has no dependent %d", customerId, id)
+}
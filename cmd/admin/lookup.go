@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	pb "github.com/fatcatfablab/fcfl-member-sync/proto"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+	uaTypes "github.com/fatcatfablab/fcfl-member-sync/types"
+	"github.com/miquelruiz/go-unifi-access-api/schema"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// person is what each system knows about someone. Any of them can be nil.
+type person struct {
+	source *pb.Member
+	stripe *types.Member
+	user   *schema.UserResponse
+}
+
+// find returns everyone matching query in any system, with their records in
+// the other systems linked by member id and access id.
+func (s *systems) find(ctx context.Context, query string) ([]*person, error) {
+	users, err := s.api.ListUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing UniFi Access users: %w", err)
+	}
+	byAccessId := make(map[string]*schema.UserResponse)
+	byEmployeeNumber := make(map[string]*schema.UserResponse)
+	for i := range users {
+		byAccessId[users[i].Id] = &users[i]
+		if users[i].EmployeeNumber != "" {
+			byEmployeeNumber[users[i].EmployeeNumber] = &users[i]
+		}
+	}
+
+	var source []*pb.Member
+	if *addr != "" {
+		if source, err = getSourceMembers(ctx); err != nil {
+			return nil, fmt.Errorf("error listing source members: %w", err)
+		}
+	}
+	sourceById := make(map[string]*pb.Member)
+	for _, m := range source {
+		sourceById[strconv.Itoa(int(m.Id))] = m
+	}
+
+	var people []*person
+	seen := make(map[string]bool)
+	add := func(p *person) {
+		if p.user == nil && p.stripe != nil && p.stripe.AccessId != nil {
+			p.user = byAccessId[*p.stripe.AccessId]
+		}
+		if p.user == nil && p.source != nil {
+			p.user = byEmployeeNumber[strconv.Itoa(int(p.source.Id))]
+		}
+		if p.user != nil {
+			if seen[p.user.Id] {
+				return
+			}
+			seen[p.user.Id] = true
+
+			if p.source == nil {
+				p.source = sourceById[p.user.EmployeeNumber]
+			}
+			if p.stripe == nil {
+				p.stripe = s.stripeByAccessId(ctx, p.user.Id)
+			}
+		}
+		people = append(people, p)
+	}
+
+	if s.db != nil {
+		members, err := s.db.FindMembers(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for i := range members {
+			add(&person{stripe: &members[i]})
+		}
+	}
+
+	for _, m := range source {
+		if matches(query, strconv.Itoa(int(m.Id)), m.Email, m.FirstName+" "+m.LastName) {
+			add(&person{source: m})
+		}
+	}
+
+	for i := range users {
+		u := &users[i]
+		if matches(query, u.Id, u.EmployeeNumber, u.UserEmail, u.FirstName+" "+u.LastName) {
+			add(&person{user: u})
+		}
+	}
+
+	return people, nil
+}
+
+func (s *systems) stripeByAccessId(ctx context.Context, accessId string) *types.Member {
+	if s.db == nil {
+		return nil
+	}
+
+	members, err := s.db.FindMembers(ctx, accessId)
+	if err != nil {
+		return nil
+	}
+	for i := range members {
+		if members[i].AccessId != nil && *members[i].AccessId == accessId {
+			return &members[i]
+		}
+	}
+
+	return nil
+}
+
+// matches tells if query is one of the ids or emails, or part of the name,
+// which always comes last.
+func matches(query string, fields ...string) bool {
+	name := fields[len(fields)-1]
+	for _, f := range fields[:len(fields)-1] {
+		if f != "" && strings.EqualFold(f, query) {
+			return true
+		}
+	}
+
+	return strings.Contains(strings.ToLower(name), strings.ToLower(query))
+}
+
+func show(ctx context.Context, query string) error {
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	people, err := s.find(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	if len(people) == 0 {
+		fmt.Printf("Nobody matches %q\n", query)
+		return nil
+	}
+
+	for i, p := range people {
+		if i > 0 {
+			fmt.Println()
+		}
+		p.print()
+	}
+
+	return nil
+}
+
+// history prints the timeline of the only person matching query, as
+// recorded in the Stripe member database.
+func history(ctx context.Context, query string) error {
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	if s.db == nil {
+		return errors.New("history needs the Stripe member database")
+	}
+
+	people, err := s.find(ctx, query)
+	if err != nil {
+		return err
+	}
+	if len(people) != 1 {
+		return fmt.Errorf("%d people match %q, history needs exactly one", len(people), query)
+	}
+	p := people[0]
+	if p.stripe == nil {
+		return fmt.Errorf("%q has no Stripe record", query)
+	}
+
+	entries, err := s.db.History(ctx, p.stripe.MemberId)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Printf("No history for member %d\n", p.stripe.MemberId)
+		return nil
+	}
+
+	return audit.WriteTimeline(os.Stdout, entries)
+}
+
+func (p *person) print() {
+	var source, stripe, user [6]string
+	if p.source != nil {
+		source = [6]string{
+			strconv.Itoa(int(p.source.Id)),
+			p.source.FirstName + " " + p.source.LastName,
+			p.source.Email,
+			"active",
+		}
+	}
+	if p.stripe != nil {
+		stripe = [6]string{
+			strconv.FormatInt(p.stripe.MemberId, 10),
+			p.stripe.Name,
+			p.stripe.Email,
+			p.stripe.Status,
+			deref(p.stripe.AccessId),
+			p.stripe.CustomerId,
+		}
+	}
+	if p.user != nil {
+		user = [6]string{
+			p.user.EmployeeNumber,
+			p.user.FirstName + " " + p.user.LastName,
+			p.user.UserEmail,
+			p.user.Status,
+			p.user.Id,
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\tSOURCE\tSTRIPE\tUNIFI ACCESS")
+	for i, field := range []string{"member id", "name", "email", "status", "access id", "customer id"} {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", field, orDash(source[i]), orDash(stripe[i]), orDash(user[i]))
+	}
+	if p.user != nil {
+		fmt.Fprintf(w, "nfc cards\t\t\t%d\n", len(p.user.NfcCards))
+	}
+	w.Flush()
+
+	for _, problem := range p.problems() {
+		fmt.Println("! " + problem)
+	}
+}
+
+// problems lists the likely reasons someone can't get in
+func (p *person) problems() []string {
+	var problems []string
+	enabled := p.user != nil && p.user.Status == uaTypes.StatusActive
+
+	if p.source != nil && p.user == nil {
+		problems = append(problems, "in the member source but has no UniFi Access user")
+	}
+	if p.source != nil && p.user != nil && !enabled {
+		problems = append(problems, "in the member source but disabled in UniFi Access")
+	}
+	if p.source == nil && *addr != "" && enabled {
+		problems = append(problems, "enabled in UniFi Access but not in the member source")
+	}
+
+	if p.stripe != nil {
+		stripeActive := p.stripe.Status == types.MemberStatusActive
+		switch {
+		case stripeActive && p.user == nil:
+			problems = append(problems, "active in Stripe but has no UniFi Access user")
+		case stripeActive && !enabled:
+			problems = append(problems, "active in Stripe but disabled in UniFi Access")
+		case !stripeActive && enabled:
+			problems = append(problems, "not active in Stripe but enabled in UniFi Access")
+		}
+	}
+
+	if enabled && len(p.user.NfcCards) == 0 {
+		problems = append(problems, "has no NFC card")
+	}
+
+	return problems
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func orDash(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
+
+func getSourceMembers(ctx context.Context) ([]*pb.Member, error) {
+	conn, err := createMembershipConn()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect: %w", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	members, err := pb.NewMembershipClient(conn).List(ctx, &pb.Empty{})
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	return members.Members, nil
+}
+
+// getSourceMember returns nil when the member source doesn't have a current
+// member with the given id.
+func getSourceMember(ctx context.Context, id int32) (*pb.Member, error) {
+	conn, err := createMembershipConn()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect: %w", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	m, err := pb.NewMembershipClient(conn).GetMember(ctx, &pb.MemberRequest{Id: id})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	return m, nil
+}
+
+func createMembershipConn() (*grpc.ClientConn, error) {
+	cert, err := tls.LoadX509KeyPair(*crt, *key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client cert: %w", err)
+	}
+
+	certPool := x509.NewCertPool()
+	caBytes, err := os.ReadFile(*ca)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ca %q: %w", *ca, err)
+	}
+	if !certPool.AppendCertsFromPEM(caBytes) {
+		return nil, fmt.Errorf("failed to parse %q", *ca)
+	}
+
+	serverUrl := url.URL{Host: *addr}
+	tlsConfig := &tls.Config{
+		ServerName:   serverUrl.Hostname(),
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      certPool,
+	}
+
+	return grpc.NewClient(serverUrl.Host, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+}
@@ -0,0 +1,206 @@
+// Command admin looks people up across the member source, the Stripe member
+// database and UniFi Access, and fixes them one at a time.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/db"
+	"github.com/fatcatfablab/fcfl-member-sync/updater"
+	"github.com/fatcatfablab/fcfl-member-sync/version"
+)
+
+var (
+	addr = flag.String("addr", os.Getenv("FCFL_CRM_ADDR"), "Address of the member source. Skipped when empty")
+	crt  = flag.String("crt", "certs/client.crt", "Path to the client certificate")
+	key  = flag.String("key", "certs/client.key", "Path to the client private key")
+	ca   = flag.String("ca", "certs/root_ca.crt", "Path to the CA root certificate")
+
+	dsn = flag.String("dsn", os.Getenv("WEBHOOK_DSN"), "Stripe member database connection string: a MySQL DSN, or sqlite:///path/to.db. Skipped when empty")
+
+	uaHost  = flag.String("uaHost", os.Getenv("UA_HOST"), "Hostname or IP of the UniFi Access endpoint")
+	uaToken = flag.String("token", os.Getenv("UA_TOKEN"), "Auth token for the UniFi Access API")
+
+	managedGroup = flag.String("managed-group", "", "Only manage the UniFi Access users in this user group id. Must match the sync's")
+	managedIds   = flag.String("managed-ids", "", "Only manage the UniFi Access users with an employee number in this range, e.g. 1-99999. Must match the sync's")
+
+	dryRun      = flag.Bool("dry-run", false, "Do not actually make any changes")
+	versionflag = flag.Bool("version", false, "Print the version and exit")
+)
+
+const usage = `Usage: admin [flags] COMMAND ARGS
+
+Commands:
+  show QUERY                   Show everyone matching QUERY in every system.
+                               QUERY is a name, email, Stripe customer id,
+                               member id or UniFi Access id
+  resync QUERY                 Make UniFi Access match the member source, or
+                               the Stripe database, for the one person
+                               matching QUERY
+  disable ACCESS_ID            Disable a UniFi Access user
+  enable ACCESS_ID             Re-enable a UniFi Access user
+  relink CUSTOMER_ID ACCESS_ID Point a Stripe member to another UniFi Access user
+  set-names CUSTOMER_ID FIRST LAST [PREFERRED]
+                               Override the names a Stripe member is shown
+                               with on the doors. Empty names, as in "", clear
+                               the override
+  merge FROM_CUSTOMER_ID INTO_CUSTOMER_ID
+                               Merge a Stripe member into another one, e.g.
+                               the same person under a new customer, moving
+                               their history. A UniFi Access user left over
+                               when both had one is disabled
+  history QUERY                Show everything done to the one person matching
+                               QUERY, as recorded in the Stripe database
+  dependents CUSTOMER_ID       List the dependents of a Stripe member
+  add-dependent CUSTOMER_ID NAME [EMAIL]
+                               Add a dependent to the family membership of a
+                               Stripe member, who gets access whenever the
+                               member has it
+  remove-dependent CUSTOMER_ID DEPENDENT_ID
+                               Remove a dependent and disable their UniFi
+                               Access user
+
+Flags:
+`
+
+func main() {
+	var logOpts logging.Options
+	logOpts.RegisterFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := logging.Setup(logOpts); err != nil {
+		logging.Fatal("Error setting up logging", logging.Err(err))
+	}
+
+	if *versionflag {
+		version.PrintVersion()
+		return
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	ctx = audit.WithActor(ctx, audit.LocalAdmin())
+
+	var err error
+	switch cmd, args := args[0], args[1:]; {
+	case cmd == "show" && len(args) == 1:
+		err = show(ctx, args[0])
+	case cmd == "resync" && len(args) == 1:
+		err = resync(ctx, args[0])
+	case cmd == "disable" && len(args) == 1:
+		err = setEnabled(ctx, args[0], false)
+	case cmd == "enable" && len(args) == 1:
+		err = setEnabled(ctx, args[0], true)
+	case cmd == "relink" && len(args) == 2:
+		err = relink(ctx, args[0], args[1])
+	case cmd == "set-names" && (len(args) == 3 || len(args) == 4):
+		err = setNames(ctx, args[0], args[1], args[2], strings.Join(args[3:], ""))
+	case cmd == "merge" && len(args) == 2:
+		err = merge(ctx, args[0], args[1])
+	case cmd == "history" && len(args) == 1:
+		err = history(ctx, args[0])
+	case cmd == "dependents" && len(args) == 1:
+		err = dependents(ctx, args[0])
+	case cmd == "add-dependent" && (len(args) == 2 || len(args) == 3):
+		err = addDependent(ctx, args[0], args[1], strings.Join(args[2:], ""))
+	case cmd == "remove-dependent" && len(args) == 2:
+		err = removeDependent(ctx, args[0], args[1])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		logging.Fatal("Command failed", "command", args[0], logging.Err(err))
+	}
+}
+
+type systems struct {
+	api     *updater.API
+	updater *updater.UAUpdater
+	db      *db.DB
+}
+
+// connect sets up the UniFi Access client and, when -dsn is given, the
+// database. The schema is left alone: it must already be at the version of
+// this binary, as only the listener and "webhook migrate up" migrate it.
+func connect(ctx context.Context) (*systems, error) {
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		},
+	}
+	api, err := updater.NewAPI(*uaHost, *uaToken, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("error creating UniFi Access API client: %w", err)
+	}
+
+	ownership, err := newOwnership(api)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &systems{
+		api:     api,
+		updater: updater.New(api, *dryRun),
+	}
+	s.updater.SetOwnership(ownership)
+
+	if *dsn != "" {
+		if s.db, err = db.Open(*dsn); err != nil {
+			return nil, fmt.Errorf("error connecting to database: %w", err)
+		}
+		version, err := s.db.SchemaVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if version != db.LatestVersion() {
+			return nil, fmt.Errorf("database schema is at version %d but this binary expects %d", version, db.LatestVersion())
+		}
+		s.updater.SetHistory(s.db)
+	}
+
+	return s, nil
+}
+
+// newOwnership tells the users the sync manages apart the same way the client
+// does, so the users created here get managed by it.
+func newOwnership(api *updater.API) (updater.Ownership, error) {
+	switch {
+	case *managedGroup != "" && *managedIds != "":
+		return nil, errors.New("-managed-group and -managed-ids are mutually exclusive")
+	case *managedGroup != "":
+		return updater.NewGroupOwnership(api, *managedGroup), nil
+	case *managedIds != "":
+		o, err := updater.ParseIdRange(*managedIds)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -managed-ids: %w", err)
+		}
+		return o, nil
+	}
+
+	return updater.AnyIdOwnership{}, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/archive"
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/localdb"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+)
+
+const archiveUsage = `Usage: %s [flags] archive <run|list|restore MEMBER_ID> [archive flags]
+
+run      deletes from UniFi Access the users deactivated for longer than the
+         retention period, after saving them locally. Use -dry-run to only
+         report which users would be archived
+list     prints the archived users
+restore  recreates the latest archived user of a member in UniFi Access
+
+run and restore take the -lock, so they don't run alongside a sync or the
+daemon.
+
+`
+
+// runArchive implements the archive subcommand
+func runArchive(args []string) {
+	fs := flag.NewFlagSet("archive", flag.ExitOnError)
+	days := fs.Int("days", 365, "Retention period, in days, for deactivated users")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), archiveUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	fs.Parse(args[1:])
+
+	// Archiving and restoring change UniFi Access, like a sync does
+	if args[0] != "list" {
+		lock := acquireLock()
+		defer lock.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	state, err := localdb.New(*stateDb)
+	if err != nil {
+		logging.Fatal("Error opening local state", logging.Err(err))
+	}
+	defer state.Close()
+
+	api := newAPI()
+	archiver := archive.New(state, api, newOwnership(api), *dryRun)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	switch args[0] {
+	case "run":
+		ctx := audit.WithActor(ctx, audit.Reconcile(audit.RunId(time.Now())))
+		archived, err := archiver.Archive(ctx, time.Duration(*days)*24*time.Hour, time.Now())
+		fmt.Fprintln(w, "MEMBER ID\tNAME\tDEACTIVATED")
+		for _, a := range archived {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", a.MemberId, a.User.FullName, formatDate(a.DeactivatedAt))
+		}
+		w.Flush()
+		if err != nil {
+			logging.Fatal("Error archiving users", logging.Err(err))
+		}
+
+	case "list":
+		archived, err := state.ArchivedUsers(ctx)
+		if err != nil {
+			logging.Fatal("Error listing archived users", logging.Err(err))
+		}
+		fmt.Fprintln(w, "MEMBER ID\tNAME\tDEACTIVATED\tARCHIVED")
+		for _, a := range archived {
+			fmt.Fprintf(
+				w,
+				"%d\t%s\t%s\t%s\n",
+				a.MemberId,
+				a.User.FullName,
+				formatDate(a.DeactivatedAt),
+				formatDate(a.ArchivedAt),
+			)
+		}
+
+	case "restore":
+		id, err := strconv.ParseInt(fs.Arg(0), 10, 32)
+		if err != nil {
+			logging.Fatal("Invalid member id", "arg", fs.Arg(0))
+		}
+		accessId, err := archiver.Restore(audit.WithActor(ctx, audit.LocalAdmin()), int32(id))
+		if err != nil {
+			logging.Fatal("Error restoring member", logging.KeyMemberId, id, logging.Err(err))
+		}
+		slog.Info("Member restored", logging.KeyMemberId, id, logging.KeyAccessId, accessId)
+
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+func formatDate(ts int64) string {
+	return time.Unix(ts, 0).Format(time.DateOnly)
+}
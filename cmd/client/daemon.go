@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+)
+
+// runDaemon syncs every -interval until ctx is done, and right away on
+// SIGUSR1. A sync in progress when ctx is done gets cancelled, leaving the
+// changes it didn't get to for the next start.
+func runDaemon(ctx context.Context, s *syncer) {
+	if *interval <= 0 {
+		logging.Fatal("-interval must be positive", "interval", *interval)
+	}
+
+	trigger := make(chan os.Signal, 1)
+	signal.Notify(trigger, syscall.SIGUSR1)
+	defer signal.Stop(trigger)
+
+	slog.Info("Starting daemon", "interval", *interval, "jitter", *jitter)
+	failures := 0
+	for {
+		err := s.run(ctx)
+		if ctx.Err() != nil {
+			slog.Info("Stopping daemon", logging.Err(err))
+			return
+		}
+		if err != nil {
+			failures++
+			slog.Error("Error syncing members", "failures", failures, logging.Err(err))
+		} else {
+			failures = 0
+		}
+
+		wait := nextSync(failures)
+		slog.Debug("Waiting for the next sync", "wait", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			slog.Info("Stopping daemon")
+			return
+		case <-trigger:
+			timer.Stop()
+			slog.Info("Sync triggered by SIGUSR1")
+		case <-timer.C:
+		}
+	}
+}
+
+// nextSync tells how long to wait for the next sync. After failures it backs
+// off exponentially, up to -max-backoff, so an unreachable server or UniFi
+// Access doesn't get hammered.
+func nextSync(failures int) time.Duration {
+	wait := *interval
+	for i := 0; i < failures && wait < *maxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, max(*maxBackoff, *interval))
+
+	if *jitter > 0 {
+		wait += rand.N(*jitter)
+	}
+
+	return wait
+}
+
+// acquireLock makes sure only one sync runs at a time, exiting when another
+// one holds the lock. The lock is released when the returned file is closed
+// or the process exits.
+func acquireLock() *os.File {
+	f, err := os.OpenFile(*lockFile, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		logging.Fatal("Error opening lock file", "path", *lockFile, logging.Err(err))
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		logging.Fatal("Another sync is already running", "path", *lockFile, logging.Err(err))
+	}
+
+	// Only informative, the lock is what matters
+	f.Truncate(0)
+	fmt.Fprintf(f, "%d\n", os.Getpid())
+
+	return f
+}
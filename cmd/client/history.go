@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/localdb"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+)
+
+const historyUsage = `Usage: %s [flags] history <id>
+
+Prints everything the sync did in UniFi Access to the member with the given
+id, oldest first.
+`
+
+// runHistory implements the history subcommand
+func runHistory(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, historyUsage, os.Args[0])
+		os.Exit(2)
+	}
+
+	id, err := strconv.ParseInt(args[0], 10, 32)
+	if err != nil {
+		logging.Fatal("Invalid member id", "id", args[0])
+	}
+
+	state, err := localdb.New(*stateDb)
+	if err != nil {
+		logging.Fatal("Error opening local state", logging.Err(err))
+	}
+	defer state.Close()
+
+	entries, err := state.History(context.Background(), id)
+	if err != nil {
+		logging.Fatal("Error getting history", logging.KeyMemberId, id, logging.Err(err))
+	}
+
+	if len(entries) == 0 {
+		fmt.Printf("No history for member %d\n", id)
+		return
+	}
+	if err := audit.WriteTimeline(os.Stdout, entries); err != nil {
+		logging.Fatal("Error printing history", logging.Err(err))
+	}
+}
@@ -4,22 +4,29 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/localdb"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/metrics"
+	"github.com/fatcatfablab/fcfl-member-sync/notify"
+	"github.com/fatcatfablab/fcfl-member-sync/overrides"
 	pb "github.com/fatcatfablab/fcfl-member-sync/proto"
 	"github.com/fatcatfablab/fcfl-member-sync/sync"
 	"github.com/fatcatfablab/fcfl-member-sync/types"
 	"github.com/fatcatfablab/fcfl-member-sync/updater"
 	"github.com/fatcatfablab/fcfl-member-sync/version"
-	ua "github.com/miquelruiz/go-unifi-access-api"
-
 	"github.com/samber/lo"
 
 	"google.golang.org/grpc"
@@ -35,71 +42,340 @@ var (
 	uaHost  = flag.String("uaHost", os.Getenv("UA_HOST"), "Hostname or IP of the UniFi Access endpoint")
 	uaToken = flag.String("token", os.Getenv("UA_TOKEN"), "Auth token for the UniFi Access API")
 
+	stateDb       = flag.String("state-db", "state.sqlite", "Path to the local state database")
+	mobileInvites = flag.Bool("mobile-invites", false, "Invite new members to set up a UniFi Access mobile credential")
+	reinvite      = flag.Int("reinvite", 0, "Send the mobile credential invitation again to the given member id and exit")
+
+	overridesFile = flag.String("overrides", "", "Path to a JSON file with access overrides for guests and exceptions")
+
+	managedGroup = flag.String("managed-group", "", "Only manage the UniFi Access users in this user group id")
+	managedIds   = flag.String("managed-ids", "", "Only manage the UniFi Access users with an employee number in this range, e.g. 1-99999. Members outside it are never added")
+
+	notifyConfig = flag.String("notify", "", "Path to a JSON file with the notification sinks")
+	maxDisable   = flag.Int("max-disable", 0, "Refuse to disable more than this many members in a single run. 0 means no limit")
+
+	sourceTimeout    = flag.Duration("source-timeout", time.Second, "How long to wait for the member source. 0 means no limit")
+	uaTimeout        = flag.Duration("ua-timeout", 30*time.Second, "How long listing the UniFi Access users may take. 0 means no limit")
+	reconcileTimeout = flag.Duration("reconcile-timeout", 5*time.Minute, "How long applying the changes to UniFi Access may take. 0 means no limit")
+
+	metricsAddr = flag.String("metrics-addr", "", "Address to serve Prometheus metrics on. Disabled when empty")
+
+	daemon     = flag.Bool("daemon", false, "Keep running, syncing every -interval, instead of syncing once")
+	interval   = flag.Duration("interval", time.Minute, "How often the daemon syncs")
+	jitter     = flag.Duration("jitter", 10*time.Second, "Up to how much time to randomly add to -interval")
+	maxBackoff = flag.Duration("max-backoff", 30*time.Minute, "Longest the daemon waits between syncs when they keep failing")
+	lockFile   = flag.String("lock", "sync.lock", "Path to the lock file that keeps two syncs from running at once")
+
 	dryRun      = flag.Bool("dry-run", false, "Do not actually make any changes")
 	versionflag = flag.Bool("version", false, "Print the version and exit")
 )
 
 func main() {
+	var logOpts logging.Options
+	logOpts.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
+	if err := logging.Setup(logOpts); err != nil {
+		logging.Fatal("Error setting up logging", logging.Err(err))
+	}
+
 	if *versionflag {
 		version.PrintVersion()
 		return
 	}
 
-	uaClient, err := ua.NewWithHttpClient(*uaHost, *uaToken, &http.Client{
-		Transport: &http.Transport{
+	if *metricsAddr != "" {
+		metrics.Serve(*metricsAddr)
+	}
+
+	switch flag.Arg(0) {
+	case "":
+		runSync()
+	case "member":
+		runMemberSync(flag.Args()[1:])
+	case "visits":
+		runVisits(flag.Args()[1:])
+	case "archive":
+		runArchive(flag.Args()[1:])
+	case "history":
+		runHistory(flag.Args()[1:])
+	default:
+		logging.Fatal("Unknown command", "command", flag.Arg(0))
+	}
+}
+
+func newAPI() *updater.API {
+	httpClient := &http.Client{
+		Transport: metrics.InstrumentTransport(&http.Transport{
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: true,
 			},
-		},
-	})
+		}),
+	}
+	api, err := updater.NewAPI(*uaHost, *uaToken, httpClient)
 	if err != nil {
-		log.Fatalf("error creating UniFi Access API client: %s", err)
+		logging.Fatal("Error creating UniFi Access API client", logging.Err(err))
 	}
-	uniFiUpdater := updater.New(uaClient, *dryRun)
 
-	remoteMembers, err := getRemoteMembers()
+	return api
+}
+
+// syncer has everything a sync needs, so the daemon sets it up only once
+type syncer struct {
+	updater  *updater.UAUpdater
+	state    *localdb.DB
+	notifier *notify.Notifier
+	conn     *grpc.ClientConn
+	members  pb.MembershipClient
+}
+
+func newSyncer() *syncer {
+	api := newAPI()
+	uniFiUpdater := updater.New(api, *dryRun)
+	uniFiUpdater.SetOwnership(newOwnership(api))
+
+	state, err := localdb.New(*stateDb)
 	if err != nil {
-		log.Fatalf("error getting remote members: %s", err)
+		logging.Fatal("Error opening local state", logging.Err(err))
+	}
+
+	uniFiUpdater.SetHistory(state)
+	if *mobileInvites || *reinvite != 0 {
+		uniFiUpdater.EnableInvitations(state)
 	}
 
-	localMembers, err := uniFiUpdater.List()
+	var n *notify.Notifier
+	if *notifyConfig != "" {
+		if n, err = notify.Load(*notifyConfig, "client"); err != nil {
+			logging.Fatal("Error loading notifications config", logging.Err(err))
+		}
+		uniFiUpdater.SetNotifier(n)
+	}
+
+	conn, err := createMembershipConn()
 	if err != nil {
-		log.Fatalf("error getting local members: %s", err)
+		logging.Fatal("Error connecting to the member source", logging.Err(err))
 	}
 
-	if err = sync.Reconcile(remoteMembers, localMembers, uniFiUpdater); err != nil {
-		log.Fatalf("error reconciling local members list: %s", err)
+	return &syncer{
+		updater:  uniFiUpdater,
+		state:    state,
+		notifier: n,
+		conn:     conn,
+		members:  pb.NewMembershipClient(conn),
 	}
 }
 
-func getRemoteMembers() (types.MemberSet, error) {
-	conn, err := createMembershipConn()
+func (s *syncer) Close() {
+	s.conn.Close()
+	s.state.Close()
+}
+
+func runSync() {
+	lock := acquireLock()
+	defer lock.Close()
+
+	s := newSyncer()
+	defer s.Close()
+
+	// Stopping cancels whatever UniFi Access or member source call is in
+	// flight. The changes already made stay, and the next sync picks up
+	// the rest.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	if *reinvite != 0 {
+		ctx := audit.WithActor(ctx, audit.LocalAdmin())
+		_, localMembers, emails, err := s.loadMembers(ctx)
+		if err == nil {
+			err = reinviteMember(ctx, s.updater, localMembers, emails, int32(*reinvite))
+		}
+		if err != nil {
+			logging.Fatal("Error re-sending invitation", logging.Err(err))
+		}
+		return
+	}
+
+	if *daemon {
+		runDaemon(ctx, s)
+		return
+	}
+
+	if err := s.run(ctx); err != nil {
+		logging.Fatal("Error syncing members", logging.Err(err))
+	}
+}
+
+// run does a full sync, reporting how it went
+func (s *syncer) run(ctx context.Context) error {
+	ctx = audit.WithActor(ctx, audit.Reconcile(audit.RunId(time.Now())))
+	remoteMembers, localMembers, _, err := s.loadMembers(ctx)
+	if err == nil {
+		err = sync.Stage(ctx, "reconcile", *reconcileTimeout, func(ctx context.Context) error {
+			return reconcileMembers(ctx, s.updater, s.state, remoteMembers, localMembers)
+		})
+	}
+
+	if !*dryRun {
+		// Record how it went even when the sync got cancelled
+		reportSync(context.WithoutCancel(ctx), s.notifier, s.state, err)
+	}
+	if ferr := s.notifier.Flush(); ferr != nil {
+		slog.Error("Error sending notifications", logging.Err(ferr))
+	}
+
+	return err
+}
+
+func (s *syncer) loadMembers(ctx context.Context) (types.MemberSet, types.MemberMap, map[int32]string, error) {
+	u := s.updater
+	remoteMembers, emails, err := getRemoteMembers(ctx, s.members)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error getting remote members: %w", err)
+	}
+	u.SetEmails(emails)
+
+	if *overridesFile != "" {
+		o, err := overrides.Load(*overridesFile)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error loading overrides: %w", err)
+		}
+		remoteMembers = overrides.Apply(remoteMembers, o, time.Now())
+	}
+
+	var localMembers types.MemberMap
+	err = sync.Stage(ctx, "listing UniFi Access users", *uaTimeout, func(ctx context.Context) error {
+		localMembers, err = u.List(ctx)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("couldn't connect: %w", err)
+		return nil, nil, nil, fmt.Errorf("error getting local members: %w", err)
+	}
+
+	return remoteMembers, localMembers, emails, nil
+}
+
+func reconcileMembers(ctx context.Context, u *updater.UAUpdater, state *localdb.DB, remote types.MemberSet, local types.MemberMap) error {
+	remote, collisions := sync.SkipCollisions(remote, local, u.Unmanaged())
+	for accessId, m := range collisions {
+		slog.Warn(
+			"Unmanaged user has the id of a managed member, leaving it alone",
+			logging.KeyAccessId, accessId,
+			logging.Inline(m),
+		)
+	}
+
+	// UniFi Access doesn't record when users got deactivated, which is needed
+	// to archive them after the retention period. Nothing is tracked in dry-run
+	// mode, like nothing is changed.
+	if !*dryRun {
+		if err := state.TrackDeactivations(ctx, local, time.Now().Unix()); err != nil {
+			slog.Error("Error tracking deactivations", logging.Err(err))
+		}
+	}
+
+	if err := sync.ReconcileWithLimit(ctx, remote, local, u, *maxDisable); err != nil {
+		return fmt.Errorf("error reconciling local members list: %w", err)
+	}
+
+	return nil
+}
+
+// reportSync keeps count of consecutive failed syncs and queues a
+// notification about them, backing off exponentially so a sync that's broken
+// for hours doesn't send one every minute.
+func reportSync(ctx context.Context, n *notify.Notifier, state *localdb.DB, err error) {
+	if serr := state.SaveLastSync(ctx, time.Now().Unix(), err); serr != nil {
+		slog.Error("Error recording sync result", logging.Err(serr))
+	}
+
+	if err == nil {
+		failures, serr := state.RecordSyncSuccess(ctx)
+		if serr != nil {
+			slog.Error("Error recording sync status", logging.Err(serr))
+		} else if failures > 0 {
+			slog.Info("Sync recovered", "failures", failures)
+		}
+		return
 	}
-	defer conn.Close()
-	mClient := pb.NewMembershipClient(conn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
-	remoteMembers, err := mClient.List(ctx, &pb.Empty{})
+	failures, serr := state.RecordSyncFailure(ctx, time.Now().Unix())
+	if serr != nil {
+		slog.Error("Error recording sync status", logging.Err(serr))
+		failures = 1
+	}
+
+	if !notify.Alert(failures) {
+		return
+	}
+
+	class := notify.ClassSyncFailure
+	if errors.Is(err, sync.ErrTooManyDisables) {
+		class = notify.ClassGuardTripped
+	}
+	n.Notify(notify.Event{
+		Class:   class,
+		Message: fmt.Sprintf("Sync failed %d times in a row: %s", failures, err),
+	})
+}
+
+func newOwnership(api *updater.API) updater.Ownership {
+	switch {
+	case *managedGroup != "" && *managedIds != "":
+		logging.Fatal("-managed-group and -managed-ids are mutually exclusive")
+	case *managedGroup != "":
+		return updater.NewGroupOwnership(api, *managedGroup)
+	case *managedIds != "":
+		o, err := updater.ParseIdRange(*managedIds)
+		if err != nil {
+			logging.Fatal("Invalid -managed-ids", logging.Err(err))
+		}
+		return o
+	}
+
+	return updater.AnyIdOwnership{}
+}
+
+func reinviteMember(ctx context.Context, u *updater.UAUpdater, local types.MemberMap, emails map[int32]string, id int32) error {
+	for accessId, m := range local {
+		if m.Id == id {
+			return u.InviteMember(ctx, accessId, m, emails[id])
+		}
+	}
+
+	return fmt.Errorf("member %d not found in UniFi Access", id)
+}
+
+func getRemoteMembers(ctx context.Context, mClient pb.MembershipClient) (types.MemberSet, map[int32]string, error) {
+	var remoteMembers *pb.MemberList
+	err := sync.Stage(ctx, "member source", *sourceTimeout, func(ctx context.Context) (err error) {
+		remoteMembers, err = mClient.List(ctx, &pb.Empty{})
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	emails := make(map[int32]string)
+	for _, m := range remoteMembers.Members {
+		if m.Email != "" {
+			emails[m.Id] = m.Email
+		}
 	}
 
 	return mapset.NewSet(lo.Map(
 		remoteMembers.Members,
-		func(m *pb.Member, _ int) types.ComparableMember {
-			return types.ComparableMember{
-				Id:        m.Id,
-				FirstName: m.FirstName,
-				LastName:  m.LastName,
-				Status:    types.StatusActive, // remote members are always ACTIVE
-			}
-		},
-	)...), nil
+		func(m *pb.Member, _ int) types.ComparableMember { return toMember(m) },
+	)...), emails, nil
+}
+
+func toMember(m *pb.Member) types.ComparableMember {
+	return types.ComparableMember{
+		Id:        m.Id,
+		FirstName: m.FirstName,
+		LastName:  m.LastName,
+		Status:    types.StatusActive, // remote members are always ACTIVE
+	}
 }
 
 func createMembershipConn() (*grpc.ClientConn, error) {
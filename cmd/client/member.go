@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/localdb"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/overrides"
+	pb "github.com/fatcatfablab/fcfl-member-sync/proto"
+	"github.com/fatcatfablab/fcfl-member-sync/sync"
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+	"github.com/fatcatfablab/fcfl-member-sync/updater"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const memberUsage = `Usage: %s [flags] member <id>
+
+Syncs a single member, applying the same rules as a full sync to that member
+only.
+`
+
+// runMemberSync implements the member subcommand
+func runMemberSync(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, memberUsage, os.Args[0])
+		os.Exit(2)
+	}
+
+	id, err := strconv.ParseInt(args[0], 10, 32)
+	if err != nil {
+		logging.Fatal("Invalid member id", "id", args[0])
+	}
+
+	lock := acquireLock()
+	defer lock.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	api := newAPI()
+	uniFiUpdater := updater.New(api, *dryRun)
+	uniFiUpdater.SetOwnership(newOwnership(api))
+
+	state, err := localdb.New(*stateDb)
+	if err != nil {
+		logging.Fatal("Error opening local state", logging.Err(err))
+	}
+	defer state.Close()
+
+	uniFiUpdater.SetHistory(state)
+	if *mobileInvites {
+		uniFiUpdater.EnableInvitations(state)
+	}
+
+	ctx = audit.WithActor(ctx, audit.Reconcile(audit.RunId(time.Now())))
+	if err := syncMember(ctx, uniFiUpdater, int32(id)); err != nil {
+		logging.Fatal("Error syncing member", logging.KeyMemberId, id, logging.Err(err))
+	}
+}
+
+func syncMember(ctx context.Context, u *updater.UAUpdater, id int32) error {
+	remote, emails, err := getRemoteMember(ctx, id)
+	if err != nil {
+		return fmt.Errorf("error getting remote member: %w", err)
+	}
+	u.SetEmails(emails)
+
+	if *overridesFile != "" {
+		o, err := overrides.Load(*overridesFile)
+		if err != nil {
+			return fmt.Errorf("error loading overrides: %w", err)
+		}
+		remote = overrides.Apply(remote, o, time.Now())
+	}
+
+	var local types.MemberMap
+	err = sync.Stage(ctx, "listing UniFi Access users", *uaTimeout, func(ctx context.Context) error {
+		local, err = u.Member(ctx, id)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error getting local member: %w", err)
+	}
+
+	remote, collisions := sync.SkipCollisions(remote, local, u.Unmanaged())
+	for accessId, m := range collisions {
+		slog.Warn(
+			"Unmanaged user has the id of a managed member, leaving it alone",
+			logging.KeyAccessId, accessId,
+			logging.Inline(m),
+		)
+	}
+
+	return sync.Stage(ctx, "reconcile", *reconcileTimeout, func(ctx context.Context) error {
+		return sync.ReconcileMember(ctx, id, remote, local, u)
+	})
+}
+
+// getRemoteMember returns a set with the member with the given id, or an empty
+// one when the member source doesn't know about them.
+func getRemoteMember(ctx context.Context, id int32) (types.MemberSet, map[int32]string, error) {
+	conn, err := createMembershipConn()
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't connect: %w", err)
+	}
+	defer conn.Close()
+	mClient := pb.NewMembershipClient(conn)
+
+	var m *pb.Member
+	err = sync.Stage(ctx, "member source", *sourceTimeout, func(ctx context.Context) (err error) {
+		m, err = mClient.GetMember(ctx, &pb.MemberRequest{Id: id})
+		return err
+	})
+	if status.Code(err) == codes.NotFound {
+		return types.NewMemberSet(), nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	emails := make(map[int32]string)
+	if m.Email != "" {
+		emails[m.Id] = m.Email
+	}
+
+	return types.NewMemberSet(toMember(m)), emails, nil
+}
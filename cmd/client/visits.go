@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/analytics"
+	"github.com/fatcatfablab/fcfl-member-sync/localdb"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	pb "github.com/fatcatfablab/fcfl-member-sync/proto"
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+	"github.com/fatcatfablab/fcfl-member-sync/updater"
+)
+
+const visitsUsage = `Usage: %s [flags] visits <pull|report> [visits flags]
+
+pull    fetches the door openings from UniFi Access into the local database.
+        Use -dry-run to only report how many there are
+report  prints a report of the visits stored in the local database
+
+`
+
+// runVisits implements the visits subcommand
+func runVisits(args []string) {
+	fs := flag.NewFlagSet("visits", flag.ExitOnError)
+	days := fs.Int("days", 30, "Number of days to pull or report on")
+	report := fs.String("report", "members", "Report to print: members, hours or dormant")
+	format := fs.String("format", "csv", "Output format: csv or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), visitsUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	fs.Parse(args[1:])
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	state, err := localdb.New(*stateDb)
+	if err != nil {
+		logging.Fatal("Error opening local state", logging.Err(err))
+	}
+	defer state.Close()
+
+	api := newAPI()
+	uniFiUpdater := updater.New(api, *dryRun)
+	uniFiUpdater.SetOwnership(newOwnership(api))
+	until := time.Now()
+	since := until.AddDate(0, 0, -*days)
+
+	switch args[0] {
+	case "pull":
+		err = pullVisits(ctx, state, uniFiUpdater, api, since, until)
+	case "report":
+		err = reportVisits(ctx, state, uniFiUpdater, *report, *format, since, until)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		logging.Fatal("Error running visits", "command", args[0], logging.Err(err))
+	}
+}
+
+func pullVisits(ctx context.Context, state *localdb.DB, u *updater.UAUpdater, api *updater.API, since time.Time, until time.Time) error {
+	// Don't go further back than needed if we've pulled before
+	last, err := state.LastVisit(ctx)
+	if err != nil {
+		return err
+	}
+	from := max(since.Unix(), last)
+
+	members, err := knownMembers(ctx, state, u)
+	if err != nil {
+		return err
+	}
+
+	events, err := api.DoorOpenings(ctx, from, until.Unix())
+	if err != nil {
+		return err
+	}
+
+	visits := analytics.ToVisits(events, members)
+	slog.Info("Pulled door openings", "events", len(events), "visits", len(visits), logging.KeyDryRun, *dryRun)
+	if *dryRun {
+		return nil
+	}
+	return state.SaveVisits(ctx, visits)
+}
+
+// knownMembers returns the managed UniFi Access users along with the archived
+// ones, which still did the visits from before they were archived.
+func knownMembers(ctx context.Context, state *localdb.DB, u *updater.UAUpdater) (types.MemberMap, error) {
+	members, err := u.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting local members: %w", err)
+	}
+
+	archived, err := state.ArchivedUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return analytics.WithArchived(members, archived), nil
+}
+
+func reportVisits(
+	ctx context.Context,
+	state *localdb.DB,
+	u *updater.UAUpdater,
+	report string,
+	format string,
+	since time.Time,
+	until time.Time,
+) error {
+	visits, err := state.Visits(ctx, since.Unix(), until.Unix())
+	if err != nil {
+		return err
+	}
+
+	var r analytics.Report
+	switch report {
+	case "members":
+		members, err := knownMembers(ctx, state, u)
+		if err != nil {
+			return err
+		}
+		r = analytics.VisitsPerMember(
+			visits,
+			types.NewMemberSet(slices.Collect(maps.Values(members))...),
+			time.Local,
+		)
+	case "hours":
+		r = analytics.PeakHours(visits, time.Local)
+	case "dormant":
+		// Paying members are the ones the member source considers active
+		conn, err := createMembershipConn()
+		if err != nil {
+			return fmt.Errorf("couldn't connect: %w", err)
+		}
+		defer conn.Close()
+
+		remote, _, err := getRemoteMembers(ctx, pb.NewMembershipClient(conn))
+		if err != nil {
+			return fmt.Errorf("error getting remote members: %w", err)
+		}
+		r = analytics.Dormant(visits, remote)
+	default:
+		return fmt.Errorf("unknown report %q", report)
+	}
+
+	switch format {
+	case "csv":
+		return analytics.WriteCSV(os.Stdout, r)
+	case "json":
+		return analytics.WriteJSON(os.Stdout, r)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
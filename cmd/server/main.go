@@ -4,17 +4,26 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/metrics"
 	pb "github.com/fatcatfablab/fcfl-member-sync/proto"
+	"github.com/fatcatfablab/fcfl-member-sync/server/gateway"
 	"github.com/fatcatfablab/fcfl-member-sync/server/userlist"
 	"github.com/fatcatfablab/fcfl-member-sync/version"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -28,6 +37,14 @@ var (
 	ca   = flag.String("ca", "certs/root_ca.crt", "Path to CA root certificate")
 	dsn  = flag.String("dsn", os.Getenv("DSN"), "Database DSN")
 
+	dependentRelationships = flag.String("dependent-relationships", "", "Comma separated ids of the CiviCRM relationship types, e.g. household member of, whose contacts get access while the member they're related to has it")
+
+	httpPort   = flag.Int("http-port", 0, "Port to serve the read-only HTTP/JSON API on. Disabled when 0")
+	httpAuth   = flag.String("http-auth", "mtls", "How HTTP/JSON API clients authenticate: mtls or token")
+	httpTokens = flag.String("http-tokens", "", "Path to a file with the bearer tokens accepted by the HTTP/JSON API, one per line")
+
+	metricsAddr = flag.String("metrics-addr", "", "Address to serve Prometheus metrics on. Disabled when empty")
+
 	versionflag = flag.Bool("version", false, "Print the version and exit")
 )
 
@@ -36,36 +53,56 @@ type server struct {
 }
 
 func (s *server) List(ctx context.Context, _ *pb.Empty) (*pb.MemberList, error) {
-	log.Print("List called")
+	slog.Debug("List called")
 	return userlist.List(ctx)
 }
 
+func (s *server) GetMember(ctx context.Context, r *pb.MemberRequest) (*pb.Member, error) {
+	slog.Debug("GetMember called", logging.KeyMemberId, r.Id)
+	m, err := userlist.Get(ctx, r.Id)
+	if errors.Is(err, userlist.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return m, err
+}
+
 func main() {
+	var logOpts logging.Options
+	logOpts.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
+	if err := logging.Setup(logOpts); err != nil {
+		logging.Fatal("Error setting up logging", logging.Err(err))
+	}
+
 	if *versionflag {
 		version.PrintVersion()
 		return
 	}
 
-	log.Println("Oh, hai!")
+	slog.Info("Oh, hai!")
 
 	if err := userlist.Init(driver, *dsn); err != nil {
-		log.Fatalf("error initializing userlist module: %v", err)
+		logging.Fatal("Error initializing userlist module", logging.Err(err))
+	}
+	typeIds, err := parseIds(*dependentRelationships)
+	if err != nil {
+		logging.Fatal("Error parsing -dependent-relationships", logging.Err(err))
 	}
+	userlist.SetDependentRelationships(typeIds)
 
 	cert, err := tls.LoadX509KeyPair(*crt, *key)
 	if err != nil {
-		log.Fatalf("error loading certs: %v", err)
+		logging.Fatal("Error loading certs", logging.Err(err))
 	}
 
 	certPool := x509.NewCertPool()
 	caBytes, err := os.ReadFile(*ca)
 	if err != nil {
-		log.Fatalf("error reading %q: %v", *ca, err)
+		logging.Fatal("Error reading CA", "path", *ca, logging.Err(err))
 	}
 	if !certPool.AppendCertsFromPEM(caBytes) {
-		log.Fatalf("error adding CA to pool")
+		logging.Fatal("Error adding CA to pool")
 	}
 
 	tlsConfig := &tls.Config{
@@ -74,16 +111,86 @@ func main() {
 		ClientCAs:    certPool,
 	}
 
-	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
-	pb.RegisterMembershipServer(s, &server{})
+	if *metricsAddr != "" {
+		metrics.Serve(*metricsAddr)
+	}
+
+	srv := &server{}
+	if *httpPort != 0 {
+		h, err := newGateway(srv, tlsConfig)
+		if err != nil {
+			logging.Fatal("Error setting up the HTTP/JSON API", logging.Err(err))
+		}
+		go serveGateway(h)
+	}
+
+	s := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(tlsConfig)),
+		grpc.UnaryInterceptor(metrics.UnaryServerInterceptor),
+	)
+	pb.RegisterMembershipServer(s, srv)
 
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
 	if err != nil {
-		log.Fatalf("couldn't listen: %v", err)
+		logging.Fatal("Couldn't listen", logging.Err(err))
 	}
 
-	log.Printf("server listening on %v", lis.Addr())
+	slog.Info("Server listening", "addr", lis.Addr().String())
 	if err := s.Serve(lis); err != nil {
-		log.Fatalf("error serving: %v", err)
+		logging.Fatal("Error serving", logging.Err(err))
+	}
+}
+
+// newGateway returns the HTTP server for the JSON API. With mtls it asks for
+// client certificates like the gRPC server does, with token it only checks
+// bearer tokens.
+func newGateway(srv *server, grpcTLS *tls.Config) (*http.Server, error) {
+	g := gateway.New(srv, userlist.Ping)
+	tlsConfig := grpcTLS.Clone()
+
+	switch *httpAuth {
+	case "mtls":
+	case "token":
+		if *httpTokens == "" {
+			return nil, errors.New("-http-auth token needs -http-tokens")
+		}
+		tokens, err := gateway.LoadTokens(*httpTokens)
+		if err != nil {
+			return nil, err
+		}
+		g.RequireTokens(tokens)
+		tlsConfig.ClientAuth = tls.NoClientCert
+	default:
+		return nil, fmt.Errorf("unknown -http-auth %q", *httpAuth)
+	}
+
+	return &http.Server{
+		Addr:      fmt.Sprintf(":%d", *httpPort),
+		Handler:   g.Handler(),
+		TLSConfig: tlsConfig,
+	}, nil
+}
+
+func serveGateway(h *http.Server) {
+	slog.Info("HTTP/JSON API listening", "addr", h.Addr, "auth", *httpAuth)
+	if err := h.ListenAndServeTLS("", ""); err != nil {
+		logging.Fatal("Error serving the HTTP/JSON API", logging.Err(err))
+	}
+}
+
+// parseIds parses a comma separated list of ids, which may be empty
+func parseIds(s string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q: %w", field, err)
+		}
+		ids = append(ids, id)
 	}
+
+	return ids, nil
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/backfill"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/db"
+)
+
+const backfillUsage = `Usage: %s [flags] backfill
+
+Pages through every Stripe customer and subscription, and creates or fixes
+their member in the database: active when they have an active or trialing
+subscription allowed by -membership-ids, not active otherwise. Needs
+-stripe-key.
+
+Run it once after upgrading a database from before subscriptions, or the
+policies they give, were recorded. Until then the webhook doesn't know about
+the other subscriptions of a member, takes their access away when any one of
+them ends, and leaves out their policies when assigning them.
+
+UniFi Access isn't touched. Resync the members that changed from the
+dashboard, or let the next event for them do it.
+`
+
+// runBackfill implements the backfill subcommand
+func runBackfill(args []string) {
+	if len(args) != 0 || stripeKey == "" {
+		fmt.Fprintf(os.Stderr, backfillUsage, os.Args[0])
+		os.Exit(2)
+	}
+
+	d, err := db.New(dsn)
+	if err != nil {
+		logging.Fatal("Error connecting to database", logging.Err(err))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	ctx = audit.WithActor(ctx, audit.Reconcile(audit.RunId(time.Now())))
+
+	res, err := backfill.Run(ctx, newStripeAPI(), d, newMembershipFilter(), dryRun)
+	fmt.Printf("Backfilled %d active and %d inactive customers, %d failed\n", res.Active, res.Inactive, res.Failed)
+	if err != nil {
+		logging.Fatal("Error backfilling members", logging.Err(err))
+	}
+}
@@ -1,16 +1,29 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
-	ua "github.com/miquelruiz/go-unifi-access-api"
-
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/localdb"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/metrics"
+	"github.com/fatcatfablab/fcfl-member-sync/notify"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/api"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/dashboard"
 	"github.com/fatcatfablab/fcfl-member-sync/stripe/db"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/household"
 	"github.com/fatcatfablab/fcfl-member-sync/stripe/listener"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/mailer"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/membership"
+	uaTypes "github.com/fatcatfablab/fcfl-member-sync/types"
 	"github.com/fatcatfablab/fcfl-member-sync/updater"
 	"github.com/fatcatfablab/fcfl-member-sync/version"
 )
@@ -22,6 +35,31 @@ var (
 	dsn                  string
 	uaToken              string
 	uaHost               string
+	mobileInvites        bool
+	reinvite             string
+	managedGroup         string
+	metricsAddr          string
+	notifyConfig         string
+	notifyInterval       time.Duration
+	eventTimeout         time.Duration
+	emailSMTP            string
+	emailUsername        string
+	emailPassword        string
+	emailFrom            string
+	emailTemplates       string
+	previewEmail         string
+	previewCustomer      string
+	dashboardAddr        string
+	dashboardUser        string
+	dashboardPassword    string
+	dashboardStateDb     string
+	stripeKey            string
+	stripeAPIURL         string
+	reconcileInterval    time.Duration
+	reconcileStripe      bool
+	maxDisable           int
+	membershipIds        string
+	logOpts              logging.Options
 	dryRun               bool
 	versionflag          bool
 )
@@ -30,9 +68,34 @@ func init() {
 	flag.StringVar(&stripeEndpointSecret, "endpoint-secret", os.Getenv("STRIPE_ENDPOINT_SECRET"), "Stripe endpoint secret")
 	flag.StringVar(&listenAddr, "listen-address", "127.0.0.1:8081", "Address to listen on")
 	flag.StringVar(&listenEndpoint, "listen-endpoint", "/stripe_events", "Endpoint of the listener")
-	flag.StringVar(&dsn, "dsn", os.Getenv("WEBHOOK_DSN"), "Database connection string")
+	flag.StringVar(&dsn, "dsn", os.Getenv("WEBHOOK_DSN"), "Database connection string: a MySQL DSN, or sqlite:///path/to.db")
 	flag.StringVar(&uaToken, "uaToken", os.Getenv("UA_TOKEN"), "UniFi Access token")
 	flag.StringVar(&uaHost, "uaHost", "https://192.168.2.1:12445", "UniFi Access url")
+	flag.BoolVar(&mobileInvites, "mobile-invites", false, "Invite new members to set up a UniFi Access mobile credential")
+	flag.StringVar(&reinvite, "reinvite", "", "Send the mobile credential invitation again to the given Stripe customer id and exit")
+	flag.StringVar(&managedGroup, "managed-group", "", "UniFi Access user group id new users are added to, to mark them as managed")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics on. Disabled when empty")
+	flag.StringVar(&notifyConfig, "notify", "", "Path to a JSON file with the notification sinks")
+	flag.DurationVar(&notifyInterval, "notify-interval", 5*time.Minute, "How often to send the pending notifications as a digest")
+	flag.DurationVar(&eventTimeout, "event-timeout", 15*time.Second, "How long handling a single Stripe event may take. 0 means no limit")
+	flag.StringVar(&emailSMTP, "email-smtp", "", "host:port of the SMTP server used to email members. Emails are disabled when empty")
+	flag.StringVar(&emailUsername, "email-username", "", "SMTP username")
+	flag.StringVar(&emailPassword, "email-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&emailFrom, "email-from", "", "Sender of the member emails")
+	flag.StringVar(&emailTemplates, "email-templates", "", "Directory with templates overriding the built-in member emails")
+	flag.StringVar(&previewEmail, "preview-email", "", "Render the member email of this kind (access_granted, access_suspended or access_revoked) and exit")
+	flag.StringVar(&previewCustomer, "preview-customer", "", "Stripe customer id of the member -preview-email renders the email for")
+	flag.StringVar(&dashboardAddr, "dashboard-address", "", "Address to serve the admin dashboard on. Disabled when empty")
+	flag.StringVar(&dashboardUser, "dashboard-user", "admin", "Username to log into the dashboard")
+	flag.StringVar(&dashboardPassword, "dashboard-password", os.Getenv("DASHBOARD_PASSWORD"), "Password to log into the dashboard")
+	flag.StringVar(&dashboardStateDb, "dashboard-state-db", "", "Path to the sync client's state database, to show the last sync on the dashboard")
+	flag.StringVar(&stripeKey, "stripe-key", os.Getenv("STRIPE_API_KEY"), "Stripe API key, to fetch customers the database is missing. Disabled when empty")
+	flag.StringVar(&stripeAPIURL, "stripe-api-url", api.DefaultBaseURL, "Base URL of the Stripe API")
+	flag.StringVar(&membershipIds, "membership-ids", "", "Comma separated price or product ids of the subscriptions that make someone a member. Every subscription does when empty")
+	flag.DurationVar(&reconcileInterval, "reconcile-interval", 0, "How often to fix the drift between the database and UniFi Access. Disabled when 0")
+	flag.BoolVar(&reconcileStripe, "reconcile-stripe", false, "Update the database from the Stripe subscriptions before fixing the drift. Needs -stripe-key")
+	flag.IntVar(&maxDisable, "max-disable", 0, "Refuse to disable more than this many members when fixing the drift. 0 means no limit")
+	logOpts.RegisterFlags(flag.CommandLine)
 	flag.BoolVar(&versionflag, "version", false, "Print the version and exit")
 	flag.BoolVar(&dryRun, "dry-run", false, "Wether UniFi Access should be called or not")
 }
@@ -40,38 +103,207 @@ func init() {
 func main() {
 	flag.Parse()
 
+	if err := logging.Setup(logOpts); err != nil {
+		logging.Fatal("Error setting up logging", logging.Err(err))
+	}
+
 	if versionflag {
 		version.PrintVersion()
 		return
 	}
 
 	if dsn == "" {
-		log.Fatal("No database connection string given")
+		logging.Fatal("No database connection string given")
+	}
+
+	switch flag.Arg(0) {
+	case "migrate":
+		runMigrate(flag.Args()[1:])
+		return
+	case "backfill":
+		runBackfill(flag.Args()[1:])
+		return
+	}
+
+	if previewEmail != "" {
+		if err := previewMemberEmail(context.Background(), previewEmail, previewCustomer); err != nil {
+			logging.Fatal("Error previewing email", logging.Err(err))
+		}
+		return
 	}
 
 	if uaToken == "" {
-		log.Fatal("No UniFi Access token given")
+		logging.Fatal("No UniFi Access token given")
 	}
 
-	uaClient, err := ua.NewWithHttpClient(uaHost, uaToken, &http.Client{
-		Transport: &http.Transport{
+	httpClient := &http.Client{
+		Transport: metrics.InstrumentTransport(&http.Transport{
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: true,
 			},
-		},
-	})
+		}),
+	}
+	api, err := updater.NewAPI(uaHost, uaToken, httpClient)
 	if err != nil {
-		log.Fatalf("error creating UniFi Access API client: %s", err)
+		logging.Fatal("Error creating UniFi Access API client", logging.Err(err))
 	}
-	uniFiUpdater := updater.New(uaClient, dryRun)
+	uniFiUpdater := updater.New(api, dryRun)
 
 	d, err := db.New(dsn)
 	if err != nil {
-		log.Fatalf("error connecting to database: %s", err)
+		logging.Fatal("Error connecting to database", logging.Err(err))
+	}
+
+	uniFiUpdater.SetHistory(d)
+	if mobileInvites || reinvite != "" {
+		uniFiUpdater.EnableInvitations(d)
+	}
+
+	if managedGroup != "" {
+		uniFiUpdater.SetOwnership(updater.NewGroupOwnership(api, managedGroup))
+	}
+
+	if reinvite != "" {
+		if err := reinviteMember(audit.WithActor(context.Background(), audit.LocalAdmin()), d, uniFiUpdater, reinvite); err != nil {
+			logging.Fatal("Error re-sending invitation", logging.Err(err))
+		}
+		return
+	}
+
+	reconciler := newReconciler(d, uniFiUpdater)
+	if flag.Arg(0) == "reconcile" {
+		runReconcile(reconciler)
+		return
+	}
+
+	if metricsAddr != "" {
+		metrics.Serve(metricsAddr)
+	}
+
+	if notifyConfig != "" {
+		n, err := notify.Load(notifyConfig, "webhook")
+		if err != nil {
+			logging.Fatal("Error loading notifications config", logging.Err(err))
+		}
+		uniFiUpdater.SetNotifier(n)
+		go flushNotifications(n, notifyInterval)
 	}
 
 	l := listener.New(stripeEndpointSecret, listenAddr, listenEndpoint, d, uniFiUpdater)
+	l.SetEventStore(d)
+	l.SetTimeout(eventTimeout)
+	l.SetMemberships(newMembershipFilter())
+	l.SetHouseholds(household.New(d, uniFiUpdater))
+	if stripeKey != "" {
+		l.SetCustomerSource(newStripeAPI())
+	}
+	if emailSMTP != "" {
+		templates, err := mailer.LoadTemplates(emailTemplates)
+		if err != nil {
+			logging.Fatal("Error loading email templates", logging.Err(err))
+		}
+		l.SetMailer(mailer.New(mailer.SMTPConfig{
+			Addr:     emailSMTP,
+			Username: emailUsername,
+			Password: emailPassword,
+			From:     emailFrom,
+		}, templates, d, dryRun))
+	}
+
+	if reconcileInterval > 0 {
+		go reconcileDrift(reconciler, reconcileInterval)
+	}
+
+	if dashboardAddr != "" {
+		if dashboardPassword == "" {
+			logging.Fatal("No dashboard password given")
+		}
+		go serveDashboard(d, l)
+	}
+
 	if err := l.Start(); err != nil {
-		log.Fatalf("error after calling listener.Start: %s", err)
+		logging.Fatal("Error after calling listener.Start", logging.Err(err))
+	}
+}
+
+func newStripeAPI() *api.Client {
+	return api.New(stripeAPIURL, stripeKey, &http.Client{Timeout: 30 * time.Second})
+}
+
+// newMembershipFilter allows the subscriptions in -membership-ids. The
+// access policies in the price metadata come with the events, the ones in
+// the product metadata need -stripe-key to be read.
+func newMembershipFilter() *membership.Filter {
+	f := membership.NewFilter(strings.Split(membershipIds, ","))
+	if stripeKey != "" {
+		f.SetProductSource(newStripeAPI())
+	}
+
+	return f
+}
+
+// flushNotifications sends a digest of what the listener did every interval
+func flushNotifications(n *notify.Notifier, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := n.Flush(); err != nil {
+			slog.Error("Error sending notifications", logging.Err(err))
+		}
+	}
+}
+
+func serveDashboard(d *db.DB, l *listener.Listener) {
+	dash := dashboard.New(dashboardAddr, dashboardUser, dashboardPassword, d, l)
+	if dashboardStateDb != "" {
+		state, err := localdb.New(dashboardStateDb)
+		if err != nil {
+			logging.Fatal("Error opening the sync state database", logging.Err(err))
+		}
+		dash.SetSyncStatus(state)
 	}
+
+	if err := dash.Start(); err != nil {
+		logging.Fatal("Error after calling dashboard.Start", logging.Err(err))
+	}
+}
+
+func previewMemberEmail(ctx context.Context, kind string, customerId string) error {
+	templates, err := mailer.LoadTemplates(emailTemplates)
+	if err != nil {
+		return err
+	}
+
+	d, err := db.New(dsn)
+	if err != nil {
+		return err
+	}
+
+	m, err := d.FindMemberByCustomerId(ctx, customerId)
+	if err != nil {
+		return err
+	}
+
+	msg, err := templates.Render(kind, *m)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("To: %s\nSubject: %s\n\n%s", m.Email, msg.Subject, msg.Text)
+	if msg.HTML != "" {
+		fmt.Printf("\n--- HTML ---\n%s", msg.HTML)
+	}
+
+	return nil
+}
+
+func reinviteMember(ctx context.Context, d *db.DB, u *updater.UAUpdater, customerId string) error {
+	m, err := d.FindMemberByCustomerId(ctx, customerId)
+	if err != nil {
+		return err
+	}
+
+	if m.AccessId == nil {
+		return fmt.Errorf("customer %q has no UniFi Access user", customerId)
+	}
+
+	return u.InviteMember(ctx, *m.AccessId, uaTypes.ComparableMember{Id: int32(m.MemberId)}, m.Email)
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/db"
+)
+
+const migrateUsage = `Usage: %s [flags] migrate <up|status>
+
+up      applies the pending migrations to the database
+status  prints which migrations have been applied
+
+The listener applies the pending migrations when it starts, and refuses to
+start when the database was migrated by a newer version.
+`
+
+// runMigrate implements the migrate subcommand
+func runMigrate(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, migrateUsage, os.Args[0])
+		os.Exit(2)
+	}
+
+	d, err := db.Open(dsn)
+	if err != nil {
+		logging.Fatal("Error connecting to database", logging.Err(err))
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := d.Migrate(ctx)
+		if err != nil {
+			logging.Fatal("Error migrating database", logging.Err(err))
+		}
+		fmt.Printf("Applied %d migrations, the database is at version %d\n", len(applied), db.LatestVersion())
+
+	case "status":
+		if err := printMigrationStatus(ctx, d); err != nil {
+			logging.Fatal("Error getting migration status", logging.Err(err))
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, migrateUsage, os.Args[0])
+		os.Exit(2)
+	}
+}
+
+func printMigrationStatus(ctx context.Context, d *db.DB) error {
+	version, err := d.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := d.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "Database version: %d\nBinary version: %d\n\n", version, db.LatestVersion())
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range status {
+		applied := "pending"
+		if s.AppliedAt != 0 {
+			applied = time.Unix(s.AppliedAt, 0).Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	if version > db.LatestVersion() {
+		fmt.Fprintln(w, "\nThe database was migrated by a newer version")
+	}
+
+	return nil
+}
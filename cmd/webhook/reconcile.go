@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/db"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/drift"
+	"github.com/fatcatfablab/fcfl-member-sync/sync"
+	"github.com/fatcatfablab/fcfl-member-sync/updater"
+)
+
+func newReconciler(d *db.DB, u *updater.UAUpdater) *drift.Reconciler {
+	r := drift.New(d, u, dryRun)
+	r.SetMaxDisable(maxDisable)
+	if reconcileStripe {
+		if stripeKey == "" {
+			logging.Fatal("-reconcile-stripe needs -stripe-key")
+		}
+		r.SetStripe(newStripeAPI(), newMembershipFilter())
+	}
+
+	return r
+}
+
+// runReconcile implements the reconcile subcommand, which fixes the drift
+// once and exits.
+func runReconcile(r *drift.Reconciler) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	if err := r.Run(ctx); err != nil {
+		logging.Fatal("Error reconciling drift", logging.Err(err))
+	}
+}
+
+// reconcileDrift fixes the drift every interval. A run taking longer than
+// that is cut short, so they never pile up.
+func reconcileDrift(r *drift.Reconciler, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := sync.Stage(context.Background(), "reconciling drift", interval, r.Run); err != nil {
+			slog.Error("Error reconciling drift", logging.Err(err))
+		}
+	}
+}
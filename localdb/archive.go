@@ -0,0 +1,159 @@
+package localdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+// TrackDeactivations records when each deactivated user was first seen
+// deactivated, and forgets about the ones that are active again or gone.
+func (d *DB) TrackDeactivations(ctx context.Context, members types.MemberMap, now int64) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	tracked, err := queryDeactivations(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	for _, dt := range tracked {
+		if m, ok := members[dt.AccessId]; !ok || m.Status != types.StatusDeactivated {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM deactivations WHERE access_id=?", dt.AccessId); err != nil {
+				return fmt.Errorf("error forgetting deactivation of %q: %w", dt.AccessId, err)
+			}
+		}
+	}
+
+	for accessId, m := range members {
+		if m.Status != types.StatusDeactivated {
+			continue
+		}
+		if _, err := tx.ExecContext(
+			ctx,
+			"INSERT OR IGNORE INTO deactivations (access_id, member_id, since) VALUES (?, ?, ?)",
+			accessId,
+			m.Id,
+			now,
+		); err != nil {
+			return fmt.Errorf("error tracking deactivation of %q: %w", accessId, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *DB) Deactivations(ctx context.Context) ([]types.Deactivation, error) {
+	return queryDeactivations(ctx, d.db)
+}
+
+func (d *DB) ForgetDeactivation(ctx context.Context, accessId string) error {
+	if _, err := d.db.ExecContext(ctx, "DELETE FROM deactivations WHERE access_id=?", accessId); err != nil {
+		return fmt.Errorf("error forgetting deactivation of %q: %w", accessId, err)
+	}
+	return nil
+}
+
+func queryDeactivations(ctx context.Context, q interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}) ([]types.Deactivation, error) {
+	r, err := q.QueryContext(ctx, "SELECT access_id, member_id, since FROM deactivations ORDER BY since")
+	if err != nil {
+		return nil, fmt.Errorf("error querying deactivations: %w", err)
+	}
+	defer r.Close()
+
+	var deactivations []types.Deactivation
+	for r.Next() {
+		var dt types.Deactivation
+		if err := r.Scan(&dt.AccessId, &dt.MemberId, &dt.Since); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		deactivations = append(deactivations, dt)
+	}
+
+	return deactivations, r.Err()
+}
+
+func (d *DB) SaveArchivedUser(ctx context.Context, a types.ArchivedUser) error {
+	user, err := json.Marshal(a.User)
+	if err != nil {
+		return fmt.Errorf("error encoding user: %w", err)
+	}
+
+	if _, err := d.db.ExecContext(
+		ctx,
+		"INSERT INTO archived_users "+
+			"(member_id, access_id, deactivated_at, archived_at, user) VALUES (?, ?, ?, ?, ?) "+
+			"ON CONFLICT (access_id, archived_at) DO UPDATE SET member_id=excluded.member_id, "+
+			"deactivated_at=excluded.deactivated_at, user=excluded.user",
+		a.MemberId,
+		a.AccessId,
+		a.DeactivatedAt,
+		a.ArchivedAt,
+		string(user),
+	); err != nil {
+		return fmt.Errorf("error saving archived user: %w", err)
+	}
+
+	return nil
+}
+
+// FindArchivedUser returns the latest archive of the member, or nil without
+// error when the member isn't archived
+func (d *DB) FindArchivedUser(ctx context.Context, memberId int32) (*types.ArchivedUser, error) {
+	archived, err := d.queryArchivedUsers(ctx, "WHERE member_id=? ORDER BY archived_at DESC LIMIT 1", memberId)
+	if err != nil || len(archived) == 0 {
+		return nil, err
+	}
+	return &archived[0], nil
+}
+
+func (d *DB) ArchivedUsers(ctx context.Context) ([]types.ArchivedUser, error) {
+	return d.queryArchivedUsers(ctx, "ORDER BY archived_at")
+}
+
+func (d *DB) DeleteArchivedUser(ctx context.Context, accessId string, archivedAt int64) error {
+	if _, err := d.db.ExecContext(
+		ctx,
+		"DELETE FROM archived_users WHERE access_id=? AND archived_at=?",
+		accessId,
+		archivedAt,
+	); err != nil {
+		return fmt.Errorf("error deleting archived user %q: %w", accessId, err)
+	}
+	return nil
+}
+
+func (d *DB) queryArchivedUsers(ctx context.Context, where string, args ...any) ([]types.ArchivedUser, error) {
+	r, err := d.db.QueryContext(
+		ctx,
+		"SELECT member_id, access_id, deactivated_at, archived_at, user FROM archived_users "+where,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying archived users: %w", err)
+	}
+	defer r.Close()
+
+	var archived []types.ArchivedUser
+	for r.Next() {
+		var a types.ArchivedUser
+		var user string
+		if err := r.Scan(&a.MemberId, &a.AccessId, &a.DeactivatedAt, &a.ArchivedAt, &user); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		if err := json.Unmarshal([]byte(user), &a.User); err != nil {
+			return nil, fmt.Errorf("error decoding archived user %d: %w", a.MemberId, err)
+		}
+		archived = append(archived, a)
+	}
+
+	return archived, r.Err()
+}
@@ -0,0 +1,106 @@
+// Package localdb keeps the state the sync client needs between runs in a
+// local SQLite database.
+package localdb
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+const (
+	dbDriver = "sqlite"
+)
+
+//go:embed schema/invitations.sql
+var createInvitationsTable string
+
+//go:embed schema/visits.sql
+var createVisitsTable string
+
+//go:embed schema/archive.sql
+var createArchiveTables string
+
+//go:embed schema/sync_status.sql
+var createSyncStatusTable string
+
+//go:embed schema/last_sync.sql
+var createLastSyncTable string
+
+//go:embed schema/history.sql
+var createHistoryTable string
+
+type DB struct {
+	db *sql.DB
+}
+
+func New(path string) (*DB, error) {
+	db, err := sql.Open(dbDriver, path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open database %q: %w", path, err)
+	}
+
+	for _, create := range []string{
+		createInvitationsTable,
+		createVisitsTable,
+		createArchiveTables,
+		createSyncStatusTable,
+		createLastSyncTable,
+		createHistoryTable,
+	} {
+		if _, err := db.Exec(create); err != nil {
+			return nil, fmt.Errorf("error creating table: %w", err)
+		}
+	}
+
+	return &DB{db: db}, nil
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+func (d *DB) SaveInvitation(ctx context.Context, inv types.Invitation) error {
+	if _, err := d.db.ExecContext(
+		ctx,
+		"INSERT INTO invitations "+
+			"(member_id, access_id, email, status, sent_at) VALUES (?, ?, ?, ?, ?) "+
+			"ON CONFLICT (member_id) DO UPDATE SET access_id=excluded.access_id, "+
+			"email=excluded.email, status=excluded.status, sent_at=excluded.sent_at",
+		inv.MemberId,
+		inv.AccessId,
+		inv.Email,
+		inv.Status,
+		inv.SentAt,
+	); err != nil {
+		return fmt.Errorf("error saving invitation: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DB) FindInvitation(ctx context.Context, memberId int32) (*types.Invitation, error) {
+	r := d.db.QueryRowContext(
+		ctx,
+		"SELECT member_id, access_id, email, status, sent_at "+
+			"FROM invitations WHERE member_id=?",
+		memberId,
+	)
+
+	var inv types.Invitation
+	err := r.Scan(&inv.MemberId, &inv.AccessId, &inv.Email, &inv.Status, &inv.SentAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying invitation for member %d: %w", memberId, err)
+	}
+
+	return &inv, nil
+}
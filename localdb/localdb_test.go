@@ -0,0 +1,322 @@
+package localdb
+
+import (
+	"context"
+	"errors"
+	"path"
+	"slices"
+	"testing"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+func getDb(t *testing.T) *DB {
+	db, err := New(path.Join(t.TempDir(), "localdb-test.sqlite"))
+	if err != nil {
+		t.Fatalf("can't open test db: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestInvitations(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t)
+
+	inv, err := db.FindInvitation(ctx, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if inv != nil {
+		t.Fatalf("unexpected invitation: %+v", *inv)
+	}
+
+	for _, tt := range []struct {
+		name string
+		inv  types.Invitation
+	}{
+		{
+			name: "New invitation",
+			inv:  types.Invitation{MemberId: 1, AccessId: "uaid1", Email: "email", Status: types.InvitationSent, SentAt: 1},
+		},
+		{
+			name: "Invitation gets revoked",
+			inv:  types.Invitation{MemberId: 1, AccessId: "uaid1", Email: "email", Status: types.InvitationRevoked, SentAt: 1},
+		},
+		{
+			name: "Invitation gets re-sent to a new email",
+			inv:  types.Invitation{MemberId: 1, AccessId: "uaid1", Email: "email2", Status: types.InvitationSent, SentAt: 2},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := db.SaveInvitation(ctx, tt.inv); err != nil {
+				t.Fatalf("error saving invitation: %s", err)
+			}
+
+			got, err := db.FindInvitation(ctx, tt.inv.MemberId)
+			if err != nil {
+				t.Fatalf("error finding invitation: %s", err)
+			}
+
+			if got == nil || *got != tt.inv {
+				t.Errorf("unexpected invitation. Got: %+v Want: %+v", got, tt.inv)
+			}
+		})
+	}
+}
+
+func TestVisits(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t)
+
+	last, err := db.LastVisit(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if last != 0 {
+		t.Errorf("unexpected last visit in empty db: %d", last)
+	}
+
+	v1 := types.Visit{EventId: "e1", MemberId: 1, AccessId: "uaid1", Door: "front", Timestamp: 100}
+	v2 := types.Visit{EventId: "e2", MemberId: 2, AccessId: "uaid2", Door: "front", Timestamp: 200}
+	v3 := types.Visit{EventId: "e3", MemberId: 1, AccessId: "uaid1", Door: "back", Timestamp: 300}
+
+	if err := db.SaveVisits(ctx, []types.Visit{v1, v2}); err != nil {
+		t.Fatalf("error saving visits: %s", err)
+	}
+	// Overlapping pull
+	if err := db.SaveVisits(ctx, []types.Visit{v2, v3}); err != nil {
+		t.Fatalf("error saving overlapping visits: %s", err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		since int64
+		until int64
+		want  []types.Visit
+	}{
+		{name: "All visits", since: 0, until: 1000, want: []types.Visit{v1, v2, v3}},
+		{name: "Window", since: 150, until: 300, want: []types.Visit{v2}},
+		{name: "No visits", since: 400, until: 1000, want: nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.Visits(ctx, tt.since, tt.until)
+			if err != nil {
+				t.Fatalf("error querying visits: %s", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("unexpected visits. Got: %+v Want: %+v", got, tt.want)
+			}
+		})
+	}
+
+	last, err = db.LastVisit(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if last != v3.Timestamp {
+		t.Errorf("unexpected last visit: %d", last)
+	}
+}
+
+func TestTrackDeactivations(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t)
+
+	for _, tt := range []struct {
+		name    string
+		members types.MemberMap
+		now     int64
+		want    []types.Deactivation
+	}{
+		{
+			name: "Deactivated member gets tracked",
+			members: types.MemberMap{
+				"uaid1": {Id: 1, Status: types.StatusActive},
+				"uaid2": {Id: 2, Status: types.StatusDeactivated},
+			},
+			now:  100,
+			want: []types.Deactivation{{AccessId: "uaid2", MemberId: 2, Since: 100}},
+		},
+		{
+			name: "Deactivation time is kept",
+			members: types.MemberMap{
+				"uaid1": {Id: 1, Status: types.StatusDeactivated},
+				"uaid2": {Id: 2, Status: types.StatusDeactivated},
+			},
+			now: 200,
+			want: []types.Deactivation{
+				{AccessId: "uaid2", MemberId: 2, Since: 100},
+				{AccessId: "uaid1", MemberId: 1, Since: 200},
+			},
+		},
+		{
+			name: "Reactivated and deleted members are forgotten",
+			members: types.MemberMap{
+				"uaid1": {Id: 1, Status: types.StatusActive},
+			},
+			now:  300,
+			want: nil,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := db.TrackDeactivations(ctx, tt.members, tt.now); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := db.Deactivations(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("unexpected deactivations. Got: %+v Want: %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArchivedUsers(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t)
+
+	for _, a := range []types.ArchivedUser{
+		{MemberId: 1, AccessId: "uaid1", DeactivatedAt: 100, ArchivedAt: 200},
+		{MemberId: 1, AccessId: "uaid2", DeactivatedAt: 300, ArchivedAt: 400},
+		{MemberId: 2, AccessId: "uaid3", DeactivatedAt: 100, ArchivedAt: 200},
+	} {
+		if err := db.SaveArchivedUser(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archived, err := db.ArchivedUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 3 {
+		t.Fatalf("archiving a member again overwrote the earlier archive: %+v", archived)
+	}
+
+	latest, err := db.FindArchivedUser(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest == nil || latest.AccessId != "uaid2" {
+		t.Fatalf("unexpected latest archive: %+v", latest)
+	}
+
+	if err := db.DeleteArchivedUser(ctx, latest.AccessId, latest.ArchivedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	earlier, err := db.FindArchivedUser(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if earlier == nil || earlier.AccessId != "uaid1" {
+		t.Errorf("unexpected archive after deleting the latest: %+v", earlier)
+	}
+
+	if none, err := db.FindArchivedUser(ctx, 3); err != nil || none != nil {
+		t.Errorf("unexpected archive: %+v %v", none, err)
+	}
+}
+
+func TestSyncStatus(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t)
+
+	for i := 1; i <= 3; i++ {
+		failures, err := db.RecordSyncFailure(ctx, int64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failures != i {
+			t.Errorf("want %d failures, got %d", i, failures)
+		}
+	}
+
+	failures, err := db.RecordSyncSuccess(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures != 3 {
+		t.Errorf("want 3 failures before the success, got %d", failures)
+	}
+
+	failures, err = db.RecordSyncFailure(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures != 1 {
+		t.Errorf("want the count reset after a success, got %d", failures)
+	}
+}
+
+func TestLastSync(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t)
+
+	r, err := db.LastSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != nil {
+		t.Errorf("want no sync recorded, got %+v", r)
+	}
+
+	if err := db.SaveLastSync(ctx, 10, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RecordSyncFailure(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	r, err = db.LastSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *r != (types.SyncResult{FinishedAt: 10, Error: "boom", Failures: 1}) {
+		t.Errorf("unexpected failed sync: %+v", r)
+	}
+
+	if err := db.SaveLastSync(ctx, 20, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RecordSyncSuccess(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r, err = db.LastSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *r != (types.SyncResult{FinishedAt: 20}) {
+		t.Errorf("unexpected successful sync: %+v", r)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t)
+
+	want := []audit.Entry{
+		{MemberId: 1, At: 20, Actor: "reconcile:b", Action: audit.ActionUADisable, New: "DEACTIVATED", Outcome: "boom"},
+		{MemberId: 1, At: 10, Actor: "reconcile:a", Action: audit.ActionUAAdd, New: "Jane Doe", Outcome: audit.OutcomeOK},
+		{MemberId: 2, At: 15, Actor: "reconcile:a", Action: audit.ActionUAAdd, New: "John Doe", Outcome: audit.OutcomeOK},
+	}
+	for _, e := range want {
+		if err := db.RecordHistory(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := db.History(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []audit.Entry{want[1], want[0]}) {
+		t.Errorf("unexpected history: %+v", got)
+	}
+}
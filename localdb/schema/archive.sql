@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS deactivations (
+    access_id TEXT PRIMARY KEY,
+    member_id INTEGER NOT NULL,
+    since INTEGER NOT NULL
+) STRICT;
+CREATE TABLE IF NOT EXISTS archived_users (
+    access_id TEXT NOT NULL,
+    archived_at INTEGER NOT NULL,
+    member_id INTEGER NOT NULL,
+    deactivated_at INTEGER NOT NULL,
+    user TEXT NOT NULL,
+    PRIMARY KEY (access_id, archived_at)
+) STRICT;
+CREATE INDEX IF NOT EXISTS archived_users_member ON archived_users (member_id, archived_at);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS last_sync (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    finished_at INTEGER NOT NULL,
+    error TEXT NOT NULL
+) STRICT;
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS sync_status (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    failures INTEGER NOT NULL,
+    last_failure INTEGER NOT NULL
+) STRICT;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS visits (
+    event_id TEXT PRIMARY KEY,
+    member_id INTEGER NOT NULL,
+    access_id TEXT NOT NULL,
+    door TEXT NOT NULL,
+    ts INTEGER NOT NULL
+) STRICT;
+CREATE INDEX IF NOT EXISTS visits_ts ON visits (ts);
@@ -0,0 +1,74 @@
+package localdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+// RecordSyncFailure bumps the count of consecutive failed syncs and returns
+// it.
+func (d *DB) RecordSyncFailure(ctx context.Context, now int64) (int, error) {
+	var failures int
+	err := d.db.QueryRowContext(
+		ctx,
+		"INSERT INTO sync_status (id, failures, last_failure) VALUES (1, 1, ?) "+
+			"ON CONFLICT (id) DO UPDATE SET failures=failures+1, last_failure=excluded.last_failure "+
+			"RETURNING failures",
+		now,
+	).Scan(&failures)
+
+	return failures, err
+}
+
+// RecordSyncSuccess resets the count of consecutive failed syncs and returns
+// what it was.
+func (d *DB) RecordSyncSuccess(ctx context.Context) (int, error) {
+	var failures int
+	err := d.db.QueryRowContext(ctx, "SELECT failures FROM sync_status WHERE id=1").Scan(&failures)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	_, err = d.db.ExecContext(ctx, "DELETE FROM sync_status")
+	return failures, err
+}
+
+// SaveLastSync records how the latest sync run went
+func (d *DB) SaveLastSync(ctx context.Context, finishedAt int64, syncErr error) error {
+	var msg string
+	if syncErr != nil {
+		msg = syncErr.Error()
+	}
+
+	_, err := d.db.ExecContext(
+		ctx,
+		"INSERT INTO last_sync (id, finished_at, error) VALUES (1, ?, ?) "+
+			"ON CONFLICT (id) DO UPDATE SET finished_at=excluded.finished_at, error=excluded.error",
+		finishedAt,
+		msg,
+	)
+	return err
+}
+
+// LastSync returns how the latest sync run went, along with how many runs in
+// a row have failed. It returns nil if no sync was recorded yet.
+func (d *DB) LastSync(ctx context.Context) (*types.SyncResult, error) {
+	var r types.SyncResult
+	err := d.db.QueryRowContext(
+		ctx,
+		"SELECT l.finished_at, l.error, COALESCE(s.failures, 0) "+
+			"FROM last_sync l LEFT JOIN sync_status s ON s.id=l.id",
+	).Scan(&r.FinishedAt, &r.Error, &r.Failures)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
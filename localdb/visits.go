@@ -0,0 +1,72 @@
+package localdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+// SaveVisits stores the given visits. Visits already stored are skipped, so
+// overlapping pulls from UniFi Access are harmless.
+func (d *DB) SaveVisits(ctx context.Context, visits []types.Visit) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(
+		ctx,
+		"INSERT OR IGNORE INTO visits (event_id, member_id, access_id, door, ts) "+
+			"VALUES (?, ?, ?, ?, ?)",
+	)
+	if err != nil {
+		return fmt.Errorf("error preparing visit insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, v := range visits {
+		if _, err := stmt.ExecContext(ctx, v.EventId, v.MemberId, v.AccessId, v.Door, v.Timestamp); err != nil {
+			return fmt.Errorf("error inserting visit %q: %w", v.EventId, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Visits returns the visits between since and until, both unix timestamps.
+func (d *DB) Visits(ctx context.Context, since int64, until int64) ([]types.Visit, error) {
+	r, err := d.db.QueryContext(
+		ctx,
+		"SELECT event_id, member_id, access_id, door, ts FROM visits "+
+			"WHERE ts >= ? AND ts < ? ORDER BY ts",
+		since,
+		until,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying visits: %w", err)
+	}
+	defer r.Close()
+
+	var visits []types.Visit
+	for r.Next() {
+		var v types.Visit
+		if err := r.Scan(&v.EventId, &v.MemberId, &v.AccessId, &v.Door, &v.Timestamp); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		visits = append(visits, v)
+	}
+
+	return visits, r.Err()
+}
+
+// LastVisit returns the timestamp of the most recent visit stored, or 0 if
+// there's none.
+func (d *DB) LastVisit(ctx context.Context) (int64, error) {
+	var ts int64
+	if err := d.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(ts), 0) FROM visits").Scan(&ts); err != nil {
+		return 0, fmt.Errorf("error querying last visit: %w", err)
+	}
+	return ts, nil
+}
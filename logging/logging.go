@@ -0,0 +1,108 @@
+// Package logging configures the log/slog default logger shared by every
+// binary and defines the attribute keys used across packages.
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Attribute keys. Use these instead of ad-hoc names so logs can be searched
+// the same way regardless of which binary wrote them.
+const (
+	KeyMemberId   = "member_id"
+	KeyCustomerId = "customer_id"
+	KeyAccessId   = "access_id"
+	KeyEventId    = "event_id"
+	KeyEventType  = "event_type"
+	KeyAction     = "action"
+	KeyDryRun     = "dry_run"
+	KeyFirstName  = "first_name"
+	KeyLastName   = "last_name"
+	KeyName       = "name"
+	KeyEmail      = "email"
+	KeyError      = "error"
+)
+
+const redacted = "[REDACTED]"
+
+// piiKeys are the attributes dropped when redaction is enabled
+var piiKeys = map[string]bool{
+	KeyFirstName: true,
+	KeyLastName:  true,
+	KeyName:      true,
+	KeyEmail:     true,
+}
+
+type Options struct {
+	Format string
+	Level  string
+	Redact bool
+}
+
+// RegisterFlags adds the logging flags to fs
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Format, "log-format", "text", "Log output format: text or json")
+	fs.StringVar(&o.Level, "log-level", "info", "Minimum log level: debug, info, warn or error")
+	fs.BoolVar(&o.Redact, "log-redact", false, "Redact member names and emails from the logs")
+}
+
+// Setup installs the default logger according to o
+func Setup(o Options) error {
+	h, err := newHandler(os.Stderr, o)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+func newHandler(w io.Writer, o Options) (slog.Handler, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(o.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", o.Level)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	if o.Redact {
+		opts.ReplaceAttr = redact
+	}
+
+	switch strings.ToLower(o.Format) {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+
+	return nil, fmt.Errorf("invalid log format %q", o.Format)
+}
+
+func redact(_ []string, a slog.Attr) slog.Attr {
+	if piiKeys[a.Key] && a.Value.Kind() != slog.KindGroup {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// Err is the attribute errors are logged with
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+// Fatal logs msg at error level and exits
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// Inline logs the attributes of v at the top level instead of under a group,
+// so a member's id ends up as member_id wherever it's logged.
+func Inline(v slog.LogValuer) slog.Attr {
+	return slog.Any("", v)
+}
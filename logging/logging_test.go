@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestNewHandler(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "text", opts: Options{Format: "text", Level: "info"}},
+		{name: "json", opts: Options{Format: "JSON", Level: "debug"}},
+		{name: "bad format", opts: Options{Format: "xml", Level: "info"}, wantErr: true},
+		{name: "bad level", opts: Options{Format: "text", Level: "loud"}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newHandler(&bytes.Buffer{}, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		redact bool
+		want   string
+	}{
+		{name: "plain", redact: false, want: "Jane"},
+		{name: "redacted", redact: true, want: redacted},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			h, err := newHandler(buf, Options{Format: "json", Level: "info", Redact: tt.redact})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			slog.New(h).Info(
+				"test",
+				slog.Group("member", slog.Int(KeyMemberId, 1), slog.String(KeyFirstName, "Jane")),
+				slog.String(KeyEmail, "jane@example.com"),
+			)
+
+			var line struct {
+				Member struct {
+					MemberId  int    `json:"member_id"`
+					FirstName string `json:"first_name"`
+				} `json:"member"`
+				Email string `json:"email"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatal(err)
+			}
+
+			if line.Member.FirstName != tt.want {
+				t.Errorf("first_name: want %q, got %q", tt.want, line.Member.FirstName)
+			}
+			if tt.redact && line.Email != redacted {
+				t.Errorf("email not redacted: %q", line.Email)
+			}
+			if line.Member.MemberId != 1 {
+				t.Errorf("member_id should never be redacted, got %d", line.Member.MemberId)
+			}
+		})
+	}
+}
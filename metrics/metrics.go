@@ -0,0 +1,162 @@
+// Package metrics holds the Prometheus metrics exposed by the binaries
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const namespace = "fcfl"
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	ResultIgnored = "ignored"
+	ResultTimeout = "timeout"
+)
+
+var (
+	ReconcileDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "reconcile_duration_seconds",
+		Help:      "Time spent reconciling the remote and UniFi Access members.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	})
+
+	ReconcileRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "reconcile_runs_total",
+		Help:      "Reconcile runs by result.",
+	}, []string{"result"})
+
+	LastSuccessfulSync = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last reconcile run that finished without errors.",
+	})
+
+	MemberChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "member_changes_total",
+		Help:      "Members added, updated or disabled in UniFi Access, by result.",
+	}, []string{"action", "result"})
+
+	GRPCRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "server",
+		Name:      "grpc_requests_total",
+		Help:      "gRPC requests by method and status code.",
+	}, []string{"method", "code"})
+
+	GRPCDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "server",
+		Name:      "grpc_request_duration_seconds",
+		Help:      "gRPC request latency by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	WebhookEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "webhook",
+		Name:      "events_total",
+		Help:      "Stripe events received, by type and result.",
+	}, []string{"type", "result"})
+
+	WebhookSignatureFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "webhook",
+		Name:      "signature_failures_total",
+		Help:      "Stripe requests rejected because of an invalid signature.",
+	})
+
+	uaRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "unifi",
+		Name:      "requests_total",
+		Help:      "UniFi Access API calls by method and status code.",
+	}, []string{"method", "code"})
+
+	uaErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "unifi",
+		Name:      "request_errors_total",
+		Help:      "UniFi Access API calls that failed before getting a response.",
+	}, []string{"method"})
+
+	uaDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "unifi",
+		Name:      "request_duration_seconds",
+		Help:      "UniFi Access API call latency by method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+)
+
+// Result maps an error to the result label
+func Result(err error) string {
+	switch {
+	case err == nil:
+		return ResultSuccess
+	case errors.Is(err, context.DeadlineExceeded):
+		return ResultTimeout
+	}
+	return ResultFailure
+}
+
+// Serve exposes the metrics on addr under /metrics. It doesn't block.
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+
+	go func() {
+		slog.Info("Serving metrics", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("Error serving metrics", logging.Err(err))
+		}
+	}()
+}
+
+// InstrumentTransport wraps the transport used to talk to UniFi Access
+func InstrumentTransport(rt http.RoundTripper) http.RoundTripper {
+	instrumented := promhttp.InstrumentRoundTripperCounter(
+		uaRequests,
+		promhttp.InstrumentRoundTripperDuration(uaDuration, rt),
+	)
+
+	return promhttp.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := instrumented.RoundTrip(r)
+		if err != nil {
+			uaErrors.WithLabelValues(r.Method).Inc()
+		}
+		return resp, err
+	})
+}
+
+// UnaryServerInterceptor records the count and latency of gRPC requests
+func UnaryServerInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	GRPCDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+	GRPCRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+	return resp, err
+}
@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Config is the notifications file. Each sink lists the event classes it
+// wants to hear about.
+type Config struct {
+	SMTP     []SMTPConfig    `json:"smtp"`
+	Webhooks []WebhookConfig `json:"webhooks"`
+}
+
+// Load reads the config at path and returns a notifier for source with all
+// of its sinks routed.
+func Load(path string, source string) (*Notifier, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cfg Config
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("error parsing %q: %w", path, err)
+	}
+
+	n := New(source)
+	for _, c := range cfg.SMTP {
+		if c.Addr == "" || c.From == "" || len(c.To) == 0 {
+			return nil, fmt.Errorf("smtp sinks need addr, from and to")
+		}
+		if err := validClasses(c.Events); err != nil {
+			return nil, err
+		}
+		n.Route(NewSMTPSink(c), c.Events...)
+	}
+
+	for _, c := range cfg.Webhooks {
+		if c.URL == "" {
+			return nil, fmt.Errorf("webhook sinks need a url")
+		}
+		if err := validClasses(c.Events); err != nil {
+			return nil, err
+		}
+		n.Route(NewWebhookSink(c), c.Events...)
+	}
+
+	return n, nil
+}
+
+func validClasses(events []Class) error {
+	if len(events) == 0 {
+		return fmt.Errorf("sinks need at least one event class")
+	}
+
+	for _, e := range events {
+		if !slices.Contains(classes, e) {
+			return fmt.Errorf("unknown event class %q", e)
+		}
+	}
+
+	return nil
+}
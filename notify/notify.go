@@ -0,0 +1,171 @@
+// Package notify tells people about membership changes and sync problems.
+// Events are buffered and sent as one digest per sink when flushed.
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+)
+
+type Class string
+
+const (
+	ClassMemberAdded    Class = "member_added"
+	ClassMemberDisabled Class = "member_disabled"
+	ClassSyncFailure    Class = "sync_failure"
+	ClassGuardTripped   Class = "guard_tripped"
+)
+
+var classes = []Class{ClassMemberAdded, ClassMemberDisabled, ClassSyncFailure, ClassGuardTripped}
+
+const (
+	defaultAttempts = 4
+	defaultBackoff  = 2 * time.Second
+)
+
+type Event struct {
+	Class    Class     `json:"class"`
+	Time     time.Time `json:"time"`
+	MemberId int32     `json:"member_id,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Message  string    `json:"message"`
+}
+
+// Digest is what sinks get sent: every pending event they subscribed to
+type Digest struct {
+	Source string  `json:"source"`
+	Events []Event `json:"events"`
+}
+
+type Sink interface {
+	Name() string
+	Send(Digest) error
+}
+
+type route struct {
+	sink    Sink
+	classes map[Class]bool
+}
+
+// Notifier buffers events until Flush. It's safe for concurrent use, and a
+// nil *Notifier drops everything, so callers don't need to check whether
+// notifications are enabled.
+type Notifier struct {
+	source string
+	routes []route
+
+	mu      sync.Mutex
+	pending []Event
+
+	attempts int
+	backoff  time.Duration
+	now      func() time.Time
+}
+
+// New returns a notifier without sinks for source, usually the name of the
+// binary. Use Route to add sinks.
+func New(source string) *Notifier {
+	return &Notifier{
+		source:   source,
+		attempts: defaultAttempts,
+		backoff:  defaultBackoff,
+		now:      time.Now,
+	}
+}
+
+// Route sends the events in the given classes to sink
+func (n *Notifier) Route(sink Sink, classes ...Class) {
+	r := route{sink: sink, classes: make(map[Class]bool)}
+	for _, c := range classes {
+		r.classes[c] = true
+	}
+	n.routes = append(n.routes, r)
+}
+
+// Notify queues an event for the next Flush
+func (n *Notifier) Notify(e Event) {
+	if n == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = n.now()
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pending = append(n.pending, e)
+}
+
+// Flush sends the pending events to every sink routed to at least one of
+// them. Failing sinks are retried with exponential backoff, and the events
+// are dropped once all attempts have been used up.
+func (n *Notifier) Flush() error {
+	if n == nil {
+		return nil
+	}
+
+	n.mu.Lock()
+	pending := n.pending
+	n.pending = nil
+	n.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	var errs []error
+	for _, r := range n.routes {
+		d := Digest{Source: n.source}
+		for _, e := range pending {
+			if r.classes[e.Class] {
+				d.Events = append(d.Events, e)
+			}
+		}
+
+		if len(d.Events) == 0 {
+			continue
+		}
+
+		if err := n.send(r.sink, d); err != nil {
+			errs = append(errs, fmt.Errorf("error notifying %s: %w", r.sink.Name(), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) send(s Sink, d Digest) error {
+	var err error
+	for attempt := range n.attempts {
+		if attempt > 0 {
+			wait := n.backoff << (attempt - 1)
+			slog.Warn(
+				"Notification failed, retrying",
+				"sink", s.Name(),
+				"attempt", attempt,
+				"wait", wait,
+				logging.Err(err),
+			)
+			time.Sleep(wait)
+		}
+
+		if err = s.Send(d); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// Alert tells whether the given number of consecutive failures is worth
+// notifying about. It backs off exponentially, so a sync that keeps failing
+// pages on the 1st, 2nd, 4th, 8th... failure instead of on every run.
+func Alert(consecutive int) bool {
+	return consecutive > 0 && consecutive&(consecutive-1) == 0
+}
@@ -0,0 +1,304 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSink struct {
+	digests []Digest
+	fails   int
+}
+
+func (f *fakeSink) Name() string { return "fake" }
+
+func (f *fakeSink) Send(d Digest) error {
+	if f.fails > 0 {
+		f.fails--
+		return errors.New("unavailable")
+	}
+	f.digests = append(f.digests, d)
+	return nil
+}
+
+func newTestNotifier() *Notifier {
+	n := New("test")
+	n.backoff = time.Millisecond
+	return n
+}
+
+func TestFlush(t *testing.T) {
+	added := Event{Class: ClassMemberAdded, MemberId: 1, Message: "added"}
+	disabled := Event{Class: ClassMemberDisabled, MemberId: 2, Message: "disabled"}
+
+	for _, tt := range []struct {
+		name        string
+		classes     []Class
+		events      []Event
+		fails       int
+		wantDigests int
+		wantEvents  int
+		wantErr     bool
+	}{
+		{
+			name:        "One digest per flush",
+			classes:     []Class{ClassMemberAdded, ClassMemberDisabled},
+			events:      []Event{added, disabled, added},
+			wantDigests: 1,
+			wantEvents:  3,
+		},
+		{
+			name:        "Only routed classes",
+			classes:     []Class{ClassMemberDisabled},
+			events:      []Event{added, disabled},
+			wantDigests: 1,
+			wantEvents:  1,
+		},
+		{
+			name:    "Nothing routed",
+			classes: []Class{ClassSyncFailure},
+			events:  []Event{added, disabled},
+		},
+		{
+			name:        "Retried after failures",
+			classes:     []Class{ClassMemberAdded},
+			events:      []Event{added},
+			fails:       defaultAttempts - 1,
+			wantDigests: 1,
+			wantEvents:  1,
+		},
+		{
+			name:    "Gives up",
+			classes: []Class{ClassMemberAdded},
+			events:  []Event{added},
+			fails:   defaultAttempts,
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &fakeSink{fails: tt.fails}
+			n := newTestNotifier()
+			n.Route(sink, tt.classes...)
+			for _, e := range tt.events {
+				n.Notify(e)
+			}
+
+			err := n.Flush()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(sink.digests) != tt.wantDigests {
+				t.Fatalf("want %d digests, got %d", tt.wantDigests, len(sink.digests))
+			}
+			if tt.wantDigests > 0 && len(sink.digests[0].Events) != tt.wantEvents {
+				t.Errorf("want %d events, got %d", tt.wantEvents, len(sink.digests[0].Events))
+			}
+
+			// Events are only sent once
+			if err := n.Flush(); err != nil || len(sink.digests) != tt.wantDigests {
+				t.Errorf("second flush sent again: %v", err)
+			}
+		})
+	}
+}
+
+func TestNilNotifier(t *testing.T) {
+	var n *Notifier
+	n.Notify(Event{Class: ClassMemberAdded})
+	if err := n.Flush(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAlert(t *testing.T) {
+	var alerted []int
+	for i := range 20 {
+		if Alert(i) {
+			alerted = append(alerted, i)
+		}
+	}
+
+	want := []int{1, 2, 4, 8, 16}
+	if len(alerted) != len(want) {
+		t.Fatalf("want %v, got %v", want, alerted)
+	}
+	for i := range want {
+		if alerted[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, alerted)
+		}
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got Digest
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	s := NewWebhookSink(WebhookConfig{URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer x"}})
+	err := s.Send(Digest{Source: "test", Events: []Event{{Class: ClassSyncFailure, Message: "boom"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Source != "test" || len(got.Events) != 1 || got.Events[0].Message != "boom" {
+		t.Errorf("unexpected digest: %+v", got)
+	}
+	if auth != "Bearer x" {
+		t.Errorf("missing header, got %q", auth)
+	}
+}
+
+func TestWebhookSinkError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	if err := NewWebhookSink(WebhookConfig{URL: srv.URL}).Send(Digest{}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// fakeSMTP accepts a single message and returns its data
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+
+	data := make(chan string, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var msg strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					data <- msg.String()
+					reply("250 OK")
+					continue
+				}
+				msg.WriteString(line)
+				continue
+			}
+
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "DATA":
+				inData = true
+				reply("354 go ahead")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return lis.Addr().String(), data
+}
+
+func TestSMTPSink(t *testing.T) {
+	addr, data := fakeSMTP(t)
+
+	s := NewSMTPSink(SMTPConfig{Addr: addr, From: "sync@example.com", To: []string{"board@example.com"}})
+	err := s.Send(Digest{Source: "client", Events: []Event{
+		{Class: ClassMemberDisabled, Time: time.Now(), Message: "Disabled member 1"},
+		{Class: ClassMemberDisabled, Time: time.Now(), Message: "Disabled member 2"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-data
+	for _, want := range []string{
+		"Subject: [client] 2 membership notifications",
+		"To: board@example.com",
+		"Disabled member 1",
+		"Disabled member 2",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message is missing %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		config     string
+		wantRoutes int
+		wantErr    bool
+	}{
+		{
+			name: "Both sinks",
+			config: `{
+				"smtp": [{"addr": "localhost:25", "from": "a@example.com", "to": ["b@example.com"], "events": ["member_disabled"]}],
+				"webhooks": [{"url": "http://localhost/hook", "events": ["sync_failure", "guard_tripped"]}]
+			}`,
+			wantRoutes: 2,
+		},
+		{
+			name:    "Unknown class",
+			config:  `{"webhooks": [{"url": "http://localhost/hook", "events": ["member_eaten"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "No classes",
+			config:  `{"webhooks": [{"url": "http://localhost/hook"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Incomplete smtp",
+			config:  `{"smtp": [{"addr": "localhost:25", "events": ["member_added"]}]}`,
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "notify.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			n, err := Load(path, "test")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && len(n.routes) != tt.wantRoutes {
+				t.Errorf("want %d routes, got %d", tt.wantRoutes, len(n.routes))
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type SMTPConfig struct {
+	// Addr is the host:port of the SMTP server
+	Addr     string   `json:"addr"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Events   []Class  `json:"events"`
+}
+
+type SMTPSink struct {
+	cfg SMTPConfig
+}
+
+func NewSMTPSink(cfg SMTPConfig) *SMTPSink {
+	return &SMTPSink{cfg: cfg}
+}
+
+func (s *SMTPSink) Name() string {
+	return "smtp " + s.cfg.Addr
+}
+
+func (s *SMTPSink) Send(d Digest) error {
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(s.cfg.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)
+	}
+
+	return smtp.SendMail(s.cfg.Addr, auth, s.cfg.From, s.cfg.To, s.message(d))
+}
+
+func (s *SMTPSink) message(d Digest) []byte {
+	subject := fmt.Sprintf("[%s] %d membership notifications", d.Source, len(d.Events))
+	if len(d.Events) == 1 {
+		subject = fmt.Sprintf("[%s] %s", d.Source, d.Events[0].Message)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	for _, e := range d.Events {
+		fmt.Fprintf(&b, "%s  %-16s %s\r\n", e.Time.Format(time.DateTime), e.Class, e.Message)
+	}
+
+	return b.Bytes()
+}
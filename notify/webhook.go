@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// Headers are added to every request, e.g. for authentication
+	Headers map[string]string `json:"headers,omitempty"`
+	Events  []Class           `json:"events"`
+}
+
+// WebhookSink POSTs every digest as JSON to a URL
+type WebhookSink struct {
+	cfg        WebhookConfig
+	httpClient *http.Client
+}
+
+func NewWebhookSink(cfg WebhookConfig) *WebhookSink {
+	return &WebhookSink{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w *WebhookSink) Name() string {
+	return "webhook " + w.cfg.URL
+}
+
+func (w *WebhookSink) Send(d Digest) error {
+	body, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+// Package overrides lets a locally managed list of people get door access
+// regardless of their membership: guests, instructors or board members can
+// be granted access for a while, and members can be denied it.
+package overrides
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+const (
+	ActionGrant = "grant"
+	ActionDeny  = "deny"
+)
+
+// Override is an entry of the overrides file. Grants need a name, as they
+// may create a UniFi Access user. Expires is a date in
+// YYYY-MM-DD format; the override applies until the end of that day. An
+// empty Expires means the override never expires.
+type Override struct {
+	Id        int32  `json:"id"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	Action    string `json:"action"`
+	Expires   string `json:"expires,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+
+	expires time.Time
+}
+
+// Load reads and validates the JSON overrides file
+func Load(path string) ([]Override, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading overrides %q: %w", path, err)
+	}
+
+	var overrides []Override
+	if err := json.Unmarshal(raw, &overrides); err != nil {
+		return nil, fmt.Errorf("error decoding overrides %q: %w", path, err)
+	}
+
+	for i := range overrides {
+		if err := overrides[i].validate(); err != nil {
+			return nil, fmt.Errorf("invalid override #%d in %q: %w", i+1, path, err)
+		}
+	}
+
+	return overrides, nil
+}
+
+func (o *Override) validate() error {
+	if o.Id == 0 {
+		return fmt.Errorf("missing id")
+	}
+
+	if o.Action != ActionGrant && o.Action != ActionDeny {
+		return fmt.Errorf("unknown action %q for %d", o.Action, o.Id)
+	}
+
+	if o.Action == ActionGrant && o.FirstName == "" && o.LastName == "" {
+		return fmt.Errorf("missing name for grant to %d", o.Id)
+	}
+
+	if o.Expires != "" {
+		t, err := time.ParseInLocation(time.DateOnly, o.Expires, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid expiry date for %d: %w", o.Id, err)
+		}
+		o.expires = t.AddDate(0, 0, 1)
+	}
+
+	return nil
+}
+
+func (o *Override) expired(now time.Time) bool {
+	return !o.expires.IsZero() && !now.Before(o.expires)
+}
+
+// Apply merges the overrides into the remote member set. Granted members are
+// added, or kept if they're already there, and denied members are removed.
+// Expired overrides are ignored, so a guest whose grant expired is missing
+// from the result and Reconcile disables them.
+func Apply(remote types.MemberSet, overrides []Override, now time.Time) types.MemberSet {
+	merged := remote.Clone()
+	remoteIds := types.ToIdMap(remote)
+
+	for _, o := range overrides {
+		if o.expired(now) {
+			slog.Info("Ignoring expired override", o.attrs()...)
+			continue
+		}
+
+		m, isMember := remoteIds[o.Id]
+		switch o.Action {
+		case ActionGrant:
+			if isMember {
+				slog.Info("Override grants access to a member who already has it", o.attrs()...)
+				continue
+			}
+			m = types.ComparableMember{
+				Id:        o.Id,
+				FirstName: o.FirstName,
+				LastName:  o.LastName,
+				Status:    types.StatusActive,
+			}
+			slog.Info("Override granting access", append(o.attrs(), logging.KeyFirstName, m.FirstName, logging.KeyLastName, m.LastName)...)
+			merged.Add(m)
+
+		case ActionDeny:
+			if !isMember {
+				slog.Info("Override denies access to someone without it", o.attrs()...)
+				continue
+			}
+			slog.Info("Override denying access", append(o.attrs(), logging.KeyFirstName, m.FirstName, logging.KeyLastName, m.LastName)...)
+			merged.Remove(m)
+		}
+	}
+
+	return merged
+}
+
+func (o Override) attrs() []any {
+	expires := o.Expires
+	if expires == "" {
+		expires = "never"
+	}
+
+	return []any{
+		logging.KeyMemberId, o.Id,
+		logging.KeyAction, o.Action,
+		"expires", expires,
+		"reason", o.Reason,
+	}
+}
@@ -0,0 +1,115 @@
+package overrides
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+var (
+	now = time.Date(2025, 3, 15, 12, 0, 0, 0, time.Local)
+
+	m1 = types.ComparableMember{Id: 1, FirstName: "m1", Status: types.StatusActive}
+	m2 = types.ComparableMember{Id: 2, FirstName: "m2", Status: types.StatusActive}
+	g1 = types.ComparableMember{Id: 9001, FirstName: "guest", LastName: "one", Status: types.StatusActive}
+)
+
+func writeOverrides(t *testing.T, content string) string {
+	p := path.Join(t.TempDir(), "overrides.json")
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoad(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		content    string
+		shouldFail bool
+	}{
+		{
+			name:    "Valid overrides",
+			content: `[{"id":9001,"first_name":"guest","action":"grant","expires":"2025-03-31"},{"id":2,"action":"deny"}]`,
+		},
+		{name: "Malformed json", content: `[{`, shouldFail: true},
+		{name: "Missing id", content: `[{"action":"grant"}]`, shouldFail: true},
+		{name: "Grant without names", content: `[{"id":9001,"action":"grant"}]`, shouldFail: true},
+		{name: "Unknown action", content: `[{"id":1,"action":"allow"}]`, shouldFail: true},
+		{name: "Invalid expiry", content: `[{"id":1,"action":"deny","expires":"31/03/2025"}]`, shouldFail: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeOverrides(t, tt.content))
+			if (err != nil) != tt.shouldFail {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		remote    types.MemberSet
+		overrides string
+		want      types.MemberSet
+	}{
+		{
+			name:      "Guest gets access",
+			remote:    types.NewMemberSet(m1),
+			overrides: `[{"id":9001,"first_name":"guest","last_name":"one","action":"grant","expires":"2025-03-31"}]`,
+			want:      types.NewMemberSet(m1, g1),
+		},
+		{
+			name:      "Grant without expiry",
+			remote:    types.NewMemberSet(m1),
+			overrides: `[{"id":9001,"first_name":"guest","last_name":"one","action":"grant"}]`,
+			want:      types.NewMemberSet(m1, g1),
+		},
+		{
+			name:      "Grant lasts until the end of the expiry day",
+			remote:    types.NewMemberSet(m1),
+			overrides: `[{"id":9001,"first_name":"guest","last_name":"one","action":"grant","expires":"2025-03-15"}]`,
+			want:      types.NewMemberSet(m1, g1),
+		},
+		{
+			name:      "Expired grant is ignored",
+			remote:    types.NewMemberSet(m1),
+			overrides: `[{"id":9001,"first_name":"guest","last_name":"one","action":"grant","expires":"2025-03-14"}]`,
+			want:      types.NewMemberSet(m1),
+		},
+		{
+			name:      "Grant to a member keeps the member's details",
+			remote:    types.NewMemberSet(m1),
+			overrides: `[{"id":1,"first_name":"other","action":"grant"}]`,
+			want:      types.NewMemberSet(m1),
+		},
+		{
+			name:      "Suspended member is denied",
+			remote:    types.NewMemberSet(m1, m2),
+			overrides: `[{"id":2,"action":"deny","reason":"suspended"}]`,
+			want:      types.NewMemberSet(m1),
+		},
+		{
+			name:      "Expired deny is ignored",
+			remote:    types.NewMemberSet(m1, m2),
+			overrides: `[{"id":2,"action":"deny","expires":"2025-01-01"}]`,
+			want:      types.NewMemberSet(m1, m2),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			overrides, err := Load(writeOverrides(t, tt.overrides))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := Apply(tt.remote, overrides, now)
+			if !got.Equal(tt.want) {
+				t.Errorf("unexpected members. Got: %v Want: %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.35.2
+// 	protoc-gen-go v1.36.6
 // 	protoc        v5.29.0
 // source: proto/members.proto
 
@@ -11,6 +11,7 @@ import (
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
 	sync "sync"
+	unsafe "unsafe"
 )
 
 const (
@@ -21,11 +22,10 @@ const (
 )
 
 type MemberList struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Members       []*Member              `protobuf:"bytes,1,rep,name=members,proto3" json:"members,omitempty"`
 	unknownFields protoimpl.UnknownFields
-
-	Members []*Member `protobuf:"bytes,1,rep,name=members,proto3" json:"members,omitempty"`
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *MemberList) Reset() {
@@ -66,14 +66,14 @@ func (x *MemberList) GetMembers() []*Member {
 }
 
 type Member struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FirstName     string                 `protobuf:"bytes,1,opt,name=first_name,json=firstName,proto3" json:"first_name,omitempty"`
+	LastName      string                 `protobuf:"bytes,2,opt,name=last_name,json=lastName,proto3" json:"last_name,omitempty"`
+	CardId        string                 `protobuf:"bytes,3,opt,name=card_id,json=cardId,proto3" json:"card_id,omitempty"`
+	Id            int32                  `protobuf:"varint,4,opt,name=id,proto3" json:"id,omitempty"`
+	Email         string                 `protobuf:"bytes,5,opt,name=email,proto3" json:"email,omitempty"`
 	unknownFields protoimpl.UnknownFields
-
-	FirstName string `protobuf:"bytes,1,opt,name=first_name,json=firstName,proto3" json:"first_name,omitempty"`
-	LastName  string `protobuf:"bytes,2,opt,name=last_name,json=lastName,proto3" json:"last_name,omitempty"`
-	CardId    string `protobuf:"bytes,3,opt,name=card_id,json=cardId,proto3" json:"card_id,omitempty"`
-	Id        int32  `protobuf:"varint,4,opt,name=id,proto3" json:"id,omitempty"`
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Member) Reset() {
@@ -134,10 +134,17 @@ func (x *Member) GetId() int32 {
 	return 0
 }
 
+func (x *Member) GetEmail() string {
+	if x != nil {
+		return x.Email
+	}
+	return ""
+}
+
 type Empty struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
+	state         protoimpl.MessageState `protogen:"open.v1"`
 	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Empty) Reset() {
@@ -170,56 +177,100 @@ func (*Empty) Descriptor() ([]byte, []int) {
 	return file_proto_members_proto_rawDescGZIP(), []int{2}
 }
 
-var File_proto_members_proto protoreflect.FileDescriptor
+type MemberRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int32                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MemberRequest) Reset() {
+	*x = MemberRequest{}
+	mi := &file_proto_members_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MemberRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MemberRequest) ProtoMessage() {}
+
+func (x *MemberRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_members_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
 
-var file_proto_members_proto_rawDesc = []byte{
-	0x0a, 0x13, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x2e,
-	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x07, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x22, 0x37,
-	0x0a, 0x0a, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x29, 0x0a, 0x07,
-	0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e,
-	0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x2e, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x52, 0x07,
-	0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x22, 0x6d, 0x0a, 0x06, 0x4d, 0x65, 0x6d, 0x62, 0x65,
-	0x72, 0x12, 0x1d, 0x0a, 0x0a, 0x66, 0x69, 0x72, 0x73, 0x74, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18,
-	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x66, 0x69, 0x72, 0x73, 0x74, 0x4e, 0x61, 0x6d, 0x65,
-	0x12, 0x1b, 0x0a, 0x09, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20,
-	0x01, 0x28, 0x09, 0x52, 0x08, 0x6c, 0x61, 0x73, 0x74, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x17, 0x0a,
-	0x07, 0x63, 0x61, 0x72, 0x64, 0x5f, 0x69, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06,
-	0x63, 0x61, 0x72, 0x64, 0x49, 0x64, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x04, 0x20, 0x01,
-	0x28, 0x05, 0x52, 0x02, 0x69, 0x64, 0x22, 0x07, 0x0a, 0x05, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x32,
-	0x3b, 0x0a, 0x0a, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x12, 0x2d, 0x0a,
-	0x04, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x0e, 0x2e, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x2e,
-	0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x13, 0x2e, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x2e,
-	0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x4c, 0x69, 0x73, 0x74, 0x22, 0x00, 0x42, 0x30, 0x5a, 0x2e,
-	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x66, 0x61, 0x74, 0x63, 0x61,
-	0x74, 0x66, 0x61, 0x62, 0x6c, 0x61, 0x62, 0x2f, 0x66, 0x63, 0x66, 0x6c, 0x2d, 0x6d, 0x65, 0x6d,
-	0x62, 0x65, 0x72, 0x2d, 0x73, 0x79, 0x6e, 0x63, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06,
-	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+// Deprecated: Use MemberRequest.ProtoReflect.Descriptor instead.
+func (*MemberRequest) Descriptor() ([]byte, []int) {
+	return file_proto_members_proto_rawDescGZIP(), []int{3}
 }
 
+func (x *MemberRequest) GetId() int32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+var File_proto_members_proto protoreflect.FileDescriptor
+
+const file_proto_members_proto_rawDesc = "" +
+	"\n" +
+	"\x13proto/members.proto\x12\amembers\"7\n" +
+	"\n" +
+	"MemberList\x12)\n" +
+	"\amembers\x18\x01 \x03(\v2\x0f.members.MemberR\amembers\"\x83\x01\n" +
+	"\x06Member\x12\x1d\n" +
+	"\n" +
+	"first_name\x18\x01 \x01(\tR\tfirstName\x12\x1b\n" +
+	"\tlast_name\x18\x02 \x01(\tR\blastName\x12\x17\n" +
+	"\acard_id\x18\x03 \x01(\tR\x06cardId\x12\x0e\n" +
+	"\x02id\x18\x04 \x01(\x05R\x02id\x12\x14\n" +
+	"\x05email\x18\x05 \x01(\tR\x05email\"\a\n" +
+	"\x05Empty\"\x1f\n" +
+	"\rMemberRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x05R\x02id2s\n" +
+	"\n" +
+	"Membership\x12-\n" +
+	"\x04List\x12\x0e.members.Empty\x1a\x13.members.MemberList\"\x00\x126\n" +
+	"\tGetMember\x12\x16.members.MemberRequest\x1a\x0f.members.Member\"\x00B0Z.github.com/fatcatfablab/fcfl-member-sync/protob\x06proto3"
+
 var (
 	file_proto_members_proto_rawDescOnce sync.Once
-	file_proto_members_proto_rawDescData = file_proto_members_proto_rawDesc
+	file_proto_members_proto_rawDescData []byte
 )
 
 func file_proto_members_proto_rawDescGZIP() []byte {
 	file_proto_members_proto_rawDescOnce.Do(func() {
-		file_proto_members_proto_rawDescData = protoimpl.X.CompressGZIP(file_proto_members_proto_rawDescData)
+		file_proto_members_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_members_proto_rawDesc), len(file_proto_members_proto_rawDesc)))
 	})
 	return file_proto_members_proto_rawDescData
 }
 
-var file_proto_members_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_proto_members_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_proto_members_proto_goTypes = []any{
-	(*MemberList)(nil), // 0: members.MemberList
-	(*Member)(nil),     // 1: members.Member
-	(*Empty)(nil),      // 2: members.Empty
+	(*MemberList)(nil),    // 0: members.MemberList
+	(*Member)(nil),        // 1: members.Member
+	(*Empty)(nil),         // 2: members.Empty
+	(*MemberRequest)(nil), // 3: members.MemberRequest
 }
 var file_proto_members_proto_depIdxs = []int32{
 	1, // 0: members.MemberList.members:type_name -> members.Member
 	2, // 1: members.Membership.List:input_type -> members.Empty
-	0, // 2: members.Membership.List:output_type -> members.MemberList
-	2, // [2:3] is the sub-list for method output_type
-	1, // [1:2] is the sub-list for method input_type
+	3, // 2: members.Membership.GetMember:input_type -> members.MemberRequest
+	0, // 3: members.Membership.List:output_type -> members.MemberList
+	1, // 4: members.Membership.GetMember:output_type -> members.Member
+	3, // [3:5] is the sub-list for method output_type
+	1, // [1:3] is the sub-list for method input_type
 	1, // [1:1] is the sub-list for extension type_name
 	1, // [1:1] is the sub-list for extension extendee
 	0, // [0:1] is the sub-list for field type_name
@@ -234,9 +285,9 @@ func file_proto_members_proto_init() {
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
-			RawDescriptor: file_proto_members_proto_rawDesc,
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_members_proto_rawDesc), len(file_proto_members_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -245,7 +296,6 @@ func file_proto_members_proto_init() {
 		MessageInfos:      file_proto_members_proto_msgTypes,
 	}.Build()
 	File_proto_members_proto = out.File
-	file_proto_members_proto_rawDesc = nil
 	file_proto_members_proto_goTypes = nil
 	file_proto_members_proto_depIdxs = nil
 }
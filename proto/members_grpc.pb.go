@@ -19,7 +19,8 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	Membership_List_FullMethodName = "/members.Membership/List"
+	Membership_List_FullMethodName      = "/members.Membership/List"
+	Membership_GetMember_FullMethodName = "/members.Membership/GetMember"
 )
 
 // MembershipClient is the client API for Membership service.
@@ -27,6 +28,7 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MembershipClient interface {
 	List(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*MemberList, error)
+	GetMember(ctx context.Context, in *MemberRequest, opts ...grpc.CallOption) (*Member, error)
 }
 
 type membershipClient struct {
@@ -47,11 +49,22 @@ func (c *membershipClient) List(ctx context.Context, in *Empty, opts ...grpc.Cal
 	return out, nil
 }
 
+func (c *membershipClient) GetMember(ctx context.Context, in *MemberRequest, opts ...grpc.CallOption) (*Member, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Member)
+	err := c.cc.Invoke(ctx, Membership_GetMember_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MembershipServer is the server API for Membership service.
 // All implementations must embed UnimplementedMembershipServer
 // for forward compatibility.
 type MembershipServer interface {
 	List(context.Context, *Empty) (*MemberList, error)
+	GetMember(context.Context, *MemberRequest) (*Member, error)
 	mustEmbedUnimplementedMembershipServer()
 }
 
@@ -65,6 +78,9 @@ type UnimplementedMembershipServer struct{}
 func (UnimplementedMembershipServer) List(context.Context, *Empty) (*MemberList, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
 }
+func (UnimplementedMembershipServer) GetMember(context.Context, *MemberRequest) (*Member, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMember not implemented")
+}
 func (UnimplementedMembershipServer) mustEmbedUnimplementedMembershipServer() {}
 func (UnimplementedMembershipServer) testEmbeddedByValue()                    {}
 
@@ -104,6 +120,24 @@ func _Membership_List_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Membership_GetMember_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MemberRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MembershipServer).GetMember(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Membership_GetMember_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MembershipServer).GetMember(ctx, req.(*MemberRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Membership_ServiceDesc is the grpc.ServiceDesc for Membership service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -115,6 +149,10 @@ var Membership_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "List",
 			Handler:    _Membership_List_Handler,
 		},
+		{
+			MethodName: "GetMember",
+			Handler:    _Membership_GetMember_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/members.proto",
@@ -0,0 +1,189 @@
+// Package gateway serves a read-only HTTP/JSON mirror of the Membership
+// service, for tools that can't speak gRPC.
+//
+// Every response is JSON. Field names are the ones in proto/members.proto and
+// every field is always present, even when empty:
+//
+//	GET /v1/members       {"members": [Member, ...]}
+//	GET /v1/members/{id}  Member, or 404 when there's no current member with that id
+//	GET /healthz          {"status": "ok"}, or 503 when the member source is down
+//
+// where Member is
+//
+//	{"first_name": "Jane", "last_name": "Doe", "card_id": "1234", "id": 42, "email": "jane@example.com"}
+//
+// Errors come as {"error": "message"} with a matching HTTP status.
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	pb "github.com/fatcatfablab/fcfl-member-sync/proto"
+)
+
+var marshaler = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+type Gateway struct {
+	membership pb.MembershipServer
+	health     func(context.Context) error
+	tokens     [][]byte
+}
+
+// New returns a gateway answering with what the given Membership server
+// does. health tells whether the member source is reachable.
+func New(membership pb.MembershipServer, health func(context.Context) error) *Gateway {
+	return &Gateway{membership: membership, health: health}
+}
+
+// RequireTokens makes the gateway refuse requests without one of the given
+// bearer tokens. The health check never needs one.
+func (g *Gateway) RequireTokens(tokens []string) {
+	for _, t := range tokens {
+		g.tokens = append(g.tokens, []byte(t))
+	}
+}
+
+func (g *Gateway) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /v1/members", g.auth(http.HandlerFunc(g.list)))
+	mux.Handle("GET /v1/members/{id}", g.auth(http.HandlerFunc(g.get)))
+	mux.HandleFunc("GET /healthz", g.healthz)
+
+	return mux
+}
+
+func (g *Gateway) list(w http.ResponseWriter, req *http.Request) {
+	members, err := g.membership.List(req.Context(), &pb.Empty{})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeMessage(w, members)
+}
+
+func (g *Gateway) get(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(req.PathValue("id"), 10, 32)
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "invalid member id %q", req.PathValue("id")))
+		return
+	}
+
+	m, err := g.membership.GetMember(req.Context(), &pb.MemberRequest{Id: int32(id)})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeMessage(w, m)
+}
+
+func (g *Gateway) healthz(w http.ResponseWriter, req *http.Request) {
+	if err := g.health(req.Context()); err != nil {
+		slog.Error("Health check failed", logging.Err(err))
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (g *Gateway) auth(next http.Handler) http.Handler {
+	if len(g.tokens) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			for _, t := range g.tokens {
+				if subtle.ConstantTimeCompare([]byte(token), t) == 1 {
+					next.ServeHTTP(w, req)
+					return
+				}
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, status.Error(codes.Unauthenticated, "missing or invalid bearer token"))
+	})
+}
+
+// LoadTokens reads the bearer tokens in path, one per line. Empty lines and
+// lines starting with # are skipped.
+func LoadTokens(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening tokens file: %w", err)
+	}
+	defer f.Close()
+
+	var tokens []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading tokens file: %w", err)
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens in %q", path)
+	}
+
+	return tokens, nil
+}
+
+func writeMessage(w http.ResponseWriter, m proto.Message) {
+	b, err := marshaler.Marshal(m)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	msg := "internal error"
+
+	switch status.Code(err) {
+	case codes.NotFound:
+		code, msg = http.StatusNotFound, status.Convert(err).Message()
+	case codes.InvalidArgument:
+		code, msg = http.StatusBadRequest, status.Convert(err).Message()
+	case codes.Unauthenticated:
+		code, msg = http.StatusUnauthorized, status.Convert(err).Message()
+	default:
+		slog.Error("Error serving request", logging.Err(err))
+	}
+
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,176 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"slices"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/fatcatfablab/fcfl-member-sync/proto"
+)
+
+type fakeMembership struct {
+	pb.UnimplementedMembershipServer
+}
+
+func (fakeMembership) List(context.Context, *pb.Empty) (*pb.MemberList, error) {
+	return &pb.MemberList{Members: []*pb.Member{
+		{Id: 1, FirstName: "Jane", LastName: "Doe", CardId: "1234", Email: "jane@example.com"},
+		{Id: 2, FirstName: "John"},
+	}}, nil
+}
+
+func (fakeMembership) GetMember(_ context.Context, r *pb.MemberRequest) (*pb.Member, error) {
+	switch r.Id {
+	case 1:
+		return &pb.Member{Id: 1, FirstName: "Jane", LastName: "Doe"}, nil
+	case 2:
+		return nil, errors.New("database is gone")
+	}
+	return nil, status.Error(codes.NotFound, "member not found")
+}
+
+func TestGateway(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		path     string
+		tokens   []string
+		token    string
+		health   error
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "List",
+			path:     "/v1/members",
+			wantCode: http.StatusOK,
+			wantBody: `{"members":[{"first_name":"Jane","last_name":"Doe","card_id":"1234","id":1,"email":"jane@example.com"},{"first_name":"John","last_name":"","card_id":"","id":2,"email":""}]}`,
+		},
+		{
+			name:     "Get",
+			path:     "/v1/members/1",
+			wantCode: http.StatusOK,
+			wantBody: `{"first_name":"Jane","last_name":"Doe","card_id":"","id":1,"email":""}`,
+		},
+		{
+			name:     "Get unknown member",
+			path:     "/v1/members/3",
+			wantCode: http.StatusNotFound,
+			wantBody: `{"error":"member not found"}`,
+		},
+		{
+			name:     "Get invalid id",
+			path:     "/v1/members/abc",
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"error":"invalid member id \"abc\""}`,
+		},
+		{
+			name:     "Internal errors aren't leaked",
+			path:     "/v1/members/2",
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"error":"internal error"}`,
+		},
+		{
+			name:     "Valid token",
+			path:     "/v1/members/1",
+			tokens:   []string{"t1", "t2"},
+			token:    "t2",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Missing token",
+			path:     "/v1/members",
+			tokens:   []string{"t1"},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Wrong token",
+			path:     "/v1/members",
+			tokens:   []string{"t1"},
+			token:    "t3",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Healthy without token",
+			path:     "/healthz",
+			tokens:   []string{"t1"},
+			wantCode: http.StatusOK,
+			wantBody: `{"status":"ok"}`,
+		},
+		{
+			name:     "Unhealthy",
+			path:     "/healthz",
+			health:   errors.New("down"),
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: `{"status":"unavailable"}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			g := New(fakeMembership{}, func(context.Context) error { return tt.health })
+			if tt.tokens != nil {
+				g.RequireTokens(tt.tokens)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			g.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("want status %d, got %d", tt.wantCode, rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("unexpected content type %q", ct)
+			}
+			// protojson randomly adds spaces to keep people from relying on
+			// its exact output, so compare the decoded bodies
+			if tt.wantBody != "" && !sameJSON(t, tt.wantBody, rec.Body.String()) {
+				t.Errorf("want body %s, got %s", tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func sameJSON(t *testing.T, a, b string) bool {
+	var va, vb any
+	if err := json.Unmarshal([]byte(a), &va); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(b), &vb); err != nil {
+		t.Fatal(err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestLoadTokens(t *testing.T) {
+	p := path.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(p, []byte("# kiosk\nt1\n\n  t2  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := LoadTokens(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(tokens, []string{"t1", "t2"}) {
+		t.Errorf("unexpected tokens: %v", tokens)
+	}
+
+	if err := os.WriteFile(p, []byte("# nothing\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTokens(p); err == nil {
+		t.Error("want error for a file without tokens")
+	}
+}
@@ -5,8 +5,11 @@ package userlist
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -21,21 +24,62 @@ type queryRow struct {
 	CardId    int
 }
 
+// ErrNotFound is returned by Get when the contact isn't a current member
+var ErrNotFound = errors.New("member not found")
+
 var (
-	db    *sql.DB
-	query = `
-		SELECT co.id, co.first_name, co.last_name, ca.card_id
+	db          *sql.DB
+	selectQuery = `
+		SELECT co.id, co.first_name, co.last_name, ca.card_id, e.email
 		FROM civicrm_contact co
 		JOIN civicrm_membership m ON co.id=m.contact_id
 		LEFT JOIN civicrm_accesscard_cards ca on co.id=ca.contact_id
+		LEFT JOIN civicrm_email e on co.id=e.contact_id AND e.is_primary=1
 		WHERE m.status_id < 4
-		ORDER BY co.id;
 	`
-	initialized = false
+	// Dependents are on either side of an active relationship with a current
+	// member
+	dependentsQuery = `
+		SELECT co.id, co.first_name, co.last_name, ca.card_id, e.email
+		FROM civicrm_contact co
+		JOIN civicrm_relationship r ON co.id IN (r.contact_id_a, r.contact_id_b)
+		JOIN civicrm_membership m ON m.contact_id =
+			CASE WHEN r.contact_id_a=co.id THEN r.contact_id_b ELSE r.contact_id_a END
+		LEFT JOIN civicrm_accesscard_cards ca on co.id=ca.contact_id
+		LEFT JOIN civicrm_email e on co.id=e.contact_id AND e.is_primary=1
+		WHERE m.status_id < 4 AND r.is_active=1 AND r.relationship_type_id IN (%s)
+	`
+	query       = selectQuery + "ORDER BY co.id;"
+	memberQuery = selectQuery + "AND co.id = ? LIMIT 1;"
+	// relationshipTypes fill the placeholders of the dependents part of query
+	// and memberQuery
+	relationshipTypes []any
+	initialized       = false
 )
 
+// SetDependentRelationships makes the contacts related to a current member
+// by any of the given relationship types, e.g. the household members of a
+// family plan, count as members for as long as the membership lasts.
+func SetDependentRelationships(typeIds []int) {
+	relationshipTypes = nil
+	if len(typeIds) == 0 {
+		query = selectQuery + "ORDER BY co.id;"
+		memberQuery = selectQuery + "AND co.id = ? LIMIT 1;"
+		return
+	}
+
+	for _, id := range typeIds {
+		relationshipTypes = append(relationshipTypes, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(typeIds)), ", ")
+	union := selectQuery + "UNION" + fmt.Sprintf(dependentsQuery, placeholders)
+
+	query = union + "ORDER BY 1;"
+	memberQuery = "SELECT * FROM (" + union + ") AS members WHERE id = ? LIMIT 1;"
+}
+
 func Init(driver, dsn string) error {
-	log.Printf("Setting up db connection")
+	slog.Info("Setting up db connection")
 
 	var err error
 	db, err = sql.Open(driver, dsn)
@@ -60,33 +104,78 @@ func List(ctx context.Context) (*pb.MemberList, error) {
 		panic("List called before Init")
 	}
 
-	rows, err := db.QueryContext(ctx, query)
+	rows, err := db.QueryContext(ctx, query, relationshipTypes...)
 	if err != nil {
 		return nil, fmt.Errorf("error querying db: %w", err)
 	}
 
+	defer rows.Close()
+
 	res := pb.MemberList{}
 	for rows.Next() {
-		var id int
-		var firstName, lastName, cardId *string
-
-		if err := rows.Scan(&id, &firstName, &lastName, &cardId); err != nil {
-			return nil, fmt.Errorf("error scanning row: %w", err)
+		m, err := scanMember(rows)
+		if err != nil {
+			return nil, err
 		}
+		res.Members = append(res.Members, m)
+	}
 
-		m := pb.Member{}
-		if firstName != nil {
-			m.FirstName = *firstName
-		}
-		if lastName != nil {
-			m.LastName = *lastName
-		}
-		if cardId != nil {
-			m.CardId = *cardId
+	return &res, rows.Err()
+}
+
+// Get returns the current member with the given contact id, or ErrNotFound
+func Get(ctx context.Context, id int32) (*pb.Member, error) {
+	if !initialized {
+		panic("Get called before Init")
+	}
+
+	args := append(slices.Clone(relationshipTypes), id)
+	m, err := scanMember(db.QueryRowContext(ctx, memberQuery, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrNotFound, id)
+	}
+
+	return m, err
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMember(row scanner) (*pb.Member, error) {
+	var id int
+	var firstName, lastName, cardId, email *string
+
+	if err := row.Scan(&id, &firstName, &lastName, &cardId, &email); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
 		}
-		m.Id = int32(id)
-		res.Members = append(res.Members, &m)
+		return nil, fmt.Errorf("error scanning row: %w", err)
+	}
+
+	m := pb.Member{}
+	if firstName != nil {
+		m.FirstName = *firstName
+	}
+	if lastName != nil {
+		m.LastName = *lastName
+	}
+	if cardId != nil {
+		m.CardId = *cardId
+	}
+	if email != nil {
+		m.Email = *email
+	}
+	m.Id = int32(id)
+
+	return &m, nil
+}
+
+// Ping checks the member source database is reachable
+func Ping(ctx context.Context) error {
+	if !initialized {
+		panic("Ping called before Init")
 	}
 
-	return &res, nil
+	return db.PingContext(ctx)
 }
@@ -3,6 +3,7 @@ package userlist
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"path"
 	"testing"
@@ -28,6 +29,19 @@ const (
 		contact_id INTEGER REFERENCES civicrm_contact (id),
 		card_id INTEGER
 	) STRICT`
+	createEmail = `CREATE TABLE civicrm_email (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		contact_id INTEGER REFERENCES civicrm_contact (id),
+		email TEXT NOT NULL,
+		is_primary INTEGER NOT NULL
+	) STRICT`
+	createRelationship = `CREATE TABLE civicrm_relationship (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		contact_id_a INTEGER REFERENCES civicrm_contact (id),
+		contact_id_b INTEGER REFERENCES civicrm_contact (id),
+		relationship_type_id INTEGER NOT NULL,
+		is_active INTEGER NOT NULL
+	) STRICT`
 )
 
 type dbEntry struct {
@@ -36,6 +50,16 @@ type dbEntry struct {
 	lastName  string
 	statusId  int
 	cardId    *int
+	email     *string
+	// relatedTo makes a relationship of the given type with the contact,
+	// instead of a membership
+	relatedTo *relationship
+}
+
+type relationship struct {
+	contactId int
+	typeId    int
+	inactive  bool
 }
 
 func initDb(t *testing.T, entries []dbEntry) string {
@@ -46,7 +70,7 @@ func initDb(t *testing.T, entries []dbEntry) string {
 	}
 
 	for _, create := range []string{
-		createContact, createMembership, createAccesscardCards,
+		createContact, createMembership, createAccesscardCards, createEmail, createRelationship,
 	} {
 		_, err = db.Exec(create)
 		if err != nil {
@@ -76,12 +100,27 @@ func insertEntry(db *sql.DB, e dbEntry) error {
 		return err
 	}
 
-	_, err = db.Exec(
-		`INSERT INTO civicrm_membership (contact_id, status_id)
-		VALUES (?, ?)`,
-		e.contactId,
-		e.statusId,
-	)
+	if e.relatedTo != nil {
+		active := 1
+		if e.relatedTo.inactive {
+			active = 0
+		}
+		_, err = db.Exec(
+			`INSERT INTO civicrm_relationship (contact_id_a, contact_id_b, relationship_type_id, is_active)
+			VALUES (?, ?, ?, ?)`,
+			e.contactId,
+			e.relatedTo.contactId,
+			e.relatedTo.typeId,
+			active,
+		)
+	} else {
+		_, err = db.Exec(
+			`INSERT INTO civicrm_membership (contact_id, status_id)
+			VALUES (?, ?)`,
+			e.contactId,
+			e.statusId,
+		)
+	}
 	if err != nil {
 		return err
 	}
@@ -93,6 +132,21 @@ func insertEntry(db *sql.DB, e dbEntry) error {
 			e.contactId,
 			*e.cardId,
 		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if e.email != nil {
+		// A secondary email that must never be picked up
+		_, err = db.Exec(
+			`INSERT INTO civicrm_email (contact_id, email, is_primary)
+			VALUES (?, ?, 0), (?, ?, 1)`,
+			e.contactId,
+			"old-"+*e.email,
+			e.contactId,
+			*e.email,
+		)
 	}
 
 	return err
@@ -102,6 +156,10 @@ func intPtr(i int) *int {
 	return &i
 }
 
+func strPtr(s string) *string {
+	return &s
+}
+
 func cmpMemberLists(want []*pb.Member, got []*pb.Member) bool {
 	if len(want) != len(got) {
 		log.Printf("want: %d items", len(want))
@@ -116,7 +174,8 @@ func cmpMemberLists(want []*pb.Member, got []*pb.Member) bool {
 		if wMap[k].Id != gMap[k].Id ||
 			wMap[k].FirstName != gMap[k].FirstName ||
 			wMap[k].LastName != gMap[k].LastName ||
-			wMap[k].CardId != gMap[k].CardId {
+			wMap[k].CardId != gMap[k].CardId ||
+			wMap[k].Email != gMap[k].Email {
 			log.Printf("want: %+v", wMap[k])
 			log.Printf("got : %+v", gMap[k])
 			return false
@@ -137,6 +196,7 @@ func toMap(list []*pb.Member) map[int32]pb.Member {
 			FirstName: m.FirstName,
 			LastName:  m.LastName,
 			CardId:    m.CardId,
+			Email:     m.Email,
 		}
 	}
 
@@ -167,6 +227,15 @@ func TestList(t *testing.T) {
 				{Id: 1, FirstName: "firstName", LastName: "lastName", CardId: ""},
 			},
 		},
+		{
+			name: "Active member with email",
+			entries: []dbEntry{
+				{contactId: 1, firstName: "firstName", lastName: "lastName", statusId: 2, email: strPtr("member@example.com")},
+			},
+			want: []*pb.Member{
+				{Id: 1, FirstName: "firstName", LastName: "lastName", Email: "member@example.com"},
+			},
+		},
 		{
 			name: "Inactive member with card",
 			entries: []dbEntry{
@@ -199,3 +268,88 @@ func TestList(t *testing.T) {
 		})
 	}
 }
+
+func TestGet(t *testing.T) {
+	entries := []dbEntry{
+		{contactId: 1, firstName: "Active", lastName: "Member", statusId: 2, cardId: intPtr(1234), email: strPtr("member@example.com")},
+		{contactId: 2, firstName: "Inactive", lastName: "Member", statusId: 4},
+	}
+
+	for _, tt := range []struct {
+		name    string
+		id      int32
+		want    *pb.Member
+		wantErr error
+	}{
+		{
+			name: "Active member",
+			id:   1,
+			want: &pb.Member{Id: 1, FirstName: "Active", LastName: "Member", CardId: "1234", Email: "member@example.com"},
+		},
+		{
+			name:    "Inactive member",
+			id:      2,
+			wantErr: ErrNotFound,
+		},
+		{
+			name:    "Unknown contact",
+			id:      3,
+			wantErr: ErrNotFound,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn := initDb(t, entries)
+			if err := Init(driver, dsn); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Get(context.Background(), tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+
+			if tt.want != nil && !cmpMemberLists([]*pb.Member{tt.want}, []*pb.Member{got}) {
+				t.Error("members differ")
+			}
+		})
+	}
+}
+
+func TestDependents(t *testing.T) {
+	const household, employer = 8, 5
+	entries := []dbEntry{
+		{contactId: 1, firstName: "Primary", lastName: "Member", statusId: 2},
+		{contactId: 2, firstName: "Kid", lastName: "Member", cardId: intPtr(1234), relatedTo: &relationship{contactId: 1, typeId: household}},
+		{contactId: 3, firstName: "Co", lastName: "Worker", relatedTo: &relationship{contactId: 1, typeId: employer}},
+		{contactId: 4, firstName: "Former", lastName: "Member", statusId: 4},
+		{contactId: 5, firstName: "Former", lastName: "Kid", relatedTo: &relationship{contactId: 4, typeId: household}},
+		{contactId: 6, firstName: "Ex", lastName: "Partner", relatedTo: &relationship{contactId: 1, typeId: household, inactive: true}},
+	}
+	dsn := initDb(t, entries)
+	if err := Init(driver, dsn); err != nil {
+		t.Fatal(err)
+	}
+	SetDependentRelationships([]int{household})
+	t.Cleanup(func() { SetDependentRelationships(nil) })
+
+	list, err := List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*pb.Member{
+		{Id: 1, FirstName: "Primary", LastName: "Member"},
+		{Id: 2, FirstName: "Kid", LastName: "Member", CardId: "1234"},
+	}
+	if !cmpMemberLists(want, list.GetMembers()) {
+		t.Error("lists differ")
+	}
+
+	if m, err := Get(context.Background(), 2); err != nil || !cmpMemberLists(want[1:], []*pb.Member{m}) {
+		t.Errorf("want the dependent, got %+v and %v", m, err)
+	}
+	for _, id := range []int32{3, 5, 6} {
+		if _, err := Get(context.Background(), id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%d: want ErrNotFound, got %v", id, err)
+		}
+	}
+}
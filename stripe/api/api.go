@@ -0,0 +1,167 @@
+// Package api is a minimal client for the parts of the Stripe API the
+// member sync needs: looking up customers, and listing customers and
+// subscriptions.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+// DefaultBaseURL is where the real Stripe API lives. Tests and local mocks
+// like stripe-mock use their own.
+const DefaultBaseURL = "https://api.stripe.com"
+
+const pageSize = "100"
+
+// ErrNotFound is returned by GetCustomer when the customer doesn't exist, or
+// was deleted.
+var ErrNotFound = errors.New("not found in Stripe")
+
+type Client struct {
+	baseURL string
+	key     string
+	http    *http.Client
+}
+
+// New returns a client for the Stripe API at baseURL authenticating with the
+// secret key. A restricted key with read access to customers and
+// subscriptions is enough.
+func New(baseURL string, key string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		key:     key,
+		http:    httpClient,
+	}
+}
+
+// GetCustomer fetches the customer with the given id
+func (c *Client) GetCustomer(ctx context.Context, customerId string) (*types.Customer, error) {
+	var customer struct {
+		types.Customer
+		Deleted bool `json:"deleted"`
+	}
+	if err := c.get(ctx, "/v1/customers/"+url.PathEscape(customerId), nil, &customer); err != nil {
+		return nil, fmt.Errorf("error fetching customer %q: %w", customerId, err)
+	}
+
+	if customer.Deleted {
+		return nil, fmt.Errorf("customer %q was deleted: %w", customerId, ErrNotFound)
+	}
+
+	return &customer.Customer, nil
+}
+
+// GetProduct fetches the product with the given id
+func (c *Client) GetProduct(ctx context.Context, productId string) (*types.Product, error) {
+	var product types.Product
+	if err := c.get(ctx, "/v1/products/"+url.PathEscape(productId), nil, &product); err != nil {
+		return nil, fmt.Errorf("error fetching product %q: %w", productId, err)
+	}
+
+	return &product, nil
+}
+
+// Customers calls f with every customer, newest first, stopping at the
+// first error.
+func (c *Client) Customers(ctx context.Context, f func(types.Customer) error) error {
+	return list(ctx, c, "/v1/customers", func(cus types.Customer) string { return cus.CustomerId }, f)
+}
+
+// Subscriptions calls f with every subscription that wasn't canceled,
+// stopping at the first error.
+func (c *Client) Subscriptions(ctx context.Context, f func(types.Subscription) error) error {
+	return list(ctx, c, "/v1/subscriptions", func(s types.Subscription) string { return s.Id }, f)
+}
+
+// list pages through a Stripe list endpoint. id returns what the next page
+// starts after.
+func list[T any](ctx context.Context, c *Client, path string, id func(T) string, f func(T) error) error {
+	params := url.Values{"limit": {pageSize}}
+
+	for {
+		var page struct {
+			Data    []T  `json:"data"`
+			HasMore bool `json:"has_more"`
+		}
+		if err := c.get(ctx, path, params, &page); err != nil {
+			return fmt.Errorf("error listing %s: %w", path, err)
+		}
+
+		for _, item := range page.Data {
+			if err := f(item); err != nil {
+				return err
+			}
+		}
+
+		if !page.HasMore || len(page.Data) == 0 {
+			return nil
+		}
+		params.Set("starting_after", id(page.Data[len(page.Data)-1]))
+	}
+}
+
+func (c *Client) get(ctx context.Context, path string, params url.Values, v any) error {
+	u := c.baseURL + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.key)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp.StatusCode, body)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+
+	return nil
+}
+
+// apiError turns a failed response into an error, with the message Stripe
+// gave when there's one.
+func apiError(status int, body []byte) error {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	msg := http.StatusText(status)
+	if json.Unmarshal(body, &e) == nil && e.Error.Message != "" {
+		msg = e.Error.Message
+	}
+
+	if status == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, msg)
+	}
+	return fmt.Errorf("stripe returned %d: %s", status, msg)
+}
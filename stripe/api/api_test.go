@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+func newServer(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": {"message": "Invalid API Key provided"}}`)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	return New(srv.URL+"/", "sk_test", srv.Client())
+}
+
+func TestGetCustomer(t *testing.T) {
+	c := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/customers/cus_1":
+			fmt.Fprint(w, `{"id": "cus_1", "name": "Mary Smith", "email": "mary@example.com", "metadata": {"dependents": "Kid Smith"}}`)
+		case "/v1/customers/cus_deleted":
+			fmt.Fprint(w, `{"id": "cus_deleted", "deleted": true}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": {"message": "No such customer"}}`)
+		}
+	})
+	ctx := context.Background()
+
+	got, err := c.GetCustomer(ctx, "cus_1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := types.Customer{
+		CustomerId: "cus_1",
+		Name:       "Mary Smith",
+		Email:      "mary@example.com",
+		Metadata:   map[string]string{"dependents": "Kid Smith"},
+	}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("want %+v, got %+v", want, *got)
+	}
+
+	for _, id := range []string{"cus_deleted", "cus_missing"} {
+		if _, err := c.GetCustomer(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: want ErrNotFound, got %v", id, err)
+		}
+	}
+
+	bad := New(c.baseURL, "sk_wrong", c.http)
+	if _, err := bad.GetCustomer(ctx, "cus_1"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("want an auth error, got %v", err)
+	}
+}
+
+func TestGetProduct(t *testing.T) {
+	c := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/products/prod_1" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		fmt.Fprint(w, `{"id": "prod_1", "name": "Membership", "metadata": {"unifi_access_policies": "policy_1"}}`)
+	})
+
+	got, err := c.GetProduct(context.Background(), "prod_1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got.Name != "Membership" || got.Metadata["unifi_access_policies"] != "policy_1" {
+		t.Errorf("unexpected product %+v", got)
+	}
+}
+
+func TestCustomersPaging(t *testing.T) {
+	pages := map[string]string{
+		"":      `{"data": [{"id": "cus_3"}, {"id": "cus_2"}], "has_more": true}`,
+		"cus_2": `{"data": [{"id": "cus_1"}], "has_more": false}`,
+	}
+	c := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/customers" || r.URL.Query().Get("limit") != pageSize {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		fmt.Fprint(w, pages[r.URL.Query().Get("starting_after")])
+	})
+
+	var got []string
+	err := c.Customers(context.Background(), func(cus types.Customer) error {
+		got = append(got, cus.CustomerId)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"cus_3", "cus_2", "cus_1"}; !slices.Equal(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	stop := errors.New("stop")
+	err = c.Customers(context.Background(), func(types.Customer) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Errorf("want the callback error, got %v", err)
+	}
+}
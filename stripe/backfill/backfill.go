@@ -0,0 +1,110 @@
+// Package backfill rebuilds the Stripe member database from the Stripe API,
+// for when webhook events were missed or the database was lost.
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/membership"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+type source interface {
+	Customers(ctx context.Context, f func(types.Customer) error) error
+	Subscriptions(ctx context.Context, f func(types.Subscription) error) error
+}
+
+type store interface {
+	CreateMember(ctx context.Context, c types.Customer) error
+	ActivateMember(ctx context.Context, customerId string) error
+	DeactivateMember(ctx context.Context, customerId string) error
+	SaveSubscription(ctx context.Context, customerId string, s types.Subscription) error
+}
+
+// Result counts the customers a backfill went through
+type Result struct {
+	Active   int
+	Inactive int
+	Failed   int
+}
+
+// Run makes every Stripe customer a member, active when they have an active
+// or trialing subscription that f allows, and records those subscriptions
+// along with the policies they give.
+// Existing members get their name, email and status overwritten, and keep
+// their member and access ids.
+//
+// Only the database is touched: UniFi Access catches up on the next event or
+// resync of each member.
+func Run(ctx context.Context, src source, db store, f *membership.Filter, dryRun bool) (Result, error) {
+	var res Result
+
+	subscriptions := make(map[string][]types.Subscription)
+	active := make(map[string]bool)
+	err := src.Subscriptions(ctx, func(s types.Subscription) error {
+		if !f.Allows(s) {
+			return nil
+		}
+		if s.Active() {
+			var err error
+			if s.Policies, err = f.Policies(ctx, s); err != nil {
+				return fmt.Errorf("error getting access policies of %q: %w", s.Id, err)
+			}
+		}
+		subscriptions[s.Customer] = append(subscriptions[s.Customer], s)
+		active[s.Customer] = active[s.Customer] || s.Active()
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+
+	err = src.Customers(ctx, func(c types.Customer) error {
+		logger := slog.With(logging.KeyCustomerId, c.CustomerId, "active", active[c.CustomerId], logging.KeyDryRun, dryRun)
+		if active[c.CustomerId] {
+			res.Active++
+		} else {
+			res.Inactive++
+		}
+
+		logger.Debug("Backfilling customer", logging.KeyName, c.Name, logging.KeyEmail, c.Email)
+		if dryRun {
+			return nil
+		}
+
+		if err := backfill(ctx, db, c, subscriptions[c.CustomerId], active[c.CustomerId]); err != nil {
+			// One bad customer shouldn't stop the rest
+			logger.Error("Error backfilling customer", logging.Err(err))
+			res.Failed++
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return res, err
+	}
+
+	if res.Failed > 0 {
+		return res, fmt.Errorf("%d customers failed to backfill", res.Failed)
+	}
+	return res, nil
+}
+
+func backfill(ctx context.Context, db store, c types.Customer, subscriptions []types.Subscription, active bool) error {
+	if err := db.CreateMember(ctx, c); err != nil {
+		return fmt.Errorf("error creating member: %w", err)
+	}
+
+	for _, s := range subscriptions {
+		if err := db.SaveSubscription(ctx, c.CustomerId, s); err != nil {
+			return err
+		}
+	}
+
+	if active {
+		return db.ActivateMember(ctx, c.CustomerId)
+	}
+	return db.DeactivateMember(ctx, c.CustomerId)
+}
@@ -0,0 +1,181 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/membership"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+type fakeSource struct {
+	customers     []types.Customer
+	subscriptions []types.Subscription
+}
+
+func (f *fakeSource) Customers(_ context.Context, fn func(types.Customer) error) error {
+	for _, c := range f.customers {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeSource) Subscriptions(_ context.Context, fn func(types.Subscription) error) error {
+	for _, s := range f.subscriptions {
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fakeStore keeps the status and subscriptions of each member, and the
+// policies of each subscription, failing the members in fail
+type fakeStore struct {
+	status        map[string]string
+	subscriptions map[string][]string
+	policies      map[string][]string
+	fail          map[string]bool
+}
+
+func (f *fakeStore) CreateMember(_ context.Context, c types.Customer) error {
+	if f.fail[c.CustomerId] {
+		return errors.New("boom")
+	}
+	if _, ok := f.status[c.CustomerId]; !ok {
+		f.status[c.CustomerId] = types.MemberStatusNotActive
+	}
+	return nil
+}
+
+func (f *fakeStore) SaveSubscription(_ context.Context, customerId string, s types.Subscription) error {
+	if f.subscriptions == nil {
+		f.subscriptions = make(map[string][]string)
+		f.policies = make(map[string][]string)
+	}
+	f.subscriptions[customerId] = append(f.subscriptions[customerId], s.Id)
+	f.policies[s.Id] = s.Policies
+	return nil
+}
+
+func (f *fakeStore) ActivateMember(_ context.Context, customerId string) error {
+	f.status[customerId] = types.MemberStatusActive
+	return nil
+}
+
+func (f *fakeStore) DeactivateMember(_ context.Context, customerId string) error {
+	f.status[customerId] = types.MemberStatusNotActive
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	src := &fakeSource{
+		customers: []types.Customer{
+			{CustomerId: "cus_active"},
+			{CustomerId: "cus_trialing"},
+			{CustomerId: "cus_past_due"},
+			{CustomerId: "cus_left"},
+			{CustomerId: "cus_broken"},
+		},
+		subscriptions: []types.Subscription{
+			{Customer: "cus_active", Status: types.SubscriptionActive},
+			{Customer: "cus_trialing", Status: types.SubscriptionTrialing},
+			{Customer: "cus_past_due", Status: types.SubscriptionPastDue},
+			{Customer: "cus_deleted", Status: types.SubscriptionActive},
+		},
+	}
+
+	for _, tt := range []struct {
+		name       string
+		dryRun     bool
+		wantStatus map[string]string
+	}{
+		{
+			name: "Members are rebuilt",
+			wantStatus: map[string]string{
+				"cus_active":   types.MemberStatusActive,
+				"cus_trialing": types.MemberStatusActive,
+				"cus_past_due": types.MemberStatusNotActive,
+				"cus_left":     types.MemberStatusNotActive,
+			},
+		},
+		{
+			name:   "Dry run",
+			dryRun: true,
+			wantStatus: map[string]string{
+				"cus_left": types.MemberStatusActive,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeStore{
+				// A member the database wrongly thinks is still active
+				status: map[string]string{"cus_left": types.MemberStatusActive},
+				fail:   map[string]bool{"cus_broken": true},
+			}
+
+			res, err := Run(context.Background(), src, db, nil, tt.dryRun)
+			if tt.dryRun && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if !tt.dryRun && err == nil {
+				t.Error("expected the broken customer to fail the backfill")
+			}
+
+			want := Result{Active: 2, Inactive: 3}
+			if !tt.dryRun {
+				want.Failed = 1
+			}
+			if res != want {
+				t.Errorf("want %+v, got %+v", want, res)
+			}
+
+			if len(db.status) != len(tt.wantStatus) {
+				t.Errorf("want %v, got %v", tt.wantStatus, db.status)
+			}
+			for id, status := range tt.wantStatus {
+				if db.status[id] != status {
+					t.Errorf("%s: want %q, got %q", id, status, db.status[id])
+				}
+			}
+		})
+	}
+}
+
+func TestRunMembershipsOnly(t *testing.T) {
+	item := func(product string, policies string) types.SubscriptionItems {
+		price := types.Price{Product: product, Metadata: map[string]string{membership.PoliciesMetadataKey: policies}}
+		return types.SubscriptionItems{Data: []types.SubscriptionItem{{Price: price}}}
+	}
+	src := &fakeSource{
+		customers: []types.Customer{{CustomerId: "cus_member"}, {CustomerId: "cus_donor"}},
+		subscriptions: []types.Subscription{
+			{Id: "sub_old", Customer: "cus_member", Status: types.SubscriptionPastDue, Items: item("prod_membership", "policy_old")},
+			{Id: "sub_new", Customer: "cus_member", Status: types.SubscriptionActive, Items: item("prod_membership", "policy_doors")},
+			{Id: "sub_donation", Customer: "cus_donor", Status: types.SubscriptionActive, Items: item("prod_donation", "")},
+		},
+	}
+	db := &fakeStore{status: make(map[string]string)}
+
+	res, err := Run(context.Background(), src, db, membership.NewFilter([]string{"prod_membership"}), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := (Result{Active: 1, Inactive: 1}); res != want {
+		t.Errorf("want %+v, got %+v", want, res)
+	}
+	if db.status["cus_member"] != types.MemberStatusActive || db.status["cus_donor"] != types.MemberStatusNotActive {
+		t.Errorf("only the member should be active: %v", db.status)
+	}
+	if want := []string{"sub_old", "sub_new"}; !slices.Equal(db.subscriptions["cus_member"], want) || len(db.subscriptions["cus_donor"]) != 0 {
+		t.Errorf("want the membership subscriptions %v recorded, got %v", want, db.subscriptions)
+	}
+	// Only active subscriptions give policies
+	if len(db.policies["sub_old"]) != 0 || !slices.Equal(db.policies["sub_new"], []string{"policy_doors"}) {
+		t.Errorf("unexpected policies recorded: %v", db.policies)
+	}
+}
@@ -0,0 +1,203 @@
+// Package dashboard serves an HTML admin page next to the webhook listener,
+// for the volunteers who'd rather not use the admin CLI.
+package dashboard
+
+import (
+	"context"
+	"crypto/subtle"
+	"embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+	uaTypes "github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+const recentEvents = 50
+
+//go:embed templates
+var templates embed.FS
+
+type memberDb interface {
+	ListMembers(ctx context.Context) ([]types.Member, error)
+	RecentEvents(ctx context.Context, limit int) ([]types.WebhookEvent, error)
+}
+
+type actions interface {
+	Requeue(ctx context.Context, eventId string) error
+	Resync(ctx context.Context, customerId string) error
+}
+
+type syncStatus interface {
+	LastSync(ctx context.Context) (*uaTypes.SyncResult, error)
+}
+
+type Dashboard struct {
+	listenAddr string
+	username   string
+	password   string
+	db         memberDb
+	actions    actions
+	sync       syncStatus
+	tmpl       *template.Template
+}
+
+type page struct {
+	Members  []types.Member
+	Events   []types.WebhookEvent
+	Failed   int
+	Sync     *uaTypes.SyncResult
+	Message  string
+	Username string
+}
+
+func New(listenAddr, username, password string, d memberDb, a actions) *Dashboard {
+	tmpl := template.Must(template.New("").Funcs(template.FuncMap{
+		"time": formatTime,
+		"deref": func(s *string) string {
+			if s == nil {
+				return ""
+			}
+			return *s
+		},
+	}).ParseFS(templates, "templates/*.html.tmpl"))
+
+	return &Dashboard{
+		listenAddr: listenAddr,
+		username:   username,
+		password:   password,
+		db:         d,
+		actions:    a,
+		tmpl:       tmpl,
+	}
+}
+
+// SetSyncStatus makes the dashboard show how the last sync went
+func (d *Dashboard) SetSyncStatus(s syncStatus) {
+	d.sync = s
+}
+
+// Start does not return until the dashboard exits
+func (d *Dashboard) Start() error {
+	s := http.Server{
+		Addr:    d.listenAddr,
+		Handler: d.handler(),
+	}
+
+	slog.Info("Dashboard listening", "addr", d.listenAddr)
+	return s.ListenAndServe()
+}
+
+func (d *Dashboard) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", d.index)
+	mux.Handle("POST /requeue", sameOrigin(d.action("event_id", "Requeued event", d.actions.Requeue)))
+	mux.Handle("POST /resync", sameOrigin(d.action("customer_id", "Resynced member", d.actions.Resync)))
+
+	return d.auth(mux)
+}
+
+func (d *Dashboard) index(w http.ResponseWriter, req *http.Request) {
+	var p page
+	var err error
+
+	user, _, _ := req.BasicAuth()
+	p.Username = user
+	p.Message = req.URL.Query().Get("msg")
+
+	if p.Members, err = d.db.ListMembers(req.Context()); err != nil {
+		d.fail(w, "Error listing members", err)
+		return
+	}
+
+	if p.Events, err = d.db.RecentEvents(req.Context(), recentEvents); err != nil {
+		d.fail(w, "Error listing events", err)
+		return
+	}
+	for _, e := range p.Events {
+		if e.Status == types.EventStatusFailed {
+			p.Failed++
+		}
+	}
+
+	if d.sync != nil {
+		if p.Sync, err = d.sync.LastSync(req.Context()); err != nil {
+			slog.Error("Error reading the last sync", logging.Err(err))
+		}
+	}
+
+	if err := d.tmpl.ExecuteTemplate(w, "index.html.tmpl", p); err != nil {
+		slog.Error("Error rendering dashboard", logging.Err(err))
+	}
+}
+
+// action runs do with the value of the given form field and goes back to the
+// index, telling how it went.
+func (d *Dashboard) action(field, done string, do func(context.Context, string) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		value := req.PostFormValue(field)
+		if value == "" {
+			http.Error(w, "missing "+field, http.StatusBadRequest)
+			return
+		}
+
+		user, _, _ := req.BasicAuth()
+		msg := fmt.Sprintf("%s %s", done, value)
+		ctx := audit.WithActor(req.Context(), audit.Admin(user))
+		if err := do(ctx, value); err != nil {
+			slog.Error("Dashboard action failed", "user", user, "action", req.URL.Path, "target", value, logging.Err(err))
+			msg = fmt.Sprintf("Error: %s", err)
+		} else {
+			slog.Info("Dashboard action", "user", user, "action", req.URL.Path, "target", value)
+		}
+
+		http.Redirect(w, req, "/?msg="+url.QueryEscape(msg), http.StatusSeeOther)
+	})
+}
+
+func (d *Dashboard) fail(w http.ResponseWriter, msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	http.Error(w, msg, http.StatusInternalServerError)
+}
+
+func (d *Dashboard) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user, pass, ok := req.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(d.username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(d.password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fcfl-member-sync", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// sameOrigin refuses requests coming from other sites, since browsers send
+// the basic auth credentials along with them anyway.
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin, err := url.Parse(req.Header.Get("Origin"))
+		if err != nil || origin.Host != req.Host {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+func formatTime(ts int64) string {
+	if ts == 0 {
+		return "-"
+	}
+	return time.Unix(ts, 0).Format(time.DateTime)
+}
@@ -0,0 +1,194 @@
+package dashboard
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+	uaTypes "github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+type fakeDb struct{}
+
+func (fakeDb) ListMembers(context.Context) ([]types.Member, error) {
+	accessId := "a1"
+	return []types.Member{
+		{MemberId: 1, CustomerId: "cus_1", AccessId: &accessId, Name: "Jane <Doe>", Status: types.MemberStatusActive},
+	}, nil
+}
+
+func (fakeDb) RecentEvents(_ context.Context, limit int) ([]types.WebhookEvent, error) {
+	return []types.WebhookEvent{
+		{Id: "evt_1", Type: "customer.subscription.created", Status: types.EventStatusFailed, Error: "boom"},
+		{Id: "evt_2", Type: "customer.created", Status: types.EventStatusHandled},
+	}, nil
+}
+
+type fakeActions struct {
+	requeued []string
+	resynced []string
+	actors   []string
+}
+
+func (a *fakeActions) Requeue(ctx context.Context, id string) error {
+	a.requeued = append(a.requeued, id)
+	a.actors = append(a.actors, audit.Actor(ctx))
+	return nil
+}
+
+func (a *fakeActions) Resync(ctx context.Context, id string) error {
+	a.resynced = append(a.resynced, id)
+	a.actors = append(a.actors, audit.Actor(ctx))
+	return errors.New("no such member")
+}
+
+type fakeSync struct{}
+
+func (fakeSync) LastSync(context.Context) (*uaTypes.SyncResult, error) {
+	return &uaTypes.SyncResult{FinishedAt: 1, Error: "sync broke", Failures: 3}, nil
+}
+
+func TestIndex(t *testing.T) {
+	d := New("", "admin", "secret", fakeDb{}, &fakeActions{})
+	d.SetSyncStatus(fakeSync{})
+	h := d.handler()
+
+	for _, tt := range []struct {
+		name     string
+		user     string
+		pass     string
+		wantCode int
+		want     []string
+	}{
+		{name: "No credentials", wantCode: http.StatusUnauthorized},
+		{name: "Wrong password", user: "admin", pass: "nope", wantCode: http.StatusUnauthorized},
+		{
+			name:     "Logged in",
+			user:     "admin",
+			pass:     "secret",
+			wantCode: http.StatusOK,
+			want: []string{
+				"Jane &lt;Doe&gt;",
+				"evt_1",
+				"Recent events (1 failed)",
+				`name="event_id" value="evt_1"`,
+				"sync broke",
+				"3 failures in a row",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.pass)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want status %d, got %d", tt.wantCode, rec.Code)
+			}
+			body := rec.Body.String()
+			for _, w := range tt.want {
+				if !strings.Contains(body, w) {
+					t.Errorf("want %q in the page", w)
+				}
+			}
+			if strings.Contains(body, `value="evt_2"`) {
+				t.Error("handled events can't be requeued")
+			}
+		})
+	}
+}
+
+func TestActions(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		path         string
+		form         url.Values
+		origin       string
+		wantCode     int
+		wantMsg      string
+		wantRequeued int
+		wantResynced int
+	}{
+		{
+			name:         "Requeue",
+			path:         "/requeue",
+			form:         url.Values{"event_id": {"evt_1"}},
+			origin:       "http://example.com",
+			wantCode:     http.StatusSeeOther,
+			wantMsg:      "Requeued event evt_1",
+			wantRequeued: 1,
+		},
+		{
+			name:         "Failed resync",
+			path:         "/resync",
+			form:         url.Values{"customer_id": {"cus_1"}},
+			origin:       "http://example.com",
+			wantCode:     http.StatusSeeOther,
+			wantMsg:      "Error: no such member",
+			wantResynced: 1,
+		},
+		{
+			name:     "Missing field",
+			path:     "/requeue",
+			origin:   "http://example.com",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Cross-site request",
+			path:     "/requeue",
+			form:     url.Values{"event_id": {"evt_1"}},
+			origin:   "https://evil.example.org",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "No origin",
+			path:     "/requeue",
+			form:     url.Values{"event_id": {"evt_1"}},
+			wantCode: http.StatusForbidden,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &fakeActions{}
+			h := New("", "admin", "secret", fakeDb{}, a).handler()
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetBasicAuth("admin", "secret")
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("want status %d, got %d", tt.wantCode, rec.Code)
+			}
+			if len(a.requeued) != tt.wantRequeued || len(a.resynced) != tt.wantResynced {
+				t.Errorf("unexpected actions: %+v", a)
+			}
+			for _, actor := range a.actors {
+				if actor != "admin:admin" {
+					t.Errorf("want the logged in admin as actor, got %q", actor)
+				}
+			}
+			if tt.wantMsg != "" {
+				loc, err := url.Parse(rec.Header().Get("Location"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := loc.Query().Get("msg"); got != tt.wantMsg {
+					t.Errorf("want message %q, got %q", tt.wantMsg, got)
+				}
+			}
+		})
+	}
+}
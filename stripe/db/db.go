@@ -1,85 +1,298 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	_ "embed"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
 
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/names"
 	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
 )
 
-const (
-	dbDriver = "mysql"
-)
-
-//go:embed schema/members.sql
-var createTable string
+// querier is what a database and a transaction have in common
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
 
 type sqldb interface {
-	Exec(query string, args ...any) (sql.Result, error)
-	QueryRow(query string, args ...any) *sql.Row
+	querier
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
 }
 
+// memberColumns are the columns scanMember reads
+const memberColumns = "member_id, customer_id, access_id, name, email, status, " +
+	"first_name, last_name, first_name_override, last_name_override, preferred_name"
+
 type DB struct {
-	db sqldb
+	db      sqldb
+	dialect *dialect
 }
 
-func New(dsn string) (*DB, error) {
-	db, err := sql.Open(dbDriver, dsn)
+// Open connects to the database without touching its schema. The scheme of
+// dsn picks the database: "sqlite:///path/to.db" for SQLite, or a MySQL DSN
+// otherwise.
+func Open(dsn string) (*DB, error) {
+	dialect, dsn := parseDSN(dsn)
+	db, err := sql.Open(dialect.driver, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("can't connect to database: %w", err)
 	}
 
 	db.SetConnMaxLifetime(60 * time.Second)
+	if dialect == sqliteDialect {
+		// SQLite only takes one writer at a time anyway
+		db.SetMaxOpenConns(1)
+	}
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("can't ping the database: %w", err)
 	}
 
-	if _, err := db.Exec(createTable); err != nil {
-		return nil, fmt.Errorf("error creating table: %w", err)
+	slog.Info("Connected to db", "dialect", dialect.name)
+	return &DB{db: db, dialect: dialect}, nil
+}
+
+// New connects to the database and applies the pending migrations
+func New(dsn string) (*DB, error) {
+	d, err := Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := d.Migrate(context.Background()); err != nil {
+		return nil, err
 	}
 
-	log.Printf("Connected to db")
-	return &DB{db: db}, nil
+	return d, nil
 }
 
-func (d *DB) CreateMember(c types.Customer) error {
-	if _, err := d.db.Exec(
-		"INSERT INTO members "+
-			"(customer_id, name, email) VALUES (?, ?, ?) "+
-			"ON DUPLICATE KEY UPDATE name=VALUE(name), email=VALUE(email)",
-		c.CustomerId,
-		c.Name,
-		c.Email,
-	); err != nil {
-		return fmt.Errorf("error inserting member: %w", err)
+// inTx runs f in a transaction, which is committed unless f fails
+func (d *DB) inTx(ctx context.Context, f func(q querier) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
 	}
 
-	return nil
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateMember adds the customer as a member, or updates the name and email
+// of the member they already are. The first and last names come from their
+// metadata, and are left empty when it doesn't have them. An email another
+// member already has is an EmailConflictError.
+func (d *DB) CreateMember(ctx context.Context, c types.Customer) error {
+	firstName, lastName := names.FromCustomer(c)
+
+	return d.inTx(ctx, func(q querier) error {
+		old, err := findMember(ctx, q, c.CustomerId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if c.Email != "" {
+			var other string
+			err := q.QueryRowContext(
+				ctx,
+				"SELECT customer_id FROM members WHERE email=? AND customer_id<>?",
+				c.Email,
+				c.CustomerId,
+			).Scan(&other)
+			if err == nil {
+				return &types.EmailConflictError{CustomerId: c.CustomerId, Email: c.Email, MemberCustomerId: other}
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("error checking email of %q: %w", c.CustomerId, err)
+			}
+		}
+
+		if _, err := q.ExecContext(
+			ctx,
+			"INSERT INTO members "+
+				"(customer_id, name, email, first_name, last_name) VALUES (?, ?, ?, ?, ?) "+
+				d.dialect.upsert("customer_id", "name", "email", "first_name", "last_name"),
+			c.CustomerId,
+			c.Name,
+			c.Email,
+			firstName,
+			lastName,
+		); err != nil {
+			return fmt.Errorf("error inserting member: %w", err)
+		}
+
+		if old == nil {
+			m, err := findMember(ctx, q, c.CustomerId)
+			if err != nil {
+				return err
+			}
+			return recordHistory(ctx, q, audit.NewEntry(ctx, m.MemberId, audit.ActionCreate, "", contact(c.Name, c.Email)))
+		}
+
+		if old.FirstName != firstName || old.LastName != lastName {
+			if err := recordHistory(ctx, q, audit.NewEntry(
+				ctx,
+				old.MemberId,
+				audit.ActionNames,
+				fullName(old.FirstName, old.LastName),
+				fullName(firstName, lastName),
+			)); err != nil {
+				return err
+			}
+		}
+
+		if old.Name == c.Name && old.Email == c.Email {
+			return nil
+		}
+		return recordHistory(ctx, q, audit.NewEntry(
+			ctx,
+			old.MemberId,
+			audit.ActionDetails,
+			contact(old.Name, old.Email),
+			contact(c.Name, c.Email),
+		))
+	})
 }
 
-func (d *DB) ActivateMember(customerId string) error {
-	return d.setMemberStatus(customerId, types.MemberStatusActive)
+// SetMemberNames sets the names admins override the ones from Stripe with.
+// Empty ones don't override anything.
+func (d *DB) SetMemberNames(ctx context.Context, customerId string, firstName, lastName, preferredName string) error {
+	return d.inTx(ctx, func(q querier) error {
+		old, err := findMember(ctx, q, customerId)
+		if err != nil {
+			return err
+		}
+
+		oldNames := overrides(old.FirstNameOverride, old.LastNameOverride, old.PreferredName)
+		newNames := overrides(firstName, lastName, preferredName)
+		if oldNames == newNames {
+			return nil
+		}
+
+		r, err := q.ExecContext(
+			ctx,
+			"UPDATE members SET first_name_override=?, last_name_override=?, preferred_name=? WHERE customer_id=?",
+			firstName,
+			lastName,
+			preferredName,
+			customerId,
+		)
+		if err != nil {
+			return fmt.Errorf("error updating member names: %w", err)
+		}
+		if err := oneRowAffected(r); err != nil {
+			return err
+		}
+
+		return recordHistory(ctx, q, audit.NewEntry(ctx, old.MemberId, audit.ActionNames, oldNames, newNames))
+	})
 }
 
-func (d *DB) DeactivateMember(customerId string) error {
-	return d.setMemberStatus(customerId, types.MemberStatusNotActive)
+func fullName(firstName, lastName string) string {
+	return strings.TrimSpace(firstName + " " + lastName)
 }
 
-func (d *DB) setMemberStatus(customerId string, status string) error {
-	r, err := d.db.Exec(
-		"UPDATE members SET status=? WHERE customer_id=?",
-		status,
-		customerId,
-	)
-	if err != nil {
-		return fmt.Errorf("error updating member status: %w", err)
+// overrides describes the names an admin set, for the history
+func overrides(firstName, lastName, preferredName string) string {
+	var set []string
+	for _, n := range [][2]string{{"first", firstName}, {"last", lastName}, {"preferred", preferredName}} {
+		if n[1] != "" {
+			set = append(set, n[0]+" "+n[1])
+		}
 	}
+	return strings.Join(set, ", ")
+}
+
+func contact(name, email string) string {
+	return fmt.Sprintf("%s <%s>", name, email)
+}
+
+func (d *DB) ActivateMember(ctx context.Context, customerId string) error {
+	return d.setMemberStatus(ctx, customerId, types.MemberStatusActive)
+}
+
+func (d *DB) DeactivateMember(ctx context.Context, customerId string) error {
+	return d.setMemberStatus(ctx, customerId, types.MemberStatusNotActive)
+}
+
+func (d *DB) setMemberStatus(ctx context.Context, customerId string, status string) error {
+	return d.inTx(ctx, func(q querier) error {
+		old, err := findMember(ctx, q, customerId)
+		if err != nil {
+			return err
+		}
+		if old.Status == status {
+			return nil
+		}
+
+		r, err := q.ExecContext(
+			ctx,
+			"UPDATE members SET status=? WHERE customer_id=?",
+			status,
+			customerId,
+		)
+		if err != nil {
+			return fmt.Errorf("error updating member status: %w", err)
+		}
+		if err := oneRowAffected(r); err != nil {
+			return err
+		}
+
+		return recordHistory(ctx, q, audit.NewEntry(ctx, old.MemberId, audit.ActionStatus, old.Status, status))
+	})
+}
 
+func (d *DB) UpdateMemberAccess(ctx context.Context, customerId string, accessId string) error {
+	// Only MySQL has a uuid type to refuse anything else
+	if _, err := uuid.Parse(accessId); err != nil {
+		return fmt.Errorf("invalid access id %q: %w", accessId, err)
+	}
+
+	return d.inTx(ctx, func(q querier) error {
+		old, err := findMember(ctx, q, customerId)
+		if err != nil {
+			return err
+		}
+		oldAccessId := ""
+		if old.AccessId != nil {
+			oldAccessId = *old.AccessId
+		}
+		if oldAccessId == accessId {
+			return nil
+		}
+
+		r, err := q.ExecContext(
+			ctx,
+			"UPDATE members SET access_id=? WHERE customer_id=?",
+			accessId,
+			customerId,
+		)
+		if err != nil {
+			return fmt.Errorf("error updating member's access id: %w", err)
+		}
+		if err := oneRowAffected(r); err != nil {
+			return err
+		}
+
+		return recordHistory(ctx, q, audit.NewEntry(ctx, old.MemberId, audit.ActionAccessId, oldAccessId, accessId))
+	})
+}
+
+func oneRowAffected(r sql.Result) error {
 	num, err := r.RowsAffected()
 	if err != nil {
 		return fmt.Errorf("error checking update rows affected: %w", err)
@@ -92,49 +305,104 @@ func (d *DB) setMemberStatus(customerId string, status string) error {
 	return nil
 }
 
-func (d *DB) UpdateMemberAccess(customerId string, accessId string) error {
-	r, err := d.db.Exec(
-		"UPDATE members SET access_id=? WHERE customer_id=?",
-		accessId,
+func (d *DB) FindMemberByCustomerId(ctx context.Context, customerId string) (*types.Member, error) {
+	return findMember(ctx, d.db, customerId)
+}
+
+func findMember(ctx context.Context, q querier, customerId string) (*types.Member, error) {
+	r := q.QueryRowContext(
+		ctx,
+		"SELECT "+memberColumns+" "+
+			"FROM members WHERE customer_id=?",
 		customerId,
 	)
+
+	m, err := scanMember(r)
 	if err != nil {
-		return fmt.Errorf("error updating member's access id: %w", err)
+		return nil, fmt.Errorf(
+			"error querying customer_id %q: %w",
+			customerId,
+			err,
+		)
 	}
 
-	num, err := r.RowsAffected()
+	return m, nil
+}
+
+// ListMembers returns every member, newest first
+func (d *DB) ListMembers(ctx context.Context) ([]types.Member, error) {
+	rows, err := d.db.QueryContext(
+		ctx,
+		"SELECT "+memberColumns+" "+
+			"FROM members ORDER BY member_id DESC",
+	)
 	if err != nil {
-		return fmt.Errorf("error checking update rows affected: %w", err)
+		return nil, fmt.Errorf("error listing members: %w", err)
 	}
 
-	if num != 1 {
-		return fmt.Errorf("unexpected number of rows affected: %d", num)
+	return scanMembers(rows)
+}
+
+// FindMembers looks members up by customer id, email, access id, member id
+// or part of their name.
+func (d *DB) FindMembers(ctx context.Context, query string) ([]types.Member, error) {
+	where := []string{"customer_id=?", "email=?", "name LIKE ?"}
+	args := []any{query, query, "%" + query + "%"}
+
+	// Comparing the uuid column to anything else is an error
+	if _, err := uuid.Parse(query); err == nil {
+		where = append(where, "access_id=?")
+		args = append(args, query)
+	}
+	if id, err := strconv.ParseInt(query, 10, 64); err == nil {
+		where = append(where, "member_id=?")
+		args = append(args, id)
 	}
 
-	return nil
+	rows, err := d.db.QueryContext(
+		ctx,
+		"SELECT "+memberColumns+" "+
+			"FROM members WHERE "+strings.Join(where, " OR ")+" ORDER BY member_id",
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error searching members: %w", err)
+	}
+
+	return scanMembers(rows)
 }
 
-func (d *DB) FindMemberByCustomerId(customerId string) (*types.Member, error) {
-	r := d.db.QueryRow(
-		"SELECT member_id, customer_id, access_id, name, email, status "+
-			"FROM members WHERE customer_id=?",
-		customerId,
-	)
+func scanMembers(rows *sql.Rows) ([]types.Member, error) {
+	defer rows.Close()
+
+	var members []types.Member
+	for rows.Next() {
+		m, err := scanMember(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning member: %w", err)
+		}
+		members = append(members, *m)
+	}
+
+	return members, rows.Err()
+}
 
+func scanMember(row scanner) (*types.Member, error) {
 	var m types.Member
-	if err := r.Scan(
+	if err := row.Scan(
 		&m.MemberId,
 		&m.CustomerId,
 		&m.AccessId,
 		&m.Name,
 		&m.Email,
 		&m.Status,
+		&m.FirstName,
+		&m.LastName,
+		&m.FirstNameOverride,
+		&m.LastNameOverride,
+		&m.PreferredName,
 	); err != nil {
-		return nil, fmt.Errorf(
-			"error querying customer_id %q: %w",
-			customerId,
-			err,
-		)
+		return nil, err
 	}
 
 	return &m, nil
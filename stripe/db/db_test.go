@@ -1,11 +1,16 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
 	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
 )
 
@@ -13,8 +18,25 @@ const (
 	dsn = "root@/"
 )
 
-func getDb(t *testing.T, dbName string) *DB {
-	sqlDB, err := sql.Open(dbDriver, dsn)
+// forEachDialect runs f against a fresh database of every dialect
+func forEachDialect(t *testing.T, dbName string, f func(t *testing.T, db *DB)) {
+	for _, d := range dialects {
+		t.Run(d.name, func(t *testing.T) {
+			f(t, getDb(t, d, dbName))
+		})
+	}
+}
+
+func getDb(t *testing.T, d *dialect, dbName string) *DB {
+	if d == sqliteDialect {
+		db, err := New("sqlite://" + filepath.Join(t.TempDir(), dbName+".db"))
+		if err != nil {
+			t.Fatalf("can't create test db: %s", err)
+		}
+		return db
+	}
+
+	sqlDB, err := sql.Open(d.driver, dsn)
 	if err != nil {
 		t.Fatalf("can't connect to db: %s", err)
 	}
@@ -31,7 +53,7 @@ func getDb(t *testing.T, dbName string) *DB {
 	}
 
 	t.Cleanup(func() {
-		db.db.Exec("DROP DATABASE ?", dbName)
+		db.db.ExecContext(context.Background(), "DROP DATABASE ?", dbName)
 	})
 
 	return db
@@ -41,205 +63,716 @@ func equal(m types.Member, c types.Customer) bool {
 	return m.CustomerId == c.CustomerId && m.Name == c.Name && m.Email == c.Email
 }
 
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
 func TestCreateMember(t *testing.T) {
-	db := getDb(t, fmt.Sprintf("test_create_member_%d", time.Now().Unix()))
-
-	for _, tt := range []struct {
-		name       string
-		customer   types.Customer
-		shouldFail bool
-	}{
-		{
-			name:     "Create member and find it",
-			customer: types.Customer{CustomerId: "abc", Name: "name", Email: "email"},
-		},
-		{
-			name:       "Create member with same email fails",
-			customer:   types.Customer{CustomerId: "qwer", Name: "name2", Email: "email"},
-			shouldFail: true,
-		},
-		{
-			name:     "Create same member updates",
-			customer: types.Customer{CustomerId: "abc", Name: "name", Email: "email2"},
-		},
-	} {
-		t.Run(tt.name, func(t *testing.T) {
-			if err := db.CreateMember(tt.customer); err != nil && !tt.shouldFail {
-				t.Fatalf("error creating member: %s", err)
+	ctx := context.Background()
+	forEachDialect(t, fmt.Sprintf("test_create_member_%d", time.Now().Unix()), func(t *testing.T, db *DB) {
+		for _, tt := range []struct {
+			name       string
+			customer   types.Customer
+			shouldFail bool
+		}{
+			{
+				name:     "Create member and find it",
+				customer: types.Customer{CustomerId: "abc", Name: "name", Email: "email"},
+			},
+			{
+				name:       "Create member with same email fails",
+				customer:   types.Customer{CustomerId: "qwer", Name: "name2", Email: "email"},
+				shouldFail: true,
+			},
+			{
+				name:     "Create same member updates",
+				customer: types.Customer{CustomerId: "abc", Name: "name", Email: "email2"},
+			},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				if err := db.CreateMember(ctx, tt.customer); err != nil && !tt.shouldFail {
+					t.Fatalf("error creating member: %s", err)
+				}
+
+				if tt.shouldFail {
+					return
+				}
+
+				m, err := db.FindMemberByCustomerId(ctx, tt.customer.CustomerId)
+				if err != nil {
+					t.Fatalf("error finding member: %s", err)
+				}
+
+				if m.Status != types.MemberStatusNotActive {
+					t.Errorf("new member expected to be not_active: %+v", *m)
+				}
+
+				if !equal(*m, tt.customer) {
+					t.Errorf(
+						"member doesn't match customer. Got: %+v Want: %+v",
+						*m,
+						tt.customer,
+					)
+				}
+			})
+		}
+	})
+}
+
+func TestActivateDeactivateMember(t *testing.T) {
+	ctx := context.Background()
+	forEachDialect(t, fmt.Sprintf("test_activate_member_%d", time.Now().Unix()), func(t *testing.T, db *DB) {
+		for _, c := range []types.Customer{
+			{CustomerId: "abc", Name: "name1", Email: "email1"},
+			{CustomerId: "xyz", Name: "name2", Email: "email2"},
+		} {
+			if err := db.CreateMember(ctx, c); err != nil {
+				t.Fatalf("error creating fixture: %s", err)
 			}
+		}
+
+		for _, tt := range []struct {
+			name       string
+			shouldFail bool
+			customerId string
+			status     string
+		}{
+			{
+				name:       "Activate unexistant member fails",
+				shouldFail: true,
+				customerId: "xxx",
+				status:     types.MemberStatusActive,
+			},
+			{
+				name:       "Activate member",
+				customerId: "abc",
+				status:     types.MemberStatusActive,
+			},
+			{
+				name:       "Activate member 2",
+				customerId: "xyz",
+				status:     types.MemberStatusActive,
+			},
+			{
+				name:       "Deactivate unexistant member fails",
+				shouldFail: true,
+				customerId: "xxx",
+				status:     types.MemberStatusNotActive,
+			},
+			{
+				name:       "Deactivate member",
+				customerId: "abc",
+				status:     types.MemberStatusNotActive,
+			},
+			{
+				name:       "Deactivate member 2",
+				customerId: "xyz",
+				status:     types.MemberStatusNotActive,
+			},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				var call func(context.Context, string) error
+				if tt.status == types.MemberStatusActive {
+					call = db.ActivateMember
+				} else {
+					call = db.DeactivateMember
+				}
+				err := call(ctx, tt.customerId)
+				if err != nil {
+					if !tt.shouldFail {
+						t.Errorf("unexpected error: %s", err)
+					}
+				}
+
+				if tt.shouldFail {
+					if err == nil {
+						t.Errorf("expected error, but didn't")
+					}
+					return
+				}
+
+				m, err := db.FindMemberByCustomerId(ctx, tt.customerId)
+				if err != nil {
+					t.Errorf("error finding member: %s", err)
+				}
+
+				if m.Status != tt.status {
+					t.Errorf("unexpected member status: %+v", *m)
+				}
+			})
+		}
+	})
+}
 
-			if tt.shouldFail {
-				return
+func TestUpdateMemberAccess(t *testing.T) {
+	ctx := context.Background()
+	forEachDialect(t, fmt.Sprintf("test_update_member_access_%d", time.Now().Unix()), func(t *testing.T, db *DB) {
+		for _, c := range []types.Customer{
+			{CustomerId: "abc", Name: "name1", Email: "email1"},
+			{CustomerId: "xyz", Name: "name2", Email: "email2"},
+		} {
+			if err := db.CreateMember(ctx, c); err != nil {
+				t.Fatalf("error creating fixture: %s", err)
 			}
+		}
 
-			m, err := db.FindMemberByCustomerId(tt.customer.CustomerId)
-			if err != nil {
-				t.Fatalf("error finding member: %s", err)
+		for _, tt := range []struct {
+			name       string
+			shouldFail bool
+			customerId string
+			accessId   string
+		}{
+			{
+				name:       "Update unexisting member fails",
+				shouldFail: true,
+				customerId: "xxx",
+				accessId:   "abc",
+			},
+			{
+				name:       "Invalid accessId fails",
+				shouldFail: true,
+				customerId: "abc",
+				accessId:   "weqrqwer",
+			},
+			{
+				name:       "Regular update",
+				customerId: "abc",
+				accessId:   "1c897f18-2cb4-4644-a900-8ddfc23d6f77",
+			},
+			{
+				name:       "Update with the same accessId fails",
+				customerId: "xyz",
+				accessId:   "1c897f18-2cb4-4644-a900-8ddfc23d6f77",
+				shouldFail: true,
+			},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				err := db.UpdateMemberAccess(ctx, tt.customerId, tt.accessId)
+				if err != nil {
+					if !tt.shouldFail {
+						t.Errorf("unexpected error: %s", err)
+					}
+				}
+
+				if tt.shouldFail {
+					if err == nil {
+						t.Errorf("expected error, but didn't")
+					}
+					return
+				}
+
+				m, err := db.FindMemberByCustomerId(ctx, tt.customerId)
+				if err != nil {
+					t.Errorf("error finding customer: %s", err)
+				}
+
+				if m.AccessId == nil || *m.AccessId != tt.accessId {
+					t.Errorf("unexpected access_id: %s", *m.AccessId)
+				}
+			})
+		}
+	})
+}
+
+func TestSentEmails(t *testing.T) {
+	ctx := context.Background()
+	forEachDialect(t, fmt.Sprintf("test_sent_emails_%d", time.Now().Unix()), func(t *testing.T, db *DB) {
+		if err := db.CreateMember(ctx, types.Customer{CustomerId: "abc", Name: "name", Email: "email"}); err != nil {
+			t.Fatalf("error creating fixture: %s", err)
+		}
+		m, err := db.FindMemberByCustomerId(ctx, "abc")
+		if err != nil {
+			t.Fatalf("error finding fixture: %s", err)
+		}
+
+		want := []types.SentEmail{
+			{MemberId: m.MemberId, Kind: types.EmailAccessGranted, Email: "email", Subject: "Welcome", Status: types.EmailStatusSent, SentAt: 100},
+			{MemberId: m.MemberId, Kind: types.EmailAccessRevoked, Email: "email", Subject: "Bye", Status: types.EmailStatusFailed, SentAt: 200},
+		}
+		for _, e := range want {
+			if err := db.SaveSentEmail(ctx, e); err != nil {
+				t.Fatalf("error saving sent email: %s", err)
 			}
+		}
+
+		if err := db.SaveSentEmail(ctx, types.SentEmail{MemberId: m.MemberId, Kind: "spam", Email: "email", Status: types.EmailStatusSent}); err == nil {
+			t.Error("expected error saving an unknown kind")
+		}
 
-			if m.Status != types.MemberStatusNotActive {
-				t.Errorf("new member expected to be not_active: %+v", *m)
+		got, err := db.SentEmails(ctx, m.MemberId)
+		if err != nil {
+			t.Fatalf("error listing sent emails: %s", err)
+		}
+
+		if len(got) != len(want) {
+			t.Fatalf("want %d emails, got %d", len(want), len(got))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("want %+v, got %+v", want[i], got[i])
 			}
+		}
 
-			if !equal(*m, tt.customer) {
-				t.Errorf(
-					"member doesn't match customer. Got: %+v Want: %+v",
-					*m,
-					tt.customer,
-				)
+		// Only emails that went out count, until the status changes again
+		for _, tt := range []struct {
+			kind string
+			want bool
+		}{{types.EmailAccessGranted, true}, {types.EmailAccessRevoked, false}} {
+			if sent, err := db.SentSinceStatusChange(ctx, m.MemberId, tt.kind); err != nil || sent != tt.want {
+				t.Errorf("%s: want sent %t, got %t and %v", tt.kind, tt.want, sent, err)
 			}
-		})
-	}
+		}
+		if err := db.ActivateMember(ctx, "abc"); err != nil {
+			t.Fatalf("error activating member: %s", err)
+		}
+		if sent, err := db.SentSinceStatusChange(ctx, m.MemberId, types.EmailAccessGranted); err != nil || sent {
+			t.Errorf("want nothing sent since activating, got %t and %v", sent, err)
+		}
+	})
 }
 
-func TestActivateDeactivateMember(t *testing.T) {
-	db := getDb(t, fmt.Sprintf("test_activate_member_%d", time.Now().Unix()))
-	for _, c := range []types.Customer{
-		{CustomerId: "abc", Name: "name1", Email: "email1"},
-		{CustomerId: "xyz", Name: "name2", Email: "email2"},
-	} {
-		if err := db.CreateMember(c); err != nil {
+func TestFindMembers(t *testing.T) {
+	ctx := context.Background()
+	forEachDialect(t, fmt.Sprintf("test_find_members_%d", time.Now().Unix()), func(t *testing.T, db *DB) {
+		for _, c := range []types.Customer{
+			{CustomerId: "cus_1", Name: "Jane Doe", Email: "jane@example.com"},
+			{CustomerId: "cus_2", Name: "John Doe", Email: "john@example.com"},
+			{CustomerId: "cus_3", Name: "Mary Ann Smith", Email: "mary@example.com"},
+		} {
+			if err := db.CreateMember(ctx, c); err != nil {
+				t.Fatalf("error creating fixture: %s", err)
+			}
+		}
+
+		accessId := "1c897f18-2cb4-4644-a900-8ddfc23d6f77"
+		if err := db.UpdateMemberAccess(ctx, "cus_3", accessId); err != nil {
 			t.Fatalf("error creating fixture: %s", err)
 		}
-	}
 
-	for _, tt := range []struct {
-		name       string
-		shouldFail bool
-		customerId string
-		status     string
-	}{
-		{
-			name:       "Activate unexistant member fails",
-			shouldFail: true,
-			customerId: "xxx",
-			status:     types.MemberStatusActive,
-		},
-		{
-			name:       "Activate member",
-			customerId: "abc",
-			status:     types.MemberStatusActive,
-		},
-		{
-			name:       "Activate member 2",
-			customerId: "xyz",
-			status:     types.MemberStatusActive,
-		},
-		{
-			name:       "Deactivate unexistant member fails",
-			shouldFail: true,
-			customerId: "xxx",
-			status:     types.MemberStatusNotActive,
-		},
-		{
-			name:       "Deactivate member",
-			customerId: "abc",
-			status:     types.MemberStatusNotActive,
-		},
-		{
-			name:       "Deactivate member 2",
-			customerId: "xyz",
-			status:     types.MemberStatusNotActive,
-		},
-	} {
-		t.Run(tt.name, func(t *testing.T) {
-			var call func(string) error
-			if tt.status == types.MemberStatusActive {
-				call = db.ActivateMember
-			} else {
-				call = db.DeactivateMember
-			}
-			err := call(tt.customerId)
-			if err != nil {
-				if !tt.shouldFail {
-					t.Errorf("unexpected error: %s", err)
+		for _, tt := range []struct {
+			name  string
+			query string
+			want  []string
+		}{
+			{name: "By customer id", query: "cus_2", want: []string{"cus_2"}},
+			{name: "By email", query: "jane@example.com", want: []string{"cus_1"}},
+			{name: "By partial name", query: "Doe", want: []string{"cus_1", "cus_2"}},
+			{name: "By access id", query: accessId, want: []string{"cus_3"}},
+			{name: "No matches", query: "nobody"},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				members, err := db.FindMembers(ctx, tt.query)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
 				}
-			}
 
-			if tt.shouldFail {
-				if err == nil {
-					t.Errorf("expected error, but didn't")
+				if len(members) != len(tt.want) {
+					t.Fatalf("want %d members, got %d", len(tt.want), len(members))
 				}
-				return
+				for i, m := range members {
+					if m.CustomerId != tt.want[i] {
+						t.Errorf("want %s, got %s", tt.want[i], m.CustomerId)
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestWebhookEvents(t *testing.T) {
+	ctx := context.Background()
+	forEachDialect(t, fmt.Sprintf("test_webhook_events_%d", time.Now().Unix()), func(t *testing.T, db *DB) {
+		for _, e := range []types.WebhookEvent{
+			{Id: "evt_1", Type: "customer.created", Payload: []byte("{}"), Status: types.EventStatusHandled, ReceivedAt: 100, HandledAt: 100},
+			{Id: "evt_2", Type: "customer.subscription.created", Payload: []byte("{}"), Status: types.EventStatusFailed, Error: "boom", ReceivedAt: 200, HandledAt: 200},
+		} {
+			if err := db.SaveEvent(ctx, e); err != nil {
+				t.Fatalf("error saving event: %s", err)
 			}
+		}
+
+		// Requeued successfully
+		if err := db.SaveEvent(ctx, types.WebhookEvent{Id: "evt_2", Type: "customer.subscription.created", Payload: []byte("{}"), Status: types.EventStatusHandled, ReceivedAt: 200, HandledAt: 300}); err != nil {
+			t.Fatalf("error saving event again: %s", err)
+		}
+
+		e, err := db.FindEvent(ctx, "evt_2")
+		if err != nil {
+			t.Fatalf("error finding event: %s", err)
+		}
+		if e.Status != types.EventStatusHandled || e.Error != "" || e.Attempts != 2 || e.ReceivedAt != 200 || e.HandledAt != 300 {
+			t.Errorf("unexpected event after requeueing: %+v", e)
+		}
+
+		if e, err := db.FindEvent(ctx, "evt_nope"); err != nil || e != nil {
+			t.Errorf("want no event and no error, got %+v and %v", e, err)
+		}
+
+		events, err := db.RecentEvents(ctx, 1)
+		if err != nil {
+			t.Fatalf("error listing events: %s", err)
+		}
+		if len(events) != 1 || events[0].Id != "evt_2" {
+			t.Errorf("want only evt_2, got %+v", events)
+		}
+	})
+}
+
+func TestHistory(t *testing.T) {
+	ctx := audit.WithActor(context.Background(), audit.Event("evt_1"))
+	forEachDialect(t, fmt.Sprintf("test_history_%d", time.Now().Unix()), func(t *testing.T, db *DB) {
+		accessId := "1c897f18-2cb4-4644-a900-8ddfc23d6f77"
+		c := types.Customer{CustomerId: "abc", Name: "name", Email: "email"}
+		moved := types.Customer{CustomerId: "abc", Name: "name", Email: "email2"}
+		for _, f := range []func() error{
+			func() error { return db.CreateMember(ctx, c) },
+			func() error { return db.CreateMember(ctx, c) },
+			func() error { return db.CreateMember(ctx, moved) },
+			func() error { return db.ActivateMember(ctx, "abc") },
+			func() error { return db.ActivateMember(ctx, "abc") },
+			func() error { return db.UpdateMemberAccess(ctx, "abc", accessId) },
+			func() error { return db.DeactivateMember(audit.WithActor(ctx, audit.Admin("jane")), "abc") },
+		} {
+			if err := f(); err != nil {
+				t.Fatalf("error changing member: %s", err)
+			}
+		}
+
+		m, err := db.FindMemberByCustomerId(ctx, "abc")
+		if err != nil {
+			t.Fatalf("error finding member: %s", err)
+		}
 
-			m, err := db.FindMemberByCustomerId(tt.customerId)
+		got, err := db.History(ctx, m.MemberId)
+		if err != nil {
+			t.Fatalf("error getting history: %s", err)
+		}
+
+		// Changes that change nothing aren't recorded
+		want := []audit.Entry{
+			{Actor: "event:evt_1", Action: audit.ActionCreate, New: "name <email>"},
+			{Actor: "event:evt_1", Action: audit.ActionDetails, Old: "name <email>", New: "name <email2>"},
+			{Actor: "event:evt_1", Action: audit.ActionStatus, Old: types.MemberStatusNotActive, New: types.MemberStatusActive},
+			{Actor: "event:evt_1", Action: audit.ActionAccessId, New: accessId},
+			{Actor: "admin:jane", Action: audit.ActionStatus, Old: types.MemberStatusActive, New: types.MemberStatusNotActive},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("want %d entries, got %+v", len(want), got)
+		}
+		for i := range want {
+			want[i].MemberId = m.MemberId
+			want[i].At = got[i].At
+			if got[i] != want[i] {
+				t.Errorf("want %+v, got %+v", want[i], got[i])
+			}
+		}
+	})
+}
+
+func TestSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	forEachDialect(t, fmt.Sprintf("test_subscriptions_%d", time.Now().Unix()), func(t *testing.T, db *DB) {
+		if err := db.CreateMember(ctx, types.Customer{CustomerId: "abc", Name: "name", Email: "email"}); err != nil {
+			t.Fatalf("error creating member: %s", err)
+		}
+
+		for _, tt := range []struct {
+			sub          types.Subscription
+			want         []string
+			wantPolicies []string
+		}{
+			{
+				sub:          types.Subscription{Id: "sub_1", Status: types.SubscriptionActive, Policies: []string{"doors", "shop"}},
+				want:         []string{"sub_1"},
+				wantPolicies: []string{"doors", "shop"},
+			},
+			{
+				sub:          types.Subscription{Id: "sub_2", Status: types.SubscriptionTrialing, Policies: []string{"shop", "weekends"}},
+				want:         []string{"sub_1", "sub_2"},
+				wantPolicies: []string{"doors", "shop", "weekends"},
+			},
+			{
+				sub:          types.Subscription{Id: "sub_2", Status: types.SubscriptionTrialing, Policies: []string{"weekends"}},
+				want:         []string{"sub_1", "sub_2"},
+				wantPolicies: []string{"doors", "shop", "weekends"},
+			},
+			{
+				sub:          types.Subscription{Id: "sub_1", Status: types.SubscriptionCanceled},
+				want:         []string{"sub_2"},
+				wantPolicies: []string{"weekends"},
+			},
+			{sub: types.Subscription{Id: "sub_2", Status: types.SubscriptionPastDue}, want: nil},
+		} {
+			if err := db.SaveSubscription(ctx, "abc", tt.sub); err != nil {
+				t.Fatalf("error saving subscription: %s", err)
+			}
+
+			got, err := db.ActiveSubscriptions(ctx, "abc")
 			if err != nil {
-				t.Errorf("error finding member: %s", err)
+				t.Fatalf("error getting active subscriptions: %s", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("after %s %s: want %v, got %v", tt.sub.Id, tt.sub.Status, tt.want, got)
 			}
 
-			if m.Status != tt.status {
-				t.Errorf("unexpected member status: %+v", *m)
+			policies, err := db.ActivePolicies(ctx, "abc")
+			if err != nil {
+				t.Fatalf("error getting active policies: %s", err)
 			}
-		})
-	}
+			if !slices.Equal(policies, tt.wantPolicies) {
+				t.Errorf("after %s %s: want policies %v, got %v", tt.sub.Id, tt.sub.Status, tt.wantPolicies, policies)
+			}
+		}
+
+		if err := db.SaveSubscription(ctx, "unknown", types.Subscription{Id: "sub_3", Status: types.SubscriptionActive}); err == nil {
+			t.Error("expected an error saving the subscription of an unknown customer")
+		}
+	})
 }
 
-func TestUpdateMemberAccess(t *testing.T) {
-	db := getDb(t, fmt.Sprintf("test_update_member_access_%d", time.Now().Unix()))
+func TestDependents(t *testing.T) {
+	ctx := context.Background()
+	forEachDialect(t, fmt.Sprintf("test_dependents_%d", time.Now().Unix()), func(t *testing.T, db *DB) {
+		for _, c := range []types.Customer{
+			{CustomerId: "abc", Name: "name", Email: "email"},
+			{CustomerId: "def", Name: "other", Email: "other-email"},
+		} {
+			if err := db.CreateMember(ctx, c); err != nil {
+				t.Fatalf("error creating member: %s", err)
+			}
+		}
 
-	for _, c := range []types.Customer{
-		{CustomerId: "abc", Name: "name1", Email: "email1"},
-		{CustomerId: "xyz", Name: "name2", Email: "email2"},
-	} {
-		if err := db.CreateMember(c); err != nil {
-			t.Fatalf("error creating fixture: %s", err)
+		for _, dep := range []types.Dependent{
+			{Name: "Kid One", Source: types.DependentSourceMetadata},
+			{Name: "Kid Two", Email: "two@example.com", Source: types.DependentSourceAdmin},
+			{Name: "Kid One", Email: "one@example.com", Source: types.DependentSourceMetadata},
+		} {
+			if err := db.SaveDependent(ctx, "abc", dep); err != nil {
+				t.Fatalf("error saving dependent: %s", err)
+			}
+		}
+		// Names only need to be unique within a household
+		if err := db.SaveDependent(ctx, "def", types.Dependent{Name: "Kid One", Source: types.DependentSourceAdmin}); err != nil {
+			t.Fatalf("error saving dependent: %s", err)
 		}
-	}
 
-	for _, tt := range []struct {
-		name       string
-		shouldFail bool
-		customerId string
-		accessId   string
-	}{
-		{
-			name:       "Update unexisting member fails",
-			shouldFail: true,
-			customerId: "xxx",
-			accessId:   "abc",
-		},
-		{
-			name:       "Invalid accessId fails",
-			shouldFail: true,
-			customerId: "abc",
-			accessId:   "weqrqwer",
-		},
-		{
-			name:       "Regular update",
-			customerId: "abc",
-			accessId:   "1c897f18-2cb4-4644-a900-8ddfc23d6f77",
-		},
-		{
-			name:       "Update with the same accessId fails",
-			customerId: "xyz",
-			accessId:   "1c897f18-2cb4-4644-a900-8ddfc23d6f77",
-			shouldFail: true,
-		},
-	} {
-		t.Run(tt.name, func(t *testing.T) {
-			err := db.UpdateMemberAccess(tt.customerId, tt.accessId)
-			if err != nil {
-				if !tt.shouldFail {
-					t.Errorf("unexpected error: %s", err)
-				}
+		deps, err := db.Dependents(ctx, "abc")
+		if err != nil {
+			t.Fatalf("error getting dependents: %s", err)
+		}
+		if len(deps) != 2 || deps[0].Name != "Kid One" || deps[0].Email != "one@example.com" || deps[1].Name != "Kid Two" {
+			t.Fatalf("unexpected dependents: %+v", deps)
+		}
+
+		// They mustn't share door user employee numbers with members
+		m, err := db.FindMemberByCustomerId(ctx, "abc")
+		if err != nil {
+			t.Fatalf("error finding member: %s", err)
+		}
+		if deps[0].MemberId != m.MemberId || deps[0].DependentId < 1000000 {
+			t.Errorf("want a dependent of member %d with an id from 1000000, got %+v", m.MemberId, deps[0])
+		}
+
+		accessId := "00000000-0000-0000-0000-000000000001"
+		if err := db.UpdateDependentAccess(ctx, deps[0].DependentId, accessId); err != nil {
+			t.Fatalf("error updating dependent access: %s", err)
+		}
+		if err := db.RemoveDependent(ctx, "def", deps[1].DependentId); err == nil {
+			t.Error("expected an error removing the dependent of someone else")
+		}
+		if err := db.RemoveDependent(ctx, "abc", deps[1].DependentId); err != nil {
+			t.Fatalf("error removing dependent: %s", err)
+		}
+
+		deps, err = db.Dependents(ctx, "abc")
+		if err != nil {
+			t.Fatalf("error getting dependents: %s", err)
+		}
+		if len(deps) != 1 || deps[0].AccessId == nil || *deps[0].AccessId != accessId {
+			t.Errorf("want the first dependent left with access id %s, got %+v", accessId, deps)
+		}
+
+		history, err := db.History(ctx, m.MemberId)
+		if err != nil {
+			t.Fatalf("error getting history: %s", err)
+		}
+		var changes int
+		var linked bool
+		for _, e := range history {
+			switch e.Action {
+			case audit.ActionDependent:
+				changes++
+			case audit.ActionAccessId:
+				linked = linked || e.New == fmt.Sprintf("dependent %d %s", deps[0].DependentId, accessId)
 			}
+		}
+		if changes != 4 || !linked {
+			t.Errorf("want 4 dependent changes and their user recorded, got %+v", history)
+		}
+	})
+}
 
-			if tt.shouldFail {
-				if err == nil {
-					t.Errorf("expected error, but didn't")
-				}
-				return
+func TestMemberNames(t *testing.T) {
+	ctx := context.Background()
+	forEachDialect(t, fmt.Sprintf("test_member_names_%d", time.Now().Unix()), func(t *testing.T, db *DB) {
+		c := types.Customer{
+			CustomerId: "abc",
+			Name:       "Mary Ann Smith Jones",
+			Email:      "email",
+			Metadata:   map[string]string{"first_name": "Mary Ann", "last_name": "Smith Jones"},
+		}
+		if err := db.CreateMember(ctx, c); err != nil {
+			t.Fatalf("error creating member: %s", err)
+		}
+		if err := db.SetMemberNames(ctx, "abc", "", "", "Annie"); err != nil {
+			t.Fatalf("error setting names: %s", err)
+		}
+		// Stripe taking the names out of the metadata keeps the overrides
+		c.Metadata = nil
+		if err := db.CreateMember(ctx, c); err != nil {
+			t.Fatalf("error updating member: %s", err)
+		}
+
+		m, err := db.FindMemberByCustomerId(ctx, "abc")
+		if err != nil {
+			t.Fatalf("error finding member: %s", err)
+		}
+		if m.FirstName != "" || m.LastName != "" || m.PreferredName != "Annie" {
+			t.Errorf("unexpected names: %+v", m)
+		}
+
+		history, err := db.History(ctx, m.MemberId)
+		if err != nil {
+			t.Fatalf("error getting history: %s", err)
+		}
+		var got []string
+		for _, e := range history {
+			if e.Action == audit.ActionNames {
+				got = append(got, e.New)
 			}
+		}
+		if want := []string{"preferred Annie", ""}; !slices.Equal(got, want) {
+			t.Errorf("want names changes %q, got %q", want, got)
+		}
+	})
+}
 
-			m, err := db.FindMemberByCustomerId(tt.customerId)
-			if err != nil {
-				t.Errorf("error finding customer: %s", err)
+func TestMergeMember(t *testing.T) {
+	ctx := context.Background()
+	forEachDialect(t, fmt.Sprintf("test_merge_member_%d", time.Now().Unix()), func(t *testing.T, db *DB) {
+		oldAccessId := "00000000-0000-0000-0000-000000000001"
+		newAccessId := "00000000-0000-0000-0000-000000000002"
+		kidAccessId := "00000000-0000-0000-0000-000000000003"
+		for _, c := range []types.Customer{
+			{CustomerId: "old", Name: "name", Email: "email"},
+			{CustomerId: "new", Name: "name", Email: "new-email"},
+		} {
+			if err := db.CreateMember(ctx, c); err != nil {
+				t.Fatalf("error creating member: %s", err)
+			}
+		}
+		if err := db.UpdateMemberAccess(ctx, "old", oldAccessId); err != nil {
+			t.Fatalf("error updating member access: %s", err)
+		}
+		if err := db.ActivateMember(ctx, "old"); err != nil {
+			t.Fatalf("error activating member: %s", err)
+		}
+		if err := db.UpdateMemberAccess(ctx, "new", newAccessId); err != nil {
+			t.Fatalf("error updating member access: %s", err)
+		}
+		for _, dep := range []struct {
+			customerId string
+			name       string
+		}{{"old", "Kid One"}, {"old", "Kid Two"}, {"new", "Kid One"}} {
+			if err := db.SaveDependent(ctx, dep.customerId, types.Dependent{Name: dep.name, Source: types.DependentSourceAdmin}); err != nil {
+				t.Fatalf("error saving dependent: %s", err)
 			}
+		}
+		oldDeps, err := db.Dependents(ctx, "old")
+		if err != nil {
+			t.Fatalf("error getting dependents: %s", err)
+		}
+		if err := db.UpdateDependentAccess(ctx, oldDeps[0].DependentId, kidAccessId); err != nil {
+			t.Fatalf("error updating dependent access: %s", err)
+		}
 
-			if m.AccessId == nil || *m.AccessId != tt.accessId {
-				t.Errorf("unexpected access_id: %s", *m.AccessId)
+		var conflict *types.EmailConflictError
+		err = db.CreateMember(ctx, types.Customer{CustomerId: "new", Name: "name", Email: "email"})
+		if !errors.As(err, &conflict) || conflict.MemberCustomerId != "old" {
+			t.Fatalf("want an email conflict with old, got %v", err)
+		}
+
+		orphans, err := db.MergeMember(ctx, "old", "new")
+		if err != nil {
+			t.Fatalf("error merging members: %s", err)
+		}
+		// Both had a user, so the one of old is left behind
+		if orphans.Member == nil || orphans.Member.CustomerId != "old" || deref(orphans.Member.AccessId) != oldAccessId {
+			t.Errorf("want old orphaned with their user, got %+v", orphans.Member)
+		}
+		if len(orphans.Dependents) != 1 || deref(orphans.Dependents[0].AccessId) != kidAccessId {
+			t.Errorf("want the duplicate Kid One orphaned with their user, got %+v", orphans.Dependents)
+		}
+		if _, err := db.FindMemberByCustomerId(ctx, "old"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("want the old member gone, got %v", err)
+		}
+		if err := db.CreateMember(ctx, types.Customer{CustomerId: "new", Name: "name", Email: "email"}); err != nil {
+			t.Fatalf("error taking over the email: %s", err)
+		}
+
+		m, err := db.FindMemberByCustomerId(ctx, "new")
+		if err != nil {
+			t.Fatalf("error finding member: %s", err)
+		}
+		if deref(m.AccessId) != newAccessId || m.Status != types.MemberStatusActive {
+			t.Errorf("want new active with their own user, got %+v", m)
+		}
+		deps, err := db.Dependents(ctx, "new")
+		if err != nil {
+			t.Fatalf("error getting dependents: %s", err)
+		}
+		// The new member keeps their own Kid One
+		if len(deps) != 2 || deps[0].Name != "Kid Two" || deps[1].Name != "Kid One" {
+			t.Errorf("unexpected dependents: %+v", deps)
+		}
+
+		history, err := db.History(ctx, m.MemberId)
+		if err != nil {
+			t.Fatalf("error getting history: %s", err)
+		}
+		var accessChanges, merges int
+		for _, e := range history {
+			switch {
+			case e.Action == audit.ActionAccessId && (e.New == oldAccessId || e.New == newAccessId):
+				accessChanges++
+			case e.Action == audit.ActionMerge:
+				merges++
 			}
-		})
-	}
+		}
+		if accessChanges != 2 || merges != 1 {
+			t.Errorf("want the history of both members and the merge, got %+v", history)
+		}
+
+		// Merging into a customer that isn't a member just renames it
+		orphans, err = db.MergeMember(ctx, "new", "newer")
+		if err != nil {
+			t.Fatalf("error merging members: %s", err)
+		}
+		if orphans.Member != nil || len(orphans.Dependents) != 0 {
+			t.Errorf("want nothing orphaned by a rename, got %+v", orphans)
+		}
+		if err := db.UnlinkMember(ctx, "newer"); err != nil {
+			t.Fatalf("error unlinking member: %s", err)
+		}
+		m2, err := db.FindMemberByCustomerId(ctx, "newer")
+		if err != nil {
+			t.Fatalf("error finding member: %s", err)
+		}
+		if m2.MemberId != m.MemberId || m2.AccessId != nil {
+			t.Errorf("want member %d without an access id, got %+v", m.MemberId, m2)
+		}
+	})
 }
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+// SaveDependent adds a dependent to the member with the given customer id,
+// or updates the email and source of the one they already have with that
+// name.
+func (d *DB) SaveDependent(ctx context.Context, customerId string, dep types.Dependent) error {
+	if dep.Name == "" {
+		return fmt.Errorf("dependent of %q has no name", customerId)
+	}
+
+	return d.inTx(ctx, func(q querier) error {
+		m, err := findMember(ctx, q, customerId)
+		if err != nil {
+			return err
+		}
+
+		old, err := findDependent(ctx, q, m.MemberId, dep.Name)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if old != nil && old.Email == dep.Email && old.Source == dep.Source {
+			return nil
+		}
+
+		if _, err := q.ExecContext(
+			ctx,
+			"INSERT INTO dependents "+
+				"(member_id, name, email, source) VALUES (?, ?, ?, ?) "+
+				d.dialect.upsert("member_id, name", "email", "source"),
+			m.MemberId,
+			dep.Name,
+			dep.Email,
+			dep.Source,
+		); err != nil {
+			return fmt.Errorf("error saving dependent of %q: %w", customerId, err)
+		}
+
+		oldValue := ""
+		if old != nil {
+			oldValue = contact(old.Name, old.Email)
+		}
+		return recordHistory(ctx, q, audit.NewEntry(ctx, m.MemberId, audit.ActionDependent, oldValue, contact(dep.Name, dep.Email)))
+	})
+}
+
+// RemoveDependent removes a dependent from the member with the given
+// customer id. Their door user is left as is.
+func (d *DB) RemoveDependent(ctx context.Context, customerId string, dependentId int64) error {
+	return d.inTx(ctx, func(q querier) error {
+		m, err := findMember(ctx, q, customerId)
+		if err != nil {
+			return err
+		}
+
+		deps, err := dependents(ctx, q, "member_id=? AND dependent_id=?", m.MemberId, dependentId)
+		if err != nil {
+			return err
+		}
+		if len(deps) == 0 {
+			return fmt.Errorf("no dependent %d of %q: %w", dependentId, customerId, sql.ErrNoRows)
+		}
+
+		if _, err := q.ExecContext(ctx, "DELETE FROM dependents WHERE dependent_id=?", dependentId); err != nil {
+			return fmt.Errorf("error removing dependent %d: %w", dependentId, err)
+		}
+
+		return recordHistory(ctx, q, audit.NewEntry(ctx, m.MemberId, audit.ActionDependent, contact(deps[0].Name, deps[0].Email), ""))
+	})
+}
+
+// UpdateDependentAccess links a dependent to their UniFi Access user. It's
+// recorded in the history of their primary member.
+func (d *DB) UpdateDependentAccess(ctx context.Context, dependentId int64, accessId string) error {
+	if _, err := uuid.Parse(accessId); err != nil {
+		return fmt.Errorf("invalid access id %q: %w", accessId, err)
+	}
+
+	return d.inTx(ctx, func(q querier) error {
+		deps, err := dependents(ctx, q, "dependent_id=?", dependentId)
+		if err != nil {
+			return err
+		}
+		if len(deps) == 0 {
+			return fmt.Errorf("no dependent %d: %w", dependentId, sql.ErrNoRows)
+		}
+
+		r, err := q.ExecContext(
+			ctx,
+			"UPDATE dependents SET access_id=? WHERE dependent_id=?",
+			accessId,
+			dependentId,
+		)
+		if err != nil {
+			return fmt.Errorf("error updating dependent's access id: %w", err)
+		}
+		if err := oneRowAffected(r); err != nil {
+			return err
+		}
+
+		oldValue := ""
+		if deps[0].AccessId != nil {
+			oldValue = dependentAccess(dependentId, *deps[0].AccessId)
+		}
+		return recordHistory(ctx, q, audit.NewEntry(
+			ctx,
+			deps[0].MemberId,
+			audit.ActionAccessId,
+			oldValue,
+			dependentAccess(dependentId, accessId),
+		))
+	})
+}
+
+// Dependents returns the dependents of the member with the given customer
+// id, oldest first.
+func (d *DB) Dependents(ctx context.Context, customerId string) ([]types.Dependent, error) {
+	return dependents(
+		ctx,
+		d.db,
+		"member_id=(SELECT member_id FROM members WHERE customer_id=?)",
+		customerId,
+	)
+}
+
+// dependentAccess is how the access id of a dependent is shown in the
+// history of their primary member
+func dependentAccess(dependentId int64, accessId string) string {
+	return fmt.Sprintf("dependent %d %s", dependentId, accessId)
+}
+
+func findDependent(ctx context.Context, q querier, memberId int64, name string) (*types.Dependent, error) {
+	deps, err := dependents(ctx, q, "member_id=? AND name=?", memberId, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(deps) == 0 {
+		return nil, fmt.Errorf("no dependent %q of member %d: %w", name, memberId, sql.ErrNoRows)
+	}
+
+	return &deps[0], nil
+}
+
+func dependents(ctx context.Context, q querier, where string, args ...any) ([]types.Dependent, error) {
+	rows, err := q.QueryContext(
+		ctx,
+		"SELECT dependent_id, member_id, access_id, name, email, source "+
+			"FROM dependents WHERE "+where+" ORDER BY dependent_id",
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying dependents: %w", err)
+	}
+	defer rows.Close()
+
+	var deps []types.Dependent
+	for rows.Next() {
+		var dep types.Dependent
+		if err := rows.Scan(
+			&dep.DependentId,
+			&dep.MemberId,
+			&dep.AccessId,
+			&dep.Name,
+			&dep.Email,
+			&dep.Source,
+		); err != nil {
+			return nil, fmt.Errorf("error scanning dependent: %w", err)
+		}
+		deps = append(deps, dep)
+	}
+
+	return deps, rows.Err()
+}
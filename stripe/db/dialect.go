@@ -0,0 +1,117 @@
+package db
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// dialect is what changes between the databases the members can be kept in
+type dialect struct {
+	name       string
+	driver     string
+	migrations []Migration
+	// upsert makes an INSERT overwrite the given columns of the row
+	// already using key
+	upsert func(key string, columns ...string) string
+	// transactionalDDL tells whether schema changes can be rolled back
+	transactionalDDL bool
+}
+
+var (
+	mysqlDialect = &dialect{
+		name:   "mysql",
+		driver: "mysql",
+		upsert: func(_ string, columns ...string) string {
+			return "ON DUPLICATE KEY UPDATE " + assignments(columns, "VALUE(%s)")
+		},
+	}
+
+	sqliteDialect = &dialect{
+		name:   "sqlite",
+		driver: "sqlite",
+		upsert: func(key string, columns ...string) string {
+			return fmt.Sprintf("ON CONFLICT(%s) DO UPDATE SET %s", key, assignments(columns, "excluded.%s"))
+		},
+		transactionalDDL: true,
+	}
+
+	dialects = []*dialect{mysqlDialect, sqliteDialect}
+)
+
+func init() {
+	for _, d := range dialects {
+		sub, err := fs.Sub(migrationFiles, "migrations/"+d.name)
+		if err != nil {
+			panic(err)
+		}
+		if d.migrations, err = loadMigrations(sub); err != nil {
+			panic(fmt.Errorf("%s migrations: %w", d.name, err))
+		}
+	}
+
+	// Every dialect must have the same schema versions, so a database can
+	// be moved from one to another.
+	for _, d := range dialects[1:] {
+		if err := sameMigrations(dialects[0].migrations, d.migrations); err != nil {
+			panic(fmt.Errorf("%s migrations: %w", d.name, err))
+		}
+	}
+}
+
+func sameMigrations(want []Migration, got []Migration) error {
+	if len(got) != len(want) {
+		return fmt.Errorf("want %d migrations, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].Name != want[i].Name {
+			return fmt.Errorf("want migration %d_%s, got %d_%s", want[i].Version, want[i].Name, got[i].Version, got[i].Name)
+		}
+	}
+
+	return nil
+}
+
+// assignments sets every column to format, filled with the column name.
+// Columns that already are an assignment, like "n=n+1", are kept as is.
+func assignments(columns []string, format string) string {
+	set := make([]string, len(columns))
+	for i, c := range columns {
+		if strings.Contains(c, "=") {
+			set[i] = c
+		} else {
+			set[i] = c + "=" + fmt.Sprintf(format, c)
+		}
+	}
+
+	return strings.Join(set, ", ")
+}
+
+// parseDSN picks the dialect by the scheme of dsn and returns the DSN its
+// driver expects. A DSN without a scheme is a MySQL one, e.g.
+// "user:pass@tcp(host)/db". SQLite ones look like "sqlite:///path/to.db".
+func parseDSN(dsn string) (*dialect, string) {
+	switch {
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return sqliteDialect, sqliteDSN(strings.TrimPrefix(dsn, "sqlite://"))
+	case strings.HasPrefix(dsn, "sqlite:"):
+		return sqliteDialect, sqliteDSN(strings.TrimPrefix(dsn, "sqlite:"))
+	case strings.HasPrefix(dsn, "mysql://"):
+		return mysqlDialect, strings.TrimPrefix(dsn, "mysql://")
+	}
+
+	return mysqlDialect, dsn
+}
+
+// sqliteDSN turns foreign keys on, which SQLite leaves off by default
+func sqliteDSN(path string) string {
+	if strings.Contains(path, "_foreign_keys=") || strings.Contains(path, "_fk=") {
+		return path
+	}
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + "_foreign_keys=on"
+}
@@ -0,0 +1,40 @@
+package db
+
+import "testing"
+
+func TestParseDSN(t *testing.T) {
+	for _, tt := range []struct {
+		dsn     string
+		dialect *dialect
+		want    string
+	}{
+		{dsn: "user:pass@tcp(db:3306)/members", dialect: mysqlDialect, want: "user:pass@tcp(db:3306)/members"},
+		{dsn: "mysql://user@/members", dialect: mysqlDialect, want: "user@/members"},
+		{dsn: "sqlite:///var/lib/webhook/members.db", dialect: sqliteDialect, want: "/var/lib/webhook/members.db?_foreign_keys=on"},
+		{dsn: "sqlite:members.db?_busy_timeout=5000", dialect: sqliteDialect, want: "members.db?_busy_timeout=5000&_foreign_keys=on"},
+		{dsn: "sqlite://members.db?_fk=0", dialect: sqliteDialect, want: "members.db?_fk=0"},
+	} {
+		t.Run(tt.dsn, func(t *testing.T) {
+			d, dsn := parseDSN(tt.dsn)
+			if d != tt.dialect || dsn != tt.want {
+				t.Errorf("want %s %q, got %s %q", tt.dialect.name, tt.want, d.name, dsn)
+			}
+		})
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	for _, tt := range []struct {
+		dialect *dialect
+		want    string
+	}{
+		{dialect: mysqlDialect, want: "ON DUPLICATE KEY UPDATE status=VALUE(status), n=n+1"},
+		{dialect: sqliteDialect, want: "ON CONFLICT(id) DO UPDATE SET status=excluded.status, n=n+1"},
+	} {
+		t.Run(tt.dialect.name, func(t *testing.T) {
+			if got := tt.dialect.upsert("id", "status", "n=n+1"); got != tt.want {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+func (d *DB) SaveSentEmail(ctx context.Context, e types.SentEmail) error {
+	if _, err := d.db.ExecContext(
+		ctx,
+		"INSERT INTO sent_emails "+
+			"(member_id, kind, email, subject, status, sent_at) VALUES (?, ?, ?, ?, ?, ?)",
+		e.MemberId,
+		e.Kind,
+		e.Email,
+		e.Subject,
+		e.Status,
+		e.SentAt,
+	); err != nil {
+		return fmt.Errorf("error saving sent email: %w", err)
+	}
+
+	return nil
+}
+
+// SentSinceStatusChange tells whether the member was already sent an email
+// of the given kind since their status last changed, e.g. before Stripe
+// retried the event that changed it.
+func (d *DB) SentSinceStatusChange(ctx context.Context, memberId int64, kind string) (bool, error) {
+	var n int
+	if err := d.db.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*) FROM sent_emails "+
+			"WHERE member_id=? AND kind=? AND status=? AND sent_at >= "+
+			"COALESCE((SELECT MAX(at) FROM member_history WHERE member_id=? AND action=?), 0)",
+		memberId,
+		kind,
+		types.EmailStatusSent,
+		memberId,
+		audit.ActionStatus,
+	).Scan(&n); err != nil {
+		return false, fmt.Errorf("error querying emails sent to member %d: %w", memberId, err)
+	}
+
+	return n > 0, nil
+}
+
+// SentEmails returns the emails sent to a member, oldest first
+func (d *DB) SentEmails(ctx context.Context, memberId int64) ([]types.SentEmail, error) {
+	rows, err := d.db.QueryContext(
+		ctx,
+		"SELECT member_id, kind, email, subject, status, sent_at "+
+			"FROM sent_emails WHERE member_id=? ORDER BY sent_at, id",
+		memberId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying emails sent to member %d: %w", memberId, err)
+	}
+	defer rows.Close()
+
+	var emails []types.SentEmail
+	for rows.Next() {
+		var e types.SentEmail
+		if err := rows.Scan(&e.MemberId, &e.Kind, &e.Email, &e.Subject, &e.Status, &e.SentAt); err != nil {
+			return nil, fmt.Errorf("error scanning sent email: %w", err)
+		}
+		emails = append(emails, e)
+	}
+
+	return emails, rows.Err()
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+// SaveEvent records how handling a webhook event went. Saving an event again,
+// like when it's requeued, updates its outcome and counts the attempt.
+func (d *DB) SaveEvent(ctx context.Context, e types.WebhookEvent) error {
+	if _, err := d.db.ExecContext(
+		ctx,
+		"INSERT INTO webhook_events "+
+			"(event_id, type, payload, status, error, received_at, handled_at) VALUES (?, ?, ?, ?, ?, ?, ?) "+
+			d.dialect.upsert("event_id", "status", "error", "handled_at", "attempts=attempts+1"),
+		e.Id,
+		e.Type,
+		e.Payload,
+		e.Status,
+		e.Error,
+		e.ReceivedAt,
+		e.HandledAt,
+	); err != nil {
+		return fmt.Errorf("error saving event %q: %w", e.Id, err)
+	}
+
+	return nil
+}
+
+// FindEvent returns nil when there's no event with the given id
+func (d *DB) FindEvent(ctx context.Context, id string) (*types.WebhookEvent, error) {
+	r := d.db.QueryRowContext(
+		ctx,
+		"SELECT event_id, type, payload, status, error, attempts, received_at, handled_at "+
+			"FROM webhook_events WHERE event_id=?",
+		id,
+	)
+
+	e, err := scanEvent(r)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error querying event %q: %w", id, err)
+	}
+
+	return e, nil
+}
+
+// RecentEvents returns up to limit events, the most recently received first
+func (d *DB) RecentEvents(ctx context.Context, limit int) ([]types.WebhookEvent, error) {
+	rows, err := d.db.QueryContext(
+		ctx,
+		"SELECT event_id, type, payload, status, error, attempts, received_at, handled_at "+
+			"FROM webhook_events ORDER BY received_at DESC LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying recent events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []types.WebhookEvent
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning event: %w", err)
+		}
+		events = append(events, *e)
+	}
+
+	return events, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanEvent(row scanner) (*types.WebhookEvent, error) {
+	var e types.WebhookEvent
+	if err := row.Scan(
+		&e.Id,
+		&e.Type,
+		&e.Payload,
+		&e.Status,
+		&e.Error,
+		&e.Attempts,
+		&e.ReceivedAt,
+		&e.HandledAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+)
+
+// RecordHistory appends an entry to the history of a member
+func (d *DB) RecordHistory(ctx context.Context, e audit.Entry) error {
+	return recordHistory(ctx, d.db, e)
+}
+
+func recordHistory(ctx context.Context, q querier, e audit.Entry) error {
+	if _, err := q.ExecContext(
+		ctx,
+		"INSERT INTO member_history "+
+			"(member_id, at, actor, action, old_value, new_value, outcome) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		e.MemberId,
+		e.At,
+		e.Actor,
+		e.Action,
+		e.Old,
+		e.New,
+		e.Outcome,
+	); err != nil {
+		return fmt.Errorf("error recording history of member %d: %w", e.MemberId, err)
+	}
+
+	return nil
+}
+
+// History returns everything that happened to a member, oldest first
+func (d *DB) History(ctx context.Context, memberId int64) ([]audit.Entry, error) {
+	rows, err := d.db.QueryContext(
+		ctx,
+		"SELECT member_id, at, actor, action, old_value, new_value, outcome "+
+			"FROM member_history WHERE member_id=? ORDER BY at, id",
+		memberId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying history of member %d: %w", memberId, err)
+	}
+	defer rows.Close()
+
+	var entries []audit.Entry
+	for rows.Next() {
+		var e audit.Entry
+		if err := rows.Scan(&e.MemberId, &e.At, &e.Actor, &e.Action, &e.Old, &e.New, &e.Outcome); err != nil {
+			return nil, fmt.Errorf("error scanning history entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
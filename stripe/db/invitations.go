@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	uaTypes "github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+func (d *DB) SaveInvitation(ctx context.Context, inv uaTypes.Invitation) error {
+	if _, err := d.db.ExecContext(
+		ctx,
+		"INSERT INTO invitations "+
+			"(member_id, access_id, email, status, sent_at) VALUES (?, ?, ?, ?, ?) "+
+			d.dialect.upsert("member_id", "access_id", "email", "status", "sent_at"),
+		inv.MemberId,
+		inv.AccessId,
+		inv.Email,
+		inv.Status,
+		inv.SentAt,
+	); err != nil {
+		return fmt.Errorf("error saving invitation: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DB) FindInvitation(ctx context.Context, memberId int32) (*uaTypes.Invitation, error) {
+	r := d.db.QueryRowContext(
+		ctx,
+		"SELECT member_id, access_id, email, status, sent_at "+
+			"FROM invitations WHERE member_id=?",
+		memberId,
+	)
+
+	var inv uaTypes.Invitation
+	err := r.Scan(&inv.MemberId, &inv.AccessId, &inv.Email, &inv.Status, &inv.SentAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying invitation for member %d: %w", memberId, err)
+	}
+
+	return &inv, nil
+}
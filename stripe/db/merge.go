@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+// UnlinkMember forgets the UniFi Access user of the member with the given
+// customer id, e.g. because their customer was deleted.
+func (d *DB) UnlinkMember(ctx context.Context, customerId string) error {
+	return d.inTx(ctx, func(q querier) error {
+		old, err := findMember(ctx, q, customerId)
+		if err != nil {
+			return err
+		}
+		if old.AccessId == nil {
+			return nil
+		}
+
+		if _, err := q.ExecContext(ctx, "UPDATE members SET access_id=NULL WHERE member_id=?", old.MemberId); err != nil {
+			return fmt.Errorf("error unlinking member: %w", err)
+		}
+
+		return recordHistory(ctx, q, audit.NewEntry(ctx, old.MemberId, audit.ActionAccessId, *old.AccessId, ""))
+	})
+}
+
+// MergeMember makes the member with the customer id from the member of the
+// customer id into, when they turn out to be the same person. When into
+// isn't a member yet, the member just gets their customer id. Otherwise into
+// gets the UniFi Access user of from, unless they have one already, along
+// with their history, subscriptions, dependents, emails and invitation, and
+// from is deleted. Into stays active if either of them was. The users of
+// whoever was left without a member are returned, for them to be disabled.
+func (d *DB) MergeMember(ctx context.Context, from string, into string) (types.Orphans, error) {
+	var orphans types.Orphans
+	if from == into {
+		return orphans, fmt.Errorf("can't merge %q into itself", from)
+	}
+
+	err := d.inTx(ctx, func(q querier) error {
+		f, err := findMember(ctx, q, from)
+		if err != nil {
+			return err
+		}
+
+		i, err := findMember(ctx, q, into)
+		if errors.Is(err, sql.ErrNoRows) {
+			if _, err := q.ExecContext(ctx, "UPDATE members SET customer_id=? WHERE member_id=?", into, f.MemberId); err != nil {
+				return fmt.Errorf("error changing customer id of %q: %w", from, err)
+			}
+			return recordHistory(ctx, q, audit.NewEntry(ctx, f.MemberId, audit.ActionMerge, from, into))
+		}
+		if err != nil {
+			return err
+		}
+
+		if orphans.Dependents, err = moveMemberRows(ctx, q, f.MemberId, i.MemberId); err != nil {
+			return err
+		}
+
+		if _, err := q.ExecContext(ctx, "DELETE FROM members WHERE member_id=?", f.MemberId); err != nil {
+			return fmt.Errorf("error deleting merged member %q: %w", from, err)
+		}
+
+		// Only set after deleting from, since access ids are unique
+		accessId, status := i.AccessId, i.Status
+		if accessId == nil {
+			accessId = f.AccessId
+		} else if f.AccessId != nil {
+			orphans.Member = f
+		}
+		if f.Status == types.MemberStatusActive {
+			status = types.MemberStatusActive
+		}
+		if _, err := q.ExecContext(
+			ctx,
+			"UPDATE members SET access_id=?, status=? WHERE member_id=?",
+			accessId,
+			status,
+			i.MemberId,
+		); err != nil {
+			return fmt.Errorf("error updating merged member %q: %w", into, err)
+		}
+
+		return recordHistory(ctx, q, audit.NewEntry(
+			ctx,
+			i.MemberId,
+			audit.ActionMerge,
+			fmt.Sprintf("%d %s", f.MemberId, from),
+			fmt.Sprintf("%d %s", i.MemberId, into),
+		))
+	})
+	if err != nil {
+		return types.Orphans{}, err
+	}
+
+	return orphans, nil
+}
+
+// moveMemberRows points everything that belongs to member from to member
+// into. Dependents into already has, and the invitation of from when into
+// has one, are dropped instead. The dropped dependents with a UniFi Access
+// user are returned, as belonging to into, whose history they are recorded in
+// from now on.
+func moveMemberRows(ctx context.Context, q querier, from int64, into int64) ([]types.Dependent, error) {
+	kept, err := dependents(ctx, q, "member_id=?", into)
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]bool)
+	for _, dep := range kept {
+		taken[dep.Name] = true
+	}
+
+	moved, err := dependents(ctx, q, "member_id=?", from)
+	if err != nil {
+		return nil, err
+	}
+	var dropped []types.Dependent
+	for _, dep := range moved {
+		if !taken[dep.Name] {
+			continue
+		}
+		if _, err := q.ExecContext(ctx, "DELETE FROM dependents WHERE dependent_id=?", dep.DependentId); err != nil {
+			return nil, fmt.Errorf("error dropping duplicate dependent: %w", err)
+		}
+		if dep.AccessId != nil {
+			dep.MemberId = into
+			dropped = append(dropped, dep)
+		}
+	}
+
+	var invitations int
+	if err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM invitations WHERE member_id=?", into).Scan(&invitations); err != nil {
+		return nil, fmt.Errorf("error querying invitations: %w", err)
+	}
+	if invitations > 0 {
+		if _, err := q.ExecContext(ctx, "DELETE FROM invitations WHERE member_id=?", from); err != nil {
+			return nil, fmt.Errorf("error dropping invitation: %w", err)
+		}
+	}
+
+	for _, table := range []string{"member_history", "subscriptions", "dependents", "sent_emails", "invitations"} {
+		if _, err := q.ExecContext(ctx, "UPDATE "+table+" SET member_id=? WHERE member_id=?", into, from); err != nil {
+			return nil, fmt.Errorf("error moving %s: %w", table, err)
+		}
+	}
+
+	return dropped, nil
+}
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Each dialect has its migrations in migrations/<dialect name>. The first
+// MySQL ones use CREATE TABLE IF NOT EXISTS, so databases created before
+// there were migrations adopt them without changes.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+const createSchemaVersionTable = "CREATE TABLE IF NOT EXISTS `schema_version` (" +
+	"`version` int(11) NOT NULL, " +
+	"`name` varchar(255) NOT NULL, " +
+	"`applied_at` bigint NOT NULL, " +
+	"PRIMARY KEY (`version`))"
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer
+// binary, which this one can't be trusted to work with.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// Migration is a change to the schema, read from NNNN_name.sql
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationStatus tells whether a migration has been applied, and when
+type MigrationStatus struct {
+	Migration
+	AppliedAt int64
+}
+
+// loadMigrations returns the migrations sorted by version, making sure there
+// are no gaps or duplicates.
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var ms []Migration
+	for _, f := range files {
+		num, name, ok := strings.Cut(strings.TrimSuffix(f, ".sql"), "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %q isn't named NNNN_name.sql", f)
+		}
+
+		body, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %q: %w", f, err)
+		}
+		ms = append(ms, Migration{Version: version, Name: name, SQL: string(body)})
+	}
+
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	for i, m := range ms {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("expected migration %d, found %d_%s", i+1, m.Version, m.Name)
+		}
+	}
+
+	return ms, nil
+}
+
+// LatestVersion is the schema version this binary works with
+func LatestVersion() int {
+	return len(mysqlDialect.migrations)
+}
+
+// SchemaVersion returns the version of the last migration applied, or 0 when
+// none was.
+func (d *DB) SchemaVersion(ctx context.Context) (int, error) {
+	if _, err := d.db.ExecContext(ctx, createSchemaVersionTable); err != nil {
+		return 0, fmt.Errorf("error creating schema_version table: %w", err)
+	}
+
+	var version sql.NullInt64
+	if err := d.db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("error querying schema version: %w", err)
+	}
+
+	return int(version.Int64), nil
+}
+
+// MigrationStatus lists every migration this binary knows about, with the
+// time the applied ones were applied at.
+func (d *DB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	if _, err := d.SchemaVersion(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("error listing applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]int64)
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("error scanning applied migration: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(d.dialect.migrations))
+	for i, m := range d.dialect.migrations {
+		status[i] = MigrationStatus{Migration: m, AppliedAt: applied[m.Version]}
+	}
+
+	return status, nil
+}
+
+// Migrate applies the pending migrations in order and returns them. It
+// refuses to touch a database migrated by a newer binary.
+//
+// On SQLite each migration is applied along with its schema_version row in a
+// transaction, so a failing one leaves nothing behind. MySQL commits schema
+// changes right away, even inside a transaction, so a migration that fails
+// halfway there needs fixing by hand before running it again.
+func (d *DB) Migrate(ctx context.Context) ([]Migration, error) {
+	current, err := d.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if current > LatestVersion() {
+		return nil, fmt.Errorf(
+			"%w: database is at version %d, this binary knows up to %d",
+			ErrSchemaTooNew,
+			current,
+			LatestVersion(),
+		)
+	}
+
+	pending := d.dialect.migrations[current:]
+	for _, m := range pending {
+		slog.Info("Applying migration", "version", m.Version, "name", m.Name)
+		if d.dialect.transactionalDDL {
+			err = d.inTx(ctx, func(q querier) error { return applyMigration(ctx, q, m) })
+		} else {
+			err = applyMigration(ctx, d.db, m)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pending, nil
+}
+
+// applyMigration runs the statements of m and records it as applied
+func applyMigration(ctx context.Context, q querier, m Migration) error {
+	for _, stmt := range statements(m.SQL) {
+		if _, err := q.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("error applying migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	if _, err := q.ExecContext(
+		ctx,
+		"INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+		m.Version,
+		m.Name,
+		time.Now().Unix(),
+	); err != nil {
+		return fmt.Errorf("error recording migration %d_%s: %w", m.Version, m.Name, err)
+	}
+
+	return nil
+}
+
+// statements splits a migration into the statements it's made of, since the
+// driver runs one at a time. Statements end with a semicolon at the end of a
+// line.
+func statements(migration string) []string {
+	var stmts []string
+	for _, s := range strings.Split(migration, ";\n") {
+		s = strings.TrimSuffix(strings.TrimSpace(s), ";")
+		if s != "" {
+			stmts = append(stmts, s)
+		}
+	}
+
+	return stmts
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		files      []string
+		want       []string
+		shouldFail bool
+	}{
+		{
+			name:  "Sorted by version",
+			files: []string{"0002_add_column.sql", "0001_create_table.sql"},
+			want:  []string{"create_table", "add_column"},
+		},
+		{
+			name:       "Gaps are refused",
+			files:      []string{"0001_create_table.sql", "0003_add_column.sql"},
+			shouldFail: true,
+		},
+		{
+			name:       "Duplicates are refused",
+			files:      []string{"0001_create_table.sql", "0001_add_column.sql"},
+			shouldFail: true,
+		},
+		{
+			name:       "Unnumbered files are refused",
+			files:      []string{"create_table.sql"},
+			shouldFail: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, f := range tt.files {
+				fsys[f] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+			}
+
+			ms, err := loadMigrations(fsys)
+			if tt.shouldFail {
+				if err == nil {
+					t.Errorf("expected error, got %+v", ms)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var names []string
+			for _, m := range ms {
+				names = append(names, m.Name)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("want %v, got %v", tt.want, names)
+			}
+		})
+	}
+}
+
+func TestStatements(t *testing.T) {
+	got := statements("ALTER TABLE a ADD x int;\n\nUPDATE a SET x = ';';\nCREATE INDEX i ON a (x);\n")
+	want := []string{"ALTER TABLE a ADD x int", "UPDATE a SET x = ';'", "CREATE INDEX i ON a (x)"}
+	if !slices.Equal(got, want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	forEachDialect(t, fmt.Sprintf("test_migrate_%d", time.Now().Unix()), func(t *testing.T, db *DB) {
+		version, err := db.SchemaVersion(ctx)
+		if err != nil {
+			t.Fatalf("error getting schema version: %s", err)
+		}
+		if version != LatestVersion() {
+			t.Errorf("want version %d, got %d", LatestVersion(), version)
+		}
+
+		if applied, err := db.Migrate(ctx); err != nil || len(applied) != 0 {
+			t.Errorf("want nothing applied, got %+v and %v", applied, err)
+		}
+
+		status, err := db.MigrationStatus(ctx)
+		if err != nil {
+			t.Fatalf("error getting migration status: %s", err)
+		}
+		for _, s := range status {
+			if s.AppliedAt == 0 {
+				t.Errorf("migration %d_%s not applied", s.Version, s.Name)
+			}
+		}
+
+		// A newer binary migrated the database
+		if _, err := db.db.ExecContext(
+			ctx,
+			"INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'future', 1)",
+			LatestVersion()+1,
+		); err != nil {
+			t.Fatalf("error faking a newer schema: %s", err)
+		}
+		if _, err := db.Migrate(ctx); !errors.Is(err, ErrSchemaTooNew) {
+			t.Errorf("want ErrSchemaTooNew, got %v", err)
+		}
+	})
+}
+
+func TestMigrateRollsBackOnSQLite(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, sqliteDialect, fmt.Sprintf("test_migrate_rollback_%d", time.Now().Unix()))
+
+	broken := *sqliteDialect
+	broken.migrations = append(slices.Clone(sqliteDialect.migrations), Migration{
+		Version: LatestVersion() + 1,
+		Name:    "broken",
+		SQL:     "CREATE TABLE half (id INTEGER);\nINSERT INTO missing VALUES (1);\n",
+	})
+	db.dialect = &broken
+
+	if _, err := db.Migrate(ctx); err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+
+	if version, err := db.SchemaVersion(ctx); err != nil || version != LatestVersion() {
+		t.Errorf("want version %d, got %d and %v", LatestVersion(), version, err)
+	}
+	var tables int
+	if err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name='half'").Scan(&tables); err != nil || tables != 0 {
+		t.Errorf("want the half applied migration rolled back, got %d tables and %v", tables, err)
+	}
+}
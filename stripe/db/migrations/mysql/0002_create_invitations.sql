@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `invitations` (
+    `member_id` int(11) NOT NULL,
+    `access_id` uuid NOT NULL,
+    `email` varchar(255) NOT NULL,
+    `status` enum('sent','failed','revoked') NOT NULL,
+    `sent_at` bigint NOT NULL,
+    PRIMARY KEY (`member_id`),
+    FOREIGN KEY (`member_id`) REFERENCES `members` (`member_id`)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS `sent_emails` (
+    `id` int(11) NOT NULL AUTO_INCREMENT,
+    `member_id` int(11) NOT NULL,
+    `kind` enum('access_granted','access_suspended','access_revoked') NOT NULL,
+    `email` varchar(255) NOT NULL,
+    `subject` varchar(255) NOT NULL,
+    `status` enum('sent','failed') NOT NULL,
+    `sent_at` bigint NOT NULL,
+    PRIMARY KEY (`id`),
+    KEY `member_id` (`member_id`),
+    FOREIGN KEY (`member_id`) REFERENCES `members` (`member_id`)
+);
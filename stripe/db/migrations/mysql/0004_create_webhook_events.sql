@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS `webhook_events` (
+    `event_id` varchar(255) NOT NULL,
+    `type` varchar(255) NOT NULL,
+    `payload` mediumblob NOT NULL,
+    `status` enum('handled','failed','ignored') NOT NULL,
+    `error` text NOT NULL,
+    `attempts` int(11) NOT NULL DEFAULT 1,
+    `received_at` bigint NOT NULL,
+    `handled_at` bigint NOT NULL,
+    PRIMARY KEY (`event_id`),
+    KEY `received_at` (`received_at`)
+);
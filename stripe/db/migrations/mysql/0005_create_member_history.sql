@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS `member_history` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `member_id` int(11) NOT NULL,
+    `at` bigint NOT NULL,
+    `actor` varchar(255) NOT NULL,
+    `action` varchar(64) NOT NULL,
+    `old_value` text NOT NULL,
+    `new_value` text NOT NULL,
+    `outcome` text NOT NULL,
+    PRIMARY KEY (`id`),
+    KEY `member_id` (`member_id`, `at`)
+);
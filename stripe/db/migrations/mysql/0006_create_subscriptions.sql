@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `subscriptions` (
+    `subscription_id` varchar(255) NOT NULL,
+    `member_id` int(11) NOT NULL,
+    `status` varchar(32) NOT NULL,
+    `updated_at` bigint NOT NULL,
+    PRIMARY KEY (`subscription_id`),
+    KEY `member_id` (`member_id`),
+    FOREIGN KEY (`member_id`) REFERENCES `members` (`member_id`)
+);
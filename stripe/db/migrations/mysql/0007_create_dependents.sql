@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS `dependents` (
+    `dependent_id` int(11) NOT NULL AUTO_INCREMENT,
+    `member_id` int(11) NOT NULL,
+    `access_id` uuid DEFAULT NULL,
+    `name` varchar(255) NOT NULL,
+    `email` varchar(255) NOT NULL,
+    `source` enum('metadata','admin') NOT NULL,
+    PRIMARY KEY (`dependent_id`),
+    UNIQUE KEY `member_name` (`member_id`, `name`),
+    UNIQUE KEY `access_id` (`access_id`),
+    FOREIGN KEY (`member_id`) REFERENCES `members` (`member_id`)
+) AUTO_INCREMENT=1000000;
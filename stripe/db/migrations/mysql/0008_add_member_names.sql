@@ -0,0 +1,6 @@
+ALTER TABLE `members`
+    ADD COLUMN IF NOT EXISTS `first_name` varchar(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS `last_name` varchar(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS `first_name_override` varchar(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS `last_name_override` varchar(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS `preferred_name` varchar(255) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE `subscriptions`
+    ADD COLUMN IF NOT EXISTS `policies` varchar(1024) NOT NULL DEFAULT '';
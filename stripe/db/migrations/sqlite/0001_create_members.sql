@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS members (
+    member_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    customer_id TEXT NOT NULL UNIQUE,
+    access_id TEXT DEFAULT NULL UNIQUE,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL UNIQUE,
+    status TEXT DEFAULT 'not_active' CHECK (status IN ('not_active', 'active'))
+) STRICT;
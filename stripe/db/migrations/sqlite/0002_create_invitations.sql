@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS invitations (
+    member_id INTEGER PRIMARY KEY REFERENCES members (member_id),
+    access_id TEXT NOT NULL,
+    email TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('sent', 'failed', 'revoked')),
+    sent_at INTEGER NOT NULL
+) STRICT;
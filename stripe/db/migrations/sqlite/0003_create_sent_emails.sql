@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS sent_emails (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    member_id INTEGER NOT NULL REFERENCES members (member_id),
+    kind TEXT NOT NULL CHECK (kind IN ('access_granted', 'access_suspended', 'access_revoked')),
+    email TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('sent', 'failed')),
+    sent_at INTEGER NOT NULL
+) STRICT;
+CREATE INDEX IF NOT EXISTS sent_emails_member_id ON sent_emails (member_id);
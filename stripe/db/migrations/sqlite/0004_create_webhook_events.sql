@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS webhook_events (
+    event_id TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    payload BLOB NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('handled', 'failed', 'ignored')),
+    error TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 1,
+    received_at INTEGER NOT NULL,
+    handled_at INTEGER NOT NULL
+) STRICT;
+CREATE INDEX IF NOT EXISTS webhook_events_received_at ON webhook_events (received_at);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS subscriptions (
+    subscription_id TEXT PRIMARY KEY,
+    member_id INTEGER NOT NULL REFERENCES members (member_id),
+    status TEXT NOT NULL,
+    updated_at INTEGER NOT NULL
+) STRICT;
+CREATE INDEX IF NOT EXISTS subscriptions_member_id ON subscriptions (member_id);
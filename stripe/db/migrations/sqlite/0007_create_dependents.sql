@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS dependents (
+    dependent_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    member_id INTEGER NOT NULL REFERENCES members (member_id),
+    access_id TEXT DEFAULT NULL UNIQUE,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL,
+    source TEXT NOT NULL CHECK (source IN ('metadata', 'admin')),
+    UNIQUE (member_id, name)
+) STRICT;
+INSERT INTO sqlite_sequence (name, seq)
+    SELECT 'dependents', 999999
+    WHERE NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'dependents');
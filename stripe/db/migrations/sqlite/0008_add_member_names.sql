@@ -0,0 +1,5 @@
+ALTER TABLE members ADD COLUMN first_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE members ADD COLUMN last_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE members ADD COLUMN first_name_override TEXT NOT NULL DEFAULT '';
+ALTER TABLE members ADD COLUMN last_name_override TEXT NOT NULL DEFAULT '';
+ALTER TABLE members ADD COLUMN preferred_name TEXT NOT NULL DEFAULT '';
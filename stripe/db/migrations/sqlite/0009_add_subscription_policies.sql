@@ -0,0 +1 @@
+ALTER TABLE subscriptions ADD COLUMN policies TEXT NOT NULL DEFAULT '';
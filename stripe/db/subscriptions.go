@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+// SaveSubscription records the status of a subscription of the member with
+// the given customer id, and the policies it gives.
+func (d *DB) SaveSubscription(ctx context.Context, customerId string, s types.Subscription) error {
+	if s.Id == "" {
+		return fmt.Errorf("subscription of %q has no id", customerId)
+	}
+
+	return d.inTx(ctx, func(q querier) error {
+		m, err := findMember(ctx, q, customerId)
+		if err != nil {
+			return err
+		}
+
+		policies := strings.Join(s.Policies, ",")
+		var old, oldPolicies string
+		err = q.QueryRowContext(
+			ctx,
+			"SELECT status, policies FROM subscriptions WHERE subscription_id=?",
+			s.Id,
+		).Scan(&old, &oldPolicies)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("error querying subscription %q: %w", s.Id, err)
+		}
+		if old == s.Status && oldPolicies == policies {
+			return nil
+		}
+
+		if _, err := q.ExecContext(
+			ctx,
+			"INSERT INTO subscriptions "+
+				"(subscription_id, member_id, status, policies, updated_at) VALUES (?, ?, ?, ?, ?) "+
+				d.dialect.upsert("subscription_id", "member_id", "status", "policies", "updated_at"),
+			s.Id,
+			m.MemberId,
+			s.Status,
+			policies,
+			time.Now().Unix(),
+		); err != nil {
+			return fmt.Errorf("error saving subscription %q: %w", s.Id, err)
+		}
+
+		if old == s.Status {
+			return nil
+		}
+		return recordHistory(ctx, q, audit.NewEntry(ctx, m.MemberId, audit.ActionSubscription, subscription(s.Id, old), subscription(s.Id, s.Status)))
+	})
+}
+
+func subscription(id, status string) string {
+	if status == "" {
+		return ""
+	}
+	return id + " " + status
+}
+
+// ActiveSubscriptions returns the ids of the subscriptions of the member
+// with the given customer id that give them access.
+func (d *DB) ActiveSubscriptions(ctx context.Context, customerId string) ([]string, error) {
+	rows, err := d.db.QueryContext(
+		ctx,
+		"SELECT s.subscription_id FROM subscriptions s "+
+			"JOIN members m ON m.member_id = s.member_id "+
+			"WHERE m.customer_id=? AND s.status IN (?, ?) "+
+			"ORDER BY s.subscription_id",
+		customerId,
+		types.SubscriptionActive,
+		types.SubscriptionTrialing,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying subscriptions of %q: %w", customerId, err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning subscription: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// ActivePolicies returns the policies given by all the subscriptions of the
+// member with the given customer id that give them access, without repeats.
+func (d *DB) ActivePolicies(ctx context.Context, customerId string) ([]string, error) {
+	rows, err := d.db.QueryContext(
+		ctx,
+		"SELECT s.policies FROM subscriptions s "+
+			"JOIN members m ON m.member_id = s.member_id "+
+			"WHERE m.customer_id=? AND s.status IN (?, ?) "+
+			"ORDER BY s.subscription_id",
+		customerId,
+		types.SubscriptionActive,
+		types.SubscriptionTrialing,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying policies of %q: %w", customerId, err)
+	}
+	defer rows.Close()
+
+	var policies []string
+	for rows.Next() {
+		var listed string
+		if err := rows.Scan(&listed); err != nil {
+			return nil, fmt.Errorf("error scanning policies: %w", err)
+		}
+		for _, id := range strings.Split(listed, ",") {
+			if id != "" && !slices.Contains(policies, id) {
+				policies = append(policies, id)
+			}
+		}
+	}
+
+	return policies, rows.Err()
+}
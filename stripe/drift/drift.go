@@ -0,0 +1,246 @@
+// Package drift finds and fixes the differences between the Stripe member
+// database and UniFi Access that dropped webhook events, or edits made in the
+// UniFi Access console, leave behind. Only users managed by the sync are
+// changed. Dependents of family memberships aren't covered: their users are
+// fixed by the next event of their primary member, or a resync.
+package drift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/backfill"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/membership"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/names"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+	"github.com/fatcatfablab/fcfl-member-sync/sync"
+	uaTypes "github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+type memberDb interface {
+	CreateMember(ctx context.Context, c types.Customer) error
+	ActivateMember(ctx context.Context, customerId string) error
+	DeactivateMember(ctx context.Context, customerId string) error
+	SaveSubscription(ctx context.Context, customerId string, s types.Subscription) error
+	UpdateMemberAccess(ctx context.Context, customerId string, accessId string) error
+	FindMemberByCustomerId(ctx context.Context, customerId string) (*types.Member, error)
+	ListMembers(ctx context.Context) ([]types.Member, error)
+}
+
+type uaUpdater interface {
+	List(ctx context.Context) (uaTypes.MemberMap, error)
+	Unmanaged() uaTypes.MemberMap
+	AddMember(ctx context.Context, m uaTypes.ComparableMember) (string, error)
+	Update(ctx context.Context, members uaTypes.MemberMap) error
+	Disable(ctx context.Context, members uaTypes.MemberMap) error
+}
+
+type stripeSource interface {
+	Customers(ctx context.Context, f func(types.Customer) error) error
+	Subscriptions(ctx context.Context, f func(types.Subscription) error) error
+}
+
+type Reconciler struct {
+	db         memberDb
+	ua         uaUpdater
+	stripe     stripeSource
+	filter     *membership.Filter
+	maxDisable int
+	dryRun     bool
+}
+
+func New(d memberDb, u uaUpdater, dryRun bool) *Reconciler {
+	return &Reconciler{db: d, ua: u, dryRun: dryRun}
+}
+
+// SetStripe makes every run first bring the status of the members up to date
+// with their subscriptions in Stripe, counting those f allows.
+func (r *Reconciler) SetStripe(s stripeSource, f *membership.Filter) {
+	r.stripe = s
+	r.filter = f
+}
+
+// SetMaxDisable makes runs refuse to disable more than n members. 0 means no
+// limit.
+func (r *Reconciler) SetMaxDisable(n int) {
+	r.maxDisable = n
+}
+
+// Run makes UniFi Access match the members table: active members get an
+// active user with their name, everyone else gets theirs disabled. Members
+// whose user was deleted get a new one, and the access id is fixed for
+// those whose link got lost.
+func (r *Reconciler) Run(ctx context.Context) error {
+	ctx = audit.WithActor(ctx, audit.Reconcile(audit.RunId(time.Now())))
+	slog.Info("Reconciling drift", "stripe", r.stripe != nil, logging.KeyDryRun, r.dryRun)
+
+	var stripeErr error
+	if r.stripe != nil {
+		// A failing Stripe still leaves the database worth reconciling
+		if _, stripeErr = backfill.Run(ctx, r.stripe, r.db, r.filter, r.dryRun); stripeErr != nil {
+			slog.Error("Error refreshing members from Stripe", logging.Err(stripeErr))
+			stripeErr = fmt.Errorf("error refreshing members from Stripe: %w", stripeErr)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	members, err := r.db.ListMembers(ctx)
+	if err != nil {
+		return errors.Join(stripeErr, err)
+	}
+
+	users, err := r.ua.List(ctx)
+	if err != nil {
+		return errors.Join(stripeErr, fmt.Errorf("error listing UniFi Access users: %w", err))
+	}
+
+	unmanaged := r.ua.Unmanaged()
+	remote, local, err := r.compare(ctx, members, users, unmanaged)
+	if err != nil {
+		return errors.Join(stripeErr, err)
+	}
+
+	remote, collisions := sync.SkipCollisions(remote, local, unmanaged)
+	for accessId, u := range collisions {
+		slog.Warn(
+			"Unmanaged user has the id of a member, leaving it for an admin",
+			logging.KeyAccessId, accessId,
+			logging.Inline(u),
+		)
+	}
+
+	f := &fixer{uaUpdater: r.ua, db: r.db, members: make(map[int32]types.Member)}
+	for _, m := range members {
+		f.members[int32(m.MemberId)] = m
+	}
+
+	return errors.Join(stripeErr, sync.ReconcileWithLimit(ctx, remote, local, f, r.maxDisable))
+}
+
+// compare returns what UniFi Access should look like according to members,
+// and what it looks like, linked by the access id of each member. Members
+// who lost their access id get linked to the managed user with their
+// employee number that no other member has. Members pointing to an unmanaged
+// user are left out, for an admin to sort out.
+func (r *Reconciler) compare(ctx context.Context, members []types.Member, users uaTypes.MemberMap, unmanaged uaTypes.MemberMap) (uaTypes.MemberSet, uaTypes.MemberMap, error) {
+	linked := make(map[string]bool)
+	for _, m := range members {
+		linked[deref(m.AccessId)] = true
+	}
+	byEmployeeNumber := make(map[int32]string)
+	for accessId, u := range users {
+		if !linked[accessId] {
+			byEmployeeNumber[u.Id] = accessId
+		}
+	}
+
+	remote := uaTypes.NewMemberSet()
+	local := make(uaTypes.MemberMap)
+	for _, m := range members {
+		logger := slog.With(logging.KeyMemberId, m.MemberId, logging.KeyCustomerId, m.CustomerId)
+		accessId := deref(m.AccessId)
+		if _, ok := unmanaged[accessId]; ok {
+			logger.Warn("Member points to a user the sync doesn't manage, leaving it for an admin", logging.KeyAccessId, accessId)
+			continue
+		}
+
+		want := memberToComparableMember(m)
+		if m.Status == types.MemberStatusActive {
+			remote.Add(want)
+		}
+
+		if _, ok := users[accessId]; !ok {
+			if accessId != "" {
+				logger.Warn("UniFi Access user is gone", logging.KeyAccessId, accessId)
+			}
+
+			found, ok := byEmployeeNumber[want.Id]
+			if !ok {
+				continue
+			}
+			logger.Info("Relinking member to their UniFi Access user", logging.KeyAccessId, found, logging.KeyDryRun, r.dryRun)
+			if !r.dryRun {
+				if err := r.db.UpdateMemberAccess(ctx, m.CustomerId, found); err != nil {
+					return nil, nil, err
+				}
+			}
+			accessId = found
+		}
+
+		// The user is known by the member id, whatever employee number it has
+		u := users[accessId]
+		u.Id = want.Id
+		local[accessId] = u
+	}
+
+	return remote, local, nil
+}
+
+// fixer applies the changes sync.Reconcile decides on, storing the access id
+// of the users it creates.
+type fixer struct {
+	uaUpdater
+	db memberDb
+	// members as they were when the run listed them, by member id
+	members map[int32]types.Member
+}
+
+func (f *fixer) Add(ctx context.Context, members uaTypes.MemberSet) error {
+	var reterror error
+	for m := range members.Iter() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := f.add(ctx, m); err != nil {
+			slog.Error("Skipping due to failure", logging.Inline(m), logging.Err(err))
+			reterror = err
+		}
+	}
+
+	return reterror
+}
+
+func (f *fixer) add(ctx context.Context, m uaTypes.ComparableMember) error {
+	listed := f.members[m.Id]
+
+	// The listener may have given them a user since the members were listed
+	current, err := f.db.FindMemberByCustomerId(ctx, listed.CustomerId)
+	if err != nil {
+		return err
+	}
+	if deref(current.AccessId) != deref(listed.AccessId) {
+		slog.Info("Member got a user in the meantime", logging.KeyMemberId, m.Id, logging.KeyAccessId, deref(current.AccessId))
+		return nil
+	}
+
+	accessId, err := f.AddMember(ctx, m)
+	if err != nil || accessId == "" {
+		return err
+	}
+
+	return f.db.UpdateMemberAccess(ctx, listed.CustomerId, accessId)
+}
+
+func memberToComparableMember(m types.Member) uaTypes.ComparableMember {
+	firstName, lastName := names.ForDoor(m)
+	return uaTypes.ComparableMember{
+		Id:        int32(m.MemberId),
+		FirstName: firstName,
+		LastName:  lastName,
+		Status:    uaTypes.StatusActive,
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
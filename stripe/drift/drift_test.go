@@ -0,0 +1,231 @@
+package drift
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+	uaTypes "github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+const (
+	aliceUser = "00000000-0000-0000-0000-00000000000a"
+	bobUser   = "00000000-0000-0000-0000-00000000000b"
+	carolUser = "00000000-0000-0000-0000-00000000000c"
+	daveUser  = "00000000-0000-0000-0000-00000000000d"
+	newUser   = "00000000-0000-0000-0000-0000000000ff"
+)
+
+type fakeDb struct {
+	members []types.Member
+}
+
+func (f *fakeDb) find(customerId string) *types.Member {
+	for i := range f.members {
+		if f.members[i].CustomerId == customerId {
+			return &f.members[i]
+		}
+	}
+	return nil
+}
+
+func (f *fakeDb) CreateMember(_ context.Context, c types.Customer) error {
+	if f.find(c.CustomerId) == nil {
+		f.members = append(f.members, types.Member{
+			MemberId:   int64(len(f.members) + 1),
+			CustomerId: c.CustomerId,
+			Name:       c.Name,
+			Status:     types.MemberStatusNotActive,
+		})
+	}
+	return nil
+}
+
+func (f *fakeDb) SaveSubscription(_ context.Context, _ string, _ types.Subscription) error {
+	return nil
+}
+
+func (f *fakeDb) ActivateMember(_ context.Context, customerId string) error {
+	f.find(customerId).Status = types.MemberStatusActive
+	return nil
+}
+
+func (f *fakeDb) DeactivateMember(_ context.Context, customerId string) error {
+	f.find(customerId).Status = types.MemberStatusNotActive
+	return nil
+}
+
+func (f *fakeDb) UpdateMemberAccess(_ context.Context, customerId string, accessId string) error {
+	f.find(customerId).AccessId = &accessId
+	return nil
+}
+
+func (f *fakeDb) FindMemberByCustomerId(_ context.Context, customerId string) (*types.Member, error) {
+	if m := f.find(customerId); m != nil {
+		copy := *m
+		return &copy, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (f *fakeDb) ListMembers(_ context.Context) ([]types.Member, error) {
+	return append([]types.Member(nil), f.members...), nil
+}
+
+// fakeUA keeps the users by access id
+type fakeUA struct {
+	users     uaTypes.MemberMap
+	unmanaged uaTypes.MemberMap
+}
+
+func (f *fakeUA) List(_ context.Context) (uaTypes.MemberMap, error) {
+	return maps.Clone(f.users), nil
+}
+
+func (f *fakeUA) Unmanaged() uaTypes.MemberMap {
+	return f.unmanaged
+}
+
+func (f *fakeUA) AddMember(_ context.Context, m uaTypes.ComparableMember) (string, error) {
+	f.users[newUser] = m
+	return newUser, nil
+}
+
+func (f *fakeUA) Update(_ context.Context, members uaTypes.MemberMap) error {
+	for accessId, m := range members {
+		m.Status = uaTypes.StatusActive
+		f.users[accessId] = m
+	}
+	return nil
+}
+
+func (f *fakeUA) Disable(_ context.Context, members uaTypes.MemberMap) error {
+	for accessId, m := range members {
+		m.Status = uaTypes.StatusDeactivated
+		f.users[accessId] = m
+	}
+	return nil
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+func TestRun(t *testing.T) {
+	db := &fakeDb{members: []types.Member{
+		// Renamed in the UniFi Access console
+		{MemberId: 1, CustomerId: "cus_alice", Name: "Alice Smith", Status: types.MemberStatusActive, AccessId: ptr(aliceUser)},
+		// Their user was deleted
+		{MemberId: 2, CustomerId: "cus_bob", Name: "Bob Jones", Status: types.MemberStatusActive, AccessId: ptr(bobUser)},
+		// Left, but their deactivation event was missed
+		{MemberId: 3, CustomerId: "cus_carol", Name: "Carol White", Status: types.MemberStatusNotActive, AccessId: ptr(carolUser)},
+		// Got a user, but saving its access id failed
+		{MemberId: 4, CustomerId: "cus_dave", Name: "Dave Brown", Status: types.MemberStatusActive},
+	}}
+	ua := &fakeUA{users: uaTypes.MemberMap{
+		aliceUser: {Id: 1, FirstName: "Alicia", LastName: "Smith", Status: uaTypes.StatusActive},
+		carolUser: {Id: 3, FirstName: "Carol", LastName: "White", Status: uaTypes.StatusActive},
+		daveUser:  {Id: 4, FirstName: "Dave", LastName: "Brown", Status: uaTypes.StatusActive},
+	}}
+
+	if err := New(db, ua, false).Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := uaTypes.MemberMap{
+		aliceUser: {Id: 1, FirstName: "Alice", LastName: "Smith", Status: uaTypes.StatusActive},
+		newUser:   {Id: 2, FirstName: "Bob", LastName: "Jones", Status: uaTypes.StatusActive},
+		carolUser: {Id: 3, FirstName: "Carol", LastName: "White", Status: uaTypes.StatusDeactivated},
+		daveUser:  {Id: 4, FirstName: "Dave", LastName: "Brown", Status: uaTypes.StatusActive},
+	}
+	if !uaTypes.Equal(ua.users, want) {
+		t.Errorf("want users %+v, got %+v", want, ua.users)
+	}
+
+	for customerId, accessId := range map[string]string{"cus_bob": newUser, "cus_dave": daveUser} {
+		if m := db.find(customerId); deref(m.AccessId) != accessId {
+			t.Errorf("%s: want access id %q, got %q", customerId, accessId, deref(m.AccessId))
+		}
+	}
+}
+
+func TestRunLeavesUnmanagedUsers(t *testing.T) {
+	db := &fakeDb{members: []types.Member{
+		// Their user was taken out of the sync's hands
+		{MemberId: 1, CustomerId: "cus_alice", Name: "Alice Smith", Status: types.MemberStatusActive, AccessId: ptr(aliceUser)},
+		// Lost their access id, and the user with their number isn't managed
+		{MemberId: 2, CustomerId: "cus_bob", Name: "Bob Jones", Status: types.MemberStatusActive},
+	}}
+	unmanaged := uaTypes.MemberMap{
+		aliceUser: {Id: 1, FirstName: "Alicia", LastName: "Smith", Status: uaTypes.StatusDeactivated},
+		bobUser:   {Id: 2, FirstName: "Bob", LastName: "Jones", Status: uaTypes.StatusActive},
+	}
+	ua := &fakeUA{users: uaTypes.MemberMap{}, unmanaged: maps.Clone(unmanaged)}
+
+	if err := New(db, ua, false).Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(ua.users) != 0 || !uaTypes.Equal(ua.unmanaged, unmanaged) {
+		t.Errorf("want no users created or changed, got %+v and %+v", ua.users, ua.unmanaged)
+	}
+	if m := db.find("cus_bob"); m.AccessId != nil {
+		t.Errorf("want bob left unlinked, got %q", *m.AccessId)
+	}
+}
+
+type fakeStripe struct {
+	customers     []types.Customer
+	subscriptions []types.Subscription
+}
+
+func (f *fakeStripe) Customers(_ context.Context, fn func(types.Customer) error) error {
+	for _, c := range f.customers {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeStripe) Subscriptions(_ context.Context, fn func(types.Subscription) error) error {
+	for _, s := range f.subscriptions {
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestRunWithStripe(t *testing.T) {
+	// The database missed both the new member and the cancellation
+	db := &fakeDb{members: []types.Member{
+		{MemberId: 1, CustomerId: "cus_alice", Name: "Alice Smith", Status: types.MemberStatusActive, AccessId: ptr(aliceUser)},
+	}}
+	ua := &fakeUA{users: uaTypes.MemberMap{
+		aliceUser: {Id: 1, FirstName: "Alice", LastName: "Smith", Status: uaTypes.StatusActive},
+	}}
+	stripe := &fakeStripe{
+		customers: []types.Customer{{CustomerId: "cus_alice", Name: "Alice Smith"}, {CustomerId: "cus_bob", Name: "Bob Jones"}},
+		subscriptions: []types.Subscription{
+			{Customer: "cus_alice", Status: types.SubscriptionCanceled},
+			{Customer: "cus_bob", Status: types.SubscriptionActive},
+		},
+	}
+
+	r := New(db, ua, false)
+	r.SetStripe(stripe, nil)
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := uaTypes.MemberMap{
+		aliceUser: {Id: 1, FirstName: "Alice", LastName: "Smith", Status: uaTypes.StatusDeactivated},
+		newUser:   {Id: 2, FirstName: "Bob", LastName: "Jones", Status: uaTypes.StatusActive},
+	}
+	if !uaTypes.Equal(ua.users, want) {
+		t.Errorf("want users %+v, got %+v", want, ua.users)
+	}
+}
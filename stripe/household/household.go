@@ -0,0 +1,193 @@
+// Package household gives each dependent of a family membership their own
+// UniFi Access user, whose access follows the membership of the member
+// paying for it.
+package household
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"strings"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/names"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+	uaTypes "github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+// MetadataKey is the customer metadata listing their dependents, separated
+// by semicolons or new lines, as "Name" or "Name <email>".
+const MetadataKey = "dependents"
+
+type store interface {
+	Dependents(ctx context.Context, customerId string) ([]types.Dependent, error)
+	SaveDependent(ctx context.Context, customerId string, d types.Dependent) error
+	RemoveDependent(ctx context.Context, customerId string, dependentId int64) error
+	UpdateDependentAccess(ctx context.Context, dependentId int64, accessId string) error
+}
+
+type uaUpdater interface {
+	AddMember(context.Context, uaTypes.ComparableMember) (string, error)
+	UpdateMember(context.Context, string, uaTypes.ComparableMember) error
+	DisableMember(context.Context, string, uaTypes.ComparableMember) error
+}
+
+type Households struct {
+	db store
+	ua uaUpdater
+}
+
+func New(d store, u uaUpdater) *Households {
+	return &Households{db: d, ua: u}
+}
+
+// Parse returns the dependents listed in the metadata of c
+func Parse(c types.Customer) []types.Dependent {
+	var deps []types.Dependent
+	seen := make(map[string]bool)
+	for _, entry := range strings.FieldsFunc(c.Metadata[MetadataKey], func(r rune) bool { return r == ';' || r == '\n' }) {
+		d := types.Dependent{Name: strings.TrimSpace(entry), Source: types.DependentSourceMetadata}
+		if a, err := mail.ParseAddress(d.Name); err == nil && a.Name != "" {
+			d.Name, d.Email = a.Name, a.Address
+		}
+		if d.Name == "" || seen[d.Name] {
+			continue
+		}
+		seen[d.Name] = true
+		deps = append(deps, d)
+	}
+
+	return deps
+}
+
+// SetFromMetadata makes the dependents of c that came from their metadata
+// match it. The ones no longer listed are removed, and lose access. Customers
+// without metadata, as opposed to empty metadata, are left alone, and so are
+// the dependents added by an admin, even when listed.
+func (h *Households) SetFromMetadata(ctx context.Context, c types.Customer) error {
+	if c.Metadata == nil {
+		return nil
+	}
+
+	current, err := h.db.Dependents(ctx, c.CustomerId)
+	if err != nil {
+		return fmt.Errorf("error querying dependents of %q: %w", c.CustomerId, err)
+	}
+	admin := make(map[string]bool)
+	for _, d := range current {
+		admin[d.Name] = d.Source == types.DependentSourceAdmin
+	}
+
+	listed := make(map[string]bool)
+	for _, d := range Parse(c) {
+		listed[d.Name] = true
+		if admin[d.Name] {
+			continue
+		}
+		if err := h.db.SaveDependent(ctx, c.CustomerId, d); err != nil {
+			return fmt.Errorf("error saving dependent: %w", err)
+		}
+	}
+
+	for _, d := range current {
+		if d.Source == types.DependentSourceMetadata && !listed[d.Name] {
+			if err := h.Remove(ctx, c.CustomerId, d); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Remove disables the UniFi Access user of the dependent, if they have one,
+// and removes them from the household.
+func (h *Households) Remove(ctx context.Context, customerId string, d types.Dependent) error {
+	slog.Info("Removing dependent", logging.KeyCustomerId, customerId, logging.KeyMemberId, d.DependentId)
+	if d.AccessId != nil {
+		if err := h.ua.DisableMember(historyOf(ctx, d), *d.AccessId, dependentToComparableMember(d)); err != nil {
+			return fmt.Errorf("error disabling dependent %d in UA: %w", d.DependentId, err)
+		}
+	}
+
+	return h.db.RemoveDependent(ctx, customerId, d.DependentId)
+}
+
+// Disable disables the UniFi Access users of dependents who are no longer
+// part of any household, e.g. dropped by merging members. Every dependent is
+// tried even when some fail.
+func (h *Households) Disable(ctx context.Context, deps []types.Dependent) error {
+	var reterror error
+	for _, d := range deps {
+		if d.AccessId == nil {
+			continue
+		}
+		if err := h.ua.DisableMember(historyOf(ctx, d), *d.AccessId, dependentToComparableMember(d)); err != nil {
+			reterror = errors.Join(reterror, fmt.Errorf("error disabling dependent %d in UA: %w", d.DependentId, err))
+		}
+	}
+
+	return reterror
+}
+
+// Follow makes the UniFi Access users of the dependents of m active when m
+// is, creating the missing ones, and disables them otherwise. Every
+// dependent is tried even when some fail.
+func (h *Households) Follow(ctx context.Context, m types.Member) error {
+	deps, err := h.db.Dependents(ctx, m.CustomerId)
+	if err != nil {
+		return fmt.Errorf("error querying dependents of %q: %w", m.CustomerId, err)
+	}
+
+	var reterror error
+	for _, d := range deps {
+		if err := h.follow(ctx, m, d); err != nil {
+			slog.Error("Error updating dependent", logging.KeyCustomerId, m.CustomerId, logging.KeyMemberId, d.DependentId, logging.Err(err))
+			reterror = errors.Join(reterror, err)
+		}
+	}
+
+	return reterror
+}
+
+func (h *Households) follow(ctx context.Context, m types.Member, d types.Dependent) error {
+	ctx = historyOf(ctx, d)
+	cm := dependentToComparableMember(d)
+	active := m.Status == types.MemberStatusActive
+
+	switch {
+	case active && d.AccessId == nil:
+		accessId, err := h.ua.AddMember(ctx, cm)
+		if err != nil {
+			return fmt.Errorf("failed to add dependent %d to UA: %w", d.DependentId, err)
+		}
+		if accessId != "" {
+			return h.db.UpdateDependentAccess(ctx, d.DependentId, accessId)
+		}
+	case active:
+		return h.ua.UpdateMember(ctx, *d.AccessId, cm)
+	case d.AccessId != nil:
+		return h.ua.DisableMember(ctx, *d.AccessId, cm)
+	}
+
+	return nil
+}
+
+// historyOf makes the UniFi Access changes done to d with the returned context
+// be recorded in the history of their primary member, since dependents don't
+// have one of their own.
+func historyOf(ctx context.Context, d types.Dependent) context.Context {
+	return audit.WithPrimaryMember(ctx, d.MemberId)
+}
+
+func dependentToComparableMember(d types.Dependent) uaTypes.ComparableMember {
+	firstName, lastName := names.Split(d.Name)
+	return uaTypes.ComparableMember{
+		Id:        int32(d.DependentId),
+		FirstName: firstName,
+		LastName:  lastName,
+	}
+}
@@ -0,0 +1,191 @@
+package household
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+	uaTypes "github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+// fakeStore keeps the dependents of a single household
+type fakeStore struct {
+	deps     []types.Dependent
+	nextId   int64
+	memberId int64
+}
+
+func (f *fakeStore) Dependents(_ context.Context, _ string) ([]types.Dependent, error) {
+	return slices.Clone(f.deps), nil
+}
+
+func (f *fakeStore) SaveDependent(_ context.Context, _ string, d types.Dependent) error {
+	for i := range f.deps {
+		if f.deps[i].Name == d.Name {
+			f.deps[i].Email, f.deps[i].Source = d.Email, d.Source
+			return nil
+		}
+	}
+	f.nextId++
+	d.DependentId, d.MemberId = f.nextId, f.memberId
+	f.deps = append(f.deps, d)
+	return nil
+}
+
+func (f *fakeStore) RemoveDependent(_ context.Context, _ string, dependentId int64) error {
+	f.deps = slices.DeleteFunc(f.deps, func(d types.Dependent) bool { return d.DependentId == dependentId })
+	return nil
+}
+
+func (f *fakeStore) UpdateDependentAccess(_ context.Context, dependentId int64, accessId string) error {
+	for i := range f.deps {
+		if f.deps[i].DependentId == dependentId {
+			f.deps[i].AccessId = &accessId
+		}
+	}
+	return nil
+}
+
+// fakeUA records what was done to which user, and the member whose history
+// it would be recorded in
+type fakeUA struct {
+	calls     []string
+	histories []int64
+}
+
+func (f *fakeUA) AddMember(ctx context.Context, m uaTypes.ComparableMember) (string, error) {
+	f.call(ctx, fmt.Sprintf("add %d", m.Id))
+	return fmt.Sprintf("access-%d", m.Id), nil
+}
+
+func (f *fakeUA) UpdateMember(ctx context.Context, id string, _ uaTypes.ComparableMember) error {
+	f.call(ctx, "update "+id)
+	return nil
+}
+
+func (f *fakeUA) DisableMember(ctx context.Context, id string, _ uaTypes.ComparableMember) error {
+	f.call(ctx, "disable "+id)
+	return nil
+}
+
+func (f *fakeUA) call(ctx context.Context, call string) {
+	f.calls = append(f.calls, call)
+	memberId, _ := audit.PrimaryMember(ctx)
+	f.histories = append(f.histories, memberId)
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		metadata string
+		want     []types.Dependent
+	}{
+		{name: "Nothing", metadata: ""},
+		{
+			name:     "Names and emails",
+			metadata: "Kid One; Kid Two <two@example.com>\n Kid Three ",
+			want: []types.Dependent{
+				{Name: "Kid One", Source: types.DependentSourceMetadata},
+				{Name: "Kid Two", Email: "two@example.com", Source: types.DependentSourceMetadata},
+				{Name: "Kid Three", Source: types.DependentSourceMetadata},
+			},
+		},
+		{
+			name:     "Duplicates and blanks are skipped",
+			metadata: "Kid One;; Kid One <one@example.com>;",
+			want:     []types.Dependent{{Name: "Kid One", Source: types.DependentSourceMetadata}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(types.Customer{Metadata: map[string]string{MetadataKey: tt.metadata}})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHouseholds(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeStore{nextId: 1000000, memberId: 7}
+	ua := &fakeUA{}
+	h := New(db, ua)
+
+	admin := types.Dependent{Name: "Partner", Source: types.DependentSourceAdmin}
+	if err := db.SaveDependent(ctx, "cus_1", admin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Partner is also listed in Stripe, but stays the admin's
+	c := types.Customer{CustomerId: "cus_1", Metadata: map[string]string{MetadataKey: "Kid One; Kid Two; Partner <p@example.com>"}}
+	if err := h.SetFromMetadata(ctx, c); err != nil {
+		t.Fatalf("error setting dependents: %s", err)
+	}
+	if d := db.deps[0]; d.Source != types.DependentSourceAdmin || d.Email != "" {
+		t.Errorf("want the admin's dependent untouched, got %+v", d)
+	}
+
+	m := types.Member{MemberId: 7, CustomerId: "cus_1", Status: types.MemberStatusActive}
+	if err := h.Follow(ctx, m); err != nil {
+		t.Fatalf("error following member: %s", err)
+	}
+	want := []string{"add 1000001", "add 1000002", "add 1000003"}
+	if !slices.Equal(ua.calls, want) {
+		t.Errorf("want %v, got %v", want, ua.calls)
+	}
+
+	// Kid Two grew up and got their own membership
+	ua.calls = nil
+	c.Metadata[MetadataKey] = "Kid One"
+	if err := h.SetFromMetadata(ctx, c); err != nil {
+		t.Fatalf("error setting dependents: %s", err)
+	}
+	if want := []string{"disable access-1000003"}; !slices.Equal(ua.calls, want) {
+		t.Errorf("want %v, got %v", want, ua.calls)
+	}
+	if len(db.deps) != 2 {
+		t.Errorf("want the admin and metadata dependents left, got %+v", db.deps)
+	}
+
+	// Their access follows the primary member's
+	ua.calls = nil
+	m.Status = types.MemberStatusNotActive
+	if err := h.Follow(ctx, m); err != nil {
+		t.Fatalf("error following member: %s", err)
+	}
+	if want := []string{"disable access-1000001", "disable access-1000002"}; !slices.Equal(ua.calls, want) {
+		t.Errorf("want %v, got %v", want, ua.calls)
+	}
+
+	// Every change is recorded in the history of the primary member
+	for i, memberId := range ua.histories {
+		if memberId != m.MemberId {
+			t.Errorf("%s recorded under member %d", ua.calls[i], memberId)
+		}
+	}
+
+	// Customers without metadata leave their dependents alone
+	if err := h.SetFromMetadata(ctx, types.Customer{CustomerId: "cus_1"}); err != nil || len(db.deps) != 2 {
+		t.Errorf("want dependents untouched, got %+v and %v", db.deps, err)
+	}
+}
+
+func TestDisable(t *testing.T) {
+	ua := &fakeUA{}
+	h := New(&fakeStore{}, ua)
+
+	accessId := "access-1000001"
+	deps := []types.Dependent{
+		{DependentId: 1000001, Name: "Kid One", AccessId: &accessId},
+		{DependentId: 1000002, Name: "Kid Two"},
+	}
+	if err := h.Disable(context.Background(), deps); err != nil {
+		t.Fatalf("error disabling dependents: %s", err)
+	}
+	if want := []string{"disable access-1000001"}; !slices.Equal(ua.calls, want) {
+		t.Errorf("want %v, got %v", want, ua.calls)
+	}
+}
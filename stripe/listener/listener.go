@@ -1,24 +1,35 @@
-//go:generate mockgen --destination mock_listener_test.go --package listener . memberDb,uaUpdater
+//go:generate mockgen --destination mock_listener_test.go --package listener . memberDb,uaUpdater,memberMailer,eventStore,customerSource,households
 
 package listener
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"strings"
-
+	"slices"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/metrics"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/membership"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/names"
 	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+	"github.com/fatcatfablab/fcfl-member-sync/sync"
 	uaTypes "github.com/fatcatfablab/fcfl-member-sync/types"
 )
 
 const (
 	customerUpdatedEvent        = "customer.updated"
 	customerCreatedEvent        = "customer.created"
+	customerDeletedEvent        = "customer.deleted"
 	customerSubscriptionCreated = "customer.subscription.created"
+	customerSubscriptionUpdated = "customer.subscription.updated"
 	customerSubscriptionDeleted = "customer.subscription.deleted"
 
 	maxBodyBytes          = int64(65536)
@@ -26,25 +37,61 @@ const (
 )
 
 type memberDb interface {
-	CreateMember(c types.Customer) error
-	ActivateMember(customerId string) error
-	UpdateMemberAccess(customerId string, accessId string) error
-	DeactivateMember(customerId string) error
-	FindMemberByCustomerId(customerId string) (*types.Member, error)
+	CreateMember(ctx context.Context, c types.Customer) error
+	ActivateMember(ctx context.Context, customerId string) error
+	UpdateMemberAccess(ctx context.Context, customerId string, accessId string) error
+	DeactivateMember(ctx context.Context, customerId string) error
+	FindMemberByCustomerId(ctx context.Context, customerId string) (*types.Member, error)
+	SaveSubscription(ctx context.Context, customerId string, s types.Subscription) error
+	ActiveSubscriptions(ctx context.Context, customerId string) ([]string, error)
+	ActivePolicies(ctx context.Context, customerId string) ([]string, error)
+	UnlinkMember(ctx context.Context, customerId string) error
+	MergeMember(ctx context.Context, from string, into string) (types.Orphans, error)
 }
 
 type uaUpdater interface {
-	AddMember(uaTypes.ComparableMember) (string, error)
-	DisableMember(string, uaTypes.ComparableMember) error
-	UpdateMember(string, uaTypes.ComparableMember) error
+	AddMember(context.Context, uaTypes.ComparableMember) (string, error)
+	DisableMember(context.Context, string, uaTypes.ComparableMember) error
+	UpdateMember(context.Context, string, uaTypes.ComparableMember) error
+	InviteMember(context.Context, string, uaTypes.ComparableMember, string) error
+	AssignPolicies(context.Context, string, uaTypes.ComparableMember, []string) error
+}
+
+type memberMailer interface {
+	Send(ctx context.Context, kind string, m types.Member) error
+}
+
+type eventStore interface {
+	SaveEvent(context.Context, types.WebhookEvent) error
+	FindEvent(ctx context.Context, id string) (*types.WebhookEvent, error)
+}
+
+// customerSource is where customers the database doesn't know about are
+// fetched from, e.g. because their customer.created event was missed.
+type customerSource interface {
+	GetCustomer(ctx context.Context, customerId string) (*types.Customer, error)
+}
+
+// households gives the dependents of family memberships their own door
+// user, following the member paying for it.
+type households interface {
+	SetFromMetadata(ctx context.Context, c types.Customer) error
+	Follow(ctx context.Context, m types.Member) error
+	Disable(ctx context.Context, deps []types.Dependent) error
 }
 
 type Listener struct {
-	secret     string
-	listenAddr string
-	endpoint   string
-	db         memberDb
-	ua         uaUpdater
+	secret      string
+	listenAddr  string
+	endpoint    string
+	db          memberDb
+	ua          uaUpdater
+	mailer      memberMailer
+	events      eventStore
+	customers   customerSource
+	memberships *membership.Filter
+	households  households
+	timeout     time.Duration
 }
 
 func New(secret, listenAddr, endpoint string, d memberDb, u uaUpdater) *Listener {
@@ -57,6 +104,42 @@ func New(secret, listenAddr, endpoint string, d memberDb, u uaUpdater) *Listener
 	}
 }
 
+// SetMailer makes the listener email members when their access changes
+func (l *Listener) SetMailer(m memberMailer) {
+	l.mailer = m
+}
+
+// SetEventStore makes the listener record the events it handles, so failed
+// ones can be requeued.
+func (l *Listener) SetEventStore(es eventStore) {
+	l.events = es
+}
+
+// SetCustomerSource makes the listener fetch the customers it gets
+// subscription events for but doesn't know about yet.
+func (l *Listener) SetCustomerSource(cs customerSource) {
+	l.customers = cs
+}
+
+// SetMemberships makes the listener ignore the subscriptions that aren't a
+// membership, and give members the access policies of theirs.
+func (l *Listener) SetMemberships(f *membership.Filter) {
+	l.memberships = f
+}
+
+// SetHouseholds makes the listener keep the dependents listed in the
+// metadata of customers, and give them access whenever their primary member
+// has it.
+func (l *Listener) SetHouseholds(h households) {
+	l.households = h
+}
+
+// SetTimeout limits how long handling a single event may take. Stripe gives
+// up waiting after a while anyway, and retries later.
+func (l *Listener) SetTimeout(d time.Duration) {
+	l.timeout = d
+}
+
 // Start does not return until the listener exits
 func (l *Listener) Start() error {
 	mux := http.NewServeMux()
@@ -67,7 +150,7 @@ func (l *Listener) Start() error {
 		Handler: mux,
 	}
 
-	log.Printf("Listening on %s", l.listenAddr)
+	slog.Info("Listening", "addr", l.listenAddr)
 	return s.ListenAndServe()
 }
 
@@ -75,78 +158,299 @@ func (l *Listener) webhookHandler(w http.ResponseWriter, req *http.Request) {
 	req.Body = http.MaxBytesReader(w, req.Body, maxBodyBytes)
 	payload, err := io.ReadAll(req.Body)
 	if err != nil {
-		log.Printf("Error copying request body: %v", err)
+		slog.Error("Error copying request body", logging.Err(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	signature := req.Header.Get(stripeSignatureHeader)
 	if err := verifySignature(payload, signature, l.secret); err != nil {
-		log.Printf("Error verifying signature: %v", err)
+		slog.Warn("Error verifying signature", logging.Err(err))
+		metrics.WebhookSignatureFailures.Inc()
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	var event types.Event
 	if err := json.Unmarshal(payload, &event); err != nil {
-		log.Printf("Error decoding event: %v", err)
+		slog.Error("Error decoding event", logging.Err(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	switch event.Type {
-	case customerCreatedEvent, customerUpdatedEvent:
-		err = l.handleCustomerEvent(event.Data.Raw, event.Type)
+	// The request context is cancelled if Stripe hangs up, which stops any
+	// UniFi Access or database call still in flight.
+	if err := l.handle(req.Context(), event, payload, time.Now().Unix()); err != nil {
+		// Stripe retrying won't make an email free. The event stays recorded as
+		// failed, to be requeued once the members are merged by hand.
+		var conflict *types.EmailConflictError
+		if errors.As(err, &conflict) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
 
-	case customerSubscriptionCreated:
-		err = l.handleSubscriptionCreated(event.Data.Raw)
+// Requeue handles a failed event again, as if Stripe had sent it once more
+func (l *Listener) Requeue(ctx context.Context, eventId string) error {
+	if l.events == nil {
+		return errors.New("events aren't being recorded")
+	}
 
-	case customerSubscriptionDeleted:
-		err = l.handleSubscriptionDeleted(event.Data.Raw)
+	e, err := l.events.FindEvent(ctx, eventId)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return fmt.Errorf("no event %q", eventId)
+	}
+	if e.Status != types.EventStatusFailed {
+		return fmt.Errorf("event %q didn't fail", eventId)
+	}
 
-	default:
-		log.Printf("Unhandled event type: %s", event.Type)
-		// log.Printf("Payload: %s", string(payload))
+	var event types.Event
+	if err := json.Unmarshal(e.Payload, &event); err != nil {
+		return fmt.Errorf("error decoding event %q: %w", eventId, err)
 	}
 
+	slog.Info("Requeueing event", logging.KeyEventId, event.Id, logging.KeyEventType, event.Type)
+	return l.handle(ctx, event, e.Payload, e.ReceivedAt)
+}
+
+func (l *Listener) handle(ctx context.Context, event types.Event, payload []byte, receivedAt int64) error {
+	ctx = audit.WithActor(ctx, audit.Event(event.Id))
+	logger := slog.With(logging.KeyEventId, event.Id, logging.KeyEventType, event.Type)
+	result := metrics.ResultSuccess
+	err := sync.Stage(ctx, "handling event", l.timeout, func(ctx context.Context) error {
+		switch event.Type {
+		case customerCreatedEvent, customerUpdatedEvent:
+			return l.handleCustomerEvent(ctx, logger, event.Data.Raw)
+
+		case customerDeletedEvent:
+			return l.handleCustomerDeleted(ctx, logger, event.Data.Raw)
+
+		case customerSubscriptionCreated:
+			return l.handleSubscriptionCreated(ctx, logger, event.Data.Raw)
+
+		case customerSubscriptionUpdated:
+			return l.handleSubscriptionUpdated(ctx, logger, event.Data.Raw)
+
+		case customerSubscriptionDeleted:
+			return l.handleSubscriptionDeleted(ctx, logger, event.Data.Raw)
+
+		default:
+			logger.Debug("Unhandled event type")
+			result = metrics.ResultIgnored
+			return nil
+		}
+	})
+
 	if err != nil {
-		log.Printf("error handling request: %s", err)
-		w.WriteHeader(http.StatusBadRequest)
+		result = metrics.Result(err)
+		logger.Error("Error handling event", logging.Err(err))
+	}
+	metrics.WebhookEvents.WithLabelValues(event.Type, result).Inc()
+
+	// Recorded even when Stripe hung up, so the event shows up as failed
+	l.record(context.WithoutCancel(ctx), logger, event, payload, receivedAt, result, err)
+	return err
+}
+
+// record saves the outcome of handling an event
+func (l *Listener) record(ctx context.Context, logger *slog.Logger, event types.Event, payload []byte, receivedAt int64, result string, err error) {
+	if l.events == nil || event.Id == "" {
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+
+	e := types.WebhookEvent{
+		Id:         event.Id,
+		Type:       event.Type,
+		Payload:    payload,
+		Status:     types.EventStatusHandled,
+		ReceivedAt: receivedAt,
+		HandledAt:  time.Now().Unix(),
+	}
+	switch result {
+	case metrics.ResultIgnored:
+		e.Status = types.EventStatusIgnored
+	case metrics.ResultFailure, metrics.ResultTimeout:
+		e.Status = types.EventStatusFailed
+		e.Error = err.Error()
+	}
+
+	if err := l.events.SaveEvent(ctx, e); err != nil {
+		logger.Error("Error recording event", logging.Err(err))
+	}
 }
 
-func (l *Listener) handleCustomerEvent(rawEvent json.RawMessage, eventType string) error {
+// Resync makes the UniFi Access user of a member match the database, without
+// emailing them. Members without a user get one if they're active.
+func (l *Listener) Resync(ctx context.Context, customerId string) error {
+	m, err := l.db.FindMemberByCustomerId(ctx, customerId)
+	if err != nil {
+		return fmt.Errorf("error querying member %q: %w", customerId, err)
+	}
+
+	logger := slog.With(logging.KeyCustomerId, customerId, logging.KeyMemberId, m.MemberId)
+	logger.Info("Resyncing member", "status", m.Status)
+
+	cm := memberToComparableMember(*m)
+	switch {
+	case m.Status == types.MemberStatusActive && m.AccessId == nil:
+		accessId, err := l.ua.AddMember(ctx, cm)
+		if err != nil {
+			return fmt.Errorf("failed to add member %q to UA: %w", customerId, err)
+		}
+		if accessId != "" {
+			err = l.db.UpdateMemberAccess(ctx, customerId, accessId)
+		}
+	case m.Status == types.MemberStatusActive:
+		err = l.ua.UpdateMember(ctx, *m.AccessId, cm)
+	case m.AccessId != nil:
+		err = l.ua.DisableMember(ctx, *m.AccessId, cm)
+	default:
+		logger.Info("Nothing to do")
+	}
+	if err != nil {
+		return err
+	}
+
+	return l.followHousehold(ctx, *m, m.Status)
+}
+
+func (l *Listener) handleCustomerEvent(ctx context.Context, logger *slog.Logger, rawEvent json.RawMessage) error {
 	var c types.Customer
 	if err := json.Unmarshal(rawEvent, &c); err != nil {
 		return fmt.Errorf("error unmarshalling json: %w", err)
 	}
 
 	if c.CustomerId == "" && c.Email == "" && c.Name == "" {
-		return fmt.Errorf("no relevant data in event: %s", string(rawEvent))
+		return errors.New("no relevant data in event")
 	}
 
-	log.Printf("%s event: %+v", eventType, c)
-	if err := l.db.CreateMember(c); err != nil {
-		return fmt.Errorf("error creating member: %w", err)
+	logger.Info(
+		"Customer event",
+		logging.KeyCustomerId, c.CustomerId,
+		logging.KeyName, c.Name,
+		logging.KeyEmail, c.Email,
+	)
+	if err := l.createMember(ctx, logger, c); err != nil {
+		return err
 	}
 
-	m, err := l.db.FindMemberByCustomerId(c.CustomerId)
+	m, err := l.db.FindMemberByCustomerId(ctx, c.CustomerId)
 	if err != nil {
 		return fmt.Errorf("couldn't find member after creating it: %w", err)
 	}
 
-	if m != nil && m.AccessId != nil {
-		if err := l.ua.UpdateMember(*m.AccessId, memberToComparableMember(*m)); err != nil {
+	// Updating sets the user active, which suspended members mustn't get back
+	if m != nil && m.AccessId != nil && m.Status == types.MemberStatusActive {
+		if err := l.ua.UpdateMember(ctx, *m.AccessId, memberToComparableMember(*m)); err != nil {
 			return fmt.Errorf("error updating UA member: %w", err)
 		}
 	}
 
+	if l.households == nil {
+		return nil
+	}
+	if err := l.households.SetFromMetadata(ctx, c); err != nil {
+		return fmt.Errorf("error updating dependents of %q: %w", c.CustomerId, err)
+	}
+	// The dependents of suspended members were disabled along with them
+	if m.Status != types.MemberStatusActive {
+		return nil
+	}
+	return l.followHousehold(ctx, *m, m.Status)
+}
+
+// handleCustomerDeleted takes access away from the member of a deleted
+// customer and forgets their UniFi Access user, since no event will ever
+// mention them again.
+func (l *Listener) handleCustomerDeleted(ctx context.Context, logger *slog.Logger, rawEvent json.RawMessage) error {
+	var c types.Customer
+	if err := json.Unmarshal(rawEvent, &c); err != nil {
+		return fmt.Errorf("error unmarshalling json: %w", err)
+	}
+
+	if c.CustomerId == "" {
+		return errors.New("no customer id in customer event")
+	}
+
+	logger = logger.With(logging.KeyCustomerId, c.CustomerId)
+	m, err := l.db.FindMemberByCustomerId(ctx, c.CustomerId)
+	if errors.Is(err, sql.ErrNoRows) {
+		logger.Info("Deleted customer wasn't a member")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error finding member %q: %w", c.CustomerId, err)
+	}
+
+	logger.Info("Customer deleted")
+	if err := l.deactivate(ctx, logger, c.CustomerId, m); err != nil {
+		return err
+	}
+
+	if err := l.db.UnlinkMember(ctx, c.CustomerId); err != nil {
+		return fmt.Errorf("error unlinking member %q: %w", c.CustomerId, err)
+	}
 	return nil
 }
 
-func (l *Listener) handleSubscriptionCreated(rawEvent json.RawMessage) error {
+// createMember saves c as a member. When their email belongs to a member who
+// isn't active, that's taken as the same person coming back with a new
+// customer, and the old member is merged into c. A conflict with an active
+// member is returned, to be sorted out by hand.
+func (l *Listener) createMember(ctx context.Context, logger *slog.Logger, c types.Customer) error {
+	err := l.db.CreateMember(ctx, c)
+	var conflict *types.EmailConflictError
+	if !errors.As(err, &conflict) {
+		if err != nil {
+			return fmt.Errorf("error creating member: %w", err)
+		}
+		return nil
+	}
+
+	existing, err := l.db.FindMemberByCustomerId(ctx, conflict.MemberCustomerId)
+	if err != nil {
+		return fmt.Errorf("error finding member %q: %w", conflict.MemberCustomerId, err)
+	}
+	if existing.Status == types.MemberStatusActive {
+		logger.Warn("Email belongs to an active member", "other_customer_id", conflict.MemberCustomerId)
+		return conflict
+	}
+
+	logger.Info("Merging member with the same email", "other_customer_id", conflict.MemberCustomerId)
+	orphans, err := l.db.MergeMember(ctx, conflict.MemberCustomerId, c.CustomerId)
+	if err != nil {
+		return fmt.Errorf("error merging member %q: %w", conflict.MemberCustomerId, err)
+	}
+	if err := l.db.CreateMember(ctx, c); err != nil {
+		return fmt.Errorf("error creating member: %w", err)
+	}
+	return l.disableOrphans(ctx, orphans)
+}
+
+// disableOrphans disables the UniFi Access users a merge left without a
+// member, since nothing would ever look at them again.
+func (l *Listener) disableOrphans(ctx context.Context, orphans types.Orphans) error {
+	var reterror error
+	if m := orphans.Member; m != nil {
+		if err := l.ua.DisableMember(ctx, *m.AccessId, memberToComparableMember(*m)); err != nil {
+			reterror = fmt.Errorf("error disabling user %q of merged member %q: %w", *m.AccessId, m.CustomerId, err)
+		}
+	}
+	if l.households != nil {
+		reterror = errors.Join(reterror, l.households.Disable(ctx, orphans.Dependents))
+	}
+
+	return reterror
+}
+
+func (l *Listener) handleSubscriptionCreated(ctx context.Context, logger *slog.Logger, rawEvent json.RawMessage) error {
 	var s types.Subscription
 	if err := json.Unmarshal(rawEvent, &s); err != nil {
 		return fmt.Errorf("error unmarshalling json: %w", err)
@@ -156,37 +460,257 @@ func (l *Listener) handleSubscriptionCreated(rawEvent json.RawMessage) error {
 		return errors.New("no customer id in subscription event")
 	}
 
-	log.Printf("%s event: %+v", customerSubscriptionCreated, s)
-	m, err := l.db.FindMemberByCustomerId(s.Customer)
+	logger = logger.With(logging.KeyCustomerId, s.Customer)
+	if !l.memberships.Allows(s) {
+		logger.Info("Ignoring subscription that isn't a membership", "subscription", s.Id)
+		return nil
+	}
+	logger.Info("Subscription created", "status", s.Status)
+	m, err := l.findMember(ctx, logger, s.Customer)
 	if err != nil {
-		// TODO: pull from stripe if it doesn't exist
-		return fmt.Errorf("error querying member %q: %w", s.Customer, err)
+		return err
+	}
+
+	if err := l.saveSubscription(ctx, s); err != nil {
+		return err
+	}
+	if !s.Active() {
+		// Its first payment is pending, subscription.updated tells when it's done
+		logger.Info("Subscription isn't active yet")
+		return nil
+	}
+
+	return l.activate(ctx, logger, s, m)
+}
+
+// findMember returns the member with the given customer id. Members the
+// database doesn't have are fetched from Stripe when there's a customer
+// source, so a missed customer.created event doesn't fail their subscription
+// events forever.
+func (l *Listener) findMember(ctx context.Context, logger *slog.Logger, customerId string) (*types.Member, error) {
+	m, err := l.db.FindMemberByCustomerId(ctx, customerId)
+	if err == nil {
+		return m, nil
+	}
+	if l.customers == nil || !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("error querying member %q: %w", customerId, err)
+	}
+
+	logger.Info("Unknown customer, fetching it from Stripe")
+	c, err := l.customers.GetCustomer(ctx, customerId)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching customer %q: %w", customerId, err)
 	}
+	if err := l.createMember(ctx, logger, *c); err != nil {
+		return nil, err
+	}
+
+	m, err = l.db.FindMemberByCustomerId(ctx, customerId)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't find member after creating it: %w", err)
+	}
+	return m, nil
+}
+
+// saveSubscription records the status of s, and the policies it gives while
+// active, so the access of the member can be told from all of their
+// subscriptions.
+func (l *Listener) saveSubscription(ctx context.Context, s types.Subscription) error {
+	if s.Active() {
+		var err error
+		if s.Policies, err = l.memberships.Policies(ctx, s); err != nil {
+			return fmt.Errorf("error getting access policies of %q: %w", s.Customer, err)
+		}
+	}
+	if err := l.db.SaveSubscription(ctx, s.Customer, s); err != nil {
+		return fmt.Errorf("error saving subscription %q: %w", s.Id, err)
+	}
+	return nil
+}
 
+// suspend records that s no longer gives access, and takes access away from
+// its member unless another subscription keeps it, emailing them the given
+// kind of email.
+func (l *Listener) suspend(ctx context.Context, logger *slog.Logger, s types.Subscription, kind string) error {
+	m, err := l.db.FindMemberByCustomerId(ctx, s.Customer)
+	if err != nil {
+		return fmt.Errorf("error querying member %q: %w", s.Customer, err)
+	}
+	if err := l.saveSubscription(ctx, s); err != nil {
+		return err
+	}
 	if m.Status != types.MemberStatusActive {
-		log.Printf("activating member %q", m.Name)
-		if err := l.db.ActivateMember(s.Customer); err != nil {
-			return fmt.Errorf("error activating member %q: %w", s.Customer, err)
+		return nil
+	}
+	if others, err := l.othersActive(ctx, logger, s); err != nil || others {
+		return l.keepOthers(ctx, m, err)
+	}
+
+	logger.Info("Suspending member", logging.KeyMemberId, m.MemberId)
+	if err := l.deactivate(ctx, logger, s.Customer, m); err != nil {
+		return err
+	}
+	l.sendEmail(ctx, logger, kind, *m)
+	return nil
+}
+
+// othersActive tells whether the member has active subscriptions other than
+// s, which keep them from losing access when s ends. Only the subscriptions
+// seen in events or a backfill are known, so databases from before they were
+// recorded need a backfill first.
+func (l *Listener) othersActive(ctx context.Context, logger *slog.Logger, s types.Subscription) (bool, error) {
+	ids, err := l.db.ActiveSubscriptions(ctx, s.Customer)
+	if err != nil {
+		return false, fmt.Errorf("error querying subscriptions of %q: %w", s.Customer, err)
+	}
+
+	ids = slices.DeleteFunc(ids, func(id string) bool { return id == s.Id })
+	if len(ids) > 0 {
+		logger.Info("Member still has other active subscriptions", "subscriptions", ids)
+	}
+	return len(ids) > 0, nil
+}
+
+// keepOthers leaves the member with the policies of their other active
+// subscriptions, once one of them ended, unless finding them out failed with
+// err.
+func (l *Listener) keepOthers(ctx context.Context, m *types.Member, err error) error {
+	if err != nil {
+		return err
+	}
+	return l.assignPolicies(ctx, m, deref(m.AccessId))
+}
+
+// activate gives door access to a member, creating their UniFi Access user
+// if they don't have one yet.
+func (l *Listener) activate(ctx context.Context, logger *slog.Logger, s types.Subscription, m *types.Member) error {
+	customerId := s.Customer
+	accessId := deref(m.AccessId)
+	wasActive := m.Status == types.MemberStatusActive
+	if !wasActive {
+		logger.Info("Activating member", logging.KeyName, m.Name)
+		if err := l.db.ActivateMember(ctx, customerId); err != nil {
+			return fmt.Errorf("error activating member %q: %w", customerId, err)
 		}
 	}
-	log.Printf("member id for %q: %d", m.Name, m.MemberId)
+	logger = logger.With(logging.KeyMemberId, m.MemberId)
 
 	if m.AccessId == nil {
-		accessId, err := l.ua.AddMember(memberToComparableMember(*m))
+		var err error
+		accessId, err = l.ua.AddMember(ctx, memberToComparableMember(*m))
 		if err != nil {
-			return fmt.Errorf("failed to add member %+v to UA: %w", m, err)
+			return fmt.Errorf("failed to add member %q to UA: %w", customerId, err)
 		}
-		log.Printf("access id for %q: %s", m.Name, accessId)
+		logger.Info("Member added to UniFi Access", logging.KeyAccessId, accessId)
 
 		if accessId != "" {
-			return l.db.UpdateMemberAccess(s.Customer, accessId)
+			if err := l.db.UpdateMemberAccess(ctx, customerId, accessId); err != nil {
+				return err
+			}
+
+			// The member already has door access by now, so failing to invite
+			// them must not make Stripe retry the whole event.
+			err := l.ua.InviteMember(ctx, accessId, memberToComparableMember(*m), m.Email)
+			if err != nil {
+				logger.Error("Error inviting member", logging.Err(err))
+			}
+
+			l.sendEmail(ctx, logger, types.EmailAccessGranted, *m)
 		}
+	} else if !wasActive {
+		// A returning member: their user got disabled when they left
+		if err := l.ua.UpdateMember(ctx, *m.AccessId, memberToComparableMember(*m)); err != nil {
+			return fmt.Errorf("error re-enabling member %q in UA: %w", customerId, err)
+		}
+		l.sendEmail(ctx, logger, types.EmailAccessGranted, *m)
+	}
+
+	if err := l.followHousehold(ctx, *m, types.MemberStatusActive); err != nil {
+		return err
 	}
 
+	// Assigned even to members who already were active, so Stripe retrying
+	// an event fixes a failed assignment.
+	return l.assignPolicies(ctx, m, accessId)
+}
+
+// assignPolicies gives the UniFi Access user of the member the policies of
+// all of their active subscriptions, when they have any. Those have to be
+// saved first.
+func (l *Listener) assignPolicies(ctx context.Context, m *types.Member, accessId string) error {
+	if accessId == "" {
+		return nil
+	}
+
+	policies, err := l.db.ActivePolicies(ctx, m.CustomerId)
+	if err != nil {
+		return fmt.Errorf("error getting access policies of %q: %w", m.CustomerId, err)
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+
+	if err := l.ua.AssignPolicies(ctx, accessId, memberToComparableMember(*m), policies); err != nil {
+		return fmt.Errorf("error assigning access policies to %q: %w", m.CustomerId, err)
+	}
 	return nil
 }
 
-func (l *Listener) handleSubscriptionDeleted(rawEvent json.RawMessage) error {
+// handleSubscriptionUpdated suspends the access of members whose payments
+// are failing, and restores it once they catch up.
+func (l *Listener) handleSubscriptionUpdated(ctx context.Context, logger *slog.Logger, rawEvent json.RawMessage) error {
+	var s types.Subscription
+	if err := json.Unmarshal(rawEvent, &s); err != nil {
+		return fmt.Errorf("error unmarshalling json: %w", err)
+	}
+
+	if s.Customer == "" {
+		return errors.New("no customer id in subscription event")
+	}
+
+	logger = logger.With(logging.KeyCustomerId, s.Customer)
+	if !l.memberships.Allows(s) {
+		logger.Info("Ignoring subscription that isn't a membership", "subscription", s.Id)
+		return nil
+	}
+	logger.Info("Subscription updated", "status", s.Status)
+
+	switch s.Status {
+	case types.SubscriptionPastDue, types.SubscriptionUnpaid, types.SubscriptionPaused:
+		return l.suspend(ctx, logger, s, types.EmailAccessSuspended)
+
+	case types.SubscriptionCanceled, types.SubscriptionIncompleteExpired:
+		return l.suspend(ctx, logger, s, types.EmailAccessRevoked)
+
+	case types.SubscriptionActive, types.SubscriptionTrialing:
+		m, err := l.findMember(ctx, logger, s.Customer)
+		if err != nil {
+			return err
+		}
+		if err := l.saveSubscription(ctx, s); err != nil {
+			return err
+		}
+		if m.Status == types.MemberStatusActive {
+			// They may have switched to a membership with other policies
+			return l.assignPolicies(ctx, m, deref(m.AccessId))
+		}
+
+		return l.activate(ctx, logger, s, m)
+
+	default:
+		// Recorded so it's known, e.g. while its first payment is pending
+		_, err := l.db.FindMemberByCustomerId(ctx, s.Customer)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error querying member %q: %w", s.Customer, err)
+		}
+		return l.saveSubscription(ctx, s)
+	}
+}
+
+func (l *Listener) handleSubscriptionDeleted(ctx context.Context, logger *slog.Logger, rawEvent json.RawMessage) error {
 	var s types.Subscription
 	if err := json.Unmarshal(rawEvent, &s); err != nil {
 		return fmt.Errorf("error unmarshalling json: %w", err)
@@ -196,33 +720,90 @@ func (l *Listener) handleSubscriptionDeleted(rawEvent json.RawMessage) error {
 		return errors.New("no customer found in event")
 	}
 
-	log.Printf("%s event: %+v", customerSubscriptionDeleted, s)
-	m, err := l.db.FindMemberByCustomerId(s.Customer)
+	logger = logger.With(logging.KeyCustomerId, s.Customer)
+	if !l.memberships.Allows(s) {
+		logger.Info("Ignoring subscription that isn't a membership", "subscription", s.Id)
+		return nil
+	}
+	logger.Info("Subscription deleted", "status", s.Status)
+	m, err := l.db.FindMemberByCustomerId(ctx, s.Customer)
 	if err != nil {
 		return fmt.Errorf("error finding membmer %q: %w", s.Customer, err)
 	}
 
+	if err := l.saveSubscription(ctx, s); err != nil {
+		return err
+	}
+	if others, err := l.othersActive(ctx, logger, s); err != nil || others {
+		return l.keepOthers(ctx, m, err)
+	}
+
+	if err := l.deactivate(ctx, logger, s.Customer, m); err != nil {
+		return err
+	}
+
+	l.sendEmail(ctx, logger, types.EmailAccessRevoked, *m)
+	return nil
+}
+
+func (l *Listener) deactivate(ctx context.Context, logger *slog.Logger, customerId string, m *types.Member) error {
 	if m.AccessId != nil {
-		err = l.ua.DisableMember(*m.AccessId, memberToComparableMember(*m))
+		err := l.ua.DisableMember(ctx, *m.AccessId, memberToComparableMember(*m))
 		if err != nil {
 			return fmt.Errorf(
 				"error disabing member %q in UA: %w",
-				s.Customer,
+				customerId,
 				err,
 			)
 		}
 	} else {
-		log.Printf("member didn't have an access_id: %s", s.Customer)
+		logger.Warn("Member didn't have an access_id")
 	}
 
-	return l.db.DeactivateMember(s.Customer)
+	if err := l.db.DeactivateMember(ctx, customerId); err != nil {
+		return err
+	}
+	return l.followHousehold(ctx, *m, types.MemberStatusNotActive)
+}
+
+// followHousehold gives the dependents of m access when status is active,
+// and takes it away otherwise.
+func (l *Listener) followHousehold(ctx context.Context, m types.Member, status string) error {
+	if l.households == nil {
+		return nil
+	}
+
+	m.Status = status
+	if err := l.households.Follow(ctx, m); err != nil {
+		return fmt.Errorf("error updating dependents of %q: %w", m.CustomerId, err)
+	}
+	return nil
+}
+
+// sendEmail tells the member about a change to their access. Failing to do
+// so is logged but doesn't fail the event, since the change already happened.
+func (l *Listener) sendEmail(ctx context.Context, logger *slog.Logger, kind string, m types.Member) {
+	if l.mailer == nil {
+		return
+	}
+
+	if err := l.mailer.Send(ctx, kind, m); err != nil {
+		logger.Error("Error emailing member", "kind", kind, logging.Err(err))
+	}
 }
 
 func memberToComparableMember(m types.Member) uaTypes.ComparableMember {
-	firstName, lastNAme, _ := strings.Cut(m.Name, " ")
+	firstName, lastName := names.ForDoor(m)
 	return uaTypes.ComparableMember{
 		Id:        int32(m.MemberId),
 		FirstName: firstName,
-		LastName:  lastNAme,
+		LastName:  lastName,
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
 	}
+	return *s
 }
@@ -1,20 +1,25 @@
 package listener
 
 import (
+	"context"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/membership"
 	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+	uaTypes "github.com/fatcatfablab/fcfl-member-sync/types"
 	"go.uber.org/mock/gomock"
 )
 
@@ -22,6 +27,49 @@ const (
 	secret = "somesecret"
 )
 
+// allowSubscriptions lets the handlers record subscriptions, and makes
+// members have no other active ones, unless the test expected otherwise.
+func allowSubscriptions(mdb *MockmemberDb) {
+	mdb.EXPECT().SaveSubscription(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	mdb.EXPECT().ActiveSubscriptions(gomock.Any(), gomock.Any()).AnyTimes()
+	mdb.EXPECT().ActivePolicies(gomock.Any(), gomock.Any()).AnyTimes()
+}
+
+// trackSubscriptions makes the database keep the subscriptions saved in subs,
+// keyed by id, and tell the active ones and their policies from them.
+func trackSubscriptions(mdb *MockmemberDb, subs map[string]types.Subscription) {
+	mdb.EXPECT().SaveSubscription(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ string, s types.Subscription) error {
+			subs[s.Id] = s
+			return nil
+		}).
+		AnyTimes()
+	mdb.EXPECT().ActiveSubscriptions(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ string) ([]string, error) {
+			var ids []string
+			for _, s := range subs {
+				if s.Active() {
+					ids = append(ids, s.Id)
+				}
+			}
+			slices.Sort(ids)
+			return ids, nil
+		}).
+		AnyTimes()
+	mdb.EXPECT().ActivePolicies(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ string) ([]string, error) {
+			var policies []string
+			for _, s := range subs {
+				if s.Active() {
+					policies = append(policies, s.Policies...)
+				}
+			}
+			slices.Sort(policies)
+			return slices.Compact(policies), nil
+		}).
+		AnyTimes()
+}
+
 func TestHandleCustomerEvent(t *testing.T) {
 	for _, tt := range []struct {
 		name       string
@@ -43,18 +91,18 @@ func TestHandleCustomerEvent(t *testing.T) {
 			name:  "Regular event",
 			input: []byte(`{"id":"abc","name":"name","email":"email"}`),
 			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
-				mdb.EXPECT().CreateMember(gomock.Eq(types.Customer{
+				mdb.EXPECT().CreateMember(gomock.Any(), gomock.Eq(types.Customer{
 					CustomerId: "abc",
 					Name:       "name",
 					Email:      "email",
 				})).Times(1)
 
 				mdb.EXPECT().
-					FindMemberByCustomerId(gomock.Eq("abc")).
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
 					Return(&types.Member{MemberId: 123, CustomerId: "abc"}, nil).
 					Times(1)
 
-				ua.EXPECT().UpdateMember(gomock.Any(), gomock.Any()).Times(0)
+				ua.EXPECT().UpdateMember(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 			},
 		},
 		{
@@ -62,18 +110,120 @@ func TestHandleCustomerEvent(t *testing.T) {
 			input: []byte(`{"id":"abc","name":"name","email":"email"}`),
 			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
 				accessId := "access-id"
-				mdb.EXPECT().CreateMember(gomock.Eq(types.Customer{
+				mdb.EXPECT().CreateMember(gomock.Any(), gomock.Eq(types.Customer{
 					CustomerId: "abc",
 					Name:       "name",
 					Email:      "email",
 				})).Times(1)
 
 				mdb.EXPECT().
-					FindMemberByCustomerId(gomock.Eq("abc")).
-					Return(&types.Member{MemberId: 123, CustomerId: "abc", AccessId: &accessId}, nil).
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Return(&types.Member{MemberId: 123, CustomerId: "abc", AccessId: &accessId, Status: types.MemberStatusActive}, nil).
+					Times(1)
+
+				ua.EXPECT().UpdateMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).Times(1)
+			},
+		},
+		{
+			name:  "Suspended member stays disabled",
+			input: []byte(`{"id":"abc","name":"new name","email":"email"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				accessId := "access-id"
+				mdb.EXPECT().CreateMember(gomock.Any(), gomock.Any()).Times(1)
+
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Return(&types.Member{MemberId: 123, CustomerId: "abc", AccessId: &accessId, Status: types.MemberStatusNotActive}, nil).
+					Times(1)
+
+				ua.EXPECT().UpdateMember(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			},
+		},
+		{
+			name:  "Names aren't split on the first space",
+			input: []byte(`{"id":"abc","name":"José de la Cruz","email":"email"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				accessId := "access-id"
+				mdb.EXPECT().CreateMember(gomock.Any(), gomock.Any()).Times(1)
+
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Return(&types.Member{MemberId: 123, CustomerId: "abc", AccessId: &accessId, Name: "José de la Cruz", Status: types.MemberStatusActive}, nil).
+					Times(1)
+
+				ua.EXPECT().UpdateMember(gomock.Any(), gomock.Eq(accessId), gomock.Eq(uaTypes.ComparableMember{
+					Id:        123,
+					FirstName: "José",
+					LastName:  "de la Cruz",
+				})).Times(1)
+			},
+		},
+		{
+			name:  "Returning member with a new customer gets merged",
+			input: []byte(`{"id":"abc","name":"name","email":"email"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				accessId := "access-id"
+				mdb.EXPECT().
+					CreateMember(gomock.Any(), gomock.Any()).
+					Return(&types.EmailConflictError{CustomerId: "abc", Email: "email", MemberCustomerId: "old"}).
+					Times(1)
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("old")).
+					Return(&types.Member{MemberId: 1, CustomerId: "old", Status: types.MemberStatusNotActive}, nil).
+					Times(1)
+				mdb.EXPECT().MergeMember(gomock.Any(), gomock.Eq("old"), gomock.Eq("abc")).Times(1)
+				mdb.EXPECT().CreateMember(gomock.Any(), gomock.Any()).Times(1)
+
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Return(&types.Member{MemberId: 1, CustomerId: "abc", AccessId: &accessId}, nil).
+					Times(1)
+
+				// Their subscription gives them access back
+				ua.EXPECT().UpdateMember(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			},
+		},
+		{
+			name:  "User of the merged member gets disabled when both had one",
+			input: []byte(`{"id":"abc","name":"name","email":"email"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				oldAccessId, accessId := "old-access-id", "access-id"
+				mdb.EXPECT().
+					CreateMember(gomock.Any(), gomock.Any()).
+					Return(&types.EmailConflictError{CustomerId: "abc", Email: "email", MemberCustomerId: "old"}).
+					Times(1)
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("old")).
+					Return(&types.Member{MemberId: 1, CustomerId: "old", Status: types.MemberStatusNotActive}, nil).
 					Times(1)
+				mdb.EXPECT().
+					MergeMember(gomock.Any(), gomock.Eq("old"), gomock.Eq("abc")).
+					Return(types.Orphans{Member: &types.Member{MemberId: 1, CustomerId: "old", AccessId: &oldAccessId}}, nil).
+					Times(1)
+				mdb.EXPECT().CreateMember(gomock.Any(), gomock.Any()).Times(1)
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Eq(oldAccessId), gomock.Any()).Times(1)
 
-				ua.EXPECT().UpdateMember(gomock.Eq(accessId), gomock.Any()).Times(1)
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Return(&types.Member{MemberId: 2, CustomerId: "abc", AccessId: &accessId, Status: types.MemberStatusActive}, nil).
+					Times(1)
+				ua.EXPECT().UpdateMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).Times(1)
+			},
+		},
+		{
+			name:       "Email of an active member isn't merged",
+			input:      []byte(`{"id":"abc","name":"name","email":"email"}`),
+			shouldFail: true,
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				mdb.EXPECT().
+					CreateMember(gomock.Any(), gomock.Any()).
+					Return(&types.EmailConflictError{CustomerId: "abc", Email: "email", MemberCustomerId: "old"}).
+					Times(1)
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("old")).
+					Return(&types.Member{MemberId: 1, CustomerId: "old", Status: types.MemberStatusActive}, nil).
+					Times(1)
+				mdb.EXPECT().MergeMember(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 			},
 		},
 	} {
@@ -84,10 +234,11 @@ func TestHandleCustomerEvent(t *testing.T) {
 
 			if tt.mockSetup != nil {
 				tt.mockSetup(mdb, ua)
+				allowSubscriptions(mdb)
 			}
 
 			l := New("", "", "", mdb, ua)
-			err := l.handleCustomerEvent(tt.input, customerCreatedEvent)
+			err := l.handleCustomerEvent(context.Background(), slog.Default(), tt.input)
 			failed := err != nil
 
 			if tt.shouldFail != failed {
@@ -124,16 +275,16 @@ func TestHandleSubscriptionCreated(t *testing.T) {
 			shouldFail: true,
 			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
 				mdb.EXPECT().
-					FindMemberByCustomerId(gomock.Eq("abc")).
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
 					Return(&types.Member{}, sql.ErrNoRows).
 					Times(1)
 
 				mdb.EXPECT().
-					ActivateMember(gomock.Eq("abc")).
+					ActivateMember(gomock.Any(), gomock.Eq("abc")).
 					Times(0)
 
 				ua.EXPECT().
-					AddMember(gomock.Any()).
+					AddMember(gomock.Any(), gomock.Any()).
 					Times(0)
 			},
 		},
@@ -143,17 +294,17 @@ func TestHandleSubscriptionCreated(t *testing.T) {
 			shouldFail: true,
 			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
 				mdb.EXPECT().
-					FindMemberByCustomerId(gomock.Eq("abc")).
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
 					Return(&types.Member{MemberId: 123, CustomerId: "abc"}, nil).
 					Times(1)
 
 				mdb.EXPECT().
-					ActivateMember(gomock.Eq("abc")).
+					ActivateMember(gomock.Any(), gomock.Eq("abc")).
 					Return(errors.New("")).
 					Times(1)
 
 				ua.EXPECT().
-					AddMember(gomock.Any()).
+					AddMember(gomock.Any(), gomock.Any()).
 					Times(0)
 			},
 		},
@@ -162,22 +313,95 @@ func TestHandleSubscriptionCreated(t *testing.T) {
 			input: []byte(`{"status":"active","customer":"abc"}`),
 			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
 				mdb.EXPECT().
-					FindMemberByCustomerId(gomock.Eq("abc")).
-					Return(&types.Member{MemberId: 123, CustomerId: "abc"}, nil).
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Return(&types.Member{MemberId: 123, CustomerId: "abc", Email: "member@example.com"}, nil).
+					Times(1)
+
+				mdb.EXPECT().
+					ActivateMember(gomock.Any(), gomock.Eq("abc")).
+					Times(1)
+
+				ua.EXPECT().
+					AddMember(gomock.Any(), gomock.Any()).
+					Return("access-id", nil).
+					Times(1)
+
+				mdb.EXPECT().
+					UpdateMemberAccess(gomock.Any(), gomock.Eq("abc"), gomock.Eq("access-id")).
 					Times(1)
 
+				ua.EXPECT().
+					InviteMember(gomock.Any(), gomock.Eq("access-id"), gomock.Any(), gomock.Eq("member@example.com")).
+					Times(1)
+			},
+		},
+		{
+			name:  "Failed invitation doesn't fail the event",
+			input: []byte(`{"status":"active","customer":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
 				mdb.EXPECT().
-					ActivateMember(gomock.Eq("abc")).
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Return(&types.Member{MemberId: 123, CustomerId: "abc", Email: "member@example.com"}, nil).
+					Times(1)
+
+				mdb.EXPECT().
+					ActivateMember(gomock.Any(), gomock.Eq("abc")).
 					Times(1)
 
 				ua.EXPECT().
-					AddMember(gomock.Any()).
+					AddMember(gomock.Any(), gomock.Any()).
 					Return("access-id", nil).
 					Times(1)
 
 				mdb.EXPECT().
-					UpdateMemberAccess(gomock.Eq("abc"), gomock.Eq("access-id")).
+					UpdateMemberAccess(gomock.Any(), gomock.Eq("abc"), gomock.Eq("access-id")).
+					Times(1)
+
+				ua.EXPECT().
+					InviteMember(gomock.Any(), gomock.Eq("access-id"), gomock.Any(), gomock.Eq("member@example.com")).
+					Return(errors.New("")).
+					Times(1)
+			},
+		},
+		{
+			name:  "Returning member",
+			input: []byte(`{"status":"active","customer":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				accessId := "abcdef"
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Return(&types.Member{MemberId: 123, CustomerId: "abc", Status: types.MemberStatusNotActive, AccessId: &accessId}, nil).
+					Times(1)
+
+				mdb.EXPECT().
+					ActivateMember(gomock.Any(), gomock.Eq("abc")).
+					Times(1)
+
+				ua.EXPECT().
+					UpdateMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).
+					Times(1)
+
+				ua.EXPECT().
+					AddMember(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:  "Incomplete subscription doesn't give access yet",
+			input: []byte(`{"status":"incomplete","customer":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Return(&types.Member{MemberId: 123, CustomerId: "abc"}, nil).
+					Times(1)
+
+				mdb.EXPECT().
+					SaveSubscription(gomock.Any(), gomock.Eq("abc"), gomock.Any()).
 					Times(1)
+
+				mdb.EXPECT().
+					ActivateMember(gomock.Any(), gomock.Any()).
+					Times(0)
 			},
 		},
 		{
@@ -186,16 +410,16 @@ func TestHandleSubscriptionCreated(t *testing.T) {
 			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
 				accessId := "abcdef"
 				mdb.EXPECT().
-					FindMemberByCustomerId(gomock.Eq("abc")).
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
 					Return(&types.Member{MemberId: 123, CustomerId: "abc", Status: types.MemberStatusActive, AccessId: &accessId}, nil).
 					Times(1)
 
 				mdb.EXPECT().
-					ActivateMember(gomock.Any()).
+					ActivateMember(gomock.Any(), gomock.Any()).
 					Times(0)
 
 				ua.EXPECT().
-					AddMember(gomock.Any()).
+					AddMember(gomock.Any(), gomock.Any()).
 					Times(0)
 			},
 		},
@@ -207,10 +431,417 @@ func TestHandleSubscriptionCreated(t *testing.T) {
 
 			if tt.mockSetup != nil {
 				tt.mockSetup(mdb, ua)
+				allowSubscriptions(mdb)
 			}
 
 			l := New("", "", "", mdb, ua)
-			err := l.handleSubscriptionCreated(tt.input)
+			err := l.handleSubscriptionCreated(context.Background(), slog.Default(), tt.input)
+			failed := err != nil
+
+			if tt.shouldFail != failed {
+				if tt.shouldFail {
+					t.Error("test should've failed")
+				} else {
+					t.Errorf("unexpected failure: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleSubscriptionCreatedUnknownCustomer(t *testing.T) {
+	input := []byte(`{"status":"active","customer":"abc"}`)
+	customer := types.Customer{CustomerId: "abc", Name: "Mary Smith", Email: "mary@example.com"}
+
+	for _, tt := range []struct {
+		name       string
+		shouldFail bool
+		mockSetup  func(mdb *MockmemberDb, ua *MockuaUpdater, cs *MockcustomerSource)
+	}{
+		{
+			name: "Fetched from Stripe",
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, cs *MockcustomerSource) {
+				gomock.InOrder(
+					mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(nil, sql.ErrNoRows),
+					cs.EXPECT().GetCustomer(gomock.Any(), gomock.Eq("abc")).Return(&customer, nil),
+					mdb.EXPECT().CreateMember(gomock.Any(), gomock.Eq(customer)),
+					mdb.EXPECT().
+						FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+						Return(&types.Member{MemberId: 123, CustomerId: "abc", Name: customer.Name, Email: customer.Email}, nil),
+					mdb.EXPECT().ActivateMember(gomock.Any(), gomock.Eq("abc")),
+				)
+				ua.EXPECT().AddMember(gomock.Any(), gomock.Any()).Return("access-id", nil).Times(1)
+				mdb.EXPECT().UpdateMemberAccess(gomock.Any(), gomock.Eq("abc"), gomock.Eq("access-id")).Times(1)
+				ua.EXPECT().InviteMember(gomock.Any(), gomock.Eq("access-id"), gomock.Any(), gomock.Eq(customer.Email)).Times(1)
+			},
+		},
+		{
+			name:       "Not in Stripe either",
+			shouldFail: true,
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, cs *MockcustomerSource) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(nil, sql.ErrNoRows).Times(1)
+				cs.EXPECT().GetCustomer(gomock.Any(), gomock.Eq("abc")).Return(nil, errors.New("not found")).Times(1)
+				mdb.EXPECT().CreateMember(gomock.Any(), gomock.Any()).Times(0)
+				ua.EXPECT().AddMember(gomock.Any(), gomock.Any()).Times(0)
+			},
+		},
+		{
+			name:       "Database errors aren't fetched",
+			shouldFail: true,
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, cs *MockcustomerSource) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(nil, errors.New("connection refused")).Times(1)
+				cs.EXPECT().GetCustomer(gomock.Any(), gomock.Any()).Times(0)
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mdb := NewMockmemberDb(ctrl)
+			ua := NewMockuaUpdater(ctrl)
+			cs := NewMockcustomerSource(ctrl)
+			tt.mockSetup(mdb, ua, cs)
+			allowSubscriptions(mdb)
+
+			l := New("", "", "", mdb, ua)
+			l.SetCustomerSource(cs)
+			err := l.handleSubscriptionCreated(context.Background(), slog.Default(), input)
+			if tt.shouldFail != (err != nil) {
+				t.Errorf("want failure %v, got %v", tt.shouldFail, err)
+			}
+		})
+	}
+}
+
+type fakeProducts map[string]types.Product
+
+func (f fakeProducts) GetProduct(_ context.Context, productId string) (*types.Product, error) {
+	p := f[productId]
+	return &p, nil
+}
+
+func TestMemberships(t *testing.T) {
+	accessId := "zxcv"
+	active := types.Member{MemberId: 123, CustomerId: "abc", Status: types.MemberStatusActive, AccessId: &accessId}
+	membershipSub := `{"id":"sub_1","status":"active","customer":"abc","items":{"data":[{"price":{"id":"price_1","product":"prod_membership"}}]}}`
+	donationSub := `{"id":"sub_2","status":"active","customer":"abc","items":{"data":[{"price":{"id":"price_2","product":"prod_donation"}}]}}`
+	shopSub := types.Subscription{Id: "sub_3", Status: types.SubscriptionActive, Customer: "abc", Policies: []string{"policy_shop"}}
+
+	for _, tt := range []struct {
+		name      string
+		event     string
+		input     string
+		saved     []types.Subscription
+		mockSetup func(mdb *MockmemberDb, ua *MockuaUpdater)
+	}{
+		{
+			name:  "Donations don't give access",
+			event: customerSubscriptionCreated,
+			input: donationSub,
+		},
+		{
+			name:  "Cancelled donations don't take access away",
+			event: customerSubscriptionDeleted,
+			input: donationSub,
+		},
+		{
+			name:  "New members get the policies of their membership",
+			event: customerSubscriptionCreated,
+			input: membershipSub,
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&types.Member{MemberId: 123, CustomerId: "abc"}, nil)
+				mdb.EXPECT().ActivateMember(gomock.Any(), gomock.Eq("abc"))
+				ua.EXPECT().AddMember(gomock.Any(), gomock.Any()).Return("access-id", nil)
+				mdb.EXPECT().UpdateMemberAccess(gomock.Any(), gomock.Eq("abc"), gomock.Eq("access-id"))
+				ua.EXPECT().InviteMember(gomock.Any(), gomock.Eq("access-id"), gomock.Any(), gomock.Any())
+				ua.EXPECT().AssignPolicies(gomock.Any(), gomock.Eq("access-id"), gomock.Any(), gomock.Eq([]string{"policy_doors"}))
+			},
+		},
+		{
+			name:  "Active members get the policies of their new membership",
+			event: customerSubscriptionUpdated,
+			input: membershipSub,
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&active, nil)
+				ua.EXPECT().AssignPolicies(gomock.Any(), gomock.Eq(accessId), gomock.Any(), gomock.Eq([]string{"policy_doors"}))
+			},
+		},
+		{
+			name:  "Members with two memberships get the policies of both",
+			event: customerSubscriptionUpdated,
+			input: membershipSub,
+			saved: []types.Subscription{shopSub},
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&active, nil)
+				ua.EXPECT().AssignPolicies(gomock.Any(), gomock.Eq(accessId), gomock.Any(), gomock.Eq([]string{"policy_doors", "policy_shop"}))
+			},
+		},
+		{
+			name:  "A new price on one membership keeps the policies of the other",
+			event: customerSubscriptionUpdated,
+			input: `{"id":"sub_1","status":"active","customer":"abc","items":{"data":[{"price":{"id":"price_weekends","product":"prod_membership","metadata":{"unifi_access_policies":"policy_weekends"}}}]}}`,
+			saved: []types.Subscription{
+				{Id: "sub_1", Status: types.SubscriptionActive, Customer: "abc", Policies: []string{"policy_doors"}},
+				shopSub,
+			},
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&active, nil)
+				ua.EXPECT().AssignPolicies(gomock.Any(), gomock.Eq(accessId), gomock.Any(), gomock.Eq([]string{"policy_shop", "policy_weekends"}))
+			},
+		},
+		{
+			name:  "Cancelling one of two memberships keeps the policies of the other",
+			event: customerSubscriptionDeleted,
+			input: `{"id":"sub_1","status":"canceled","customer":"abc","items":{"data":[{"price":{"id":"price_1","product":"prod_membership"}}]}}`,
+			saved: []types.Subscription{
+				{Id: "sub_1", Status: types.SubscriptionActive, Customer: "abc", Policies: []string{"policy_doors"}},
+				shopSub,
+			},
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&active, nil)
+				ua.EXPECT().AssignPolicies(gomock.Any(), gomock.Eq(accessId), gomock.Any(), gomock.Eq([]string{"policy_shop"}))
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+				mdb.EXPECT().DeactivateMember(gomock.Any(), gomock.Any()).Times(0)
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mdb := NewMockmemberDb(ctrl)
+			ua := NewMockuaUpdater(ctrl)
+			if tt.mockSetup != nil {
+				tt.mockSetup(mdb, ua)
+				subs := make(map[string]types.Subscription)
+				for _, s := range tt.saved {
+					subs[s.Id] = s
+				}
+				trackSubscriptions(mdb, subs)
+			}
+
+			f := membership.NewFilter([]string{"prod_membership"})
+			f.SetProductSource(fakeProducts{
+				"prod_membership": {Id: "prod_membership", Metadata: map[string]string{membership.PoliciesMetadataKey: "policy_doors"}},
+			})
+			l := New("", "", "", mdb, ua)
+			l.SetMemberships(f)
+
+			event := types.Event{Id: "evt_1", Type: tt.event, Data: types.EventData{Raw: json.RawMessage(tt.input)}}
+			if err := l.handle(context.Background(), event, nil, 0); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestHouseholds(t *testing.T) {
+	accessId := "zxcv"
+	member := types.Member{MemberId: 123, CustomerId: "abc", AccessId: &accessId}
+	active, inactive := member, member
+	active.Status = types.MemberStatusActive
+	inactive.Status = types.MemberStatusNotActive
+
+	for _, tt := range []struct {
+		name      string
+		event     string
+		input     string
+		mockSetup func(mdb *MockmemberDb, ua *MockuaUpdater, h *Mockhouseholds)
+	}{
+		{
+			name:  "Dependents come from the customer metadata",
+			event: customerUpdatedEvent,
+			input: `{"id":"abc","name":"name","email":"email","metadata":{"dependents":"Kid"}}`,
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, h *Mockhouseholds) {
+				mdb.EXPECT().CreateMember(gomock.Any(), gomock.Any())
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&active, nil)
+				ua.EXPECT().UpdateMember(gomock.Any(), gomock.Eq(accessId), gomock.Any())
+				h.EXPECT().SetFromMetadata(gomock.Any(), gomock.Eq(types.Customer{
+					CustomerId: "abc",
+					Name:       "name",
+					Email:      "email",
+					Metadata:   map[string]string{"dependents": "Kid"},
+				}))
+				h.EXPECT().Follow(gomock.Any(), gomock.Eq(active))
+			},
+		},
+		{
+			name:  "Dependents of suspended members are left disabled",
+			event: customerUpdatedEvent,
+			input: `{"id":"abc","name":"name","email":"email","metadata":{"dependents":"Kid"}}`,
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, h *Mockhouseholds) {
+				mdb.EXPECT().CreateMember(gomock.Any(), gomock.Any())
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&inactive, nil)
+				h.EXPECT().SetFromMetadata(gomock.Any(), gomock.Any())
+			},
+		},
+		{
+			name:  "Dependents get access with their primary member",
+			event: customerSubscriptionUpdated,
+			input: `{"id":"sub_1","status":"active","customer":"abc"}`,
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, h *Mockhouseholds) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&member, nil)
+				mdb.EXPECT().ActivateMember(gomock.Any(), gomock.Eq("abc"))
+				ua.EXPECT().UpdateMember(gomock.Any(), gomock.Eq(accessId), gomock.Any())
+				h.EXPECT().Follow(gomock.Any(), gomock.Eq(active))
+			},
+		},
+		{
+			name:  "Dependents lose access with their primary member",
+			event: customerSubscriptionDeleted,
+			input: `{"id":"sub_1","status":"canceled","customer":"abc"}`,
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, h *Mockhouseholds) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&active, nil)
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Eq(accessId), gomock.Any())
+				mdb.EXPECT().DeactivateMember(gomock.Any(), gomock.Eq("abc"))
+				h.EXPECT().Follow(gomock.Any(), gomock.Eq(inactive))
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mdb := NewMockmemberDb(ctrl)
+			ua := NewMockuaUpdater(ctrl)
+			h := NewMockhouseholds(ctrl)
+			tt.mockSetup(mdb, ua, h)
+			allowSubscriptions(mdb)
+
+			l := New("", "", "", mdb, ua)
+			l.SetHouseholds(h)
+
+			event := types.Event{Id: "evt_1", Type: tt.event, Data: types.EventData{Raw: json.RawMessage(tt.input)}}
+			if err := l.handle(context.Background(), event, nil, 0); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestHandleSubscriptionUpdated(t *testing.T) {
+	accessId := "zxcv"
+	active := types.Member{MemberId: 123, CustomerId: "abc", Status: types.MemberStatusActive, AccessId: &accessId}
+	suspended := types.Member{MemberId: 123, CustomerId: "abc", Status: types.MemberStatusNotActive, AccessId: &accessId}
+
+	for _, tt := range []struct {
+		name       string
+		input      json.RawMessage
+		shouldFail bool
+		mockSetup  func(*MockmemberDb, *MockuaUpdater, *MockmemberMailer)
+	}{
+		{
+			name:       "Empty json object",
+			input:      []byte("{}"),
+			shouldFail: true,
+		},
+		{
+			name:  "Past due member gets suspended",
+			input: []byte(`{"status":"past_due","customer":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, mm *MockmemberMailer) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&active, nil).Times(1)
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).Times(1)
+				mdb.EXPECT().DeactivateMember(gomock.Any(), gomock.Eq("abc")).Times(1)
+				mm.EXPECT().Send(gomock.Any(), gomock.Eq(types.EmailAccessSuspended), gomock.Any()).Times(1)
+			},
+		},
+		{
+			name:  "Already suspended member",
+			input: []byte(`{"status":"unpaid","customer":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, mm *MockmemberMailer) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&suspended, nil).Times(1)
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+				mm.EXPECT().Send(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			},
+		},
+		{
+			name:       "Failed suspension doesn't email",
+			input:      []byte(`{"status":"past_due","customer":"abc"}`),
+			shouldFail: true,
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, mm *MockmemberMailer) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&active, nil).Times(1)
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).Return(errors.New("")).Times(1)
+				mm.EXPECT().Send(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			},
+		},
+		{
+			name:  "Paid member gets access back",
+			input: []byte(`{"status":"active","customer":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, mm *MockmemberMailer) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&suspended, nil).Times(1)
+				mdb.EXPECT().ActivateMember(gomock.Any(), gomock.Eq("abc")).Times(1)
+				ua.EXPECT().UpdateMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).Times(1)
+				mm.EXPECT().Send(gomock.Any(), gomock.Eq(types.EmailAccessGranted), gomock.Any()).Times(1)
+			},
+		},
+		{
+			name:  "Active member stays as is",
+			input: []byte(`{"status":"active","customer":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, mm *MockmemberMailer) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&active, nil).Times(1)
+				mdb.EXPECT().ActivateMember(gomock.Any(), gomock.Any()).Times(0)
+				mm.EXPECT().Send(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			},
+		},
+		{
+			name:  "Failed email doesn't fail the event",
+			input: []byte(`{"status":"past_due","customer":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, mm *MockmemberMailer) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&active, nil).Times(1)
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).Times(1)
+				mdb.EXPECT().DeactivateMember(gomock.Any(), gomock.Eq("abc")).Times(1)
+				mm.EXPECT().Send(gomock.Any(), gomock.Eq(types.EmailAccessSuspended), gomock.Any()).Return(errors.New("")).Times(1)
+			},
+		},
+		{
+			name:  "Paused member gets suspended",
+			input: []byte(`{"status":"paused","customer":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, mm *MockmemberMailer) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&active, nil).Times(1)
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).Times(1)
+				mdb.EXPECT().DeactivateMember(gomock.Any(), gomock.Eq("abc")).Times(1)
+				mm.EXPECT().Send(gomock.Any(), gomock.Eq(types.EmailAccessSuspended), gomock.Any()).Times(1)
+			},
+		},
+		{
+			name:  "Canceled member gets access revoked",
+			input: []byte(`{"status":"canceled","customer":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, mm *MockmemberMailer) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&active, nil).Times(1)
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).Times(1)
+				mdb.EXPECT().DeactivateMember(gomock.Any(), gomock.Eq("abc")).Times(1)
+				mm.EXPECT().Send(gomock.Any(), gomock.Eq(types.EmailAccessRevoked), gomock.Any()).Times(1)
+			},
+		},
+		{
+			name:  "Other statuses are only recorded",
+			input: []byte(`{"status":"incomplete","customer":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, mm *MockmemberMailer) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&active, nil).Times(1)
+				mdb.EXPECT().SaveSubscription(gomock.Any(), gomock.Eq("abc"), gomock.Any()).Times(1)
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			},
+		},
+		{
+			name:  "Past due member with another active subscription",
+			input: []byte(`{"id":"sub_1","status":"past_due","customer":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, mm *MockmemberMailer) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&active, nil).Times(1)
+				mdb.EXPECT().ActiveSubscriptions(gomock.Any(), gomock.Eq("abc")).Return([]string{"sub_2"}, nil).Times(1)
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+				mm.EXPECT().Send(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mdb := NewMockmemberDb(ctrl)
+			ua := NewMockuaUpdater(ctrl)
+			mm := NewMockmemberMailer(ctrl)
+
+			if tt.mockSetup != nil {
+				tt.mockSetup(mdb, ua, mm)
+				allowSubscriptions(mdb)
+			}
+
+			l := New("", "", "", mdb, ua)
+			l.SetMailer(mm)
+			err := l.handleSubscriptionUpdated(context.Background(), slog.Default(), tt.input)
 			failed := err != nil
 
 			if tt.shouldFail != failed {
@@ -247,16 +878,16 @@ func TestHandleSubscriptionDeleted(t *testing.T) {
 			shouldFail: true,
 			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
 				mdb.EXPECT().
-					FindMemberByCustomerId(gomock.Eq("abc")).
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
 					Return(&types.Member{MemberId: 123, CustomerId: "abc"}, nil).
 					Times(1)
 
 				ua.EXPECT().
-					DisableMember(gomock.Any(), gomock.Any()).
+					DisableMember(gomock.Any(), gomock.Any(), gomock.Any()).
 					Times(0)
 
 				mdb.EXPECT().
-					DeactivateMember(gomock.Eq("abc")).
+					DeactivateMember(gomock.Any(), gomock.Eq("abc")).
 					Return(errors.New("")).
 					Times(1)
 			},
@@ -267,15 +898,38 @@ func TestHandleSubscriptionDeleted(t *testing.T) {
 			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
 				accessId := "zxcv"
 				mdb.EXPECT().
-					FindMemberByCustomerId(gomock.Eq("abc")).
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
 					Return(&types.Member{MemberId: 123, CustomerId: "abc", AccessId: &accessId}, nil).
 					Times(1)
 
 				ua.EXPECT().
-					DisableMember(gomock.Eq(accessId), gomock.Any()).
+					DisableMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).
 					Times(1)
 
-				mdb.EXPECT().DeactivateMember(gomock.Eq("abc")).Times(1)
+				mdb.EXPECT().DeactivateMember(gomock.Any(), gomock.Eq("abc")).Times(1)
+			},
+		},
+		{
+			name:  "Another subscription keeps the member active",
+			input: []byte(`{"id":"sub_1","status":"canceled","customer":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				accessId := "zxcv"
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Return(&types.Member{MemberId: 123, CustomerId: "abc", AccessId: &accessId}, nil).
+					Times(1)
+
+				mdb.EXPECT().
+					SaveSubscription(gomock.Any(), gomock.Eq("abc"), gomock.Cond(func(s types.Subscription) bool { return s.Id == "sub_1" })).
+					Times(1)
+
+				mdb.EXPECT().
+					ActiveSubscriptions(gomock.Any(), gomock.Eq("abc")).
+					Return([]string{"sub_2"}, nil).
+					Times(1)
+
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+				mdb.EXPECT().DeactivateMember(gomock.Any(), gomock.Any()).Times(0)
 			},
 		},
 	} {
@@ -286,10 +940,11 @@ func TestHandleSubscriptionDeleted(t *testing.T) {
 
 			if tt.mockSetup != nil {
 				tt.mockSetup(mdb, ua)
+				allowSubscriptions(mdb)
 			}
 
 			l := New("", "", "", mdb, ua)
-			err := l.handleSubscriptionDeleted(tt.input)
+			err := l.handleSubscriptionDeleted(context.Background(), slog.Default(), tt.input)
 			failed := err != nil
 
 			if tt.shouldFail != failed {
@@ -303,6 +958,169 @@ func TestHandleSubscriptionDeleted(t *testing.T) {
 	}
 }
 
+func TestRequeue(t *testing.T) {
+	payload := []byte(`{"id":"evt_1","type":"customer.subscription.deleted","data":{"object":{"status":"canceled","customer":"abc"}}}`)
+	accessId := "zxcv"
+
+	for _, tt := range []struct {
+		name       string
+		event      *types.WebhookEvent
+		shouldFail bool
+		mockSetup  func(*MockmemberDb, *MockuaUpdater, *MockeventStore)
+	}{
+		{
+			name:       "Unknown event",
+			shouldFail: true,
+		},
+		{
+			name:       "Handled event isn't requeued",
+			event:      &types.WebhookEvent{Id: "evt_1", Payload: payload, Status: types.EventStatusHandled},
+			shouldFail: true,
+		},
+		{
+			name:  "Failed event gets handled again",
+			event: &types.WebhookEvent{Id: "evt_1", Payload: payload, Status: types.EventStatusFailed, ReceivedAt: 100},
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, es *MockeventStore) {
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Return(&types.Member{MemberId: 123, CustomerId: "abc", AccessId: &accessId}, nil).
+					Times(1)
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).Times(1)
+				mdb.EXPECT().DeactivateMember(gomock.Any(), gomock.Eq("abc")).Times(1)
+				es.EXPECT().SaveEvent(gomock.Any(), gomock.Cond(func(e types.WebhookEvent) bool {
+					return e.Id == "evt_1" && e.Status == types.EventStatusHandled && e.ReceivedAt == 100
+				})).Times(1)
+			},
+		},
+		{
+			name:       "Failing again is recorded",
+			event:      &types.WebhookEvent{Id: "evt_1", Payload: payload, Status: types.EventStatusFailed},
+			shouldFail: true,
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater, es *MockeventStore) {
+				mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(nil, sql.ErrNoRows).Times(1)
+				es.EXPECT().SaveEvent(gomock.Any(), gomock.Cond(func(e types.WebhookEvent) bool {
+					return e.Status == types.EventStatusFailed && e.Error != ""
+				})).Times(1)
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mdb := NewMockmemberDb(ctrl)
+			ua := NewMockuaUpdater(ctrl)
+			es := NewMockeventStore(ctrl)
+
+			es.EXPECT().FindEvent(gomock.Any(), gomock.Eq("evt_1")).Return(tt.event, nil).Times(1)
+			if tt.mockSetup != nil {
+				tt.mockSetup(mdb, ua, es)
+				allowSubscriptions(mdb)
+			}
+
+			l := New("", "", "", mdb, ua)
+			l.SetEventStore(es)
+			err := l.Requeue(context.Background(), "evt_1")
+			if tt.shouldFail != (err != nil) {
+				t.Errorf("want failure %v, got %v", tt.shouldFail, err)
+			}
+		})
+	}
+}
+
+func TestHandleTimeout(t *testing.T) {
+	payload := []byte(`{"id":"evt_1","type":"customer.subscription.deleted","data":{"object":{"status":"canceled","customer":"abc"}}}`)
+	var event types.Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		t.Fatal(err)
+	}
+
+	ctrl := gomock.NewController(t)
+	mdb := NewMockmemberDb(ctrl)
+	ua := NewMockuaUpdater(ctrl)
+	es := NewMockeventStore(ctrl)
+
+	accessId := "zxcv"
+	mdb.EXPECT().
+		FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+		Return(&types.Member{MemberId: 123, CustomerId: "abc", AccessId: &accessId}, nil).
+		Times(1)
+	// A UniFi Access that never answers
+	ua.EXPECT().
+		DisableMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, _ string, _ any) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}).
+		Times(1)
+	es.EXPECT().SaveEvent(gomock.Any(), gomock.Cond(func(e types.WebhookEvent) bool {
+		return e.Status == types.EventStatusFailed && strings.Contains(e.Error, "timed out")
+	})).Times(1)
+
+	allowSubscriptions(mdb)
+
+	l := New("", "", "", mdb, ua)
+	l.SetEventStore(es)
+	l.SetTimeout(10 * time.Millisecond)
+
+	err := l.handle(context.Background(), event, payload, 100)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want a timeout, got %v", err)
+	}
+}
+
+func TestResync(t *testing.T) {
+	accessId := "zxcv"
+
+	for _, tt := range []struct {
+		name      string
+		member    types.Member
+		mockSetup func(*MockmemberDb, *MockuaUpdater)
+	}{
+		{
+			name:   "Active member without user gets added",
+			member: types.Member{MemberId: 123, CustomerId: "abc", Status: types.MemberStatusActive},
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				ua.EXPECT().AddMember(gomock.Any(), gomock.Any()).Return(accessId, nil).Times(1)
+				mdb.EXPECT().UpdateMemberAccess(gomock.Any(), gomock.Eq("abc"), gomock.Eq(accessId)).Times(1)
+			},
+		},
+		{
+			name:   "Active member gets updated",
+			member: types.Member{MemberId: 123, CustomerId: "abc", Status: types.MemberStatusActive, AccessId: &accessId},
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				ua.EXPECT().UpdateMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).Times(1)
+			},
+		},
+		{
+			name:   "Inactive member gets disabled",
+			member: types.Member{MemberId: 123, CustomerId: "abc", Status: types.MemberStatusNotActive, AccessId: &accessId},
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).Times(1)
+			},
+		},
+		{
+			name:   "Inactive member without user",
+			member: types.Member{MemberId: 123, CustomerId: "abc", Status: types.MemberStatusNotActive},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mdb := NewMockmemberDb(ctrl)
+			ua := NewMockuaUpdater(ctrl)
+
+			mdb.EXPECT().FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).Return(&tt.member, nil).Times(1)
+			if tt.mockSetup != nil {
+				tt.mockSetup(mdb, ua)
+				allowSubscriptions(mdb)
+			}
+
+			l := New("", "", "", mdb, ua)
+			if err := l.Resync(context.Background(), "abc"); err != nil {
+				t.Errorf("unexpected failure: %s", err)
+			}
+		})
+	}
+}
+
 func buildStripeRequest(t *testing.T, payload string) *http.Request {
 	ts := fmt.Sprintf("%d", time.Now().Unix())
 	signature, err := sign([]byte(payload), ts, secret)
@@ -330,6 +1148,84 @@ func buildInvalidSignatureRequest(t *testing.T) *http.Request {
 	return r
 }
 
+func TestHandleCustomerDeleted(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		input      json.RawMessage
+		shouldFail bool
+		mockSetup  func(mdb *MockmemberDb, ua *MockuaUpdater)
+	}{
+		{
+			name:       "Empty json object",
+			input:      []byte("{}"),
+			shouldFail: true,
+		},
+		{
+			name:  "Customer that wasn't a member",
+			input: []byte(`{"id":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Return(nil, sql.ErrNoRows).
+					Times(1)
+				mdb.EXPECT().UnlinkMember(gomock.Any(), gomock.Any()).Times(0)
+			},
+		},
+		{
+			name:  "Member gets deactivated and unlinked",
+			input: []byte(`{"id":"abc"}`),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				accessId := "zxcv"
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Return(&types.Member{MemberId: 123, CustomerId: "abc", AccessId: &accessId}, nil).
+					Times(1)
+
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).Times(1)
+				mdb.EXPECT().DeactivateMember(gomock.Any(), gomock.Eq("abc")).Times(1)
+				mdb.EXPECT().UnlinkMember(gomock.Any(), gomock.Eq("abc")).Times(1)
+			},
+		},
+		{
+			name:       "Failed deactivation keeps the link",
+			input:      []byte(`{"id":"abc"}`),
+			shouldFail: true,
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				accessId := "zxcv"
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Return(&types.Member{MemberId: 123, CustomerId: "abc", AccessId: &accessId}, nil).
+					Times(1)
+
+				ua.EXPECT().DisableMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).Return(errors.New("")).Times(1)
+				mdb.EXPECT().UnlinkMember(gomock.Any(), gomock.Any()).Times(0)
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mdb := NewMockmemberDb(ctrl)
+			ua := NewMockuaUpdater(ctrl)
+
+			if tt.mockSetup != nil {
+				tt.mockSetup(mdb, ua)
+			}
+
+			l := New("", "", "", mdb, ua)
+			err := l.handleCustomerDeleted(context.Background(), slog.Default(), tt.input)
+			failed := err != nil
+
+			if tt.shouldFail != failed {
+				if tt.shouldFail {
+					t.Error("test should've failed")
+				} else {
+					t.Errorf("unexpected failure: %s", err)
+				}
+			}
+		})
+	}
+}
+
 func TestWebhookHandler(t *testing.T) {
 	for _, tt := range []struct {
 		name           string
@@ -364,7 +1260,7 @@ func TestWebhookHandler(t *testing.T) {
 			),
 			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
 				mdb.EXPECT().
-					CreateMember(gomock.Eq(types.Customer{
+					CreateMember(gomock.Any(), gomock.Eq(types.Customer{
 						CustomerId: "abc",
 						Name:       "name",
 						Email:      "email",
@@ -372,7 +1268,35 @@ func TestWebhookHandler(t *testing.T) {
 					Times(1)
 
 				mdb.EXPECT().
-					FindMemberByCustomerId(gomock.Eq("abc")).
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
+					Times(1)
+			},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name: "Email conflict isn't retried by Stripe",
+			request: buildStripeRequest(
+				t,
+				`{
+					"type":"customer.updated",
+					"data":{
+						"object":{
+							"id":"abc",
+							"name":"name",
+							"email":"email"
+						}
+					}
+				}`,
+			),
+			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
+				mdb.EXPECT().
+					CreateMember(gomock.Any(), gomock.Any()).
+					Return(&types.EmailConflictError{CustomerId: "abc", Email: "email", MemberCustomerId: "old"}).
+					Times(1)
+
+				mdb.EXPECT().
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("old")).
+					Return(&types.Member{MemberId: 1, CustomerId: "old", Status: types.MemberStatusActive}, nil).
 					Times(1)
 			},
 			wantStatusCode: http.StatusOK,
@@ -385,6 +1309,7 @@ func TestWebhookHandler(t *testing.T) {
 					"type":"customer.subscription.created",
 					"data":{
 						"object":{
+							"status":"active",
 							"customer":"abc"
 						}
 					}
@@ -392,21 +1317,25 @@ func TestWebhookHandler(t *testing.T) {
 			),
 			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
 				mdb.EXPECT().
-					FindMemberByCustomerId(gomock.Eq("abc")).
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
 					Return(&types.Member{}, nil).
 					Times(1)
 
 				mdb.EXPECT().
-					ActivateMember(gomock.Eq("abc")).
+					ActivateMember(gomock.Any(), gomock.Eq("abc")).
 					Times(1)
 
 				ua.EXPECT().
-					AddMember(gomock.Any()).
+					AddMember(gomock.Any(), gomock.Any()).
 					Return("access-id", nil).
 					Times(1)
 
 				mdb.EXPECT().
-					UpdateMemberAccess(gomock.Eq("abc"), gomock.Eq("access-id")).
+					UpdateMemberAccess(gomock.Any(), gomock.Eq("abc"), gomock.Eq("access-id")).
+					Times(1)
+
+				ua.EXPECT().
+					InviteMember(gomock.Any(), gomock.Eq("access-id"), gomock.Any(), gomock.Any()).
 					Times(1)
 			},
 			wantStatusCode: http.StatusOK,
@@ -427,16 +1356,16 @@ func TestWebhookHandler(t *testing.T) {
 			mockSetup: func(mdb *MockmemberDb, ua *MockuaUpdater) {
 				accessId := "zxcv"
 				mdb.EXPECT().
-					FindMemberByCustomerId(gomock.Eq("abc")).
+					FindMemberByCustomerId(gomock.Any(), gomock.Eq("abc")).
 					Return(&types.Member{MemberId: 123, CustomerId: "abc", AccessId: &accessId}, nil).
 					Times(1)
 
 				ua.EXPECT().
-					DisableMember(gomock.Eq(accessId), gomock.Any()).
+					DisableMember(gomock.Any(), gomock.Eq(accessId), gomock.Any()).
 					Times(1)
 
 				mdb.EXPECT().
-					DeactivateMember(gomock.Eq("abc")).
+					DeactivateMember(gomock.Any(), gomock.Eq("abc")).
 					Times(1)
 			},
 			wantStatusCode: http.StatusOK,
@@ -449,6 +1378,7 @@ func TestWebhookHandler(t *testing.T) {
 
 			if tt.mockSetup != nil {
 				tt.mockSetup(mdb, ua)
+				allowSubscriptions(mdb)
 			}
 			l := New(secret, "", "", mdb, ua)
 
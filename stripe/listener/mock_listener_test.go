@@ -1,15 +1,16 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/fatcatfablab/fcfl-member-sync/stripe/listener (interfaces: memberDb,uaUpdater)
+// Source: github.com/fatcatfablab/fcfl-member-sync/stripe/listener (interfaces: memberDb,uaUpdater,memberMailer,eventStore,customerSource,households)
 //
 // Generated by this command:
 //
-//	mockgen --destination mock_listener_test.go --package listener . memberDb,uaUpdater
+//	mockgen --destination mock_listener_test.go --package listener . memberDb,uaUpdater,memberMailer,eventStore,customerSource,households
 //
 
 // Package listener is a generated GoMock package.
 package listener
 
 import (
+	context "context"
 	reflect "reflect"
 
 	types "github.com/fatcatfablab/fcfl-member-sync/stripe/types"
@@ -42,74 +43,147 @@ func (m *MockmemberDb) EXPECT() *MockmemberDbMockRecorder {
 }
 
 // ActivateMember mocks base method.
-func (m *MockmemberDb) ActivateMember(customerId string) error {
+func (m *MockmemberDb) ActivateMember(ctx context.Context, customerId string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ActivateMember", customerId)
+	ret := m.ctrl.Call(m, "ActivateMember", ctx, customerId)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // ActivateMember indicates an expected call of ActivateMember.
-func (mr *MockmemberDbMockRecorder) ActivateMember(customerId any) *gomock.Call {
+func (mr *MockmemberDbMockRecorder) ActivateMember(ctx, customerId any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ActivateMember", reflect.TypeOf((*MockmemberDb)(nil).ActivateMember), customerId)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ActivateMember", reflect.TypeOf((*MockmemberDb)(nil).ActivateMember), ctx, customerId)
+}
+
+// ActivePolicies mocks base method.
+func (m *MockmemberDb) ActivePolicies(ctx context.Context, customerId string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ActivePolicies", ctx, customerId)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ActivePolicies indicates an expected call of ActivePolicies.
+func (mr *MockmemberDbMockRecorder) ActivePolicies(ctx, customerId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ActivePolicies", reflect.TypeOf((*MockmemberDb)(nil).ActivePolicies), ctx, customerId)
+}
+
+// ActiveSubscriptions mocks base method.
+func (m *MockmemberDb) ActiveSubscriptions(ctx context.Context, customerId string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ActiveSubscriptions", ctx, customerId)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ActiveSubscriptions indicates an expected call of ActiveSubscriptions.
+func (mr *MockmemberDbMockRecorder) ActiveSubscriptions(ctx, customerId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ActiveSubscriptions", reflect.TypeOf((*MockmemberDb)(nil).ActiveSubscriptions), ctx, customerId)
 }
 
 // CreateMember mocks base method.
-func (m *MockmemberDb) CreateMember(c types.Customer) error {
+func (m *MockmemberDb) CreateMember(ctx context.Context, c types.Customer) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateMember", c)
+	ret := m.ctrl.Call(m, "CreateMember", ctx, c)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // CreateMember indicates an expected call of CreateMember.
-func (mr *MockmemberDbMockRecorder) CreateMember(c any) *gomock.Call {
+func (mr *MockmemberDbMockRecorder) CreateMember(ctx, c any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateMember", reflect.TypeOf((*MockmemberDb)(nil).CreateMember), c)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateMember", reflect.TypeOf((*MockmemberDb)(nil).CreateMember), ctx, c)
 }
 
 // DeactivateMember mocks base method.
-func (m *MockmemberDb) DeactivateMember(customerId string) error {
+func (m *MockmemberDb) DeactivateMember(ctx context.Context, customerId string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeactivateMember", customerId)
+	ret := m.ctrl.Call(m, "DeactivateMember", ctx, customerId)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeactivateMember indicates an expected call of DeactivateMember.
-func (mr *MockmemberDbMockRecorder) DeactivateMember(customerId any) *gomock.Call {
+func (mr *MockmemberDbMockRecorder) DeactivateMember(ctx, customerId any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeactivateMember", reflect.TypeOf((*MockmemberDb)(nil).DeactivateMember), customerId)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeactivateMember", reflect.TypeOf((*MockmemberDb)(nil).DeactivateMember), ctx, customerId)
 }
 
 // FindMemberByCustomerId mocks base method.
-func (m *MockmemberDb) FindMemberByCustomerId(customerId string) (*types.Member, error) {
+func (m *MockmemberDb) FindMemberByCustomerId(ctx context.Context, customerId string) (*types.Member, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "FindMemberByCustomerId", customerId)
+	ret := m.ctrl.Call(m, "FindMemberByCustomerId", ctx, customerId)
 	ret0, _ := ret[0].(*types.Member)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // FindMemberByCustomerId indicates an expected call of FindMemberByCustomerId.
-func (mr *MockmemberDbMockRecorder) FindMemberByCustomerId(customerId any) *gomock.Call {
+func (mr *MockmemberDbMockRecorder) FindMemberByCustomerId(ctx, customerId any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindMemberByCustomerId", reflect.TypeOf((*MockmemberDb)(nil).FindMemberByCustomerId), customerId)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindMemberByCustomerId", reflect.TypeOf((*MockmemberDb)(nil).FindMemberByCustomerId), ctx, customerId)
+}
+
+// MergeMember mocks base method.
+func (m *MockmemberDb) MergeMember(ctx context.Context, from, into string) (types.Orphans, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MergeMember", ctx, from, into)
+	ret0, _ := ret[0].(types.Orphans)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MergeMember indicates an expected call of MergeMember.
+func (mr *MockmemberDbMockRecorder) MergeMember(ctx, from, into any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MergeMember", reflect.TypeOf((*MockmemberDb)(nil).MergeMember), ctx, from, into)
+}
+
+// SaveSubscription mocks base method.
+func (m *MockmemberDb) SaveSubscription(ctx context.Context, customerId string, s types.Subscription) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveSubscription", ctx, customerId, s)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveSubscription indicates an expected call of SaveSubscription.
+func (mr *MockmemberDbMockRecorder) SaveSubscription(ctx, customerId, s any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveSubscription", reflect.TypeOf((*MockmemberDb)(nil).SaveSubscription), ctx, customerId, s)
+}
+
+// UnlinkMember mocks base method.
+func (m *MockmemberDb) UnlinkMember(ctx context.Context, customerId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnlinkMember", ctx, customerId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnlinkMember indicates an expected call of UnlinkMember.
+func (mr *MockmemberDbMockRecorder) UnlinkMember(ctx, customerId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnlinkMember", reflect.TypeOf((*MockmemberDb)(nil).UnlinkMember), ctx, customerId)
 }
 
 // UpdateMemberAccess mocks base method.
-func (m *MockmemberDb) UpdateMemberAccess(customerId, accessId string) error {
+func (m *MockmemberDb) UpdateMemberAccess(ctx context.Context, customerId, accessId string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateMemberAccess", customerId, accessId)
+	ret := m.ctrl.Call(m, "UpdateMemberAccess", ctx, customerId, accessId)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateMemberAccess indicates an expected call of UpdateMemberAccess.
-func (mr *MockmemberDbMockRecorder) UpdateMemberAccess(customerId, accessId any) *gomock.Call {
+func (mr *MockmemberDbMockRecorder) UpdateMemberAccess(ctx, customerId, accessId any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMemberAccess", reflect.TypeOf((*MockmemberDb)(nil).UpdateMemberAccess), customerId, accessId)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMemberAccess", reflect.TypeOf((*MockmemberDb)(nil).UpdateMemberAccess), ctx, customerId, accessId)
 }
 
 // MockuaUpdater is a mock of uaUpdater interface.
@@ -137,44 +211,268 @@ func (m *MockuaUpdater) EXPECT() *MockuaUpdaterMockRecorder {
 }
 
 // AddMember mocks base method.
-func (m *MockuaUpdater) AddMember(arg0 types0.ComparableMember) (string, error) {
+func (m *MockuaUpdater) AddMember(arg0 context.Context, arg1 types0.ComparableMember) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AddMember", arg0)
+	ret := m.ctrl.Call(m, "AddMember", arg0, arg1)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // AddMember indicates an expected call of AddMember.
-func (mr *MockuaUpdaterMockRecorder) AddMember(arg0 any) *gomock.Call {
+func (mr *MockuaUpdaterMockRecorder) AddMember(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddMember", reflect.TypeOf((*MockuaUpdater)(nil).AddMember), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddMember", reflect.TypeOf((*MockuaUpdater)(nil).AddMember), arg0, arg1)
+}
+
+// AssignPolicies mocks base method.
+func (m *MockuaUpdater) AssignPolicies(arg0 context.Context, arg1 string, arg2 types0.ComparableMember, arg3 []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AssignPolicies", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AssignPolicies indicates an expected call of AssignPolicies.
+func (mr *MockuaUpdaterMockRecorder) AssignPolicies(arg0, arg1, arg2, arg3 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AssignPolicies", reflect.TypeOf((*MockuaUpdater)(nil).AssignPolicies), arg0, arg1, arg2, arg3)
 }
 
 // DisableMember mocks base method.
-func (m *MockuaUpdater) DisableMember(arg0 string, arg1 types0.ComparableMember) error {
+func (m *MockuaUpdater) DisableMember(arg0 context.Context, arg1 string, arg2 types0.ComparableMember) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DisableMember", arg0, arg1)
+	ret := m.ctrl.Call(m, "DisableMember", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DisableMember indicates an expected call of DisableMember.
-func (mr *MockuaUpdaterMockRecorder) DisableMember(arg0, arg1 any) *gomock.Call {
+func (mr *MockuaUpdaterMockRecorder) DisableMember(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisableMember", reflect.TypeOf((*MockuaUpdater)(nil).DisableMember), arg0, arg1, arg2)
+}
+
+// InviteMember mocks base method.
+func (m *MockuaUpdater) InviteMember(arg0 context.Context, arg1 string, arg2 types0.ComparableMember, arg3 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InviteMember", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// InviteMember indicates an expected call of InviteMember.
+func (mr *MockuaUpdaterMockRecorder) InviteMember(arg0, arg1, arg2, arg3 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisableMember", reflect.TypeOf((*MockuaUpdater)(nil).DisableMember), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InviteMember", reflect.TypeOf((*MockuaUpdater)(nil).InviteMember), arg0, arg1, arg2, arg3)
 }
 
 // UpdateMember mocks base method.
-func (m *MockuaUpdater) UpdateMember(arg0 string, arg1 types0.ComparableMember) error {
+func (m *MockuaUpdater) UpdateMember(arg0 context.Context, arg1 string, arg2 types0.ComparableMember) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateMember", arg0, arg1)
+	ret := m.ctrl.Call(m, "UpdateMember", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateMember indicates an expected call of UpdateMember.
-func (mr *MockuaUpdaterMockRecorder) UpdateMember(arg0, arg1 any) *gomock.Call {
+func (mr *MockuaUpdaterMockRecorder) UpdateMember(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMember", reflect.TypeOf((*MockuaUpdater)(nil).UpdateMember), arg0, arg1, arg2)
+}
+
+// MockmemberMailer is a mock of memberMailer interface.
+type MockmemberMailer struct {
+	ctrl     *gomock.Controller
+	recorder *MockmemberMailerMockRecorder
+	isgomock struct{}
+}
+
+// MockmemberMailerMockRecorder is the mock recorder for MockmemberMailer.
+type MockmemberMailerMockRecorder struct {
+	mock *MockmemberMailer
+}
+
+// NewMockmemberMailer creates a new mock instance.
+func NewMockmemberMailer(ctrl *gomock.Controller) *MockmemberMailer {
+	mock := &MockmemberMailer{ctrl: ctrl}
+	mock.recorder = &MockmemberMailerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockmemberMailer) EXPECT() *MockmemberMailerMockRecorder {
+	return m.recorder
+}
+
+// Send mocks base method.
+func (m_2 *MockmemberMailer) Send(ctx context.Context, kind string, m types.Member) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "Send", ctx, kind, m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Send indicates an expected call of Send.
+func (mr *MockmemberMailerMockRecorder) Send(ctx, kind, m any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockmemberMailer)(nil).Send), ctx, kind, m)
+}
+
+// MockeventStore is a mock of eventStore interface.
+type MockeventStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockeventStoreMockRecorder
+	isgomock struct{}
+}
+
+// MockeventStoreMockRecorder is the mock recorder for MockeventStore.
+type MockeventStoreMockRecorder struct {
+	mock *MockeventStore
+}
+
+// NewMockeventStore creates a new mock instance.
+func NewMockeventStore(ctrl *gomock.Controller) *MockeventStore {
+	mock := &MockeventStore{ctrl: ctrl}
+	mock.recorder = &MockeventStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockeventStore) EXPECT() *MockeventStoreMockRecorder {
+	return m.recorder
+}
+
+// FindEvent mocks base method.
+func (m *MockeventStore) FindEvent(ctx context.Context, id string) (*types.WebhookEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindEvent", ctx, id)
+	ret0, _ := ret[0].(*types.WebhookEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindEvent indicates an expected call of FindEvent.
+func (mr *MockeventStoreMockRecorder) FindEvent(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindEvent", reflect.TypeOf((*MockeventStore)(nil).FindEvent), ctx, id)
+}
+
+// SaveEvent mocks base method.
+func (m *MockeventStore) SaveEvent(arg0 context.Context, arg1 types.WebhookEvent) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveEvent", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveEvent indicates an expected call of SaveEvent.
+func (mr *MockeventStoreMockRecorder) SaveEvent(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveEvent", reflect.TypeOf((*MockeventStore)(nil).SaveEvent), arg0, arg1)
+}
+
+// MockcustomerSource is a mock of customerSource interface.
+type MockcustomerSource struct {
+	ctrl     *gomock.Controller
+	recorder *MockcustomerSourceMockRecorder
+	isgomock struct{}
+}
+
+// MockcustomerSourceMockRecorder is the mock recorder for MockcustomerSource.
+type MockcustomerSourceMockRecorder struct {
+	mock *MockcustomerSource
+}
+
+// NewMockcustomerSource creates a new mock instance.
+func NewMockcustomerSource(ctrl *gomock.Controller) *MockcustomerSource {
+	mock := &MockcustomerSource{ctrl: ctrl}
+	mock.recorder = &MockcustomerSourceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockcustomerSource) EXPECT() *MockcustomerSourceMockRecorder {
+	return m.recorder
+}
+
+// GetCustomer mocks base method.
+func (m *MockcustomerSource) GetCustomer(ctx context.Context, customerId string) (*types.Customer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCustomer", ctx, customerId)
+	ret0, _ := ret[0].(*types.Customer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCustomer indicates an expected call of GetCustomer.
+func (mr *MockcustomerSourceMockRecorder) GetCustomer(ctx, customerId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCustomer", reflect.TypeOf((*MockcustomerSource)(nil).GetCustomer), ctx, customerId)
+}
+
+// Mockhouseholds is a mock of households interface.
+type Mockhouseholds struct {
+	ctrl     *gomock.Controller
+	recorder *MockhouseholdsMockRecorder
+	isgomock struct{}
+}
+
+// MockhouseholdsMockRecorder is the mock recorder for Mockhouseholds.
+type MockhouseholdsMockRecorder struct {
+	mock *Mockhouseholds
+}
+
+// NewMockhouseholds creates a new mock instance.
+func NewMockhouseholds(ctrl *gomock.Controller) *Mockhouseholds {
+	mock := &Mockhouseholds{ctrl: ctrl}
+	mock.recorder = &MockhouseholdsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Mockhouseholds) EXPECT() *MockhouseholdsMockRecorder {
+	return m.recorder
+}
+
+// Disable mocks base method.
+func (m *Mockhouseholds) Disable(ctx context.Context, deps []types.Dependent) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Disable", ctx, deps)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Disable indicates an expected call of Disable.
+func (mr *MockhouseholdsMockRecorder) Disable(ctx, deps any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Disable", reflect.TypeOf((*Mockhouseholds)(nil).Disable), ctx, deps)
+}
+
+// Follow mocks base method.
+func (m_2 *Mockhouseholds) Follow(ctx context.Context, m types.Member) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "Follow", ctx, m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Follow indicates an expected call of Follow.
+func (mr *MockhouseholdsMockRecorder) Follow(ctx, m any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Follow", reflect.TypeOf((*Mockhouseholds)(nil).Follow), ctx, m)
+}
+
+// SetFromMetadata mocks base method.
+func (m *Mockhouseholds) SetFromMetadata(ctx context.Context, c types.Customer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetFromMetadata", ctx, c)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetFromMetadata indicates an expected call of SetFromMetadata.
+func (mr *MockhouseholdsMockRecorder) SetFromMetadata(ctx, c any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMember", reflect.TypeOf((*MockuaUpdater)(nil).UpdateMember), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetFromMetadata", reflect.TypeOf((*Mockhouseholds)(nil).SetFromMetadata), ctx, c)
 }
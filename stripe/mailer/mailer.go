@@ -0,0 +1,168 @@
+// Package mailer sends templated emails to members when their door access
+// changes, and records them in the stripe database.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+type SMTPConfig struct {
+	// Addr is the host:port of the SMTP server
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+type store interface {
+	SaveSentEmail(ctx context.Context, e types.SentEmail) error
+	SentSinceStatusChange(ctx context.Context, memberId int64, kind string) (bool, error)
+}
+
+type sendFunc func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+type Mailer struct {
+	cfg       SMTPConfig
+	templates *Templates
+	store     store
+	send      sendFunc
+	dryRun    bool
+}
+
+func New(cfg SMTPConfig, t *Templates, s store, dryRun bool) *Mailer {
+	return &Mailer{
+		cfg:       cfg,
+		templates: t,
+		store:     s,
+		send:      smtp.SendMail,
+		dryRun:    dryRun,
+	}
+}
+
+// Send emails member m the message of the given kind and records whether it
+// went out. Members already sent one since their status last changed aren't
+// sent another, so retried events don't email them twice.
+func (m *Mailer) Send(ctx context.Context, kind string, member types.Member) error {
+	if member.Email == "" {
+		return fmt.Errorf("member %d has no email", member.MemberId)
+	}
+
+	sent, err := m.store.SentSinceStatusChange(ctx, member.MemberId, kind)
+	if err != nil {
+		return err
+	}
+	if sent {
+		slog.Info("Member was already emailed", logging.KeyMemberId, member.MemberId, "kind", kind)
+		return nil
+	}
+
+	msg, err := m.templates.Render(kind, member)
+	if err != nil {
+		return err
+	}
+
+	slog.Info(
+		"Emailing member",
+		logging.KeyMemberId, member.MemberId,
+		logging.KeyCustomerId, member.CustomerId,
+		logging.KeyEmail, member.Email,
+		"kind", kind,
+		logging.KeyDryRun, m.dryRun,
+	)
+
+	if m.dryRun {
+		return nil
+	}
+
+	record := types.SentEmail{
+		MemberId: member.MemberId,
+		Kind:     kind,
+		Email:    member.Email,
+		Subject:  msg.Subject,
+		Status:   types.EmailStatusSent,
+		SentAt:   time.Now().Unix(),
+	}
+
+	err = m.deliver(member.Email, msg)
+	if err != nil {
+		record.Status = types.EmailStatusFailed
+	}
+
+	if serr := m.store.SaveSentEmail(ctx, record); serr != nil {
+		slog.Error("Error recording sent email", logging.KeyMemberId, member.MemberId, logging.Err(serr))
+	}
+
+	return err
+}
+
+func (m *Mailer) deliver(to string, msg *Message) error {
+	body, err := build(m.cfg.From, to, msg)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(m.cfg.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, host)
+	}
+
+	return m.send(m.cfg.Addr, auth, m.cfg.From, []string{to}, body)
+}
+
+// build turns msg into a MIME message, multipart/alternative when it has an
+// HTML version.
+func build(from string, to string, msg *Message) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		b.WriteString(msg.Text)
+		return b.Bytes(), nil
+	}
+
+	w := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pw.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,155 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+var member = types.Member{MemberId: 42, CustomerId: "cus_1", Name: "Mary Ann Smith", Email: "mary@example.com"}
+
+type fakeStore struct {
+	sent        []types.SentEmail
+	alreadySent bool
+}
+
+func (f *fakeStore) SaveSentEmail(_ context.Context, e types.SentEmail) error {
+	f.sent = append(f.sent, e)
+	return nil
+}
+
+func (f *fakeStore) SentSinceStatusChange(_ context.Context, _ int64, _ string) (bool, error) {
+	return f.alreadySent, nil
+}
+
+func TestRenderDefaults(t *testing.T) {
+	tmpl, err := LoadTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kind := range Kinds {
+		t.Run(kind, func(t *testing.T) {
+			msg, err := tmpl.Render(kind, member)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if msg.Subject == "" || strings.Contains(msg.Subject, "\n") {
+				t.Errorf("bad subject: %q", msg.Subject)
+			}
+			if !strings.Contains(msg.Text, "Hi Mary Ann,") || !strings.Contains(msg.HTML, "Hi Mary Ann,") {
+				t.Errorf("member name not rendered:\n%s\n%s", msg.Text, msg.HTML)
+			}
+		})
+	}
+
+	if _, err := tmpl.Render("spam", member); err == nil {
+		t.Error("expected error rendering an unknown kind")
+	}
+}
+
+func TestLoadTemplatesOverride(t *testing.T) {
+	dir := t.TempDir()
+	custom := `{{define "subject"}}Door access for #{{.MemberId}}{{end}}Hello {{.Name}}`
+	if err := os.WriteFile(filepath.Join(dir, types.EmailAccessGranted+".txt.tmpl"), []byte(custom), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := tmpl.Render(types.EmailAccessGranted, member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Door access for #42" || msg.Text != "Hello Mary Ann Smith" || msg.HTML != "" {
+		t.Errorf("custom template not used: %+v", msg)
+	}
+
+	// Kinds without a custom template keep the built-in one
+	msg, err = tmpl.Render(types.EmailAccessRevoked, member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.HTML == "" {
+		t.Error("expected the built-in html template")
+	}
+}
+
+func TestLoadTemplatesWithoutSubject(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, types.EmailAccessRevoked+".txt.tmpl"), []byte("Bye"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadTemplates(dir); err == nil {
+		t.Error("expected error loading a template without subject")
+	}
+}
+
+func TestSend(t *testing.T) {
+	tmpl, err := LoadTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name        string
+		sendErr     error
+		dryRun      bool
+		alreadySent bool
+		wantStatus  string
+	}{
+		{name: "Sent", wantStatus: types.EmailStatusSent},
+		{name: "Failed", sendErr: errors.New("connection refused"), wantStatus: types.EmailStatusFailed},
+		{name: "Dry run", dryRun: true},
+		{name: "Already sent", alreadySent: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeStore{alreadySent: tt.alreadySent}
+			m := New(SMTPConfig{Addr: "localhost:25", From: "lab@example.com"}, tmpl, store, tt.dryRun)
+
+			var body string
+			m.send = func(_ string, _ smtp.Auth, from string, to []string, msg []byte) error {
+				if from != "lab@example.com" || len(to) != 1 || to[0] != member.Email {
+					t.Errorf("unexpected envelope: %s %v", from, to)
+				}
+				body = string(msg)
+				return tt.sendErr
+			}
+
+			err := m.Send(context.Background(), types.EmailAccessGranted, member)
+			if !errors.Is(err, tt.sendErr) {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if tt.dryRun || tt.alreadySent {
+				if body != "" || len(store.sent) != 0 {
+					t.Error("email sent anyway")
+				}
+				return
+			}
+
+			if !strings.Contains(body, "multipart/alternative") {
+				t.Errorf("expected a multipart message:\n%s", body)
+			}
+
+			if len(store.sent) != 1 {
+				t.Fatalf("want 1 recorded email, got %d", len(store.sent))
+			}
+			got := store.sent[0]
+			if got.MemberId != member.MemberId || got.Kind != types.EmailAccessGranted || got.Status != tt.wantStatus {
+				t.Errorf("unexpected record: %+v", got)
+			}
+		})
+	}
+}
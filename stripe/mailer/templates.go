@@ -0,0 +1,140 @@
+package mailer
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/names"
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// Kinds are the emails members get, named after their templates
+var Kinds = []string{
+	types.EmailAccessGranted,
+	types.EmailAccessSuspended,
+	types.EmailAccessRevoked,
+}
+
+// Data is what templates get rendered with
+type Data struct {
+	MemberId  int64
+	Name      string
+	FirstName string
+	Email     string
+}
+
+type Message struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type template struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Templates holds a text template, and optionally an HTML one, per kind
+type Templates struct {
+	templates map[string]template
+}
+
+// LoadTemplates reads KIND.txt.tmpl and, if present, KIND.html.tmpl for
+// every kind from dir. The text template must define a "subject" template.
+// Kinds missing from dir, or every kind when dir is empty, use the built-in
+// templates.
+func LoadTemplates(dir string) (*Templates, error) {
+	builtin, err := fs.Sub(defaultTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Templates{templates: make(map[string]template)}
+	for _, kind := range Kinds {
+		fsys := builtin
+		if dir != "" {
+			custom := os.DirFS(dir)
+			if _, err := fs.Stat(custom, kind+".txt.tmpl"); err == nil {
+				fsys = custom
+			}
+		}
+
+		tmpl, err := loadTemplate(fsys, kind)
+		if err != nil {
+			return nil, err
+		}
+		t.templates[kind] = tmpl
+	}
+
+	return t, nil
+}
+
+func loadTemplate(fsys fs.FS, kind string) (template, error) {
+	var tmpl template
+
+	text, err := texttemplate.ParseFS(fsys, kind+".txt.tmpl")
+	if err != nil {
+		return tmpl, fmt.Errorf("error parsing %s template: %w", kind, err)
+	}
+	if text.Lookup("subject") == nil {
+		return tmpl, fmt.Errorf("%s template doesn't define a subject", kind)
+	}
+	tmpl.text = text
+
+	htmlName := kind + ".html.tmpl"
+	if _, err := fs.Stat(fsys, htmlName); errors.Is(err, fs.ErrNotExist) {
+		return tmpl, nil
+	}
+
+	html, err := htmltemplate.ParseFS(fsys, htmlName)
+	if err != nil {
+		return tmpl, fmt.Errorf("error parsing %s html template: %w", kind, err)
+	}
+	tmpl.html = html
+
+	return tmpl, nil
+}
+
+// Render renders the email of the given kind for member m
+func (t *Templates) Render(kind string, m types.Member) (*Message, error) {
+	tmpl, ok := t.templates[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown email %q", kind)
+	}
+
+	firstName, _ := names.ForDoor(m)
+	data := Data{
+		MemberId:  m.MemberId,
+		Name:      m.Name,
+		FirstName: firstName,
+		Email:     m.Email,
+	}
+
+	var subject, text, html strings.Builder
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, fmt.Errorf("error rendering %s subject: %w", kind, err)
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("error rendering %s: %w", kind, err)
+	}
+	if tmpl.html != nil {
+		if err := tmpl.html.Execute(&html, data); err != nil {
+			return nil, fmt.Errorf("error rendering %s html: %w", kind, err)
+		}
+	}
+
+	return &Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
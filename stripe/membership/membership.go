@@ -0,0 +1,94 @@
+// Package membership tells the subscriptions that make someone a member
+// apart from everything else sold through Stripe, like donations or classes.
+package membership
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+// PoliciesMetadataKey is the price or product metadata listing the ids of
+// the UniFi Access policies its members get, separated by commas.
+const PoliciesMetadataKey = "unifi_access_policies"
+
+type productSource interface {
+	GetProduct(ctx context.Context, productId string) (*types.Product, error)
+}
+
+// Filter allows the subscriptions with a price or product in its allowlist.
+// A nil Filter, or one with an empty allowlist, allows every subscription.
+type Filter struct {
+	ids      map[string]bool
+	products productSource
+}
+
+// NewFilter allows the subscriptions to any of the given price or product
+// ids.
+func NewFilter(ids []string) *Filter {
+	f := &Filter{ids: make(map[string]bool)}
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" {
+			f.ids[id] = true
+		}
+	}
+
+	return f
+}
+
+// SetProductSource makes Policies read the policies from the metadata of the
+// products, for prices that don't list any. Without one only prices do.
+func (f *Filter) SetProductSource(ps productSource) {
+	f.products = ps
+}
+
+// Allows tells whether s makes its customer a member
+func (f *Filter) Allows(s types.Subscription) bool {
+	if f == nil || len(f.ids) == 0 {
+		return true
+	}
+	return len(f.items(s)) > 0
+}
+
+// items returns the items of s that are a membership
+func (f *Filter) items(s types.Subscription) []types.SubscriptionItem {
+	if f == nil || len(f.ids) == 0 {
+		return s.Items.Data
+	}
+
+	var items []types.SubscriptionItem
+	for _, item := range s.Items.Data {
+		if f.ids[item.Price.Id] || f.ids[item.Price.Product] {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Policies returns the UniFi Access policies the membership items of s give
+// access to, if any. Those listed by the price of an item win over the ones
+// of its product, e.g. for a price only giving access on weekends.
+func (f *Filter) Policies(ctx context.Context, s types.Subscription) ([]string, error) {
+	var policies []string
+	for _, item := range f.items(s) {
+		listed, ok := item.Price.Metadata[PoliciesMetadataKey]
+		if !ok && f != nil && f.products != nil && item.Price.Product != "" {
+			p, err := f.products.GetProduct(ctx, item.Price.Product)
+			if err != nil {
+				return nil, err
+			}
+			listed = p.Metadata[PoliciesMetadataKey]
+		}
+
+		for _, id := range strings.Split(listed, ",") {
+			if id = strings.TrimSpace(id); id != "" && !slices.Contains(policies, id) {
+				policies = append(policies, id)
+			}
+		}
+	}
+
+	return policies, nil
+}
@@ -0,0 +1,97 @@
+package membership
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+type fakeProducts map[string]types.Product
+
+func (f fakeProducts) GetProduct(_ context.Context, productId string) (*types.Product, error) {
+	if p, ok := f[productId]; ok {
+		return &p, nil
+	}
+	return nil, errors.New("no such product")
+}
+
+func subscription(prices ...types.Price) types.Subscription {
+	var s types.Subscription
+	for _, p := range prices {
+		s.Items.Data = append(s.Items.Data, types.SubscriptionItem{Price: p})
+	}
+	return s
+}
+
+var (
+	monthly   = types.Price{Id: "price_monthly", Product: "prod_membership"}
+	yearly    = types.Price{Id: "price_yearly", Product: "prod_membership"}
+	keyholder = types.Price{Id: "price_keyholder", Product: "prod_keyholder"}
+	donation  = types.Price{Id: "price_donation", Product: "prod_donation"}
+)
+
+func TestAllows(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		filter *Filter
+		s      types.Subscription
+		want   bool
+	}{
+		{name: "No filter", s: subscription(donation), want: true},
+		{name: "Empty allowlist", filter: NewFilter([]string{""}), s: subscription(donation), want: true},
+		{name: "No items", filter: NewFilter(nil), s: types.Subscription{}, want: true},
+		{name: "Allowed product", filter: NewFilter([]string{"prod_membership"}), s: subscription(yearly), want: true},
+		{name: "Allowed price", filter: NewFilter([]string{"price_monthly"}), s: subscription(monthly), want: true},
+		{name: "Other price of the product", filter: NewFilter([]string{"price_monthly"}), s: subscription(yearly), want: false},
+		{name: "Donation", filter: NewFilter([]string{"prod_membership"}), s: subscription(donation), want: false},
+		{name: "Any item", filter: NewFilter([]string{"prod_membership"}), s: subscription(donation, monthly), want: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Allows(tt.s); got != tt.want {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	f := NewFilter([]string{"prod_membership", "prod_keyholder", "prod_donation"})
+	f.SetProductSource(fakeProducts{
+		"prod_membership": {Id: "prod_membership", Metadata: map[string]string{PoliciesMetadataKey: "policy_doors"}},
+		"prod_keyholder":  {Id: "prod_keyholder", Metadata: map[string]string{PoliciesMetadataKey: "policy_doors, policy_shop"}},
+		"prod_donation":   {Id: "prod_donation"},
+	})
+
+	got, err := f.Policies(context.Background(), subscription(monthly, keyholder, donation))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"policy_doors", "policy_shop"}; !slices.Equal(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	// Prices can narrow down the policies of their product
+	weekends := types.Price{Id: "price_weekends", Product: "prod_membership", Metadata: map[string]string{PoliciesMetadataKey: "policy_weekends"}}
+	got, err = f.Policies(context.Background(), subscription(weekends))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"policy_weekends"}; !slices.Equal(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	f = NewFilter([]string{"prod_membership"})
+	f.SetProductSource(fakeProducts{})
+	if _, err := f.Policies(context.Background(), subscription(monthly)); err == nil {
+		t.Error("expected an error for a missing product")
+	}
+
+	// Prices are read without a product source
+	got, err = NewFilter(nil).Policies(context.Background(), subscription(weekends, monthly))
+	if err != nil || !slices.Equal(got, []string{"policy_weekends"}) {
+		t.Errorf("want the policies of the price, got %v and %v", got, err)
+	}
+}
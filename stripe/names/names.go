@@ -0,0 +1,89 @@
+// Package names works out the first and last names members are shown with
+// on the doors, since Stripe only has a single name for each customer.
+package names
+
+import (
+	"strings"
+
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+// Customer metadata with the first and last names of the member, for names
+// Split can't get right.
+const (
+	MetadataFirstName = "first_name"
+	MetadataLastName  = "last_name"
+)
+
+// particles start a last name when they come after the first name, as in
+// "José de la Cruz" or "Ludwig van Beethoven".
+var particles = map[string]bool{
+	"al": true, "bin": true, "da": true, "das": true, "de": true, "del": true,
+	"della": true, "der": true, "di": true, "do": true, "dos": true, "du": true,
+	"el": true, "la": true, "le": true, "st.": true, "ten": true, "ter": true,
+	"van": true, "von": true, "y": true,
+}
+
+// suffixes stay with the last name, as in "Martin Luther King Jr."
+var suffixes = map[string]bool{
+	"jr": true, "jr.": true, "sr": true, "sr.": true,
+	"ii": true, "iii": true, "iv": true,
+}
+
+// Split guesses the first and last names in a full name. The last word is
+// the last name, along with the particles and suffixes around it, and the
+// rest is the first name. "Smith, Mary Ann" is taken as last name first.
+// Single names are all first name.
+func Split(name string) (string, string) {
+	if last, first, ok := strings.Cut(name, ","); ok && !suffixes[strings.ToLower(strings.TrimSpace(first))] {
+		return strings.Join(strings.Fields(first), " "), strings.Join(strings.Fields(last), " ")
+	}
+
+	words := strings.Fields(name)
+	if len(words) < 2 {
+		return strings.Join(words, " "), ""
+	}
+
+	// The last name starts at its last word, the one before any suffixes
+	start := len(words) - 1
+	for start > 1 && suffixes[strings.ToLower(strings.TrimSuffix(words[start], ","))] {
+		start--
+	}
+	for start > 1 && particles[strings.ToLower(words[start-1])] {
+		start--
+	}
+
+	return strings.Join(words[:start], " "), strings.Join(words[start:], " ")
+}
+
+// FromCustomer returns the first and last names in the metadata of c, if it
+// has both.
+func FromCustomer(c types.Customer) (string, string) {
+	first := strings.TrimSpace(c.Metadata[MetadataFirstName])
+	last := strings.TrimSpace(c.Metadata[MetadataLastName])
+	if first == "" || last == "" {
+		return "", ""
+	}
+	return first, last
+}
+
+// ForDoor returns the names m is shown with on the doors. The names an admin
+// set win over the ones in Stripe, which win over splitting the full name,
+// and the preferred name replaces the first one.
+func ForDoor(m types.Member) (string, string) {
+	first, last := m.FirstName, m.LastName
+	if first == "" && last == "" {
+		first, last = Split(m.Name)
+	}
+	if m.FirstNameOverride != "" {
+		first = m.FirstNameOverride
+	}
+	if m.LastNameOverride != "" {
+		last = m.LastNameOverride
+	}
+	if m.PreferredName != "" {
+		first = m.PreferredName
+	}
+
+	return first, last
+}
@@ -0,0 +1,74 @@
+package names
+
+import (
+	"testing"
+
+	"github.com/fatcatfablab/fcfl-member-sync/stripe/types"
+)
+
+func TestSplit(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		first string
+		last  string
+	}{
+		{name: "Mary Smith", first: "Mary", last: "Smith"},
+		{name: "Mary Ann Smith", first: "Mary Ann", last: "Smith"},
+		{name: "José de la Cruz", first: "José", last: "de la Cruz"},
+		{name: "Ludwig van Beethoven", first: "Ludwig", last: "van Beethoven"},
+		{name: "Martin Luther King Jr.", first: "Martin Luther", last: "King Jr."},
+		{name: "Martin Luther King, Jr.", first: "Martin Luther", last: "King, Jr."},
+		{name: "Smith, Mary Ann", first: "Mary Ann", last: "Smith"},
+		{name: "De Niro", first: "De", last: "Niro"},
+		{name: "  Cher ", first: "Cher", last: ""},
+		{name: "", first: "", last: ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			first, last := Split(tt.name)
+			if first != tt.first || last != tt.last {
+				t.Errorf("want %q %q, got %q %q", tt.first, tt.last, first, last)
+			}
+		})
+	}
+}
+
+func TestForDoor(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		member types.Member
+		first  string
+		last   string
+	}{
+		{
+			name:   "Split full name",
+			member: types.Member{Name: "José de la Cruz"},
+			first:  "José",
+			last:   "de la Cruz",
+		},
+		{
+			name:   "Names from Stripe",
+			member: types.Member{Name: "Mary Ann Smith Jones", FirstName: "Mary Ann", LastName: "Smith Jones"},
+			first:  "Mary Ann",
+			last:   "Smith Jones",
+		},
+		{
+			name:   "Overrides win",
+			member: types.Member{Name: "Mary Smith", FirstName: "Mary", LastName: "Smith", LastNameOverride: "Smith-Jones"},
+			first:  "Mary",
+			last:   "Smith-Jones",
+		},
+		{
+			name:   "Preferred name",
+			member: types.Member{Name: "Robert Smith", FirstNameOverride: "Roberto", PreferredName: "Bob"},
+			first:  "Bob",
+			last:   "Smith",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			first, last := ForDoor(tt.member)
+			if first != tt.first || last != tt.last {
+				t.Errorf("want %q %q, got %q %q", tt.first, tt.last, first, last)
+			}
+		})
+	}
+}
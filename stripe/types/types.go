@@ -1,8 +1,12 @@
 package types
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 type Event struct {
+	Id   string    `json:"id"`
 	Type string    `json:"type"`
 	Data EventData `json:"data"`
 }
@@ -12,9 +16,10 @@ type EventData struct {
 }
 
 type Customer struct {
-	CustomerId string `json:"id"`
-	Name       string `json:"name"`
-	Email      string `json:"email"`
+	CustomerId string            `json:"id"`
+	Name       string            `json:"name"`
+	Email      string            `json:"email"`
+	Metadata   map[string]string `json:"metadata"`
 }
 
 const (
@@ -29,10 +34,42 @@ const (
 )
 
 type Subscription struct {
-	Status     string `json:"status"`
-	Customer   string `json:"customer"`
-	CancelAt   *int64 `json:"cancel_at"`
-	CanceledAt *int64 `json:"canceled_at"`
+	Id         string            `json:"id"`
+	Status     string            `json:"status"`
+	Customer   string            `json:"customer"`
+	CancelAt   *int64            `json:"cancel_at"`
+	CanceledAt *int64            `json:"canceled_at"`
+	Items      SubscriptionItems `json:"items"`
+	// Policies are the UniFi Access policies it gives, as told by the
+	// membership filter. Stripe doesn't send them.
+	Policies []string `json:"-"`
+}
+
+// Active tells whether the subscription gives access
+func (s Subscription) Active() bool {
+	return s.Status == SubscriptionActive || s.Status == SubscriptionTrialing
+}
+
+type SubscriptionItems struct {
+	Data []SubscriptionItem `json:"data"`
+}
+
+type SubscriptionItem struct {
+	Price Price `json:"price"`
+}
+
+// Price is what a subscription item is billed at. Product is the id of the
+// product it's a price of.
+type Price struct {
+	Id       string            `json:"id"`
+	Product  string            `json:"product"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+type Product struct {
+	Id       string            `json:"id"`
+	Name     string            `json:"name"`
+	Metadata map[string]string `json:"metadata"`
 }
 
 const (
@@ -40,11 +77,94 @@ const (
 	MemberStatusNotActive = "not_active"
 )
 
+// Member is a Stripe customer. FirstName and LastName come from the
+// customer metadata, the overrides and PreferredName are set by admins.
 type Member struct {
-	MemberId   int64
+	MemberId          int64
+	CustomerId        string
+	AccessId          *string
+	Name              string
+	Email             string
+	Status            string
+	FirstName         string
+	LastName          string
+	FirstNameOverride string
+	LastNameOverride  string
+	PreferredName     string
+}
+
+// EmailConflictError is returned when a customer has the email of another
+// member, usually the same person under an older Stripe customer.
+type EmailConflictError struct {
 	CustomerId string
-	AccessId   *string
-	Name       string
 	Email      string
+	// MemberCustomerId is the customer id of the member using the email
+	MemberCustomerId string
+}
+
+func (e *EmailConflictError) Error() string {
+	return fmt.Sprintf("email of %q is already used by member %q", e.CustomerId, e.MemberCustomerId)
+}
+
+// Orphans are what merging members leaves behind: the member merged away,
+// when their UniFi Access user wasn't kept, and the dependents dropped as
+// duplicates. No member points to their users anymore.
+type Orphans struct {
+	Member     *Member
+	Dependents []Dependent
+}
+
+const (
+	DependentSourceMetadata = "metadata"
+	DependentSourceAdmin    = "admin"
+)
+
+// Dependent is someone covered by the family membership of another member.
+// They get their own door user, with the dependent id as employee number,
+// which is active whenever their primary member is.
+type Dependent struct {
+	DependentId int64
+	MemberId    int64
+	AccessId    *string
+	Name        string
+	Email       string
+	Source      string
+}
+
+const (
+	EmailAccessGranted   = "access_granted"
+	EmailAccessSuspended = "access_suspended"
+	EmailAccessRevoked   = "access_revoked"
+
+	EmailStatusSent   = "sent"
+	EmailStatusFailed = "failed"
+)
+
+// SentEmail records an email sent, or attempted, to a member
+type SentEmail struct {
+	MemberId int64
+	Kind     string
+	Email    string
+	Subject  string
+	Status   string
+	SentAt   int64
+}
+
+const (
+	EventStatusHandled = "handled"
+	EventStatusFailed  = "failed"
+	EventStatusIgnored = "ignored"
+)
+
+// WebhookEvent records a Stripe event the listener received, so failed ones
+// can be looked at and requeued.
+type WebhookEvent struct {
+	Id         string
+	Type       string
+	Payload    []byte
 	Status     string
+	Error      string
+	Attempts   int
+	ReceivedAt int64
+	HandledAt  int64
 }
@@ -1,10 +1,16 @@
 package sync
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"maps"
 	"slices"
+	"time"
 
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/metrics"
 	"github.com/fatcatfablab/fcfl-member-sync/types"
 )
 
@@ -15,12 +21,58 @@ type (
 )
 
 type updater interface {
-	Add(MemberSet) error
-	Disable(MemberMap) error
-	Update(MemberMap) error
+	Add(context.Context, MemberSet) error
+	Disable(context.Context, MemberMap) error
+	Update(context.Context, MemberMap) error
 }
 
-func Reconcile(remote MemberSet, localMap MemberMap, u updater) error {
+// ErrTooManyDisables is returned by ReconcileWithLimit when it refuses to
+// disable members because there are more of them than allowed.
+var ErrTooManyDisables = errors.New("too many members to disable")
+
+func Reconcile(ctx context.Context, remote MemberSet, localMap MemberMap, u updater) error {
+	return ReconcileWithLimit(ctx, remote, localMap, u, 0)
+}
+
+// ReconcileWithLimit is like Reconcile, but doesn't disable anyone if that
+// means disabling more than maxDisable members, which usually points to a
+// broken remote list rather than a mass exodus. A maxDisable of 0 means no
+// limit.
+func ReconcileWithLimit(ctx context.Context, remote MemberSet, localMap MemberMap, u updater, maxDisable int) error {
+	start := time.Now()
+	err := reconcile(ctx, remote, localMap, u, maxDisable)
+
+	metrics.ReconcileDuration.Observe(time.Since(start).Seconds())
+	metrics.ReconcileRuns.WithLabelValues(metrics.Result(err)).Inc()
+	if err == nil {
+		metrics.LastSuccessfulSync.SetToCurrentTime()
+	}
+
+	return err
+}
+
+// ReconcileMember applies the Reconcile rules to the member with the given id
+// alone. Everyone else in remote and localMap is left out first, so fixing one
+// member can't touch anybody else.
+func ReconcileMember(ctx context.Context, id int32, remote MemberSet, localMap MemberMap, u updater) error {
+	member := types.NewMemberSet()
+	for m := range remote.Iter() {
+		if m.Id == id {
+			member.Add(m)
+		}
+	}
+
+	local := make(MemberMap)
+	for accessId, m := range localMap {
+		if m.Id == id {
+			local[accessId] = m
+		}
+	}
+
+	return reconcile(ctx, member, local, u, 0)
+}
+
+func reconcile(ctx context.Context, remote MemberSet, localMap MemberMap, u updater, maxDisable int) error {
 	var err error
 
 	// This allows for quick extraction of the UniFi Access ID given the Member id
@@ -31,7 +83,7 @@ func Reconcile(remote MemberSet, localMap MemberMap, u updater) error {
 
 	local := types.NewMemberSet(slices.Collect(maps.Values(localMap))...)
 	if local.Equal(remote) {
-		log.Print("Nothing to do")
+		slog.Info("Nothing to do")
 		return nil
 	}
 
@@ -40,7 +92,7 @@ func Reconcile(remote MemberSet, localMap MemberMap, u updater) error {
 	update := make(MemberMap)
 
 	for m := range remote.Difference(local).Iter() {
-		log.Printf("diff: %v", m)
+		slog.Debug("Member differs", logging.Inline(m))
 		// We need to check for the id's in order to know if a member is missing
 		// or if it just needs updating.
 		_, present := localIds[m.Id]
@@ -53,18 +105,24 @@ func Reconcile(remote MemberSet, localMap MemberMap, u updater) error {
 	}
 
 	if len(update) > 0 {
-		log.Printf("Members to update: %d", len(update))
-		if err = u.Update(update); err != nil {
-			log.Printf("error updating members: %s", err)
+		slog.Info("Members to update", "count", len(update))
+		if err = u.Update(ctx, update); err != nil {
+			slog.Error("Error updating members", logging.Err(err))
 		}
 	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
 	if !add.IsEmpty() {
-		log.Printf("Members to add: %d", add.Cardinality())
-		if err = u.Add(add); err != nil {
-			log.Printf("error adding members: %s", err)
+		slog.Info("Members to add", "count", add.Cardinality())
+		if err = u.Add(ctx, add); err != nil {
+			slog.Error("Error adding members", logging.Err(err))
 		}
 	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
 	local = types.NewMemberSet(slices.Collect(maps.Values(localIds))...)
 	extra := local.Difference(remote)
@@ -75,13 +133,92 @@ func Reconcile(remote MemberSet, localMap MemberMap, u updater) error {
 				disable[idMapping[e.Id]] = e
 			}
 		}
+		if maxDisable > 0 && len(disable) > maxDisable {
+			return fmt.Errorf("%w: %d, limit is %d", ErrTooManyDisables, len(disable), maxDisable)
+		}
 		if len(disable) > 0 {
-			log.Printf("Members to disable: %d", extra.Cardinality())
-			if err = u.Disable(disable); err != nil {
-				log.Printf("error disabling members: %s", err)
+			slog.Info("Members to disable", "count", len(disable))
+			if err = u.Disable(ctx, disable); err != nil {
+				slog.Error("Error disabling members", logging.Err(err))
 			}
 		}
 	}
 
 	return err
 }
+
+// TimeoutError is returned when a stage of the sync runs out of time, to
+// tell a slow member source or UniFi Access apart from other failures.
+type TimeoutError struct {
+	Stage   string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.Stage, e.Timeout)
+}
+
+// Is makes errors.Is(err, context.DeadlineExceeded) hold for timeouts
+func (e *TimeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// Stage runs f with a context that's done after timeout, or when ctx is.
+// Running out of time is reported as a TimeoutError. A timeout of 0 means no
+// deadline other than ctx's.
+func Stage(ctx context.Context, name string, timeout time.Duration, f func(context.Context) error) error {
+	if timeout <= 0 {
+		return f(ctx)
+	}
+
+	stageCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Errors for running out of time vary, gRPC ones don't even wrap
+	// context.DeadlineExceeded, so it's the deadline that tells.
+	err := f(stageCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(stageCtx.Err(), context.DeadlineExceeded) {
+		return &TimeoutError{Stage: name, Timeout: timeout}
+	}
+
+	return err
+}
+
+// SkipCollisions finds the unmanaged users whose id is also used by a managed
+// user or a remote member. Remote members colliding that way, and not yet in
+// UniFi Access, are left out of the returned set so Reconcile doesn't create
+// a second user with the same id.
+func SkipCollisions(remote MemberSet, local MemberMap, unmanaged MemberMap) (MemberSet, MemberMap) {
+	localIds := make(map[int32]bool)
+	for _, m := range local {
+		localIds[m.Id] = true
+	}
+
+	remoteIds := types.ToIdMap(remote)
+	collisions := make(MemberMap)
+	skip := make(map[int32]bool)
+	for accessId, u := range unmanaged {
+		_, inRemote := remoteIds[u.Id]
+		if !inRemote && !localIds[u.Id] {
+			continue
+		}
+
+		collisions[accessId] = u
+		if inRemote && !localIds[u.Id] {
+			skip[u.Id] = true
+		}
+	}
+
+	if len(skip) == 0 {
+		return remote, collisions
+	}
+
+	filtered := types.NewMemberSet()
+	for m := range remote.Iter() {
+		if !skip[m.Id] {
+			filtered.Add(m)
+		}
+	}
+
+	return filtered, collisions
+}
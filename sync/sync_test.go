@@ -3,10 +3,12 @@ package sync
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/fatcatfablab/fcfl-member-sync/types"
 
@@ -61,7 +63,7 @@ type mockUpdater struct {
 	update  MemberMap
 }
 
-func (u *mockUpdater) Add(m MemberSet) error {
+func (u *mockUpdater) Add(_ context.Context, m MemberSet) error {
 	if u.add == nil && m != nil {
 		u.t.Errorf("unexpected add: %v", m)
 	}
@@ -71,7 +73,7 @@ func (u *mockUpdater) Add(m MemberSet) error {
 	return nil
 }
 
-func (u *mockUpdater) Disable(m MemberMap) error {
+func (u *mockUpdater) Disable(_ context.Context, m MemberMap) error {
 	if u.disable == nil && m != nil {
 		u.t.Errorf("unexpected disable: %v", m)
 	}
@@ -81,7 +83,7 @@ func (u *mockUpdater) Disable(m MemberMap) error {
 	return nil
 }
 
-func (u *mockUpdater) Update(m MemberMap) error {
+func (u *mockUpdater) Update(_ context.Context, m MemberMap) error {
 	if u.update == nil && m != nil {
 		u.t.Errorf("unexpected update: %v", m)
 	}
@@ -160,7 +162,7 @@ func (s *SQLiteUpdater) List() (MemberMap, error) {
 	return memberMap, nil
 }
 
-func (s *SQLiteUpdater) Add(m MemberSet) error {
+func (s *SQLiteUpdater) Add(_ context.Context, m MemberSet) error {
 	tx, err := s.db.BeginTx(context.Background(), nil)
 	if err != nil {
 		return fmt.Errorf("error starting tx: %w", err)
@@ -204,11 +206,11 @@ func (s *SQLiteUpdater) update(m MemberMap, status string) error {
 	return tx.Commit()
 }
 
-func (s *SQLiteUpdater) Update(m MemberMap) error {
+func (s *SQLiteUpdater) Update(_ context.Context, m MemberMap) error {
 	return s.update(m, "ACTIVE")
 }
 
-func (s *SQLiteUpdater) Disable(m MemberMap) error {
+func (s *SQLiteUpdater) Disable(_ context.Context, m MemberMap) error {
 	return s.update(m, "DEACTIVATED")
 }
 
@@ -271,7 +273,104 @@ func TestReconcile(t *testing.T) {
 		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
-			err := Reconcile(tt.remote, tt.local, &mockUpdater{
+			err := Reconcile(context.Background(), tt.remote, tt.local, &mockUpdater{
+				t:       t,
+				add:     tt.wantAdd,
+				disable: tt.wantDisable,
+				update:  tt.wantUpdate,
+			})
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestReconcileWithLimit(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		maxDisable  int
+		wantDisable MemberMap
+		wantErr     error
+	}{
+		{
+			name:        "Under the limit",
+			maxDisable:  2,
+			wantDisable: MemberMap{"uaid3": m3, "uaid4": m4},
+		},
+		{
+			name:       "Over the limit",
+			maxDisable: 1,
+			wantErr:    ErrTooManyDisables,
+		},
+		{
+			name:        "No limit",
+			wantDisable: MemberMap{"uaid3": m3, "uaid4": m4},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ReconcileWithLimit(
+				context.Background(),
+				types.NewMemberSet([]Member{m1, m2}...),
+				MemberMap{"uaid1": m1, "uaid2": m2, "uaid3": m3, "uaid4": m4},
+				&mockUpdater{t: t, disable: tt.wantDisable},
+				tt.maxDisable,
+			)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestReconcileMember(t *testing.T) {
+	// Everybody else is out of sync, which must not matter
+	local := MemberMap{"uaid2": {Id: 2, FirstName: "xx", Status: types.StatusActive}, "uaid3": m3}
+	for _, tt := range []struct {
+		name        string
+		id          int32
+		remote      MemberSet
+		local       MemberMap
+		wantAdd     MemberSet
+		wantDisable MemberMap
+		wantUpdate  MemberMap
+	}{
+		{
+			name:    "Member gets added",
+			id:      1,
+			remote:  types.NewMemberSet([]Member{m1, m2, m4}...),
+			local:   local,
+			wantAdd: types.NewMemberSet([]Member{m1}...),
+		},
+		{
+			name:       "Member gets updated",
+			id:         1,
+			remote:     types.NewMemberSet([]Member{m1, m2, m4}...),
+			local:      MemberMap{"uaid1": d1, "uaid2": local["uaid2"], "uaid3": m3},
+			wantUpdate: MemberMap{"uaid1": m1},
+		},
+		{
+			name:        "Member gets disabled",
+			id:          1,
+			remote:      types.NewMemberSet([]Member{m2, m4}...),
+			local:       MemberMap{"uaid1": m1, "uaid2": local["uaid2"], "uaid3": m3},
+			wantDisable: MemberMap{"uaid1": m1},
+		},
+		{
+			name:   "Nothing to do",
+			id:     1,
+			remote: types.NewMemberSet([]Member{m1, m2, m4}...),
+			local:  MemberMap{"uaid1": m1, "uaid2": local["uaid2"], "uaid3": m3},
+		},
+		{
+			name:   "Unknown member",
+			id:     5,
+			remote: types.NewMemberSet([]Member{m1, m2, m4}...),
+			local:  local,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ReconcileMember(context.Background(), tt.id, tt.remote, tt.local, &mockUpdater{
 				t:       t,
 				add:     tt.wantAdd,
 				disable: tt.wantDisable,
@@ -345,7 +444,7 @@ func TestReconcileSQLite(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			if err = Reconcile(tt.remote, local, u); err != nil {
+			if err = Reconcile(context.Background(), tt.remote, local, u); err != nil {
 				t.Fatal(err)
 			}
 
@@ -379,7 +478,7 @@ func TestDisableThenEnable(t *testing.T) {
 
 	t.Run("Disable member", func(t *testing.T) {
 		remote := types.NewMemberSet()
-		err = Reconcile(remote, local, u)
+		err = Reconcile(context.Background(), remote, local, u)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -405,7 +504,7 @@ func TestDisableThenEnable(t *testing.T) {
 			t.Fatal(err)
 		}
 		remote := types.NewMemberSet([]Member{m1}...)
-		if err := Reconcile(remote, local, u); err != nil {
+		if err := Reconcile(context.Background(), remote, local, u); err != nil {
 			t.Fatal(err)
 		}
 
@@ -423,3 +522,114 @@ func TestDisableThenEnable(t *testing.T) {
 		}
 	})
 }
+
+func TestSkipCollisions(t *testing.T) {
+	staff := Member{FirstName: "staff", Id: 3, Status: types.StatusActive}
+	for _, tt := range []struct {
+		name           string
+		remote         MemberSet
+		local          MemberMap
+		unmanaged      MemberMap
+		wantRemote     MemberSet
+		wantCollisions MemberMap
+	}{
+		{
+			name:           "No collisions",
+			remote:         types.NewMemberSet(m1, m2),
+			local:          MemberMap{"uaid1": m1},
+			unmanaged:      MemberMap{"staff": {FirstName: "staff", Id: 900}},
+			wantRemote:     types.NewMemberSet(m1, m2),
+			wantCollisions: MemberMap{},
+		},
+		{
+			name:           "Unmanaged user collides with member to add",
+			remote:         types.NewMemberSet(m1, m3),
+			local:          MemberMap{"uaid1": m1},
+			unmanaged:      MemberMap{"staff": staff},
+			wantRemote:     types.NewMemberSet(m1),
+			wantCollisions: MemberMap{"staff": staff},
+		},
+		{
+			name:           "Unmanaged user collides with managed user",
+			remote:         types.NewMemberSet(m1, m3),
+			local:          MemberMap{"uaid1": m1, "uaid3": m3},
+			unmanaged:      MemberMap{"staff": staff},
+			wantRemote:     types.NewMemberSet(m1, m3),
+			wantCollisions: MemberMap{"staff": staff},
+		},
+		{
+			name:           "Unmanaged user collides with former member",
+			remote:         types.NewMemberSet(m1),
+			local:          MemberMap{"uaid1": m1, "uaid3": m3},
+			unmanaged:      MemberMap{"staff": staff},
+			wantRemote:     types.NewMemberSet(m1),
+			wantCollisions: MemberMap{"staff": staff},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			remote, collisions := SkipCollisions(tt.remote, tt.local, tt.unmanaged)
+			if !remote.Equal(tt.wantRemote) {
+				t.Errorf("unexpected remote set: %v", remote)
+			}
+			if !types.Equal(collisions, tt.wantCollisions) {
+				t.Errorf("unexpected collisions: %v", collisions)
+			}
+		})
+	}
+}
+
+func TestReconcileCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Updating fails because of the cancelled context, and nothing else is
+	// attempted afterwards.
+	err := Reconcile(
+		ctx,
+		types.NewMemberSet([]Member{m1, m2, {Id: 3, FirstName: "xx", Status: types.StatusActive}}...),
+		MemberMap{"uaid3": m3, "uaid4": m4},
+		&mockUpdater{t: t, update: MemberMap{"uaid3": {Id: 3, FirstName: "xx", Status: types.StatusActive}}},
+	)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+}
+
+func TestStage(t *testing.T) {
+	slow := func(ctx context.Context) error {
+		<-ctx.Done()
+		return fmt.Errorf("rpc error: code = DeadlineExceeded")
+	}
+
+	err := Stage(context.Background(), "member source", time.Millisecond, slow)
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) || timeout.Stage != "member source" {
+		t.Errorf("want a member source TimeoutError, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("timeouts should be context.DeadlineExceeded")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = Stage(ctx, "member source", time.Hour, slow)
+	if errors.As(err, &timeout) {
+		t.Errorf("cancelling isn't a timeout, got %v", err)
+	}
+
+	want := errors.New("boom")
+	err = Stage(context.Background(), "member source", time.Hour, func(context.Context) error { return want })
+	if err != want {
+		t.Errorf("want errors passed through, got %v", err)
+	}
+
+	err = Stage(context.Background(), "member source", 0, func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); ok {
+			return errors.New("unexpected deadline")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
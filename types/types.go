@@ -1,6 +1,13 @@
 package types
 
-import mapset "github.com/deckarep/golang-set/v2"
+import (
+	"log/slog"
+
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/miquelruiz/go-unifi-access-api/schema"
+)
 
 const (
 	StatusActive      = "ACTIVE"
@@ -18,6 +25,17 @@ type (
 	MemberMap = map[string]ComparableMember
 )
 
+// LogValue makes slog log members with the shared attribute keys, so their
+// names can be redacted.
+func (m ComparableMember) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int(logging.KeyMemberId, int(m.Id)),
+		slog.String(logging.KeyFirstName, m.FirstName),
+		slog.String(logging.KeyLastName, m.LastName),
+		slog.String("status", m.Status),
+	)
+}
+
 func ToIdMap(ms MemberSet) map[int32]ComparableMember {
 	idmap := make(map[int32]ComparableMember)
 	for m := range ms.Iter() {
@@ -43,3 +61,54 @@ func Equal(a, b MemberMap) bool {
 
 	return true
 }
+
+const (
+	InvitationSent    = "sent"
+	InvitationFailed  = "failed"
+	InvitationRevoked = "revoked"
+)
+
+// Invitation tracks the UniFi Access mobile credential invitation sent to a
+// member.
+type Invitation struct {
+	MemberId int32
+	AccessId string
+	Email    string
+	Status   string
+	SentAt   int64
+}
+
+// Visit is a door opening by a member, as recorded by UniFi Access.
+type Visit struct {
+	EventId   string
+	MemberId  int32
+	AccessId  string
+	Door      string
+	Timestamp int64
+}
+
+// Deactivation records since when a UniFi Access user has been deactivated,
+// since UniFi Access doesn't keep track of it.
+type Deactivation struct {
+	AccessId string
+	MemberId int32
+	Since    int64
+}
+
+// ArchivedUser is a UniFi Access user deleted after being deactivated for too
+// long. It keeps everything needed to recreate the user.
+type ArchivedUser struct {
+	MemberId      int32
+	AccessId      string
+	DeactivatedAt int64
+	ArchivedAt    int64
+	User          schema.UserResponse
+}
+
+// SyncResult is the outcome of the last sync run. Error is empty when it
+// succeeded.
+type SyncResult struct {
+	FinishedAt int64
+	Error      string
+	Failures   int
+}
@@ -0,0 +1,312 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	ua "github.com/miquelruiz/go-unifi-access-api"
+	"github.com/miquelruiz/go-unifi-access-api/schema"
+)
+
+// API talks to the UniFi Access developer endpoints that go-unifi-access-api
+// doesn't cover yet. It mirrors the way that client builds its requests, and
+// wraps the ones it does cover so every request can be cancelled.
+type API struct {
+	token      string
+	baseUrl    url.URL
+	httpClient ua.HttpClient
+}
+
+type invitationRequest struct {
+	UserId string `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+type revocationRequest struct {
+	UserId string `json:"user_id"`
+}
+
+func NewAPI(host string, token string, httpClient ua.HttpClient) (*API, error) {
+	baseUrl, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &API{
+		token:      token,
+		baseUrl:    *baseUrl,
+		httpClient: httpClient,
+	}, nil
+}
+
+// SendInvitation asks UniFi Access to email the user an invitation to set up
+// their mobile credential through UniFi Identity.
+func (a *API) SendInvitation(ctx context.Context, accessId string, email string) error {
+	_, err := doRequest[any](
+		ctx,
+		a,
+		http.MethodPost,
+		"/api/v1/developer/users/identity/invitations",
+		"",
+		[]invitationRequest{{UserId: accessId, Email: email}},
+	)
+	return err
+}
+
+// RevokeInvitation asks UniFi Access to revoke the mobile credential
+// invitation sent to the user, so it can't be redeemed anymore.
+func (a *API) RevokeInvitation(ctx context.Context, accessId string) error {
+	_, err := doRequest[any](
+		ctx,
+		a,
+		http.MethodDelete,
+		"/api/v1/developer/users/identity/invitations",
+		"",
+		[]revocationRequest{{UserId: accessId}},
+	)
+	return err
+}
+
+// contextClient binds requests to a context, for go-unifi-access-api's
+// methods that don't take one.
+type contextClient struct {
+	ctx    context.Context
+	client ua.HttpClient
+}
+
+func (c contextClient) Do(req *http.Request) (*http.Response, error) {
+	return c.client.Do(req.WithContext(c.ctx))
+}
+
+// client returns a go-unifi-access-api client whose requests are cancelled
+// along with ctx.
+func (a *API) client(ctx context.Context) *ua.Client {
+	// The url was already parsed by NewAPI, so this can't fail
+	c, _ := ua.NewWithHttpClient(a.baseUrl.String(), a.token, contextClient{ctx: ctx, client: a.httpClient})
+	return c
+}
+
+func (a *API) ListUsers(ctx context.Context) ([]schema.UserResponse, error) {
+	return a.client(ctx).ListUsers()
+}
+
+func (a *API) GetUser(ctx context.Context, accessId string) (*schema.UserResponse, error) {
+	return a.client(ctx).GetUser(accessId)
+}
+
+func (a *API) CreateUser(ctx context.Context, r schema.UserRequest) (*schema.UserResponse, error) {
+	return a.client(ctx).CreateUser(r)
+}
+
+func (a *API) UpdateUser(ctx context.Context, accessId string, r schema.UserRequest) error {
+	return a.client(ctx).UpdateUser(accessId, r)
+}
+
+func doRequest[T any](ctx context.Context, a *API, method string, path string, rawQuery string, body any) (*T, error) {
+	var reader io.Reader
+	if body != nil {
+		buffer := bytes.NewBuffer(make([]byte, 0))
+		if err := json.NewEncoder(buffer).Encode(body); err != nil {
+			return nil, err
+		}
+		reader = buffer
+	}
+
+	u := a.baseUrl
+	u.Path = path
+	u.RawQuery = rawQuery
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	rawresp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rawresp.Body.Close()
+
+	if rawresp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("endpoint returned %s", rawresp.Status)
+	}
+
+	var resp schema.Response[T]
+	if err := json.NewDecoder(rawresp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("error parsing response: %w", err)
+	}
+
+	if resp.Code != schema.ResponseSuccess {
+		return nil, errors.New(resp.Code + ": " + resp.Msg)
+	}
+
+	return &resp.Data, nil
+}
+
+const (
+	doorOpeningsTopic = "door_openings"
+	accessGranted     = "ACCESS"
+	logsPageSize      = 100
+)
+
+// DoorEvent is a door opening taken from the UniFi Access system logs.
+type DoorEvent struct {
+	Id        string
+	Timestamp int64
+	ActorId   string
+	ActorName string
+	Door      string
+	Granted   bool
+}
+
+type systemLogsRequest struct {
+	Topic string `json:"topic"`
+	Since int64  `json:"since,omitempty"`
+	Until int64  `json:"until,omitempty"`
+}
+
+type systemLogs struct {
+	Hits []struct {
+		Id     string `json:"_id"`
+		Source struct {
+			Actor struct {
+				Id          string `json:"id"`
+				DisplayName string `json:"display_name"`
+				Type        string `json:"type"`
+			} `json:"actor"`
+			Event struct {
+				Published int64  `json:"published"`
+				Result    string `json:"result"`
+				Type      string `json:"type"`
+			} `json:"event"`
+			Target []struct {
+				Id          string `json:"id"`
+				DisplayName string `json:"display_name"`
+				Type        string `json:"type"`
+			} `json:"target"`
+		} `json:"_source"`
+	} `json:"hits"`
+}
+
+// DoorOpenings pages through the system logs and returns every door opening
+// between since and until, both unix timestamps.
+func (a *API) DoorOpenings(ctx context.Context, since int64, until int64) ([]DoorEvent, error) {
+	var events []DoorEvent
+	for page := 1; ; page++ {
+		logs, err := doRequest[systemLogs](
+			ctx,
+			a,
+			http.MethodPost,
+			"/api/v1/developer/system/logs",
+			fmt.Sprintf("page_num=%d&page_size=%d", page, logsPageSize),
+			systemLogsRequest{Topic: doorOpeningsTopic, Since: since, Until: until},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching system logs page %d: %w", page, err)
+		}
+
+		for _, h := range logs.Hits {
+			e := DoorEvent{
+				Id:        h.Id,
+				Timestamp: h.Source.Event.Published / 1000,
+				ActorId:   h.Source.Actor.Id,
+				ActorName: h.Source.Actor.DisplayName,
+				Granted:   h.Source.Event.Result == accessGranted,
+			}
+			for _, t := range h.Source.Target {
+				if t.Type == "door" {
+					e.Door = t.DisplayName
+					break
+				}
+			}
+			events = append(events, e)
+		}
+
+		if len(logs.Hits) < logsPageSize {
+			return events, nil
+		}
+	}
+}
+
+type nfcCardRequest struct {
+	Token    string `json:"token"`
+	ForceAdd bool   `json:"force_add"`
+}
+
+type accessPoliciesRequest struct {
+	AccessPolicyIds []string `json:"access_policy_ids"`
+}
+
+// DeleteUser removes the user from UniFi Access for good
+func (a *API) DeleteUser(ctx context.Context, accessId string) error {
+	_, err := doRequest[any](ctx, a, http.MethodDelete, "/api/v1/developer/users/"+accessId, "", nil)
+	return err
+}
+
+func (a *API) AssignNfcCard(ctx context.Context, accessId string, token string) error {
+	_, err := doRequest[any](
+		ctx,
+		a,
+		http.MethodPut,
+		"/api/v1/developer/users/"+accessId+"/nfc_cards",
+		"",
+		nfcCardRequest{Token: token, ForceAdd: true},
+	)
+	return err
+}
+
+func (a *API) AssignAccessPolicies(ctx context.Context, accessId string, policyIds []string) error {
+	_, err := doRequest[any](
+		ctx,
+		a,
+		http.MethodPut,
+		"/api/v1/developer/users/"+accessId+"/access_policies",
+		"",
+		accessPoliciesRequest{AccessPolicyIds: policyIds},
+	)
+	return err
+}
+
+// UserGroupUsers returns the ids of every user in the group, including the
+// ones in its subgroups.
+func (a *API) UserGroupUsers(ctx context.Context, groupId string) ([]string, error) {
+	users, err := doRequest[[]schema.UserResponse](
+		ctx,
+		a,
+		http.MethodGet,
+		"/api/v1/developer/user_groups/"+groupId+"/users/all",
+		"",
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(*users))
+	for _, u := range *users {
+		ids = append(ids, u.Id)
+	}
+	return ids, nil
+}
+
+func (a *API) AddUserToGroup(ctx context.Context, groupId string, accessId string) error {
+	_, err := doRequest[any](
+		ctx,
+		a,
+		http.MethodPost,
+		"/api/v1/developer/user_groups/"+groupId+"/users",
+		"",
+		[]string{accessId},
+	)
+	return err
+}
@@ -0,0 +1,134 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+)
+
+// InvitationStore keeps track of the mobile credential invitations sent to
+// each member.
+type InvitationStore interface {
+	SaveInvitation(ctx context.Context, inv types.Invitation) error
+	// FindInvitation returns nil without error when the member was never invited
+	FindInvitation(ctx context.Context, memberId int32) (*types.Invitation, error)
+}
+
+// EnableInvitations makes the updater send a UniFi Access mobile credential
+// invitation to every member it adds, as long as it knows their email.
+func (u *UAUpdater) EnableInvitations(store InvitationStore) {
+	u.invitations = store
+}
+
+// SetEmails provides the email addresses invitations are sent to, indexed by
+// member id.
+func (u *UAUpdater) SetEmails(emails map[int32]string) {
+	u.emails = emails
+}
+
+// InviteMember sends a mobile credential invitation to the UniFi Access user
+// with the given id and records the outcome. It's a no-op when invitations
+// aren't enabled.
+func (u *UAUpdater) InviteMember(ctx context.Context, accessId string, m member, email string) error {
+	if u.invitations == nil {
+		return nil
+	}
+
+	if email == "" {
+		return fmt.Errorf("no email to invite member %d", m.Id)
+	}
+
+	slog.Info(
+		"Inviting member to set up a mobile credential",
+		logging.Inline(m),
+		logging.KeyAccessId, accessId,
+		logging.KeyEmail, email,
+		logging.KeyDryRun, u.dryRun,
+	)
+
+	if u.dryRun {
+		return nil
+	}
+
+	inv := types.Invitation{
+		MemberId: m.Id,
+		AccessId: accessId,
+		Email:    email,
+		Status:   types.InvitationSent,
+		SentAt:   time.Now().Unix(),
+	}
+
+	err := u.api.SendInvitation(ctx, accessId, email)
+	if err != nil {
+		inv.Status = types.InvitationFailed
+	}
+	u.record(ctx, m, audit.ActionUAInvite, "", email, err)
+
+	if serr := u.invitations.SaveInvitation(ctx, inv); serr != nil {
+		return errors.Join(err, fmt.Errorf("error saving invitation: %w", serr))
+	}
+
+	return err
+}
+
+// revokeInvitation revokes the member's mobile credential invitation in
+// UniFi Access, since deactivating the user doesn't stop an invitation that
+// wasn't redeemed yet from being redeemed, and only then marks it as revoked.
+func (u *UAUpdater) revokeInvitation(ctx context.Context, m member) error {
+	if u.invitations == nil || u.dryRun {
+		return nil
+	}
+
+	inv, err := u.invitations.FindInvitation(ctx, m.Id)
+	if err != nil {
+		return fmt.Errorf("error finding invitation for member %d: %w", m.Id, err)
+	}
+
+	if inv == nil || inv.Status == types.InvitationRevoked {
+		return nil
+	}
+
+	slog.Info("Revoking mobile credential", logging.Inline(m), logging.KeyAccessId, inv.AccessId)
+	// Failed invitations never reached UniFi Access, but are still marked so
+	// they're sent again if the member comes back
+	if inv.Status == types.InvitationSent {
+		err = u.api.RevokeInvitation(ctx, inv.AccessId)
+		u.record(ctx, m, audit.ActionUARevoke, inv.Email, "", err)
+		if err != nil {
+			return fmt.Errorf("error revoking invitation of member %d: %w", m.Id, err)
+		}
+	}
+
+	inv.Status = types.InvitationRevoked
+	return u.invitations.SaveInvitation(ctx, *inv)
+}
+
+// restoreInvitation re-sends the invitation of a member whose mobile
+// credential was revoked when they got disabled.
+func (u *UAUpdater) restoreInvitation(ctx context.Context, accessId string, m member) error {
+	if u.invitations == nil {
+		return nil
+	}
+
+	inv, err := u.invitations.FindInvitation(ctx, m.Id)
+	if err != nil {
+		return fmt.Errorf("error finding invitation for member %d: %w", m.Id, err)
+	}
+
+	if inv == nil || inv.Status != types.InvitationRevoked {
+		return nil
+	}
+
+	email := inv.Email
+	if e, ok := u.emails[m.Id]; ok && e != "" {
+		email = e
+	}
+
+	return u.InviteMember(ctx, accessId, m, email)
+}
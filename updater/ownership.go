@@ -0,0 +1,125 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miquelruiz/go-unifi-access-api/schema"
+)
+
+// Ownership tells which UniFi Access users are managed by the sync. Users
+// that aren't managed are never listed, so nothing ever touches them.
+type Ownership interface {
+	// Refresh is called before every listing of users
+	Refresh(ctx context.Context) error
+	Owns(user schema.UserResponse, id int32) bool
+	// Accepts tells whether a new user with the given employee number would be
+	// managed. Members it doesn't accept are never added, since the sync
+	// couldn't update or disable them afterwards.
+	Accepts(id int32) bool
+	// Claim marks a newly created user as managed. When it fails the user must
+	// not be left behind, since it would collide with the member id forever.
+	Claim(ctx context.Context, accessId string) error
+}
+
+// AnyIdOwnership considers managed every user with a numeric employee number.
+// It's the default, kept for backwards compatibility.
+type AnyIdOwnership struct{}
+
+func (AnyIdOwnership) Refresh(_ context.Context) error          { return nil }
+func (AnyIdOwnership) Owns(_ schema.UserResponse, _ int32) bool { return true }
+func (AnyIdOwnership) Accepts(_ int32) bool                     { return true }
+func (AnyIdOwnership) Claim(_ context.Context, _ string) error  { return nil }
+
+// IdRangeOwnership considers managed the users whose employee number falls
+// within [Min, Max].
+type IdRangeOwnership struct {
+	Min int32
+	Max int32
+}
+
+// ParseIdRange parses ranges like "1000-99999"
+func ParseIdRange(s string) (*IdRangeOwnership, error) {
+	lo, hi, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("malformed id range %q", s)
+	}
+
+	min, err := strconv.ParseInt(strings.TrimSpace(lo), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("malformed id range %q: %w", s, err)
+	}
+
+	max, err := strconv.ParseInt(strings.TrimSpace(hi), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("malformed id range %q: %w", s, err)
+	}
+
+	if min > max {
+		return nil, fmt.Errorf("empty id range %q", s)
+	}
+
+	return &IdRangeOwnership{Min: int32(min), Max: int32(max)}, nil
+}
+
+func (o *IdRangeOwnership) Refresh(_ context.Context) error { return nil }
+
+func (o *IdRangeOwnership) Owns(_ schema.UserResponse, id int32) bool {
+	return id >= o.Min && id <= o.Max
+}
+
+func (o *IdRangeOwnership) Accepts(id int32) bool {
+	return o.Owns(schema.UserResponse{}, id)
+}
+
+func (o *IdRangeOwnership) Claim(_ context.Context, _ string) error { return nil }
+
+// GroupOwnership considers managed the users in a UniFi Access user group
+type GroupOwnership struct {
+	api     *API
+	groupId string
+	members map[string]bool
+}
+
+func NewGroupOwnership(api *API, groupId string) *GroupOwnership {
+	return &GroupOwnership{api: api, groupId: groupId, members: make(map[string]bool)}
+}
+
+func (o *GroupOwnership) Refresh(ctx context.Context) error {
+	ids, err := o.api.UserGroupUsers(ctx, o.groupId)
+	if err != nil {
+		return fmt.Errorf("error listing users in group %q: %w", o.groupId, err)
+	}
+
+	o.members = make(map[string]bool)
+	for _, id := range ids {
+		o.members[id] = true
+	}
+	return nil
+}
+
+func (o *GroupOwnership) Owns(user schema.UserResponse, _ int32) bool {
+	return o.members[user.Id]
+}
+
+// Accepts returns true, since Claim adds every new user to the group
+func (o *GroupOwnership) Accepts(_ int32) bool {
+	return true
+}
+
+func (o *GroupOwnership) Claim(ctx context.Context, accessId string) error {
+	err := o.api.AddUserToGroup(ctx, o.groupId, accessId)
+	if err == nil {
+		o.members[accessId] = true
+		return nil
+	}
+
+	err = fmt.Errorf("error adding user %q to group %q: %w", accessId, o.groupId, err)
+	if derr := o.api.DeleteUser(ctx, accessId); derr != nil {
+		return errors.Join(err, fmt.Errorf("error deleting unclaimed user: %w", derr))
+	}
+	return err
+}
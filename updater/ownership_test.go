@@ -0,0 +1,44 @@
+package updater
+
+import (
+	"testing"
+
+	"github.com/miquelruiz/go-unifi-access-api/schema"
+)
+
+func TestParseIdRange(t *testing.T) {
+	for _, tt := range []struct {
+		input      string
+		shouldFail bool
+		owns       []int32
+		notOwns    []int32
+	}{
+		{input: "1-99999", owns: []int32{1, 500, 99999}, notOwns: []int32{0, 100000}},
+		{input: " 10 - 20 ", owns: []int32{10, 20}, notOwns: []int32{9, 21}},
+		{input: "5-5", owns: []int32{5}, notOwns: []int32{4, 6}},
+		{input: "20-10", shouldFail: true},
+		{input: "10", shouldFail: true},
+		{input: "a-b", shouldFail: true},
+	} {
+		t.Run(tt.input, func(t *testing.T) {
+			o, err := ParseIdRange(tt.input)
+			if (err != nil) != tt.shouldFail {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.shouldFail {
+				return
+			}
+
+			for _, id := range tt.owns {
+				if !o.Owns(schema.UserResponse{}, id) {
+					t.Errorf("%d should be owned", id)
+				}
+			}
+			for _, id := range tt.notOwns {
+				if o.Owns(schema.UserResponse{}, id) {
+					t.Errorf("%d shouldn't be owned", id)
+				}
+			}
+		})
+	}
+}
@@ -1,12 +1,17 @@
 package updater
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"strconv"
+	"strings"
 
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/logging"
+	"github.com/fatcatfablab/fcfl-member-sync/metrics"
+	"github.com/fatcatfablab/fcfl-member-sync/notify"
 	"github.com/fatcatfablab/fcfl-member-sync/types"
-	ua "github.com/miquelruiz/go-unifi-access-api"
 	"github.com/miquelruiz/go-unifi-access-api/schema"
 )
 
@@ -22,44 +27,110 @@ type (
 )
 
 type UAUpdater struct {
-	uaClient *ua.Client
-	dryRun   bool
+	api         *API
+	invitations InvitationStore
+	emails      map[int32]string
+	ownership   Ownership
+	unmanaged   memberMap
+	notifier    *notify.Notifier
+	history     audit.Recorder
+	dryRun      bool
 }
 
-func New(uaClient *ua.Client, dryRun bool) *UAUpdater {
-	return &UAUpdater{uaClient: uaClient, dryRun: dryRun}
+func New(api *API, dryRun bool) *UAUpdater {
+	return &UAUpdater{api: api, ownership: AnyIdOwnership{}, dryRun: dryRun}
 }
 
-func (u *UAUpdater) List() (memberMap, error) {
-	users, err := u.uaClient.ListUsers()
+// SetOwnership changes how the updater tells the users it manages apart
+func (u *UAUpdater) SetOwnership(o Ownership) {
+	u.ownership = o
+}
+
+// SetNotifier makes the updater report the members it adds and disables.
+// Nothing is reported in dry-run mode.
+func (u *UAUpdater) SetNotifier(n *notify.Notifier) {
+	u.notifier = n
+}
+
+// SetHistory makes the updater record every UniFi Access change it makes in
+// the history of the member. Nothing is recorded in dry-run mode.
+func (u *UAUpdater) SetHistory(r audit.Recorder) {
+	u.history = r
+}
+
+// Unmanaged returns the users with a numeric employee number that the last
+// call to List skipped because they aren't managed by the sync.
+func (u *UAUpdater) Unmanaged() memberMap {
+	return u.unmanaged
+}
+
+func (u *UAUpdater) List(ctx context.Context) (memberMap, error) {
+	if err := u.ownership.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	users, err := u.api.ListUsers(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	members := make(map[string]member)
+	u.unmanaged = make(map[string]member)
 	for _, user := range users {
 		id, err := strconv.ParseInt(user.EmployeeNumber, 0, 32)
 		if err != nil {
-			log.Printf("skipping user without EmployeeNumber: %s", user.FullName)
+			slog.Warn(
+				"Skipping user without EmployeeNumber",
+				logging.KeyAccessId, user.Id,
+				logging.KeyName, user.FullName,
+			)
 			continue
 		}
-		members[user.Id] = member{
+		m := member{
 			FirstName: user.FirstName,
 			LastName:  user.LastName,
 			Id:        int32(id),
 			Status:    user.Status,
 		}
+		if !u.ownership.Owns(user, m.Id) {
+			u.unmanaged[user.Id] = m
+			continue
+		}
+		members[user.Id] = m
 	}
 
 	return members, nil
 }
 
-func (u *UAUpdater) Add(members memberSet) error {
+// Member returns the managed users with the given employee number. UniFi
+// Access can't search users by employee number, so this still lists them all.
+func (u *UAUpdater) Member(ctx context.Context, id int32) (memberMap, error) {
+	all, err := u.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(memberMap)
+	for accessId, m := range all {
+		if m.Id == id {
+			members[accessId] = m
+		}
+	}
+
+	return members, nil
+}
+
+// Add adds every member, stopping early if ctx is done
+func (u *UAUpdater) Add(ctx context.Context, members memberSet) error {
 	var err, reterror error
 	for m := range members.Iter() {
-		_, err = u.AddMember(m)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		_, err = u.AddMember(ctx, m)
+		metrics.MemberChanges.WithLabelValues("add", metrics.Result(err)).Inc()
 		if err != nil {
-			log.Printf("Skipping due to failure: %v", err)
+			slog.Error("Skipping due to failure", logging.Inline(m), logging.Err(err))
 			reterror = err
 		}
 	}
@@ -67,38 +138,58 @@ func (u *UAUpdater) Add(members memberSet) error {
 	return reterror
 }
 
-func (u *UAUpdater) AddMember(m member) (string, error) {
+func (u *UAUpdater) AddMember(ctx context.Context, m member) (string, error) {
 	var err error
 	var accessId string
 
-	msg := "Adding member %v"
-	if u.dryRun {
-		msg = "[DRY-RUN] " + msg
+	if !u.ownership.Accepts(m.Id) {
+		return "", fmt.Errorf("member %d is outside the users managed by the sync", m.Id)
 	}
-	log.Printf(msg, m)
+
+	slog.Info("Adding member", logging.Inline(m), logging.KeyAction, "add", logging.KeyDryRun, u.dryRun)
 
 	id := fmt.Sprintf("%d", m.Id)
 	if !u.dryRun {
-		r, err := u.uaClient.CreateUser(schema.UserRequest{
+		r, err := u.api.CreateUser(ctx, schema.UserRequest{
 			FirstName:      m.FirstName,
 			LastName:       m.LastName,
 			EmployeeNumber: &id,
 		})
+		if err != nil {
+			u.record(ctx, m, audit.ActionUAAdd, "", fullName(m), err)
+			return "", err
+		}
 		accessId = r.Id
+
+		err = u.ownership.Claim(ctx, accessId)
+		u.record(ctx, m, audit.ActionUAAdd, "", fmt.Sprintf("%s (%s)", fullName(m), accessId), err)
 		if err != nil {
 			return "", err
 		}
+
+		u.notify(notify.ClassMemberAdded, m, "Added member")
+	}
+
+	if email := u.emails[m.Id]; email != "" {
+		if err := u.InviteMember(ctx, accessId, m, email); err != nil {
+			slog.Error("Error inviting member", logging.KeyMemberId, m.Id, logging.Err(err))
+		}
 	}
 
 	return accessId, err
 }
 
-func (u *UAUpdater) Disable(members memberMap) error {
+// Disable disables every member, stopping early if ctx is done
+func (u *UAUpdater) Disable(ctx context.Context, members memberMap) error {
 	var err, reterror error
 	for id, m := range members {
-		err = u.DisableMember(id, m)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err = u.DisableMember(ctx, id, m)
+		metrics.MemberChanges.WithLabelValues("disable", metrics.Result(err)).Inc()
 		if err != nil {
-			log.Printf("error disabling member: %s", err)
+			slog.Error("Error disabling member", logging.Inline(m), logging.KeyAccessId, id, logging.Err(err))
 			reterror = err
 		}
 	}
@@ -106,31 +197,49 @@ func (u *UAUpdater) Disable(members memberMap) error {
 	return reterror
 }
 
-func (u *UAUpdater) DisableMember(id string, m member) error {
+func (u *UAUpdater) DisableMember(ctx context.Context, id string, m member) error {
 	var err error
 
-	msg := "Disabling member %v"
-	if u.dryRun {
-		msg = "[DRY-RUN] " + msg
-	}
-	log.Printf(msg, m)
+	slog.Info(
+		"Disabling member",
+		logging.Inline(m),
+		logging.KeyAccessId, id,
+		logging.KeyAction, "disable",
+		logging.KeyDryRun, u.dryRun,
+	)
 
 	if !u.dryRun {
-		err = u.uaClient.UpdateUser(id, schema.UserRequest{
+		err = u.api.UpdateUser(ctx, id, schema.UserRequest{
 			FirstName: m.FirstName,
 			LastName:  m.LastName,
 			Status:    &deactivated,
 		})
+		u.record(ctx, m, audit.ActionUADisable, "", deactivated, err)
+	}
+
+	if err == nil {
+		if !u.dryRun {
+			u.notify(notify.ClassMemberDisabled, m, "Disabled member")
+		}
+		if err := u.revokeInvitation(ctx, m); err != nil {
+			slog.Error("Error revoking invitation", logging.KeyMemberId, m.Id, logging.Err(err))
+		}
 	}
 
 	return err
 }
 
-func (u *UAUpdater) Update(members memberMap) error {
+// Update updates every member, stopping early if ctx is done
+func (u *UAUpdater) Update(ctx context.Context, members memberMap) error {
 	var err, reterror error
 	for id, m := range members {
-		if err = u.UpdateMember(id, m); err != nil {
-			log.Printf("error updating member: %s", err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err = u.UpdateMember(ctx, id, m)
+		metrics.MemberChanges.WithLabelValues("update", metrics.Result(err)).Inc()
+		if err != nil {
+			slog.Error("Error updating member", logging.Inline(m), logging.KeyAccessId, id, logging.Err(err))
 			reterror = err
 		}
 	}
@@ -138,24 +247,88 @@ func (u *UAUpdater) Update(members memberMap) error {
 	return reterror
 }
 
-func (u *UAUpdater) UpdateMember(id string, m member) error {
+func (u *UAUpdater) UpdateMember(ctx context.Context, id string, m member) error {
 	var err error
 
-	msg := "Updating member %v"
-	if u.dryRun {
-		msg = "[DRY-RUN] " + msg
-	}
-	log.Printf(msg, m)
+	slog.Info(
+		"Updating member",
+		logging.Inline(m),
+		logging.KeyAccessId, id,
+		logging.KeyAction, "update",
+		logging.KeyDryRun, u.dryRun,
+	)
 
 	employeeNumber := fmt.Sprintf("%d", m.Id)
 	if !u.dryRun {
-		err = u.uaClient.UpdateUser(id, schema.UserRequest{
+		err = u.api.UpdateUser(ctx, id, schema.UserRequest{
 			FirstName:      m.FirstName,
 			LastName:       m.LastName,
 			EmployeeNumber: &employeeNumber,
 			Status:         &active,
 		})
+		u.record(ctx, m, audit.ActionUAUpdate, "", fmt.Sprintf("%s, %s", fullName(m), active), err)
+	}
+
+	if err == nil {
+		if err := u.restoreInvitation(ctx, id, m); err != nil {
+			slog.Error("Error restoring invitation", logging.KeyMemberId, m.Id, logging.Err(err))
+		}
 	}
 
 	return err
 }
+
+// AssignPolicies replaces the access policies of the user with the given id
+func (u *UAUpdater) AssignPolicies(ctx context.Context, id string, m member, policyIds []string) error {
+	slog.Info(
+		"Assigning access policies",
+		logging.Inline(m),
+		logging.KeyAccessId, id,
+		"policies", policyIds,
+		logging.KeyDryRun, u.dryRun,
+	)
+
+	if u.dryRun {
+		return nil
+	}
+
+	err := u.api.AssignAccessPolicies(ctx, id, policyIds)
+	u.record(ctx, m, audit.ActionUAPolicy, "", strings.Join(policyIds, ","), err)
+	return err
+}
+
+func (u *UAUpdater) notify(class notify.Class, m member, action string) {
+	name := fullName(m)
+	u.notifier.Notify(notify.Event{
+		Class:    class,
+		MemberId: m.Id,
+		Name:     name,
+		Message:  fmt.Sprintf("%s %d (%s)", action, m.Id, name),
+	})
+}
+
+// record adds a change to the history of the member. Changes to a dependent
+// go to the history of their primary member instead, telling which dependent
+// they were made to. Failing to record it doesn't fail the change, which
+// already happened.
+func (u *UAUpdater) record(ctx context.Context, m member, action, old, new string, err error) {
+	if u.history == nil {
+		return
+	}
+
+	memberId := int64(m.Id)
+	if primary, ok := audit.PrimaryMember(ctx); ok {
+		memberId = primary
+		new = fmt.Sprintf("dependent %d %s", m.Id, new)
+	}
+
+	e := audit.NewEntry(ctx, memberId, action, old, new)
+	e.Outcome = audit.Outcome(err)
+	if err := u.history.RecordHistory(ctx, e); err != nil {
+		slog.Error("Error recording history", logging.KeyMemberId, m.Id, logging.Err(err))
+	}
+}
+
+func fullName(m member) string {
+	return strings.TrimSpace(m.FirstName + " " + m.LastName)
+}
@@ -0,0 +1,241 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fatcatfablab/fcfl-member-sync/audit"
+	"github.com/fatcatfablab/fcfl-member-sync/types"
+	"github.com/miquelruiz/go-unifi-access-api/schema"
+)
+
+// fakeUA is a UniFi Access controller keeping its users and pending mobile
+// credential invitations in memory
+type fakeUA struct {
+	users      map[string]schema.UserResponse
+	invited    map[string]string
+	failRevoke bool
+	nextId     int
+}
+
+func newFakeUA(t *testing.T) (*fakeUA, *API) {
+	t.Helper()
+	f := &fakeUA{users: make(map[string]schema.UserResponse), invited: make(map[string]string)}
+	srv := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(srv.Close)
+
+	api, err := NewAPI(srv.URL, "token", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, api
+}
+
+func (f *fakeUA) serve(w http.ResponseWriter, r *http.Request) {
+	const (
+		users       = "/api/v1/developer/users"
+		invitations = users + "/identity/invitations"
+	)
+
+	var data any
+	switch id := strings.TrimPrefix(r.URL.Path, users+"/"); {
+	case r.Method == http.MethodPost && r.URL.Path == invitations:
+		var req []invitationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, inv := range req {
+			f.invited[inv.UserId] = inv.Email
+		}
+
+	case r.Method == http.MethodDelete && r.URL.Path == invitations:
+		var req []revocationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || f.failRevoke {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for _, inv := range req {
+			delete(f.invited, inv.UserId)
+		}
+
+	case r.Method == http.MethodGet && r.URL.Path == users:
+		list := []schema.UserResponse{}
+		for _, u := range f.users {
+			list = append(list, u)
+		}
+		data = list
+
+	case r.Method == http.MethodPost && r.URL.Path == users:
+		var req schema.UserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.nextId++
+		u := schema.UserResponse{
+			Id:        fmt.Sprintf("uaid%d", f.nextId),
+			FirstName: req.FirstName,
+			LastName:  req.LastName,
+			Status:    schema.StatusActive,
+		}
+		if req.EmployeeNumber != nil {
+			u.EmployeeNumber = *req.EmployeeNumber
+		}
+		f.users[u.Id] = u
+		data = u
+
+	case r.Method == http.MethodPut && f.exists(id):
+		var req schema.UserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u := f.users[id]
+		u.FirstName, u.LastName = req.FirstName, req.LastName
+		if req.Status != nil {
+			u.Status = *req.Status
+		}
+		f.users[id] = u
+		data = u
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(schema.Response[any]{Code: schema.ResponseSuccess, Data: data})
+}
+
+func (f *fakeUA) exists(id string) bool {
+	_, ok := f.users[id]
+	return ok
+}
+
+type fakeHistory struct {
+	entries []audit.Entry
+}
+
+func (f *fakeHistory) RecordHistory(_ context.Context, e audit.Entry) error {
+	f.entries = append(f.entries, e)
+	return nil
+}
+
+type fakeInvitations map[int32]types.Invitation
+
+func (f fakeInvitations) SaveInvitation(_ context.Context, inv types.Invitation) error {
+	f[inv.MemberId] = inv
+	return nil
+}
+
+func (f fakeInvitations) FindInvitation(_ context.Context, memberId int32) (*types.Invitation, error) {
+	if inv, ok := f[memberId]; ok {
+		return &inv, nil
+	}
+	return nil, nil
+}
+
+func TestAddMemberOutsideManagedIds(t *testing.T) {
+	ctx := context.Background()
+	ua, api := newFakeUA(t)
+	u := New(api, false)
+	u.SetOwnership(&IdRangeOwnership{Min: 1, Max: 999})
+
+	if _, err := u.AddMember(ctx, member{Id: 1000, FirstName: "Out", LastName: "Side"}); err == nil {
+		t.Error("expected error adding a member outside the managed ids")
+	}
+	if len(ua.users) != 0 {
+		t.Errorf("user created for a member outside the managed ids: %+v", ua.users)
+	}
+
+	accessId, err := u.AddMember(ctx, member{Id: 999, FirstName: "In", LastName: "Side"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := u.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := members[accessId]; !ok || m.Id != 999 {
+		t.Errorf("added member not managed: %+v", members)
+	}
+}
+
+func TestDependentHistory(t *testing.T) {
+	_, api := newFakeUA(t)
+	history := &fakeHistory{}
+	u := New(api, false)
+	u.SetHistory(history)
+
+	ctx := audit.WithPrimaryMember(context.Background(), 42)
+	kid := member{Id: 1000001, FirstName: "Kid", LastName: "One"}
+	accessId, err := u.AddMember(ctx, kid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.DisableMember(ctx, accessId, kid); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct{ action, new string }{
+		{audit.ActionUAAdd, "dependent 1000001 Kid One (" + accessId + ")"},
+		{audit.ActionUADisable, "dependent 1000001 " + deactivated},
+	}
+	if len(history.entries) != len(want) {
+		t.Fatalf("want %d entries, got %+v", len(want), history.entries)
+	}
+	for i, e := range history.entries {
+		if e.MemberId != 42 || e.Action != want[i].action || e.New != want[i].new || e.Outcome != audit.OutcomeOK {
+			t.Errorf("unexpected entry %d: %+v", i, e)
+		}
+	}
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	ctx := context.Background()
+	ua, api := newFakeUA(t)
+	store := fakeInvitations{}
+	u := New(api, false)
+	u.EnableInvitations(store)
+	u.SetEmails(map[int32]string{1: "mary@example.com"})
+
+	m := member{Id: 1, FirstName: "Mary", LastName: "Smith"}
+	accessId, err := u.AddMember(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ua.invited[accessId] != "mary@example.com" || store[1].Status != types.InvitationSent {
+		t.Fatalf("member not invited: %v %+v", ua.invited, store[1])
+	}
+
+	// A failed revocation isn't recorded, so it's tried again
+	ua.failRevoke = true
+	if err := u.DisableMember(ctx, accessId, m); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ua.invited[accessId]; !ok || store[1].Status != types.InvitationSent {
+		t.Errorf("invitation marked revoked without revoking it: %v %+v", ua.invited, store[1])
+	}
+
+	ua.failRevoke = false
+	if err := u.DisableMember(ctx, accessId, m); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ua.invited[accessId]; ok || store[1].Status != types.InvitationRevoked {
+		t.Errorf("invitation not revoked: %v %+v", ua.invited, store[1])
+	}
+
+	// Coming back sends it again
+	if err := u.UpdateMember(ctx, accessId, m); err != nil {
+		t.Fatal(err)
+	}
+	if ua.invited[accessId] != "mary@example.com" || store[1].Status != types.InvitationSent {
+		t.Errorf("invitation not sent again: %v %+v", ua.invited, store[1])
+	}
+}